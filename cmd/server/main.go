@@ -30,12 +30,18 @@ func main() {
 	}
 	defer func() { _ = storageInstance.Close() }()
 
+	batchStore, err := storage.InitBatchStore(logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize batch store: %v", err)
+	}
+
 	cfg, err := config.LoadServerConfigFromEnv(logger)
 	if err != nil {
 		logger.Fatal("Failed to load server configuration: %v", err)
 	}
 
 	cfg.Storage = storageInstance
+	cfg.BatchStore = batchStore
 	cfg.Logger = logger
 
 	srv, err := server.NewServer(cfg)
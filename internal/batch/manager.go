@@ -0,0 +1,598 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+)
+
+// Executor runs the body of one batch request line and returns the HTTP status code and
+// response body. It must return when ctx is cancelled.
+type Executor func(ctx context.Context, body map[string]any) (int, any)
+
+// RequestError is a client error in a file or batch request; Param names the offending field.
+type RequestError struct {
+	Param   string
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// Config configuration for Manager
+type Config struct {
+	Store       core.BatchStore
+	Executor    Executor
+	Concurrency int
+	Logger      core.Logger
+}
+
+// Manager runs OpenAI batches. Batches are processed one at a time in creation order, each by a
+// pool of workers; every finished request is persisted as it completes, so a batch interrupted
+// by a restart resumes with the requests that have no result yet.
+type Manager struct {
+	store         core.BatchStore
+	execute       Executor
+	concurrency   int
+	logger        core.Logger
+	retryInterval time.Duration
+
+	mu    sync.Mutex // guards batch record updates
+	queue *queue
+}
+
+// NewManager creates a batch manager; call Start to resume unfinished batches and begin processing.
+func NewManager(config Config) *Manager {
+	logger := config.Logger
+	if logger == nil {
+		logger = &core.NopLogger{}
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = core.DefaultBatchConcurrency
+	}
+
+	return &Manager{
+		store:         config.Store,
+		execute:       config.Executor,
+		concurrency:   concurrency,
+		logger:        logger,
+		retryInterval: core.BatchQuotaRetryInterval,
+		queue:         newQueue(),
+	}
+}
+
+// Start queues the batches left unfinished by a previous run and starts the dispatcher.
+func (m *Manager) Start() error {
+	batches, err := m.store.ListBatches()
+	if err != nil {
+		return fmt.Errorf("failed to list batches: %w", err)
+	}
+
+	resumed := 0
+	for i := len(batches) - 1; i >= 0; i-- {
+		if !isFinished(batches[i].Status) {
+			m.queue.enqueue(batches[i].ID)
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		m.logger.Info("Resuming %d unfinished batches", resumed)
+	}
+
+	m.queue.start(m.run)
+	return nil
+}
+
+// Close stops the dispatcher and waits for in-flight requests to return. Requests aborted by
+// the shutdown are not recorded, so they run again when the batch resumes.
+func (m *Manager) Close() error {
+	m.queue.close()
+	return nil
+}
+
+// CreateFile stores an uploaded batch input file.
+func (m *Manager) CreateFile(filename, purpose string, content []byte) (*core.OpenAIFile, error) {
+	if purpose != core.FilePurposeBatch {
+		return nil, &RequestError{Param: "purpose", Message: fmt.Sprintf("unsupported purpose %q; only %q is supported", purpose, core.FilePurposeBatch)}
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, &RequestError{Param: "file", Message: "file is empty"}
+	}
+	return m.saveFile(filename, purpose, content)
+}
+
+func (m *Manager) saveFile(filename, purpose string, content []byte) (*core.OpenAIFile, error) {
+	file := &core.OpenAIFile{
+		ID:        util.GenerateRandomID(core.FileIDPrefix),
+		Object:    core.FileObjectType,
+		Bytes:     int64(len(content)),
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+	}
+	if err := m.store.SaveFile(file, content); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// File returns a file record, or core.ErrNotFound.
+func (m *Manager) File(id string) (*core.OpenAIFile, error) {
+	return m.store.GetFile(id)
+}
+
+// FileContent returns the content of a file, or core.ErrNotFound.
+func (m *Manager) FileContent(id string) ([]byte, error) {
+	return m.store.GetFileContent(id)
+}
+
+// Files lists files newest first, optionally only those with the given purpose.
+func (m *Manager) Files(purpose string) ([]core.OpenAIFile, error) {
+	files, err := m.store.ListFiles()
+	if err != nil || purpose == "" {
+		return files, err
+	}
+	filtered := []core.OpenAIFile{}
+	for _, file := range files {
+		if file.Purpose == purpose {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered, nil
+}
+
+// DeleteFile deletes a file unless an unfinished batch still reads it.
+func (m *Manager) DeleteFile(id string) error {
+	batches, err := m.store.ListBatches()
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		if batch.InputFileID == id && !isFinished(batch.Status) {
+			return &RequestError{Param: "file_id", Message: fmt.Sprintf("file %s is the input of unfinished batch %s", id, batch.ID)}
+		}
+	}
+	return m.store.DeleteFile(id)
+}
+
+// Create validates the request and queues a new batch. The input file itself is validated
+// asynchronously while the batch is in the validating status.
+func (m *Manager) Create(req core.BatchCreateRequest) (*core.Batch, error) {
+	if req.Endpoint != core.BatchEndpointChatCompletions {
+		return nil, &RequestError{Param: "endpoint", Message: fmt.Sprintf("unsupported endpoint %q; only %s is supported", req.Endpoint, core.BatchEndpointChatCompletions)}
+	}
+	if req.CompletionWindow != core.BatchCompletionWindow {
+		return nil, &RequestError{Param: "completion_window", Message: fmt.Sprintf("completion_window must be %q", core.BatchCompletionWindow)}
+	}
+	file, err := m.store.GetFile(req.InputFileID)
+	if errors.Is(err, core.ErrNotFound) {
+		return nil, &RequestError{Param: "input_file_id", Message: fmt.Sprintf("No such File object: %s", req.InputFileID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.Purpose != core.FilePurposeBatch {
+		return nil, &RequestError{Param: "input_file_id", Message: fmt.Sprintf("input file must have purpose %q", core.FilePurposeBatch)}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(core.BatchCompletionWindowTime).Unix()
+	batch := &core.Batch{
+		ID:               util.GenerateRandomID(core.BatchIDPrefix),
+		Object:           core.BatchObjectType,
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           core.BatchStatusValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        &expiresAt,
+		Metadata:         req.Metadata,
+	}
+	if err := m.store.SaveBatch(batch); err != nil {
+		return nil, err
+	}
+
+	m.queue.enqueue(batch.ID)
+	return batch, nil
+}
+
+// Get returns a batch, or core.ErrNotFound.
+func (m *Manager) Get(id string) (*core.Batch, error) {
+	return m.store.GetBatch(id)
+}
+
+// List returns up to limit batches, newest first, starting after the batch with ID after.
+func (m *Manager) List(after string, limit int) (core.BatchList, error) {
+	batches, err := m.store.ListBatches()
+	if err != nil {
+		return core.BatchList{}, err
+	}
+	if after != "" {
+		for i := range batches {
+			if batches[i].ID == after {
+				batches = batches[i+1:]
+				break
+			}
+		}
+	}
+
+	list := core.BatchList{Object: core.ListObjectType, Data: batches}
+	if len(batches) > limit {
+		list.Data = batches[:limit]
+		list.HasMore = true
+	}
+	if len(list.Data) > 0 {
+		list.FirstID = &list.Data[0].ID
+		list.LastID = &list.Data[len(list.Data)-1].ID
+	}
+	return list, nil
+}
+
+// Cancel moves a validating or in-progress batch to cancelling. Requests already running
+// finish; the batch is then finalized as cancelled with the results produced so far.
+func (m *Manager) Cancel(id string) (*core.Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.store.GetBatch(id)
+	if err != nil {
+		return nil, err
+	}
+	switch batch.Status {
+	case core.BatchStatusCancelling, core.BatchStatusCancelled:
+		return batch, nil
+	case core.BatchStatusValidating, core.BatchStatusInProgress:
+	default:
+		return nil, &RequestError{Param: "batch_id", Message: fmt.Sprintf("cannot cancel a batch with status %s", batch.Status)}
+	}
+
+	batch.Status = core.BatchStatusCancelling
+	batch.CancellingAt = unixNow()
+	if err := m.store.SaveBatch(batch); err != nil {
+		return nil, err
+	}
+	m.queue.stop(id)
+	return batch, nil
+}
+
+// run drives a batch from its persisted status to a final status.
+func (m *Manager) run(id string, stop chan struct{}) {
+	batch, err := m.store.GetBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load batch %s: %v", id, err)
+		return
+	}
+	if isFinished(batch.Status) {
+		return
+	}
+
+	lines, lineErrors, err := m.readLines(batch)
+	if err != nil {
+		lineErrors = []core.BatchError{{Code: core.BatchErrorCodeInvalidJSON, Message: err.Error()}}
+	}
+	if len(lineErrors) > 0 {
+		m.logger.Warn("Batch %s failed validation with %d errors", id, len(lineErrors))
+		m.update(id, func(b *core.Batch) {
+			b.Status = core.BatchStatusFailed
+			b.FailedAt = unixNow()
+			b.Errors = &core.BatchErrors{Object: core.ListObjectType, Data: lineErrors}
+		})
+		return
+	}
+
+	batch = m.update(id, func(b *core.Batch) {
+		if b.Status == core.BatchStatusValidating {
+			b.Status = core.BatchStatusInProgress
+			b.InProgressAt = unixNow()
+		}
+		b.RequestCounts.Total = len(lines)
+	})
+	if batch == nil {
+		return
+	}
+
+	if batch.Status == core.BatchStatusInProgress {
+		m.process(batch, lines, stop)
+		if m.queue.ctx.Err() != nil {
+			return
+		}
+	}
+	m.finalize(id, lines)
+}
+
+// process runs the requests of a batch that have no result yet.
+func (m *Manager) process(batch *core.Batch, lines []core.BatchRequestLine, stop chan struct{}) {
+	done, err := m.recordedRequests(batch.ID)
+	if err != nil {
+		m.logger.Error("Failed to read results of batch %s: %v", batch.ID, err)
+		return
+	}
+	if len(done) > 0 {
+		m.logger.Info("Batch %s resuming with %d of %d requests done", batch.ID, len(done), len(lines))
+	}
+
+	pending := make([]core.BatchRequestLine, 0, len(lines)-len(done))
+	for _, line := range lines {
+		if !done[line.CustomID] {
+			pending = append(pending, line)
+		}
+	}
+
+	expiresAt := time.Unix(*batch.ExpiresAt, 0)
+	runPool(m.queue.ctx, m.concurrency, pending, stop, expiresAt, func(line core.BatchRequestLine) {
+		status, body, ok := executeWithRetry(m.queue.ctx, stop, expiresAt, m.retryInterval, func() (int, any) {
+			return m.execute(m.queue.ctx, line.Body)
+		}, func() {
+			m.logger.Warn("Batch %s request %s rate limited, retrying in %s", batch.ID, line.CustomID, m.retryInterval)
+		})
+		if ok {
+			m.record(batch.ID, line.CustomID, status, body)
+		}
+	})
+}
+
+func (m *Manager) record(batchID, customID string, status int, body any) {
+	failed := status < http.StatusOK || status >= http.StatusMultipleChoices
+	result := core.BatchResultLine{
+		ID:       util.GenerateRandomID(core.BatchRequestIDPrefix),
+		CustomID: customID,
+		Response: &core.BatchResultResponse{
+			StatusCode: status,
+			RequestID:  util.GenerateRandomID(core.RequestIDPrefix),
+			Body:       body,
+		},
+	}
+	if err := m.appendResult(batchID, failed, result); err != nil {
+		m.logger.Error("Failed to record result of batch %s request %s: %v", batchID, customID, err)
+		return
+	}
+
+	m.update(batchID, func(b *core.Batch) {
+		if failed {
+			b.RequestCounts.Failed++
+		} else {
+			b.RequestCounts.Completed++
+		}
+	})
+}
+
+func (m *Manager) appendResult(batchID string, failed bool, result core.BatchResultLine) error {
+	data, err := util.MarshalJSON(result)
+	if err != nil {
+		return err
+	}
+	return m.store.AppendBatchResult(batchID, failed, data)
+}
+
+// finalize writes the output and error files and moves the batch to its final status:
+// cancelled if it was cancelled, expired if requests were left when the window closed,
+// completed otherwise.
+func (m *Manager) finalize(id string, lines []core.BatchRequestLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.store.GetBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load batch %s: %v", id, err)
+		return
+	}
+	done, err := m.recordedRequests(id)
+	if err != nil {
+		m.logger.Error("Failed to read results of batch %s: %v", id, err)
+		return
+	}
+
+	finalStatus := core.BatchStatusCompleted
+	switch {
+	case batch.Status == core.BatchStatusCancelling:
+		finalStatus = core.BatchStatusCancelled
+	case len(done) < len(lines) && time.Now().Unix() >= *batch.ExpiresAt:
+		finalStatus = core.BatchStatusExpired
+		for _, line := range lines {
+			if done[line.CustomID] {
+				continue
+			}
+			if err := m.appendResult(id, true, core.BatchResultLine{
+				ID:       util.GenerateRandomID(core.BatchRequestIDPrefix),
+				CustomID: line.CustomID,
+				Error:    &core.BatchResultError{Code: core.BatchErrorCodeExpired, Message: "This request could not be executed before the completion window expired."},
+			}); err != nil {
+				m.logger.Error("Failed to record expiry of batch %s request %s: %v", id, line.CustomID, err)
+				return
+			}
+		}
+	default:
+		batch.Status = core.BatchStatusFinalizing
+		batch.FinalizingAt = unixNow()
+		if err := m.store.SaveBatch(batch); err != nil {
+			m.logger.Error("Failed to save batch %s: %v", id, err)
+			return
+		}
+	}
+
+	completed, failed, err := m.writeResultFiles(batch)
+	if err != nil {
+		m.logger.Error("Failed to write result files of batch %s: %v", id, err)
+		return
+	}
+	batch.RequestCounts.Completed = completed
+	batch.RequestCounts.Failed = failed
+
+	batch.Status = finalStatus
+	switch finalStatus {
+	case core.BatchStatusCancelled:
+		batch.CancelledAt = unixNow()
+	case core.BatchStatusExpired:
+		batch.ExpiredAt = unixNow()
+	default:
+		batch.CompletedAt = unixNow()
+	}
+	if err := m.store.SaveBatch(batch); err != nil {
+		m.logger.Error("Failed to save batch %s: %v", id, err)
+		return
+	}
+	if err := m.store.DeleteBatchResults(id); err != nil {
+		m.logger.Warn("Failed to delete results of batch %s: %v", id, err)
+	}
+	m.logger.Info("Batch %s %s: %d completed, %d failed", id, finalStatus, completed, failed)
+}
+
+// writeResultFiles saves the recorded results as batch_output files and returns the line counts.
+func (m *Manager) writeResultFiles(batch *core.Batch) (int, int, error) {
+	counts := [2]int{}
+	for i, failed := range []bool{false, true} {
+		data, err := m.store.GetBatchResults(batch.ID, failed)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		counts[i] = bytes.Count(data, []byte("\n"))
+
+		name := batch.ID + "_output.jsonl"
+		if failed {
+			name = batch.ID + "_error.jsonl"
+		}
+		file, err := m.saveFile(name, core.FilePurposeBatchOutput, data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if failed {
+			batch.ErrorFileID = &file.ID
+		} else {
+			batch.OutputFileID = &file.ID
+		}
+	}
+	return counts[0], counts[1], nil
+}
+
+// readLines parses the input file of a batch. Line errors are returned separately from
+// failures to read the file.
+func (m *Manager) readLines(batch *core.Batch) ([]core.BatchRequestLine, []core.BatchError, error) {
+	content, err := m.store.GetFileContent(batch.InputFileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read input file %s: %w", batch.InputFileID, err)
+	}
+	lines, lineErrors := parseLines(content, batch.Endpoint)
+	return lines, lineErrors, nil
+}
+
+// parseLines parses and validates a JSONL batch input file.
+func parseLines(content []byte, endpoint string) ([]core.BatchRequestLine, []core.BatchError) {
+	var lines []core.BatchRequestLine
+	var lineErrors []core.BatchError
+	seen := make(map[string]bool)
+
+	addError := func(lineNo int, code, message string) {
+		lineErrors = append(lineErrors, core.BatchError{Code: code, Message: message, Line: &lineNo})
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), core.MaxScannerBufferSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var line core.BatchRequestLine
+		switch err := sonic.Unmarshal(raw, &line); {
+		case err != nil || line.Body == nil:
+			addError(lineNo, core.BatchErrorCodeInvalidJSON, "line is not a JSON object with a body")
+		case line.CustomID == "":
+			addError(lineNo, core.BatchErrorCodeMissingCustomID, "custom_id is required")
+		case seen[line.CustomID]:
+			addError(lineNo, core.BatchErrorCodeDuplicateID, fmt.Sprintf("custom_id %q is not unique", line.CustomID))
+		case line.Method != http.MethodPost:
+			addError(lineNo, core.BatchErrorCodeInvalidMethod, "method must be POST")
+		case line.URL != endpoint:
+			addError(lineNo, core.BatchErrorCodeInvalidURL, fmt.Sprintf("url must match the batch endpoint %s", endpoint))
+		default:
+			seen[line.CustomID] = true
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		addError(lineNo+1, core.BatchErrorCodeInvalidJSON, err.Error())
+	}
+
+	switch {
+	case len(lineErrors) > 0:
+	case len(lines) == 0:
+		lineErrors = append(lineErrors, core.BatchError{Code: core.BatchErrorCodeEmptyFile, Message: "input file contains no requests"})
+	case len(lines) > core.BatchMaxRequests:
+		lineErrors = append(lineErrors, core.BatchError{Code: core.BatchErrorCodeTooManyRequests, Message: fmt.Sprintf("input file contains %d requests; the limit is %d", len(lines), core.BatchMaxRequests)})
+	}
+	return lines, lineErrors
+}
+
+// recordedRequests returns the custom_ids that already have a result.
+func (m *Manager) recordedRequests(batchID string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	for _, failed := range []bool{false, true} {
+		data, err := m.store.GetBatchResults(batchID, failed)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), core.MaxScannerBufferSize)
+		for scanner.Scan() {
+			var result struct {
+				CustomID string `json:"custom_id"`
+			}
+			if err := sonic.Unmarshal(scanner.Bytes(), &result); err == nil && result.CustomID != "" {
+				done[result.CustomID] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return done, nil
+}
+
+// update applies fn to the stored batch and saves it; returns the updated batch, or nil on failure.
+func (m *Manager) update(id string, fn func(b *core.Batch)) *core.Batch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.store.GetBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load batch %s: %v", id, err)
+		return nil
+	}
+	fn(batch)
+	if err := m.store.SaveBatch(batch); err != nil {
+		m.logger.Error("Failed to save batch %s: %v", id, err)
+		return nil
+	}
+	return batch
+}
+
+func isFinished(status string) bool {
+	switch status {
+	case core.BatchStatusCompleted, core.BatchStatusFailed, core.BatchStatusExpired, core.BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func unixNow() *int64 {
+	now := time.Now().Unix()
+	return &now
+}
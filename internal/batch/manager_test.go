@@ -0,0 +1,368 @@
+package batch
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/storage"
+
+	"github.com/bytedance/sonic"
+)
+
+func newTestManager(t *testing.T, store core.BatchStore, exec Executor) *Manager {
+	t.Helper()
+	m := NewManager(Config{Store: store, Executor: exec, Concurrency: 2})
+	m.retryInterval = 10 * time.Millisecond
+	if err := m.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func newTestStore(t *testing.T) *storage.FileBatchStore {
+	t.Helper()
+	store, err := storage.NewFileBatchStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	return store
+}
+
+func batchInput(ids ...string) []byte {
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString(`{"custom_id":"` + id + `","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","messages":[{"role":"user","content":"` + id + `"}]}}` + "\n")
+	}
+	return []byte(b.String())
+}
+
+func createTestBatch(t *testing.T, m *Manager, content []byte) *core.Batch {
+	t.Helper()
+	file, err := m.CreateFile("input.jsonl", core.FilePurposeBatch, content)
+	if err != nil {
+		t.Fatalf("上传文件失败: %v", err)
+	}
+	b, err := m.Create(core.BatchCreateRequest{InputFileID: file.ID, Endpoint: core.BatchEndpointChatCompletions, CompletionWindow: core.BatchCompletionWindow})
+	if err != nil {
+		t.Fatalf("创建批处理失败: %v", err)
+	}
+	return b
+}
+
+func waitForStatus(t *testing.T, m *Manager, id, status string) *core.Batch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, err := m.Get(id)
+		if err == nil && b.Status == status {
+			return b
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b, _ := m.Get(id)
+	t.Fatalf("等待状态 %s 超时，当前 %+v", status, b)
+	return nil
+}
+
+// resultLines parses the JSONL result file with the given ID, keyed by custom_id
+func resultLines(t *testing.T, m *Manager, fileID *string) map[string]core.BatchResultLine {
+	t.Helper()
+	results := make(map[string]core.BatchResultLine)
+	if fileID == nil {
+		return results
+	}
+	content, err := m.FileContent(*fileID)
+	if err != nil {
+		t.Fatalf("读取结果文件失败: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var result core.BatchResultLine
+		if err := sonic.UnmarshalString(line, &result); err != nil {
+			t.Fatalf("结果行无法解析: %s", line)
+		}
+		results[result.CustomID] = result
+	}
+	return results
+}
+
+func echoExecutor(ctx context.Context, body map[string]any) (int, any) {
+	messages, _ := body["messages"].([]any)
+	content, _ := messages[0].(map[string]any)["content"].(string)
+	if content == "bad" {
+		return http.StatusBadRequest, map[string]any{"error": map[string]any{"message": "bad request"}}
+	}
+	return http.StatusOK, map[string]any{"echo": content}
+}
+
+func TestManager_CompletesBatch(t *testing.T) {
+	m := newTestManager(t, newTestStore(t), echoExecutor)
+
+	b := createTestBatch(t, m, batchInput("a", "bad", "c"))
+	if b.Status != core.BatchStatusValidating || b.ExpiresAt == nil {
+		t.Fatalf("新建批处理应处于 validating 并设置 expires_at，实际 %+v", b)
+	}
+
+	done := waitForStatus(t, m, b.ID, core.BatchStatusCompleted)
+	if done.RequestCounts != (core.BatchRequestCounts{Total: 3, Completed: 2, Failed: 1}) {
+		t.Errorf("请求计数错误: %+v", done.RequestCounts)
+	}
+	if done.InProgressAt == nil || done.FinalizingAt == nil || done.CompletedAt == nil {
+		t.Errorf("应记录各阶段时间戳: %+v", done)
+	}
+
+	output := resultLines(t, m, done.OutputFileID)
+	if len(output) != 2 || output["a"].Response.StatusCode != http.StatusOK || output["c"].Response == nil {
+		t.Errorf("输出文件错误: %+v", output)
+	}
+	errs := resultLines(t, m, done.ErrorFileID)
+	if len(errs) != 1 || errs["bad"].Response.StatusCode != http.StatusBadRequest {
+		t.Errorf("错误文件错误: %+v", errs)
+	}
+
+	file, err := m.File(*done.OutputFileID)
+	if err != nil || file.Purpose != core.FilePurposeBatchOutput {
+		t.Errorf("输出文件的 purpose 应为 batch_output: %+v, %v", file, err)
+	}
+}
+
+func TestManager_ValidationFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantCode string
+	}{
+		{"非法 JSON", "{not json}\n", core.BatchErrorCodeInvalidJSON},
+		{"缺少 custom_id", `{"method":"POST","url":"/v1/chat/completions","body":{}}`, core.BatchErrorCodeMissingCustomID},
+		{"custom_id 重复", string(batchInput("a", "a")), core.BatchErrorCodeDuplicateID},
+		{"method 错误", `{"custom_id":"a","method":"GET","url":"/v1/chat/completions","body":{}}`, core.BatchErrorCodeInvalidMethod},
+		{"url 与 endpoint 不一致", `{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{}}`, core.BatchErrorCodeInvalidURL},
+	}
+	m := newTestManager(t, newTestStore(t), func(ctx context.Context, body map[string]any) (int, any) {
+		t.Error("校验失败的批处理不应执行请求")
+		return http.StatusOK, nil
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := createTestBatch(t, m, []byte(tt.content))
+			failed := waitForStatus(t, m, b.ID, core.BatchStatusFailed)
+			if failed.Errors == nil || len(failed.Errors.Data) == 0 || failed.Errors.Data[0].Code != tt.wantCode {
+				t.Fatalf("期望错误码 %s，实际 %+v", tt.wantCode, failed.Errors)
+			}
+			if failed.FailedAt == nil {
+				t.Error("应设置 failed_at")
+			}
+		})
+	}
+}
+
+func TestManager_CreateValidation(t *testing.T) {
+	m := newTestManager(t, newTestStore(t), echoExecutor)
+	input, _ := m.CreateFile("input.jsonl", core.FilePurposeBatch, batchInput("a"))
+
+	tests := []struct {
+		name      string
+		req       core.BatchCreateRequest
+		wantParam string
+	}{
+		{"不支持的 endpoint", core.BatchCreateRequest{InputFileID: input.ID, Endpoint: "/v1/embeddings", CompletionWindow: "24h"}, "endpoint"},
+		{"不支持的窗口", core.BatchCreateRequest{InputFileID: input.ID, Endpoint: core.BatchEndpointChatCompletions, CompletionWindow: "1h"}, "completion_window"},
+		{"文件不存在", core.BatchCreateRequest{InputFileID: "file-missing", Endpoint: core.BatchEndpointChatCompletions, CompletionWindow: "24h"}, "input_file_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Create(tt.req)
+			requestErr, ok := err.(*RequestError)
+			if !ok || requestErr.Param != tt.wantParam {
+				t.Fatalf("期望参数 %s 的 RequestError，实际 %v", tt.wantParam, err)
+			}
+		})
+	}
+}
+
+func TestManager_ResumesUnfinishedBatch(t *testing.T) {
+	store := newTestStore(t)
+
+	// First run: the executor blocks until shutdown after the first request succeeds
+	var calls atomic.Int32
+	first := NewManager(Config{Store: store, Concurrency: 1, Executor: func(ctx context.Context, body map[string]any) (int, any) {
+		if calls.Add(1) == 1 {
+			return http.StatusOK, map[string]any{}
+		}
+		<-ctx.Done()
+		return http.StatusBadGateway, nil
+	}})
+	if err := first.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	b := createTestBatch(t, first, batchInput("a", "b", "c"))
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = first.Close()
+
+	interrupted, _ := store.GetBatch(b.ID)
+	if interrupted.Status != core.BatchStatusInProgress || interrupted.RequestCounts.Completed != 1 {
+		t.Fatalf("关闭后批处理应保持 in_progress 且已完成 1 个，实际 %+v", interrupted)
+	}
+
+	// Second run: only the requests without a result are executed
+	var mu sync.Mutex
+	var executed []string
+	second := newTestManager(t, store, func(ctx context.Context, body map[string]any) (int, any) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages, _ := body["messages"].([]any)
+		executed = append(executed, messages[0].(map[string]any)["content"].(string))
+		return http.StatusOK, map[string]any{}
+	})
+	done := waitForStatus(t, second, b.ID, core.BatchStatusCompleted)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(executed) != 2 {
+		t.Errorf("恢复后应只执行剩余 2 个请求，实际 %v", executed)
+	}
+	if done.RequestCounts.Completed != 3 || len(resultLines(t, second, done.OutputFileID)) != 3 {
+		t.Errorf("恢复后输出应包含全部 3 个结果: %+v", done.RequestCounts)
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	release := make(chan struct{})
+	var started atomic.Int32
+	m := newTestManager(t, newTestStore(t), func(ctx context.Context, body map[string]any) (int, any) {
+		started.Add(1)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return http.StatusOK, map[string]any{}
+	})
+
+	b := createTestBatch(t, m, batchInput("a", "b", "c", "d", "e"))
+	deadline := time.Now().Add(5 * time.Second)
+	for started.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancelling, err := m.Cancel(b.ID)
+	if err != nil || cancelling.Status != core.BatchStatusCancelling || cancelling.CancellingAt == nil {
+		t.Fatalf("取消后应处于 cancelling: %+v, %v", cancelling, err)
+	}
+	close(release)
+
+	cancelled := waitForStatus(t, m, b.ID, core.BatchStatusCancelled)
+	if cancelled.RequestCounts.Completed != 2 || cancelled.CancelledAt == nil {
+		t.Errorf("进行中的请求应完成，其余不再执行: %+v", cancelled)
+	}
+	if _, err := m.Cancel(b.ID); err != nil {
+		t.Errorf("重复取消应幂等: %v", err)
+	}
+}
+
+func TestManager_RetriesRateLimitedRequests(t *testing.T) {
+	var calls atomic.Int32
+	m := newTestManager(t, newTestStore(t), func(ctx context.Context, body map[string]any) (int, any) {
+		if calls.Add(1) <= 2 {
+			return http.StatusTooManyRequests, map[string]any{}
+		}
+		return http.StatusOK, map[string]any{}
+	})
+
+	b := createTestBatch(t, m, batchInput("a"))
+	done := waitForStatus(t, m, b.ID, core.BatchStatusCompleted)
+	if calls.Load() != 3 || done.RequestCounts.Completed != 1 || done.ErrorFileID != nil {
+		t.Errorf("429 应重试直至成功，调用 %d 次，计数 %+v", calls.Load(), done.RequestCounts)
+	}
+}
+
+func TestManager_ExpiresUnfinishedRequests(t *testing.T) {
+	store := newTestStore(t)
+	setup := NewManager(Config{Store: store})
+	b := createTestBatch(t, setup, batchInput("a", "b"))
+	_ = setup.Close()
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	b.ExpiresAt = &expired
+	if err := store.SaveBatch(b); err != nil {
+		t.Fatalf("保存失败: %v", err)
+	}
+
+	m := newTestManager(t, store, echoExecutor)
+	done := waitForStatus(t, m, b.ID, core.BatchStatusExpired)
+	errs := resultLines(t, m, done.ErrorFileID)
+	if len(errs) != 2 || errs["a"].Error == nil || errs["a"].Error.Code != core.BatchErrorCodeExpired {
+		t.Errorf("过期批处理的未执行请求应写入错误文件: %+v", errs)
+	}
+	if done.ExpiredAt == nil || done.RequestCounts.Failed != 2 {
+		t.Errorf("过期状态错误: %+v", done)
+	}
+}
+
+func TestManager_DeleteFileInUse(t *testing.T) {
+	release := make(chan struct{})
+	m := newTestManager(t, newTestStore(t), func(ctx context.Context, body map[string]any) (int, any) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return http.StatusOK, map[string]any{}
+	})
+	b := createTestBatch(t, m, batchInput("a"))
+
+	if _, ok := m.DeleteFile(b.InputFileID).(*RequestError); !ok {
+		t.Error("未完成批处理的输入文件不应允许删除")
+	}
+	close(release)
+	waitForStatus(t, m, b.ID, core.BatchStatusCompleted)
+	if err := m.DeleteFile(b.InputFileID); err != nil {
+		t.Errorf("批处理完成后应允许删除输入文件: %v", err)
+	}
+}
+
+func TestManager_List(t *testing.T) {
+	store := newTestStore(t)
+	for i, id := range []string{"batch_1", "batch_2", "batch_3"} {
+		if err := store.SaveBatch(&core.Batch{ID: id, Status: core.BatchStatusCompleted, CreatedAt: int64(100 + i)}); err != nil {
+			t.Fatalf("保存失败: %v", err)
+		}
+	}
+	m := newTestManager(t, store, echoExecutor)
+
+	tests := []struct {
+		name     string
+		after    string
+		limit    int
+		wantIDs  []string
+		wantMore bool
+	}{
+		{"第一页", "", 2, []string{"batch_3", "batch_2"}, true},
+		{"after 翻页", "batch_2", 2, []string{"batch_1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := m.List(tt.after, tt.limit)
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			var ids []string
+			for _, b := range list.Data {
+				ids = append(ids, b.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") || list.HasMore != tt.wantMore {
+				t.Errorf("期望 %v (has_more=%v)，实际 %v (has_more=%v)", tt.wantIDs, tt.wantMore, ids, list.HasMore)
+			}
+			if *list.FirstID != tt.wantIDs[0] || *list.LastID != tt.wantIDs[len(tt.wantIDs)-1] {
+				t.Errorf("first_id/last_id 错误: %s/%s", *list.FirstID, *list.LastID)
+			}
+		})
+	}
+}
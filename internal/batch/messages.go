@@ -0,0 +1,415 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+)
+
+var customIDPattern = regexp.MustCompile(fmt.Sprintf(`^[a-zA-Z0-9_-]{1,%d}$`, core.MessageBatchCustomIDMaxLength))
+
+// MessageConfig configuration for MessageManager
+type MessageConfig struct {
+	Storage     core.MessageBatchStorage
+	Executor    Executor
+	Concurrency int
+	Logger      core.Logger
+}
+
+// MessageManager runs Anthropic message batches the same way Manager runs OpenAI batches: one
+// batch at a time in creation order, each by a pool of workers, with every result persisted as
+// it completes so that an interrupted batch resumes where it stopped.
+type MessageManager struct {
+	storage       core.MessageBatchStorage
+	execute       Executor
+	concurrency   int
+	logger        core.Logger
+	retryInterval time.Duration
+
+	mu    sync.Mutex // guards batch record updates
+	queue *queue
+}
+
+// NewMessageManager creates a message batch manager; call Start to resume unfinished batches
+// and begin processing.
+func NewMessageManager(config MessageConfig) *MessageManager {
+	logger := config.Logger
+	if logger == nil {
+		logger = &core.NopLogger{}
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = core.DefaultBatchConcurrency
+	}
+
+	return &MessageManager{
+		storage:       config.Storage,
+		execute:       config.Executor,
+		concurrency:   concurrency,
+		logger:        logger,
+		retryInterval: core.BatchQuotaRetryInterval,
+		queue:         newQueue(),
+	}
+}
+
+// Start queues the batches left unfinished by a previous run and starts the dispatcher.
+func (m *MessageManager) Start() error {
+	batches, err := m.storage.ListMessageBatches()
+	if err != nil {
+		return fmt.Errorf("failed to list message batches: %w", err)
+	}
+
+	resumed := 0
+	for i := len(batches) - 1; i >= 0; i-- {
+		if batches[i].ProcessingStatus != core.MessageBatchStatusEnded {
+			m.queue.enqueue(batches[i].ID)
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		m.logger.Info("Resuming %d unfinished message batches", resumed)
+	}
+
+	m.queue.start(m.run)
+	return nil
+}
+
+// Close stops the dispatcher and waits for in-flight requests to return. Requests aborted by
+// the shutdown are not recorded, so they run again when the batch resumes.
+func (m *MessageManager) Close() error {
+	m.queue.close()
+	return nil
+}
+
+// Create validates the requests and queues a new message batch.
+func (m *MessageManager) Create(req core.MessageBatchCreateRequest) (*core.MessageBatch, error) {
+	if len(req.Requests) == 0 {
+		return nil, &RequestError{Param: "requests", Message: "requests must contain at least one request"}
+	}
+	if len(req.Requests) > core.MessageBatchMaxRequests {
+		return nil, &RequestError{Param: "requests", Message: fmt.Sprintf("requests contains %d requests; the limit is %d", len(req.Requests), core.MessageBatchMaxRequests)}
+	}
+	seen := make(map[string]bool, len(req.Requests))
+	for i, r := range req.Requests {
+		switch {
+		case !customIDPattern.MatchString(r.CustomID):
+			return nil, &RequestError{Param: fmt.Sprintf("requests.%d.custom_id", i), Message: fmt.Sprintf("custom_id must be 1 to %d letters, digits, underscores or hyphens", core.MessageBatchCustomIDMaxLength)}
+		case seen[r.CustomID]:
+			return nil, &RequestError{Param: fmt.Sprintf("requests.%d.custom_id", i), Message: fmt.Sprintf("custom_id %q is not unique", r.CustomID)}
+		case r.Params == nil:
+			return nil, &RequestError{Param: fmt.Sprintf("requests.%d.params", i), Message: "params is required"}
+		}
+		seen[r.CustomID] = true
+	}
+
+	now := time.Now().UTC()
+	batch := &core.MessageBatch{
+		ID:               util.GenerateRandomID(core.MessageBatchIDPrefix),
+		Type:             core.MessageBatchObjectType,
+		ProcessingStatus: core.MessageBatchStatusInProgress,
+		RequestCounts:    core.MessageBatchRequestCounts{Processing: len(req.Requests)},
+		CreatedAt:        now.Format(time.RFC3339),
+		ExpiresAt:        now.Add(core.BatchCompletionWindowTime).Format(time.RFC3339),
+	}
+	// Requests first, so that a batch record never points to missing requests
+	if err := m.storage.SaveMessageBatchRequests(batch.ID, req.Requests); err != nil {
+		return nil, err
+	}
+	if err := m.storage.SaveMessageBatch(batch); err != nil {
+		return nil, err
+	}
+
+	m.queue.enqueue(batch.ID)
+	return batch, nil
+}
+
+// Get returns a message batch, or core.ErrNotFound.
+func (m *MessageManager) Get(id string) (*core.MessageBatch, error) {
+	return m.storage.GetMessageBatch(id)
+}
+
+// List returns up to limit batches, newest first. afterID pages towards older batches and
+// beforeID towards newer ones.
+func (m *MessageManager) List(beforeID, afterID string, limit int) (core.MessageBatchList, error) {
+	batches, err := m.storage.ListMessageBatches()
+	if err != nil {
+		return core.MessageBatchList{}, err
+	}
+
+	list := core.MessageBatchList{Data: batches}
+	switch {
+	case beforeID != "":
+		for i := range batches {
+			if batches[i].ID == beforeID {
+				batches = batches[:i]
+				break
+			}
+		}
+		list.Data = batches
+		if len(batches) > limit {
+			list.Data = batches[len(batches)-limit:]
+			list.HasMore = true
+		}
+	default:
+		if afterID != "" {
+			for i := range batches {
+				if batches[i].ID == afterID {
+					batches = batches[i+1:]
+					break
+				}
+			}
+		}
+		list.Data = batches
+		if len(batches) > limit {
+			list.Data = batches[:limit]
+			list.HasMore = true
+		}
+	}
+	if len(list.Data) > 0 {
+		list.FirstID = &list.Data[0].ID
+		list.LastID = &list.Data[len(list.Data)-1].ID
+	}
+	return list, nil
+}
+
+// Cancel moves an in-progress batch to canceling. Requests already running finish; the others
+// are recorded as canceled when the batch ends.
+func (m *MessageManager) Cancel(id string) (*core.MessageBatch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.storage.GetMessageBatch(id)
+	if err != nil {
+		return nil, err
+	}
+	switch batch.ProcessingStatus {
+	case core.MessageBatchStatusCanceling:
+		return batch, nil
+	case core.MessageBatchStatusEnded:
+		return nil, &RequestError{Param: "message_batch_id", Message: fmt.Sprintf("message batch %s has already ended", id)}
+	}
+
+	batch.ProcessingStatus = core.MessageBatchStatusCanceling
+	batch.CancelInitiatedAt = rfc3339Now()
+	if err := m.storage.SaveMessageBatch(batch); err != nil {
+		return nil, err
+	}
+	m.queue.stop(id)
+	return batch, nil
+}
+
+// Results returns the JSONL results of an ended batch.
+func (m *MessageManager) Results(id string) ([]byte, error) {
+	batch, err := m.storage.GetMessageBatch(id)
+	if err != nil {
+		return nil, err
+	}
+	if batch.ProcessingStatus != core.MessageBatchStatusEnded {
+		return nil, &RequestError{Param: "message_batch_id", Message: fmt.Sprintf("message batch %s is still %s; results are available once it has ended", id, batch.ProcessingStatus)}
+	}
+	return m.storage.GetMessageBatchResults(id)
+}
+
+// run drives a batch from its persisted status to ended.
+func (m *MessageManager) run(id string, stop chan struct{}) {
+	batch, err := m.storage.GetMessageBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load message batch %s: %v", id, err)
+		return
+	}
+	if batch.ProcessingStatus == core.MessageBatchStatusEnded {
+		return
+	}
+	requests, err := m.storage.GetMessageBatchRequests(id)
+	if err != nil {
+		m.logger.Error("Failed to load requests of message batch %s: %v", id, err)
+		return
+	}
+	expiresAt, err := time.Parse(time.RFC3339, batch.ExpiresAt)
+	if err != nil {
+		m.logger.Error("Invalid expires_at of message batch %s: %v", id, err)
+		return
+	}
+
+	if batch.ProcessingStatus == core.MessageBatchStatusInProgress {
+		m.process(id, requests, expiresAt, stop)
+		if m.queue.ctx.Err() != nil {
+			return
+		}
+	}
+	m.finalize(id, requests, expiresAt)
+}
+
+// process runs the requests of a batch that have no result yet.
+func (m *MessageManager) process(id string, requests []core.MessageBatchRequest, expiresAt time.Time, stop chan struct{}) {
+	results, err := m.recordedResults(id)
+	if err != nil {
+		m.logger.Error("Failed to read results of message batch %s: %v", id, err)
+		return
+	}
+	if len(results) > 0 {
+		m.logger.Info("Message batch %s resuming with %d of %d requests done", id, len(results), len(requests))
+	}
+
+	pending := make([]core.MessageBatchRequest, 0, len(requests)-len(results))
+	for _, r := range requests {
+		if _, ok := results[r.CustomID]; !ok {
+			pending = append(pending, r)
+		}
+	}
+
+	runPool(m.queue.ctx, m.concurrency, pending, stop, expiresAt, func(r core.MessageBatchRequest) {
+		status, body, ok := executeWithRetry(m.queue.ctx, stop, expiresAt, m.retryInterval, func() (int, any) {
+			return m.execute(m.queue.ctx, r.Params)
+		}, func() {
+			m.logger.Warn("Message batch %s request %s rate limited, retrying in %s", id, r.CustomID, m.retryInterval)
+		})
+		if ok {
+			m.record(id, r.CustomID, status, body)
+		}
+	})
+}
+
+func (m *MessageManager) record(id, customID string, status int, body any) {
+	result := core.MessageBatchResult{Type: core.MessageBatchResultSucceeded, Message: body}
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		result = core.MessageBatchResult{Type: core.MessageBatchResultErrored, Error: body}
+	}
+	if err := m.appendResult(id, customID, result); err != nil {
+		m.logger.Error("Failed to record result of message batch %s request %s: %v", id, customID, err)
+		return
+	}
+
+	m.update(id, func(b *core.MessageBatch) {
+		b.RequestCounts.Processing--
+		if result.Type == core.MessageBatchResultSucceeded {
+			b.RequestCounts.Succeeded++
+		} else {
+			b.RequestCounts.Errored++
+		}
+	})
+}
+
+func (m *MessageManager) appendResult(id, customID string, result core.MessageBatchResult) error {
+	data, err := util.MarshalJSON(core.MessageBatchResultLine{CustomID: customID, Result: result})
+	if err != nil {
+		return err
+	}
+	return m.storage.AppendMessageBatchResult(id, data)
+}
+
+// finalize records the requests left without a result as canceled or expired, recounts the
+// results and ends the batch.
+func (m *MessageManager) finalize(id string, requests []core.MessageBatchRequest, expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.storage.GetMessageBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load message batch %s: %v", id, err)
+		return
+	}
+	results, err := m.recordedResults(id)
+	if err != nil {
+		m.logger.Error("Failed to read results of message batch %s: %v", id, err)
+		return
+	}
+
+	if len(results) < len(requests) {
+		leftover := core.MessageBatchResultExpired
+		switch {
+		case batch.ProcessingStatus == core.MessageBatchStatusCanceling:
+			leftover = core.MessageBatchResultCanceled
+		case time.Now().Before(expiresAt):
+			// Requests still pending without cancellation or expiry; a later run picks them up
+			m.logger.Warn("Message batch %s has %d requests without a result", id, len(requests)-len(results))
+			return
+		}
+		for _, r := range requests {
+			if _, ok := results[r.CustomID]; ok {
+				continue
+			}
+			if err := m.appendResult(id, r.CustomID, core.MessageBatchResult{Type: leftover}); err != nil {
+				m.logger.Error("Failed to record result of message batch %s request %s: %v", id, r.CustomID, err)
+				return
+			}
+			results[r.CustomID] = leftover
+		}
+	}
+
+	counts := core.MessageBatchRequestCounts{}
+	for _, resultType := range results {
+		switch resultType {
+		case core.MessageBatchResultSucceeded:
+			counts.Succeeded++
+		case core.MessageBatchResultErrored:
+			counts.Errored++
+		case core.MessageBatchResultCanceled:
+			counts.Canceled++
+		case core.MessageBatchResultExpired:
+			counts.Expired++
+		}
+	}
+	batch.RequestCounts = counts
+	batch.ProcessingStatus = core.MessageBatchStatusEnded
+	batch.EndedAt = rfc3339Now()
+	if err := m.storage.SaveMessageBatch(batch); err != nil {
+		m.logger.Error("Failed to save message batch %s: %v", id, err)
+		return
+	}
+	m.logger.Info("Message batch %s ended: %d succeeded, %d errored, %d canceled, %d expired",
+		id, counts.Succeeded, counts.Errored, counts.Canceled, counts.Expired)
+}
+
+// recordedResults returns the result type of every request that already has a result, keyed by custom_id.
+func (m *MessageManager) recordedResults(id string) (map[string]string, error) {
+	data, err := m.storage.GetMessageBatchResults(id)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), core.MaxScannerBufferSize)
+	for scanner.Scan() {
+		var line struct {
+			CustomID string `json:"custom_id"`
+			Result   struct {
+				Type string `json:"type"`
+			} `json:"result"`
+		}
+		if err := sonic.Unmarshal(scanner.Bytes(), &line); err == nil && line.CustomID != "" {
+			results[line.CustomID] = line.Result.Type
+		}
+	}
+	return results, scanner.Err()
+}
+
+// update applies fn to the stored batch and saves it.
+func (m *MessageManager) update(id string, fn func(b *core.MessageBatch)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, err := m.storage.GetMessageBatch(id)
+	if err != nil {
+		m.logger.Error("Failed to load message batch %s: %v", id, err)
+		return
+	}
+	fn(batch)
+	if err := m.storage.SaveMessageBatch(batch); err != nil {
+		m.logger.Error("Failed to save message batch %s: %v", id, err)
+	}
+}
+
+func rfc3339Now() *string {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return &now
+}
@@ -0,0 +1,229 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/storage"
+
+	"github.com/bytedance/sonic"
+)
+
+func newTestMessageStorage(t *testing.T) *storage.FileStorage {
+	t.Helper()
+	return storage.NewFileStorage(filepath.Join(t.TempDir(), "stats.json"))
+}
+
+func newTestMessageManager(t *testing.T, store core.MessageBatchStorage, exec Executor) *MessageManager {
+	t.Helper()
+	m := NewMessageManager(MessageConfig{Storage: store, Executor: exec, Concurrency: 2})
+	m.retryInterval = 10 * time.Millisecond
+	if err := m.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func messageRequests(ids ...string) core.MessageBatchCreateRequest {
+	req := core.MessageBatchCreateRequest{}
+	for _, id := range ids {
+		req.Requests = append(req.Requests, core.MessageBatchRequest{
+			CustomID: id,
+			Params: map[string]any{
+				"model":      "claude-sonnet-4",
+				"max_tokens": 16,
+				"messages":   []any{map[string]any{"role": "user", "content": id}},
+			},
+		})
+	}
+	return req
+}
+
+func waitForEnded(t *testing.T, m *MessageManager, id string) *core.MessageBatch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, err := m.Get(id)
+		if err == nil && b.ProcessingStatus == core.MessageBatchStatusEnded {
+			return b
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b, _ := m.Get(id)
+	t.Fatalf("等待批处理结束超时，当前 %+v", b)
+	return nil
+}
+
+// messageResults parses the results JSONL of an ended batch, keyed by custom_id
+func messageResults(t *testing.T, m *MessageManager, id string) map[string]core.MessageBatchResultLine {
+	t.Helper()
+	data, err := m.Results(id)
+	if err != nil {
+		t.Fatalf("读取结果失败: %v", err)
+	}
+	results := make(map[string]core.MessageBatchResultLine)
+	for _, raw := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var line core.MessageBatchResultLine
+		if err := sonic.Unmarshal(raw, &line); err != nil {
+			t.Fatalf("结果行无法解析: %s", raw)
+		}
+		results[line.CustomID] = line
+	}
+	return results
+}
+
+func TestMessageManager_CompletesBatch(t *testing.T) {
+	m := newTestMessageManager(t, newTestMessageStorage(t), echoExecutor)
+
+	b, err := m.Create(messageRequests("a", "bad", "c"))
+	if err != nil {
+		t.Fatalf("创建失败: %v", err)
+	}
+	if b.ProcessingStatus != core.MessageBatchStatusInProgress || b.RequestCounts.Processing != 3 || !strings.HasPrefix(b.ID, core.MessageBatchIDPrefix) {
+		t.Fatalf("新建批处理应处于 in_progress: %+v", b)
+	}
+
+	done := waitForEnded(t, m, b.ID)
+	if done.RequestCounts != (core.MessageBatchRequestCounts{Succeeded: 2, Errored: 1}) || done.EndedAt == nil {
+		t.Errorf("结束状态错误: %+v", done)
+	}
+	results := messageResults(t, m, b.ID)
+	if len(results) != 3 || results["a"].Result.Type != core.MessageBatchResultSucceeded || results["bad"].Result.Type != core.MessageBatchResultErrored || results["bad"].Result.Error == nil {
+		t.Errorf("结果错误: %+v", results)
+	}
+}
+
+func TestMessageManager_CreateValidation(t *testing.T) {
+	m := newTestMessageManager(t, newTestMessageStorage(t), echoExecutor)
+
+	missingParams := messageRequests("a")
+	missingParams.Requests[0].Params = nil
+	tests := []struct {
+		name      string
+		req       core.MessageBatchCreateRequest
+		wantParam string
+	}{
+		{"请求为空", core.MessageBatchCreateRequest{}, "requests"},
+		{"custom_id 含非法字符", messageRequests("a b"), "requests.0.custom_id"},
+		{"custom_id 过长", messageRequests(strings.Repeat("a", core.MessageBatchCustomIDMaxLength+1)), "requests.0.custom_id"},
+		{"custom_id 重复", messageRequests("a", "a"), "requests.1.custom_id"},
+		{"缺少 params", missingParams, "requests.0.params"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Create(tt.req)
+			requestErr, ok := err.(*RequestError)
+			if !ok || requestErr.Param != tt.wantParam {
+				t.Fatalf("期望参数 %s 的 RequestError，实际 %v", tt.wantParam, err)
+			}
+		})
+	}
+}
+
+func TestMessageManager_Cancel(t *testing.T) {
+	release := make(chan struct{})
+	var started atomic.Int32
+	m := newTestMessageManager(t, newTestMessageStorage(t), func(ctx context.Context, body map[string]any) (int, any) {
+		started.Add(1)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return http.StatusOK, map[string]any{}
+	})
+
+	b, _ := m.Create(messageRequests("a", "b", "c", "d", "e"))
+	if _, err := m.Results(b.ID); err == nil {
+		t.Error("未结束的批处理不应返回结果")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for started.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	canceling, err := m.Cancel(b.ID)
+	if err != nil || canceling.ProcessingStatus != core.MessageBatchStatusCanceling || canceling.CancelInitiatedAt == nil {
+		t.Fatalf("取消后应处于 canceling: %+v, %v", canceling, err)
+	}
+	close(release)
+
+	done := waitForEnded(t, m, b.ID)
+	if done.RequestCounts != (core.MessageBatchRequestCounts{Succeeded: 2, Canceled: 3}) {
+		t.Errorf("进行中的请求应完成，其余记为 canceled: %+v", done.RequestCounts)
+	}
+	if results := messageResults(t, m, b.ID); len(results) != 5 {
+		t.Errorf("每个请求都应有结果: %+v", results)
+	}
+	if _, err := m.Cancel(b.ID); err == nil {
+		t.Error("已结束的批处理不能取消")
+	}
+}
+
+func TestMessageManager_ResumesAndExpires(t *testing.T) {
+	store := newTestMessageStorage(t)
+	setup := NewMessageManager(MessageConfig{Storage: store})
+	expiring, _ := setup.Create(messageRequests("a", "b"))
+	resumed, _ := setup.Create(messageRequests("c"))
+	_ = setup.Close()
+
+	expiring.ExpiresAt = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if err := store.SaveMessageBatch(expiring); err != nil {
+		t.Fatalf("保存失败: %v", err)
+	}
+
+	m := newTestMessageManager(t, store, echoExecutor)
+	if done := waitForEnded(t, m, expiring.ID); done.RequestCounts != (core.MessageBatchRequestCounts{Expired: 2}) {
+		t.Errorf("过期批处理的请求应记为 expired: %+v", done.RequestCounts)
+	}
+	if done := waitForEnded(t, m, resumed.ID); done.RequestCounts != (core.MessageBatchRequestCounts{Succeeded: 1}) {
+		t.Errorf("重启后应继续处理未结束的批处理: %+v", done.RequestCounts)
+	}
+}
+
+func TestMessageManager_List(t *testing.T) {
+	store := newTestMessageStorage(t)
+	for i, id := range []string{"msgbatch_a", "msgbatch_b", "msgbatch_c"} {
+		created := time.Unix(int64(1700000000+i), 0).UTC().Format(time.RFC3339)
+		b := &core.MessageBatch{ID: id, Type: core.MessageBatchObjectType, ProcessingStatus: core.MessageBatchStatusEnded, CreatedAt: created, ExpiresAt: created}
+		if err := store.SaveMessageBatch(b); err != nil {
+			t.Fatalf("保存失败: %v", err)
+		}
+	}
+	m := newTestMessageManager(t, store, echoExecutor)
+
+	tests := []struct {
+		name        string
+		beforeID    string
+		afterID     string
+		limit       int
+		wantIDs     []string
+		wantHasMore bool
+	}{
+		{"第一页", "", "", 2, []string{"msgbatch_c", "msgbatch_b"}, true},
+		{"after_id 翻页", "", "msgbatch_b", 2, []string{"msgbatch_a"}, false},
+		{"before_id 翻页", "msgbatch_a", "", 1, []string{"msgbatch_b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := m.List(tt.beforeID, tt.afterID, tt.limit)
+			if err != nil {
+				t.Fatalf("列表失败: %v", err)
+			}
+			var ids []string
+			for _, b := range list.Data {
+				ids = append(ids, b.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") || list.HasMore != tt.wantHasMore {
+				t.Errorf("期望 %v (has_more=%v)，实际 %v (has_more=%v)", tt.wantIDs, tt.wantHasMore, ids, list.HasMore)
+			}
+		})
+	}
+}
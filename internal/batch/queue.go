@@ -0,0 +1,176 @@
+package batch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// queue runs queued jobs one at a time on a dispatcher goroutine. The running job gets a stop
+// channel that is closed when the job is cancelled; ctx is cancelled on shutdown.
+type queue struct {
+	mu      sync.Mutex
+	pending []string
+	running map[string]chan struct{}
+	wake    chan struct{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newQueue() *queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &queue{
+		running: make(map[string]chan struct{}),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// start runs the dispatcher, calling run for each queued job until close.
+func (q *queue) start(run func(id string, stop chan struct{})) {
+	q.wg.Add(1)
+	go q.loop(run)
+}
+
+// close stops the dispatcher and waits for the running job to return.
+func (q *queue) close() {
+	q.closeOnce.Do(func() {
+		q.cancel()
+		q.wg.Wait()
+	})
+}
+
+func (q *queue) enqueue(id string) {
+	q.mu.Lock()
+	q.pending = append(q.pending, id)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop signals the job if it is running; a queued job checks its persisted status when it starts.
+func (q *queue) stop(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if stop, ok := q.running[id]; ok {
+		close(stop)
+		delete(q.running, id)
+	}
+}
+
+// next pops the next queued job and registers its stop channel.
+func (q *queue) next() (string, chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return "", nil, false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	stop := make(chan struct{})
+	q.running[id] = stop
+	return id, stop, true
+}
+
+func (q *queue) loop(run func(id string, stop chan struct{})) {
+	defer q.wg.Done()
+
+	for q.ctx.Err() == nil {
+		id, stop, ok := q.next()
+		if !ok {
+			select {
+			case <-q.wake:
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+
+		run(id, stop)
+
+		q.mu.Lock()
+		delete(q.running, id)
+		q.mu.Unlock()
+	}
+}
+
+// runPool hands items to concurrency workers until all are dispatched or the job stops, then
+// waits for the workers. An item handed over as the job stopped is skipped.
+func runPool[T any](ctx context.Context, concurrency int, items []T, stop chan struct{}, expiresAt time.Time, fn func(item T)) {
+	work := make(chan T)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if stopped(ctx, stop, expiresAt) {
+					continue
+				}
+				fn(item)
+			}
+		}()
+	}
+
+dispatch:
+	for _, item := range items {
+		if stopped(ctx, stop, expiresAt) {
+			break
+		}
+		select {
+		case work <- item:
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+// executeWithRetry runs exec, retrying after interval while the upstream reports exhausted quota
+// or a rate limit (429). ok is false if the job stopped or shut down before a final result;
+// such a request is left without a result.
+func executeWithRetry(ctx context.Context, stop chan struct{}, expiresAt time.Time, interval time.Duration, exec func() (int, any), onRetry func()) (status int, body any, ok bool) {
+	for {
+		status, body = exec()
+		if ctx.Err() != nil {
+			return 0, nil, false
+		}
+		if status != http.StatusTooManyRequests {
+			return status, body, true
+		}
+
+		onRetry()
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-stop:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		if stopped(ctx, stop, expiresAt) {
+			return 0, nil, false
+		}
+	}
+}
+
+// stopped reports whether a job should stop dispatching: on shutdown, cancellation or expiry.
+func stopped(ctx context.Context, stop chan struct{}, expiresAt time.Time) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+	}
+	return ctx.Err() != nil || !time.Now().Before(expiresAt)
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"crypto/sha1" //nolint:gosec // G505: sha1 for cache keys, not security
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"sync"
@@ -274,6 +275,12 @@ func GenerateToolsCacheKey(tools []core.Tool) string {
 	return fmt.Sprintf("tools:%s:%s", core.CacheKeyVersion, hex.EncodeToString(h.Sum(nil)))
 }
 
+// GenerateImageURLCacheKey creates a cache key for a fetched remote image
+func GenerateImageURLCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return fmt.Sprintf("img:%s:%s", core.CacheKeyVersion, hex.EncodeToString(sum[:]))
+}
+
 // GenerateQuotaCacheKey creates a cache key for quota data.
 // Accepts jwt and licenseID directly to avoid accessing account fields without locks.
 func (cs *CacheService) GenerateQuotaCacheKey(jwt string, licenseID string) string {
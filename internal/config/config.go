@@ -3,7 +3,11 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"jetbrainsai2api/internal/core"
@@ -14,14 +18,21 @@ import (
 
 // ServerConfig server configuration
 type ServerConfig struct {
-	Port               string
-	GinMode            string
-	ClientAPIKeys      []string
-	JetbrainsAccounts  []core.JetbrainsAccount
-	ModelsConfigPath   string
-	HTTPClientSettings HTTPClientSettings
-	Storage            core.StorageInterface
-	Logger             core.Logger
+	Port          string
+	GinMode       string
+	ClientAPIKeys []string
+	// ClientAPIKeysFile holds further client API keys and is re-read on every reload
+	ClientAPIKeysFile string
+	JetbrainsAccounts []core.JetbrainsAccount
+	ModelsConfigPath  string
+	// ConfigWatchInterval is how often models.json and the keys file are polled; 0 disables polling
+	ConfigWatchInterval time.Duration
+	HTTPClientSettings  HTTPClientSettings
+	ImageFetch          ImageFetchSettings
+	Batch               BatchSettings
+	Storage             core.StorageInterface
+	BatchStore          core.BatchStore
+	Logger              core.Logger
 }
 
 // HTTPClientSettings HTTP client configuration
@@ -46,15 +57,80 @@ func DefaultHTTPClientSettings() HTTPClientSettings {
 	}
 }
 
+// ImageFetchSettings remote image fetching configuration
+type ImageFetchSettings struct {
+	AllowedHosts []string
+	Timeout      time.Duration
+	MaxBytes     int64
+}
+
+// LoadImageFetchSettingsFromEnv loads remote image fetch settings from environment variables
+func LoadImageFetchSettingsFromEnv(logger core.Logger) ImageFetchSettings {
+	settings := ImageFetchSettings{
+		AllowedHosts: util.ParseEnvList(os.Getenv("IMAGE_FETCH_ALLOWED_HOSTS")),
+		Timeout:      core.DefaultImageFetchTimeout,
+		MaxBytes:     core.DefaultImageFetchMaxBytes,
+	}
+
+	if envTimeout := os.Getenv("IMAGE_FETCH_TIMEOUT"); envTimeout != "" {
+		if timeout, err := time.ParseDuration(envTimeout); err == nil && timeout > 0 {
+			settings.Timeout = timeout
+		} else {
+			logger.Warn("Invalid IMAGE_FETCH_TIMEOUT value '%s', using default %s", envTimeout, core.DefaultImageFetchTimeout)
+		}
+	}
+
+	if envMaxBytes := os.Getenv("IMAGE_FETCH_MAX_BYTES"); envMaxBytes != "" {
+		if maxBytes, err := strconv.ParseInt(envMaxBytes, 10, 64); err == nil && maxBytes > 0 && maxBytes <= core.MaxImageSizeBytes {
+			settings.MaxBytes = maxBytes
+		} else {
+			logger.Warn("Invalid IMAGE_FETCH_MAX_BYTES value '%s', using default %d", envMaxBytes, core.DefaultImageFetchMaxBytes)
+		}
+	}
+
+	if len(settings.AllowedHosts) > 0 {
+		logger.Info("Remote image fetching enabled for hosts: %v", settings.AllowedHosts)
+	}
+
+	return settings
+}
+
+// BatchSettings batch processing configuration
+type BatchSettings struct {
+	// Concurrency is the number of batch requests run in parallel; capped at the account count
+	Concurrency int
+}
+
+// LoadBatchSettingsFromEnv loads batch processing settings from environment variables
+func LoadBatchSettingsFromEnv(logger core.Logger) BatchSettings {
+	settings := BatchSettings{Concurrency: core.DefaultBatchConcurrency}
+
+	if envConcurrency := os.Getenv("BATCH_CONCURRENCY"); envConcurrency != "" {
+		if concurrency, err := strconv.Atoi(envConcurrency); err == nil && concurrency > 0 {
+			settings.Concurrency = concurrency
+		} else {
+			logger.Warn("Invalid BATCH_CONCURRENCY value '%s', using default %d", envConcurrency, core.DefaultBatchConcurrency)
+		}
+	}
+
+	return settings
+}
+
 // LoadModels loads model data for API response
 func LoadModels(path string, logger core.Logger) (core.ModelList, error) {
-	var result core.ModelList
-
 	config, err := LoadModelsConfig(path)
 	if err != nil {
-		return result, err
+		return core.ModelList{}, err
 	}
 
+	logger.Info("Loaded %d models from %s", len(config.Models), path)
+	return BuildModelList(config), nil
+}
+
+// BuildModelList builds the models list response of a models config, sorted by model ID
+func BuildModelList(config core.ModelsConfig) core.ModelList {
+	result := core.ModelList{Object: core.ModelListObjectType}
+
 	now := time.Now().Unix()
 	modelKeys := make([]string, 0, len(config.Models))
 	for modelKey := range config.Models {
@@ -63,21 +139,33 @@ func LoadModels(path string, logger core.Logger) (core.ModelList, error) {
 	sort.Strings(modelKeys)
 
 	for _, modelKey := range modelKeys {
-		result.Data = append(result.Data, core.ModelInfo{
-			ID:      modelKey,
-			Object:  core.ModelObjectType,
-			Created: now,
-			OwnedBy: core.ModelOwner,
-		})
+		result.Data = append(result.Data, modelInfo(modelKey, config.Models[modelKey], now))
 	}
+	return result
+}
 
-	result.Object = core.ModelListObjectType
-
-	logger.Info("Loaded %d models from %s", len(config.Models), path)
-	return result, nil
+// modelInfo builds the models list entry of a model from its models.json entry
+func modelInfo(id string, spec core.ModelSpec, created int64) core.ModelInfo {
+	info := core.ModelInfo{
+		ID:              id,
+		Object:          core.ModelObjectType,
+		Created:         created,
+		OwnedBy:         core.ModelOwner,
+		DisplayName:     spec.DisplayName,
+		ContextWindow:   spec.ContextWindow,
+		MaxOutputTokens: spec.MaxOutputTokens,
+		DeprecationDate: spec.DeprecationDate,
+	}
+	if spec.Vision != nil || spec.Tools != nil || spec.Reasoning != nil {
+		capabilities := spec.ModelCapabilities
+		info.Capabilities = &capabilities
+	}
+	return info
 }
 
-// LoadModelsConfig loads model configuration mapping
+// LoadModelsConfig loads model configuration mapping. Each model maps to its JetBrains profile,
+// either as a string or as an object with capabilities; a bare array of IDs maps every model to
+// itself.
 func LoadModelsConfig(path string) (core.ModelsConfig, error) {
 	var config core.ModelsConfig
 
@@ -91,19 +179,104 @@ func LoadModelsConfig(path string) (core.ModelsConfig, error) {
 		if err := sonic.Unmarshal(data, &modelIDs); err != nil {
 			return config, fmt.Errorf("failed to parse %s: %w", path, err)
 		}
-		config.Models = make(map[string]string)
+		config.Models = make(map[string]core.ModelSpec)
 		for _, modelID := range modelIDs {
-			config.Models[modelID] = modelID
+			config.Models[modelID] = core.ModelSpec{Profile: modelID}
 		}
 	}
 
 	if config.Models == nil {
-		config.Models = make(map[string]string)
+		config.Models = make(map[string]core.ModelSpec)
+	}
+
+	for modelID, spec := range config.Models {
+		if err := validateModelSpec(spec); err != nil {
+			return config, fmt.Errorf("model %q in %s: %w", modelID, path, err)
+		}
+		if spec.Profile == "" {
+			spec.Profile = modelID
+			config.Models[modelID] = spec
+		}
+	}
+
+	for deployment, modelID := range config.Deployments {
+		if _, ok := config.Models[modelID]; !ok {
+			return config, fmt.Errorf("deployment %q references unknown model %q in %s", deployment, modelID, path)
+		}
+	}
+
+	for modelID, variants := range config.Variants {
+		if _, ok := config.Models[modelID]; !ok {
+			return config, fmt.Errorf("variants reference unknown model %q in %s", modelID, path)
+		}
+		for effort, variant := range variants {
+			if !slices.Contains(core.ReasoningEfforts, effort) {
+				return config, fmt.Errorf("model %q has variant %q in %s; expected one of %v", modelID, effort, path, core.ReasoningEfforts)
+			}
+			if variant.BudgetTokens < 0 {
+				return config, fmt.Errorf("variant %q of model %q has negative budget_tokens in %s", effort, modelID, path)
+			}
+		}
+	}
+
+	if err := validateModelRouting(config); err != nil {
+		return config, fmt.Errorf("%w in %s", err, path)
 	}
 
 	return config, nil
 }
 
+// validateModelRouting checks that aliases and fallbacks lead to configured models
+func validateModelRouting(config core.ModelsConfig) error {
+	for alias, modelID := range config.Aliases {
+		if _, ok := config.Models[alias]; ok {
+			return fmt.Errorf("alias %q shadows a configured model", alias)
+		}
+		if _, err := path.Match(alias, ""); err != nil {
+			return fmt.Errorf("alias %q is not a valid pattern: %w", alias, err)
+		}
+		if _, ok := config.Models[modelID]; !ok {
+			return fmt.Errorf("alias %q references unknown model %q", alias, modelID)
+		}
+	}
+
+	for modelID, fallbacks := range config.Fallbacks {
+		if _, ok := config.Models[modelID]; !ok {
+			return fmt.Errorf("fallbacks reference unknown model %q", modelID)
+		}
+		for i, fallback := range fallbacks {
+			if _, ok := config.Models[fallback]; !ok {
+				return fmt.Errorf("model %q falls back to unknown model %q", modelID, fallback)
+			}
+			if fallback == modelID || slices.Contains(fallbacks[:i], fallback) {
+				return fmt.Errorf("model %q lists fallback %q more than once", modelID, fallback)
+			}
+		}
+	}
+	return nil
+}
+
+// validateModelSpec checks the values of a models.json entry
+func validateModelSpec(spec core.ModelSpec) error {
+	switch spec.Endpoint {
+	case "", core.ModelEndpointChat, core.ModelEndpointResponses:
+	default:
+		return fmt.Errorf("unknown endpoint %q; expected %q or %q", spec.Endpoint, core.ModelEndpointChat, core.ModelEndpointResponses)
+	}
+	if spec.ContextWindow < 0 || spec.MaxOutputTokens < 0 {
+		return fmt.Errorf("context_window and max_output_tokens must not be negative")
+	}
+	if spec.ContextWindow > 0 && spec.MaxOutputTokens > spec.ContextWindow {
+		return fmt.Errorf("max_output_tokens %d exceeds context_window %d", spec.MaxOutputTokens, spec.ContextWindow)
+	}
+	if spec.DeprecationDate != "" {
+		if _, err := time.Parse(core.ModelDeprecationDateLayout, spec.DeprecationDate); err != nil {
+			return fmt.Errorf("deprecation_date %q is not a YYYY-MM-DD date", spec.DeprecationDate)
+		}
+	}
+	return nil
+}
+
 // GetModelItem finds a model by ID
 func GetModelItem(modelsData core.ModelList, modelID string) *core.ModelInfo {
 	for _, model := range modelsData.Data {
@@ -116,17 +289,43 @@ func GetModelItem(modelsData core.ModelList, modelID string) *core.ModelInfo {
 
 // GetModelsConfig loads both ModelList and ModelsConfig
 func GetModelsConfig(path string, logger core.Logger) (core.ModelList, core.ModelsConfig, error) {
-	modelsData, err := LoadModels(path, logger)
+	modelsConfig, err := LoadModelsConfig(path)
 	if err != nil {
-		return modelsData, core.ModelsConfig{}, fmt.Errorf("failed to load models: %w", err)
+		return core.ModelList{}, core.ModelsConfig{}, fmt.Errorf("failed to load model mappings: %w", err)
 	}
 
-	modelsConfig, err := LoadModelsConfig(path)
-	if err != nil {
-		return modelsData, core.ModelsConfig{}, fmt.Errorf("failed to load model mappings: %w", err)
+	logger.Info("Loaded %d models from %s", len(modelsConfig.Models), path)
+	if len(modelsConfig.Parameters) == 0 {
+		logger.Info("No parameters rules in %s: sampling parameters are not forwarded upstream", path)
+	}
+	return BuildModelList(modelsConfig), modelsConfig, nil
+}
+
+// LoadClientAPIKeys returns the client API keys: those of CLIENT_API_KEYS plus, when a keys file
+// is configured, those of the file, one or more comma-separated per line. Blank lines and lines
+// starting with # are skipped.
+func LoadClientAPIKeys(envKeys []string, path string) ([]string, error) {
+	keys := slices.Clone(envKeys)
+	if path == "" {
+		return keys, nil
 	}
 
-	return modelsData, modelsConfig, nil
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path from config, not user input
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, key := range util.ParseEnvList(line) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
 }
 
 // LoadServerConfigFromEnv loads server config from environment variables
@@ -145,16 +344,29 @@ func LoadServerConfigFromEnv(logger core.Logger) (ServerConfig, error) {
 		logger.Info("Loaded %d JetBrains accounts", len(jetbrainsAccounts))
 	}
 
+	configWatchInterval := core.DefaultConfigWatchInterval
+	if envInterval := os.Getenv("CONFIG_WATCH_INTERVAL"); envInterval != "" {
+		if interval, err := time.ParseDuration(envInterval); err == nil && interval >= 0 {
+			configWatchInterval = interval
+		} else {
+			logger.Warn("Invalid CONFIG_WATCH_INTERVAL value '%s', using default %s", envInterval, core.DefaultConfigWatchInterval)
+		}
+	}
+
 	port := util.GetEnvWithDefault("PORT", core.DefaultPort)
 	ginMode := util.GetEnvWithDefault("GIN_MODE", core.DefaultGinMode)
 
 	config := ServerConfig{
-		Port:               port,
-		GinMode:            ginMode,
-		ClientAPIKeys:      clientAPIKeys,
-		JetbrainsAccounts:  jetbrainsAccounts,
-		ModelsConfigPath:   core.DefaultModelsConfigPath,
-		HTTPClientSettings: DefaultHTTPClientSettings(),
+		Port:                port,
+		GinMode:             ginMode,
+		ClientAPIKeys:       clientAPIKeys,
+		ClientAPIKeysFile:   os.Getenv("CLIENT_API_KEYS_FILE"),
+		JetbrainsAccounts:   jetbrainsAccounts,
+		ModelsConfigPath:    core.DefaultModelsConfigPath,
+		ConfigWatchInterval: configWatchInterval,
+		HTTPClientSettings:  DefaultHTTPClientSettings(),
+		ImageFetch:          LoadImageFetchSettingsFromEnv(logger),
+		Batch:               LoadBatchSettingsFromEnv(logger),
 	}
 
 	return config, nil
@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"jetbrainsai2api/internal/core"
 )
@@ -29,8 +31,8 @@ func TestLoadModelsConfig_ValidJSON(t *testing.T) {
 		t.Errorf("Expected 2 models, got %d", len(config.Models))
 	}
 
-	if config.Models["gpt-4"] != "openai-gpt-4" {
-		t.Errorf("Expected 'openai-gpt-4' for 'gpt-4', got '%s'", config.Models["gpt-4"])
+	if config.Models["gpt-4"].Profile != "openai-gpt-4" {
+		t.Errorf("Expected 'openai-gpt-4' for 'gpt-4', got '%s'", config.Models["gpt-4"].Profile)
 	}
 }
 
@@ -46,11 +48,166 @@ func TestLoadModelsConfig_ArrayFormat(t *testing.T) {
 		t.Errorf("Expected 2 models, got %d", len(config.Models))
 	}
 
-	if config.Models["model-a"] != "model-a" {
+	if config.Models["model-a"].Profile != "model-a" {
 		t.Errorf("Array format: Expected identity mapping for 'model-a'")
 	}
 }
 
+func TestLoadModelsConfig_StructuredModels(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"对象与字符串混用", `{"models":{"gpt-5.3-codex":{"profile":"openai-gpt-5-3-codex","endpoint":"responses","context_window":400000,` +
+			`"max_output_tokens":128000,"vision":false,"tools":true,"display_name":"GPT-5.3 Codex","deprecation_date":"2027-01-31"},"gpt-4o":"openai-gpt-4o"}}`, false},
+		{"未知端点", `{"models":{"gpt-4o":{"profile":"openai-gpt-4o","endpoint":"completions"}}}`, true},
+		{"最大输出超过上下文窗口", `{"models":{"gpt-4o":{"context_window":1000,"max_output_tokens":2000}}}`, true},
+		{"非法弃用日期", `{"models":{"gpt-4o":{"deprecation_date":"next year"}}}`, true},
+		{"非法条目类型", `{"models":{"gpt-4o":42}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadModelsConfig(createModelsTempFile(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadModelsConfig failed: %v", err)
+			}
+			spec := config.Models["gpt-5.3-codex"]
+			if spec.Profile != "openai-gpt-5-3-codex" || spec.Endpoint != core.ModelEndpointResponses || spec.ContextWindow != 400000 {
+				t.Errorf("结构化条目解析错误: %+v", spec)
+			}
+			if spec.SupportsVision() || !spec.SupportsTools() || !spec.SupportsReasoning() {
+				t.Errorf("能力解析错误: %+v", spec.ModelCapabilities)
+			}
+			if config.Models["gpt-4o"].Profile != "openai-gpt-4o" || !config.Models["gpt-4o"].SupportsVision() {
+				t.Errorf("字符串条目应只设置 profile: %+v", config.Models["gpt-4o"])
+			}
+		})
+	}
+}
+
+func TestLoadModelsConfig_DefaultProfile(t *testing.T) {
+	config, err := LoadModelsConfig(createModelsTempFile(t, `{"models":{"qwen-max":{"display_name":"Qwen Max"}}}`))
+	if err != nil {
+		t.Fatalf("LoadModelsConfig failed: %v", err)
+	}
+	if config.Models["qwen-max"].Profile != "qwen-max" {
+		t.Errorf("未配置 profile 时应使用模型 ID，实际 %q", config.Models["qwen-max"].Profile)
+	}
+}
+
+func TestLoadModelsConfig_ParameterRules(t *testing.T) {
+	filePath := createModelsTempFile(t, `{"models":{"o3":"openai-o3"},"parameters":{"openai-o3":["max_tokens"]}}`)
+
+	config, err := LoadModelsConfig(filePath)
+	if err != nil {
+		t.Fatalf("LoadModelsConfig failed: %v", err)
+	}
+
+	rule := config.Parameters["openai-o3"]
+	if len(rule) != 1 || rule[0] != core.SamplingParamMaxTokens {
+		t.Errorf("Expected parameter rule [max_tokens] for 'openai-o3', got %v", rule)
+	}
+}
+
+func TestLoadModelsConfig_Deployments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"部署映射到已配置模型", `{"models":{"gpt-4o":"openai-gpt-4o"},"deployments":{"prod-chat":"gpt-4o"}}`, false},
+		{"部署引用未知模型", `{"models":{"gpt-4o":"openai-gpt-4o"},"deployments":{"prod-chat":"gpt-5"}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadModelsConfig(createModelsTempFile(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadModelsConfig failed: %v", err)
+			}
+			if config.Deployments["prod-chat"] != "gpt-4o" {
+				t.Errorf("期望部署 prod-chat 映射到 gpt-4o，实际 %v", config.Deployments)
+			}
+		})
+	}
+}
+
+func TestLoadModelsConfig_Variants(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"按推理强度配置变体", `{"models":{"o3":"openai-o3"},"variants":{"o3":{"low":{"profile":"openai-o3-low"},"high":{"budget_tokens":16000}}}}`, false},
+		{"变体引用未知模型", `{"models":{"o3":"openai-o3"},"variants":{"o4":{"low":{"profile":"openai-o4-low"}}}}`, true},
+		{"未知的推理强度", `{"models":{"o3":"openai-o3"},"variants":{"o3":{"turbo":{"profile":"openai-o3-turbo"}}}}`, true},
+		{"负数预算", `{"models":{"o3":"openai-o3"},"variants":{"o3":{"high":{"budget_tokens":-1}}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadModelsConfig(createModelsTempFile(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadModelsConfig failed: %v", err)
+			}
+			if config.Variants["o3"]["low"].Profile != "openai-o3-low" || config.Variants["o3"]["high"].BudgetTokens != 16000 {
+				t.Errorf("变体解析错误: %+v", config.Variants)
+			}
+		})
+	}
+}
+
+func TestLoadModelsConfig_Routing(t *testing.T) {
+	const models = `"models":{"claude-opus-4-6":"anthropic-claude-4-6-opus","claude-sonnet-4-6":"anthropic-claude-4-6-sonnet","gpt-5.4":"openai-gpt-5-4"}`
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"别名与回退链", `{` + models + `,"aliases":{"claude-latest":"claude-opus-4-6","gpt-*":"gpt-5.4"},"fallbacks":{"claude-opus-4-6":["claude-sonnet-4-6","gpt-5.4"]}}`, false},
+		{"别名引用未知模型", `{` + models + `,"aliases":{"claude-latest":"claude-5"}}`, true},
+		{"别名遮蔽已配置模型", `{` + models + `,"aliases":{"gpt-5.4":"claude-opus-4-6"}}`, true},
+		{"非法通配符", `{` + models + `,"aliases":{"gpt-[":"gpt-5.4"}}`, true},
+		{"回退到未知模型", `{` + models + `,"fallbacks":{"claude-opus-4-6":["claude-5"]}}`, true},
+		{"回退到自身", `{` + models + `,"fallbacks":{"gpt-5.4":["gpt-5.4"]}}`, true},
+		{"重复的回退模型", `{` + models + `,"fallbacks":{"gpt-5.4":["claude-opus-4-6","claude-opus-4-6"]}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadModelsConfig(createModelsTempFile(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadModelsConfig failed: %v", err)
+			}
+			if config.Aliases["gpt-*"] != "gpt-5.4" || len(config.Fallbacks["claude-opus-4-6"]) != 2 {
+				t.Errorf("路由配置解析错误: aliases=%v fallbacks=%v", config.Aliases, config.Fallbacks)
+			}
+		})
+	}
+}
+
 func TestLoadModelsConfig_NonExistentFile(t *testing.T) {
 	_, err := LoadModelsConfig("/tmp/nonexistent_models_file_12345.json")
 	if err == nil {
@@ -71,6 +228,28 @@ func TestLoadModels(t *testing.T) {
 	}
 }
 
+func TestLoadModels_Metadata(t *testing.T) {
+	filePath := createModelsTempFile(t, `{"models":{"gpt-4o":"openai-gpt-4o",`+
+		`"o3":{"profile":"openai-o3","display_name":"o3","context_window":200000,"max_output_tokens":100000,"vision":true,"deprecation_date":"2027-06-30"}}}`)
+
+	modelsData, err := LoadModels(filePath, &core.NopLogger{})
+	if err != nil {
+		t.Fatalf("LoadModels failed: %v", err)
+	}
+
+	plain := GetModelItem(modelsData, "gpt-4o")
+	if plain == nil || plain.Capabilities != nil || plain.DisplayName != "" {
+		t.Errorf("字符串条目不应带元数据: %+v", plain)
+	}
+	o3 := GetModelItem(modelsData, "o3")
+	if o3 == nil || o3.DisplayName != "o3" || o3.ContextWindow != 200000 || o3.MaxOutputTokens != 100000 || o3.DeprecationDate != "2027-06-30" {
+		t.Fatalf("元数据错误: %+v", o3)
+	}
+	if o3.Capabilities == nil || o3.Capabilities.Vision == nil || !*o3.Capabilities.Vision || o3.Capabilities.Tools != nil {
+		t.Errorf("能力应只包含已声明的项: %+v", o3.Capabilities)
+	}
+}
+
 func TestGetModelItem(t *testing.T) {
 	modelsData := core.ModelList{
 		Data: []core.ModelInfo{
@@ -93,6 +272,43 @@ func TestGetModelItem(t *testing.T) {
 	}
 }
 
+func TestLoadClientAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(keysPath, []byte("# team keys\nkey-b, key-c\n\n  key-a  \n"), core.FilePermissionReadWrite); err != nil {
+		t.Fatalf("写入临时文件失败: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		envKeys  []string
+		path     string
+		expected []string
+		wantErr  bool
+	}{
+		{"仅环境变量", []string{"key-a"}, "", []string{"key-a"}, false},
+		{"合并文件并去重", []string{"key-a"}, keysPath, []string{"key-a", "key-b", "key-c"}, false},
+		{"文件不存在", []string{"key-a"}, filepath.Join(dir, "missing.txt"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := LoadClientAPIKeys(tt.envKeys, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("期望返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("不期望错误: %v", err)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("期望 %v，实际 %v", tt.expected, keys)
+			}
+		})
+	}
+}
+
 func TestDefaultHTTPClientSettings(t *testing.T) {
 	settings := DefaultHTTPClientSettings()
 	if settings.MaxIdleConns <= 0 {
@@ -102,3 +318,43 @@ func TestDefaultHTTPClientSettings(t *testing.T) {
 		t.Error("RequestTimeout should be positive")
 	}
 }
+
+func TestLoadImageFetchSettingsFromEnv(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		expectedHosts   int
+		expectedTimeout time.Duration
+		expectedMax     int64
+	}{
+		{"未配置时禁用远程图片", map[string]string{}, 0, core.DefaultImageFetchTimeout, core.DefaultImageFetchMaxBytes},
+		{
+			"完整配置",
+			map[string]string{"IMAGE_FETCH_ALLOWED_HOSTS": "example.com, *.cdn.example.com", "IMAGE_FETCH_TIMEOUT": "3s", "IMAGE_FETCH_MAX_BYTES": "1024"},
+			2, 3 * time.Second, 1024,
+		},
+		{
+			"非法值回退默认",
+			map[string]string{"IMAGE_FETCH_TIMEOUT": "soon", "IMAGE_FETCH_MAX_BYTES": "999999999999"},
+			0, core.DefaultImageFetchTimeout, core.DefaultImageFetchMaxBytes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"IMAGE_FETCH_ALLOWED_HOSTS", "IMAGE_FETCH_TIMEOUT", "IMAGE_FETCH_MAX_BYTES"} {
+				t.Setenv(key, tt.env[key])
+			}
+			settings := LoadImageFetchSettingsFromEnv(&core.NopLogger{})
+			if len(settings.AllowedHosts) != tt.expectedHosts {
+				t.Errorf("期望 %d 个允许主机，实际 %v", tt.expectedHosts, settings.AllowedHosts)
+			}
+			if settings.Timeout != tt.expectedTimeout {
+				t.Errorf("期望超时 %s，实际 %s", tt.expectedTimeout, settings.Timeout)
+			}
+			if settings.MaxBytes != tt.expectedMax {
+				t.Errorf("期望最大字节 %d，实际 %d", tt.expectedMax, settings.MaxBytes)
+			}
+		})
+	}
+}
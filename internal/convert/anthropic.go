@@ -6,11 +6,14 @@ import (
 
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/util"
+	"jetbrainsai2api/internal/validate"
 )
 
-// AnthropicToJetbrainsMessages converts Anthropic messages to JetBrains format
-func AnthropicToJetbrainsMessages(anthMessages []core.AnthropicMessage) []core.JetbrainsMessage {
+// AnthropicToJetbrainsMessages converts Anthropic messages to JetBrains format.
+// Image blocks become media messages; an invalid image fails the whole conversion.
+func AnthropicToJetbrainsMessages(anthMessages []core.AnthropicMessage) ([]core.JetbrainsMessage, error) {
 	var jetbrainsMessages []core.JetbrainsMessage
+	validator := validate.NewImageValidator()
 
 	// First pass: build tool ID to name mapping
 	toolIDToName := make(map[string]string)
@@ -34,15 +37,13 @@ func AnthropicToJetbrainsMessages(anthMessages []core.AnthropicMessage) []core.J
 
 	// Second pass: convert messages
 	for _, msg := range anthMessages {
-		if msg.Role == core.RoleUser && HasContentBlockType(msg.Content, core.ContentBlockTypeToolResult) {
-			toolMessages, textContent := extractMixedContent(msg.Content, toolIDToName)
-			jetbrainsMessages = append(jetbrainsMessages, toolMessages...)
-			if textContent != "" {
-				jetbrainsMessages = append(jetbrainsMessages, core.JetbrainsMessage{
-					Type:    core.JetBrainsMessageTypeUser,
-					Content: textContent,
-				})
+		if msg.Role == core.RoleUser && (HasContentBlockType(msg.Content, core.ContentBlockTypeToolResult) ||
+			HasContentBlockType(msg.Content, core.ContentBlockTypeImage)) {
+			mixedMessages, err := extractMixedContent(msg.Content, toolIDToName, validator)
+			if err != nil {
+				return nil, err
 			}
+			jetbrainsMessages = append(jetbrainsMessages, mixedMessages...)
 			continue
 		}
 
@@ -51,6 +52,8 @@ func AnthropicToJetbrainsMessages(anthMessages []core.AnthropicMessage) []core.J
 		case core.RoleUser:
 			messageType = core.JetBrainsMessageTypeUser
 		case core.RoleAssistant:
+			// Thinking goes back ahead of the text and tool calls it led to
+			jetbrainsMessages = append(jetbrainsMessages, extractThinkingBlocks(msg.Content)...)
 			if HasContentBlockType(msg.Content, core.ContentBlockTypeToolUse) {
 				// Extract text content first — if both text and tool_use exist, preserve both
 				textContent := extractTextFromContentBlocks(msg.Content)
@@ -97,7 +100,7 @@ func AnthropicToJetbrainsMessages(anthMessages []core.AnthropicMessage) []core.J
 		jetbrainsMessages = append(jetbrainsMessages, jetbrainsMessage)
 	}
 
-	return jetbrainsMessages
+	return jetbrainsMessages, nil
 }
 
 // AnthropicToJetbrainsTools converts Anthropic tool definitions to JetBrains format
@@ -161,6 +164,42 @@ func extractTextFromContentBlocks(content any) string {
 	return strings.Join(textParts, " ")
 }
 
+// extractThinkingBlocks converts the thinking and redacted_thinking blocks of an assistant message
+// into reasoning messages, keeping their signatures; redacted thinking is passed on as opaque data
+func extractThinkingBlocks(content any) []core.JetbrainsMessage {
+	contentArray, ok := content.([]any)
+	if !ok {
+		return nil
+	}
+	var messages []core.JetbrainsMessage
+	for _, block := range contentArray {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch blockType, _ := blockMap["type"].(string); blockType {
+		case core.ContentBlockTypeThinking:
+			thinking, _ := blockMap["thinking"].(string)
+			signature, _ := blockMap["signature"].(string)
+			if thinking != "" {
+				messages = append(messages, core.JetbrainsMessage{
+					Type:      core.JetBrainsMessageTypeAssistantReasoning,
+					Content:   thinking,
+					Signature: signature,
+				})
+			}
+		case core.ContentBlockTypeRedactedThinking:
+			if data, _ := blockMap["data"].(string); data != "" {
+				messages = append(messages, core.JetbrainsMessage{
+					Type: core.JetBrainsMessageTypeAssistantReasoning,
+					Data: data,
+				})
+			}
+		}
+	}
+	return messages
+}
+
 // HasContentBlockType checks if content has a specific block type
 func HasContentBlockType(content any, targetType string) bool {
 	if contentArray, ok := content.([]any); ok {
@@ -175,17 +214,41 @@ func HasContentBlockType(content any, targetType string) bool {
 	return false
 }
 
-func extractMixedContent(content any, toolIDToName map[string]string) ([]core.JetbrainsMessage, string) {
+// extractMixedContent converts a user message holding tool_result, text and image blocks.
+// Tool messages come first so they stay adjacent to the assistant tool calls; text and images
+// (including images nested in tool_result content) follow in their original order.
+func extractMixedContent(content any, toolIDToName map[string]string, validator *validate.ImageValidator) ([]core.JetbrainsMessage, error) {
 	var toolMessages []core.JetbrainsMessage
+	var trailing []core.JetbrainsMessage
 	var textParts []string
 
+	flushText := func() {
+		if len(textParts) == 0 {
+			return
+		}
+		trailing = append(trailing, core.JetbrainsMessage{
+			Type:    core.JetBrainsMessageTypeUser,
+			Content: strings.Join(textParts, " "),
+		})
+		textParts = nil
+	}
+	appendImage := func(blockMap map[string]any) error {
+		mediaMsg, err := convertAnthropicImageBlock(blockMap, validator)
+		if err != nil {
+			return err
+		}
+		flushText()
+		trailing = append(trailing, mediaMsg)
+		return nil
+	}
+
 	if contentArray, ok := content.([]any); ok {
 		for _, block := range contentArray {
 			if blockMap, ok := block.(map[string]any); ok {
 				blockType, _ := blockMap["type"].(string)
 
-				//nolint:staticcheck // QF1003: if-else is more readable here
-				if blockType == core.ContentBlockTypeToolResult {
+				switch blockType {
+				case core.ContentBlockTypeToolResult:
 					toolMsg := core.JetbrainsMessage{
 						Type:    core.JetBrainsMessageTypeTool,
 						Content: "",
@@ -207,6 +270,12 @@ func extractMixedContent(content any, toolIDToName map[string]string) ([]core.Je
 							var resultParts []string
 							for _, part := range resultArray {
 								if partMap, ok := part.(map[string]any); ok {
+									if partType, _ := partMap["type"].(string); partType == core.ContentBlockTypeImage {
+										if err := appendImage(partMap); err != nil {
+											return nil, err
+										}
+										continue
+									}
 									if text, ok := partMap["text"].(string); ok {
 										resultParts = append(resultParts, text)
 									}
@@ -222,7 +291,12 @@ func extractMixedContent(content any, toolIDToName map[string]string) ([]core.Je
 
 					toolMessages = append(toolMessages, toolMsg)
 
-				} else if blockType == core.ContentBlockTypeText {
+				case core.ContentBlockTypeImage:
+					if err := appendImage(blockMap); err != nil {
+						return nil, err
+					}
+
+				case core.ContentBlockTypeText:
 					if text, ok := blockMap["text"].(string); ok && text != "" {
 						textParts = append(textParts, text)
 					}
@@ -231,8 +305,33 @@ func extractMixedContent(content any, toolIDToName map[string]string) ([]core.Je
 		}
 	}
 
-	textContent := strings.Join(textParts, " ")
-	return toolMessages, textContent
+	flushText()
+	return append(toolMessages, trailing...), nil
+}
+
+// convertAnthropicImageBlock converts an Anthropic base64 image block into a JetBrains media message
+func convertAnthropicImageBlock(block map[string]any, validator *validate.ImageValidator) (core.JetbrainsMessage, error) {
+	source, ok := block["source"].(map[string]any)
+	if !ok {
+		return core.JetbrainsMessage{}, fmt.Errorf("image block is missing source")
+	}
+
+	sourceType, _ := source["type"].(string)
+	if sourceType != core.ImageSourceTypeBase64 {
+		return core.JetbrainsMessage{}, fmt.Errorf("unsupported image source type: %q", sourceType)
+	}
+
+	mediaType, _ := source["media_type"].(string)
+	data, _ := source["data"].(string)
+	if err := validator.ValidateImageData(mediaType, data); err != nil {
+		return core.JetbrainsMessage{}, fmt.Errorf("invalid image: %w", err)
+	}
+
+	return core.JetbrainsMessage{
+		Type:      core.JetBrainsMessageTypeMedia,
+		MediaType: mediaType,
+		Data:      data,
+	}, nil
 }
 
 // ExtractToolInfo extracts tool info from message content
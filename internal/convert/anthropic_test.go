@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/validate"
 )
 
 func TestExtractAllToolUse(t *testing.T) {
@@ -100,7 +101,10 @@ func TestAnthropicToJetbrainsMessages_MultipleToolUse(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := AnthropicToJetbrainsMessages(tt.messages)
+			result, err := AnthropicToJetbrainsMessages(tt.messages)
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
 			if len(result) != tt.expectedCount {
 				t.Errorf("期望生成 %d 个消息，实际生成 %d 个", tt.expectedCount, len(result))
 				return
@@ -120,6 +124,138 @@ func TestAnthropicToJetbrainsMessages_MultipleToolUse(t *testing.T) {
 	}
 }
 
+func TestAnthropicToJetbrainsMessages_Thinking(t *testing.T) {
+	messages := []core.AnthropicMessage{{
+		Role: core.RoleAssistant,
+		Content: []any{
+			map[string]any{"type": core.ContentBlockTypeThinking, "thinking": "查询天气", "signature": "sig_1"},
+			map[string]any{"type": core.ContentBlockTypeRedactedThinking, "data": "opaque"},
+			map[string]any{"type": core.ContentBlockTypeText, "text": "稍等"},
+			map[string]any{"type": core.ContentBlockTypeToolUse, "id": "toolu_01", "name": "get_weather"},
+		},
+	}}
+
+	result, err := AnthropicToJetbrainsMessages(messages)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	expectedTypes := []string{
+		core.JetBrainsMessageTypeAssistantReasoning,
+		core.JetBrainsMessageTypeAssistantReasoning,
+		core.JetBrainsMessageTypeAssistantText,
+		core.JetBrainsMessageTypeAssistantTool,
+	}
+	if len(result) != len(expectedTypes) {
+		t.Fatalf("期望 %d 个消息，实际 %d 个: %+v", len(expectedTypes), len(result), result)
+	}
+	for i, expected := range expectedTypes {
+		if result[i].Type != expected {
+			t.Errorf("消息 %d 类型错误，期望 '%s'，实际 '%s'", i, expected, result[i].Type)
+		}
+	}
+	if result[0].Content != "查询天气" || result[0].Signature != "sig_1" {
+		t.Errorf("thinking 块应保留内容与签名，实际 %+v", result[0])
+	}
+	if result[1].Data != "opaque" || result[1].Content != "" {
+		t.Errorf("redacted_thinking 块应以 data 传回，实际 %+v", result[1])
+	}
+}
+
+func TestAnthropicToJetbrainsMessages_Images(t *testing.T) {
+	imageBlock := func(mediaType, data string) map[string]any {
+		return map[string]any{
+			"type":   core.ContentBlockTypeImage,
+			"source": map[string]any{"type": core.ImageSourceTypeBase64, "media_type": mediaType, "data": data},
+		}
+	}
+	validData := "iVBORw0KGgo="
+
+	tests := []struct {
+		name          string
+		messages      []core.AnthropicMessage
+		expectedTypes []string
+		wantErr       bool
+	}{
+		{
+			name: "图片与文本保持顺序",
+			messages: []core.AnthropicMessage{{Role: core.RoleUser, Content: []any{
+				map[string]any{"type": core.ContentBlockTypeText, "text": "第一张"},
+				imageBlock(core.ImageFormatPNG, validData),
+				map[string]any{"type": core.ContentBlockTypeText, "text": "第二张"},
+				imageBlock(core.ImageFormatJPEG, validData),
+			}}},
+			expectedTypes: []string{
+				core.JetBrainsMessageTypeUser, core.JetBrainsMessageTypeMedia,
+				core.JetBrainsMessageTypeUser, core.JetBrainsMessageTypeMedia,
+			},
+		},
+		{
+			name: "tool_result 内嵌图片排在工具消息之后",
+			messages: []core.AnthropicMessage{
+				{Role: core.RoleAssistant, Content: []any{
+					map[string]any{"type": core.ContentBlockTypeToolUse, "id": "toolu_01", "name": "screenshot"},
+				}},
+				{Role: core.RoleUser, Content: []any{
+					map[string]any{"type": core.ContentBlockTypeToolResult, "tool_use_id": "toolu_01", "content": []any{
+						map[string]any{"type": core.ContentBlockTypeText, "text": "captured"},
+						imageBlock(core.ImageFormatPNG, validData),
+					}},
+				}},
+			},
+			expectedTypes: []string{
+				core.JetBrainsMessageTypeAssistantTool, core.JetBrainsMessageTypeTool, core.JetBrainsMessageTypeMedia,
+			},
+		},
+		{
+			name: "不支持的图片格式",
+			messages: []core.AnthropicMessage{{Role: core.RoleUser, Content: []any{
+				imageBlock("image/bmp", validData),
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "非法 base64",
+			messages: []core.AnthropicMessage{{Role: core.RoleUser, Content: []any{
+				imageBlock(core.ImageFormatPNG, "not base64!"),
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "不支持的图片来源类型",
+			messages: []core.AnthropicMessage{{Role: core.RoleUser, Content: []any{
+				map[string]any{"type": core.ContentBlockTypeImage, "source": map[string]any{"type": core.ImageSourceTypeURL, "url": "https://example.com/a.png"}},
+			}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := AnthropicToJetbrainsMessages(tt.messages)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望错误，实际成功: %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(result) != len(tt.expectedTypes) {
+				t.Fatalf("期望生成 %d 个消息，实际生成 %d 个: %+v", len(tt.expectedTypes), len(result), result)
+			}
+			for i, expectedType := range tt.expectedTypes {
+				if result[i].Type != expectedType {
+					t.Errorf("消息 %d 类型错误，期望 '%s'，实际 '%s'", i, expectedType, result[i].Type)
+				}
+				if expectedType == core.JetBrainsMessageTypeMedia && (result[i].Data != validData || result[i].MediaType == "") {
+					t.Errorf("媒体消息 %d 应保留图片数据和类型，实际: %+v", i, result[i])
+				}
+			}
+		})
+	}
+}
+
 func TestHasContentBlockType(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -180,9 +316,21 @@ func TestExtractMixedContent(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			toolMsgs, textContent := extractMixedContent(tt.content, tt.toolIDToName)
-			if len(toolMsgs) != tt.expectedToolCount {
-				t.Errorf("期望 %d 个工具消息，实际 %d 个", tt.expectedToolCount, len(toolMsgs))
+			messages, err := extractMixedContent(tt.content, tt.toolIDToName, validate.NewImageValidator())
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			toolCount, textContent := 0, ""
+			for _, msg := range messages {
+				switch msg.Type {
+				case core.JetBrainsMessageTypeTool:
+					toolCount++
+				case core.JetBrainsMessageTypeUser:
+					textContent += msg.Content
+				}
+			}
+			if toolCount != tt.expectedToolCount {
+				t.Errorf("期望 %d 个工具消息，实际 %d 个", tt.expectedToolCount, toolCount)
 			}
 			if textContent != tt.expectedText {
 				t.Errorf("期望文本 '%s'，实际 '%s'", tt.expectedText, textContent)
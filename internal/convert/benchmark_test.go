@@ -40,7 +40,7 @@ func BenchmarkMessageConversion(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		OpenAIToJetbrainsMessages(messages)
+		_, _ = OpenAIToJetbrainsMessages(messages)
 	}
 }
 
@@ -53,7 +53,7 @@ func BenchmarkMessageConversionParallel(b *testing.B) {
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			OpenAIToJetbrainsMessages(messages)
+			_, _ = OpenAIToJetbrainsMessages(messages)
 		}
 	})
 }
@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"fmt"
+
+	"jetbrainsai2api/internal/core"
+)
+
+// JetBrains only serves chat models, so legacy completions are phrased as a chat with a system
+// instruction that asks for the bare continuation.
+const (
+	completionSystemPrompt = "You are a text completion engine. Reply with only the text that continues the user message " +
+		"exactly where it ends. Do not repeat the given text, do not add explanations, and do not wrap the reply in Markdown code fences."
+	fimSystemPrompt = "You are a code completion engine. The user message holds the code before the cursor inside <prefix> tags " +
+		"and the code after the cursor inside <suffix> tags. Reply with only the code to insert at the cursor, so that prefix, " +
+		"reply and suffix together form correct code. Do not repeat the prefix or the suffix, do not add explanations, and do not " +
+		"wrap the reply in Markdown code fences."
+)
+
+// CompletionPrompt extracts the prompt of a legacy completion request: a string or an array holding
+// a single string. Token arrays and batched prompts are not supported.
+func CompletionPrompt(prompt any) (string, error) {
+	switch v := prompt.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []any:
+		if len(v) != 1 {
+			return "", fmt.Errorf("prompt arrays must contain exactly one string")
+		}
+		if s, ok := v[0].(string); ok {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("prompt must be a string; token arrays are not supported")
+}
+
+// CompletionToChatRequest converts a legacy completion request into an OpenAI chat completion request.
+// Requests with a suffix become fill-in-the-middle requests with the prefix and suffix tagged.
+func CompletionToChatRequest(req *core.CompletionRequest) (core.ChatCompletionRequest, error) {
+	prompt, err := CompletionPrompt(req.Prompt)
+	if err != nil {
+		return core.ChatCompletionRequest{}, err
+	}
+	if prompt == "" && req.Suffix == "" {
+		return core.ChatCompletionRequest{}, fmt.Errorf("prompt cannot be empty")
+	}
+
+	messages := []core.ChatMessage{
+		{Role: core.RoleSystem, Content: completionSystemPrompt},
+		{Role: core.RoleUser, Content: prompt},
+	}
+	if req.Suffix != "" {
+		messages = []core.ChatMessage{
+			{Role: core.RoleSystem, Content: fimSystemPrompt},
+			{Role: core.RoleUser, Content: "<prefix>" + prompt + "</prefix>\n<suffix>" + req.Suffix + "</suffix>"},
+		}
+	}
+
+	return core.ChatCompletionRequest{
+		Model:         req.Model,
+		Messages:      messages,
+		Stream:        req.Stream,
+		Temperature:   req.Temperature,
+		MaxTokens:     req.MaxTokens,
+		TopP:          req.TopP,
+		Stop:          req.Stop,
+		StreamOptions: req.StreamOptions,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestCompletionPrompt(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompt   any
+		expected string
+		wantErr  bool
+	}{
+		{"字符串", "def add(a, b):", "def add(a, b):", false},
+		{"单元素数组", []any{"hello"}, "hello", false},
+		{"缺省", nil, "", false},
+		{"多个 prompt", []any{"a", "b"}, "", true},
+		{"token 数组", []any{float64(1234)}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, err := CompletionPrompt(tt.prompt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+			if prompt != tt.expected {
+				t.Errorf("期望 %q，实际 %q", tt.expected, prompt)
+			}
+		})
+	}
+}
+
+func TestCompletionToChatRequest(t *testing.T) {
+	maxTokens := 32
+	tests := []struct {
+		name           string
+		req            core.CompletionRequest
+		expectedSystem string
+		expectedUser   string
+		wantErr        bool
+	}{
+		{
+			name:           "普通补全",
+			req:            core.CompletionRequest{Model: "gpt-4o", Prompt: "Once upon a time", MaxTokens: &maxTokens, Stop: "\n"},
+			expectedSystem: completionSystemPrompt,
+			expectedUser:   "Once upon a time",
+		},
+		{
+			name:           "FIM 补全",
+			req:            core.CompletionRequest{Model: "gpt-4o", Prompt: "def add(a, b):\n    ", Suffix: "\n\nprint(add(1, 2))"},
+			expectedSystem: fimSystemPrompt,
+			expectedUser:   "<prefix>def add(a, b):\n    </prefix>\n<suffix>\n\nprint(add(1, 2))</suffix>",
+		},
+		{
+			name:    "空 prompt",
+			req:     core.CompletionRequest{Model: "gpt-4o", Prompt: ""},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatReq, err := CompletionToChatRequest(&tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(chatReq.Messages) != 2 || chatReq.Messages[0].Content != tt.expectedSystem || chatReq.Messages[1].Content != tt.expectedUser {
+				t.Fatalf("消息转换错误，实际 %+v", chatReq.Messages)
+			}
+			if chatReq.MaxTokens != tt.req.MaxTokens || chatReq.Stop != tt.req.Stop {
+				t.Errorf("max_tokens 和 stop 应原样传递")
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package convert
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// GeminiToChatRequest converts a Gemini generateContent request into an OpenAI chat completion
+// request, so the existing OpenAI → JetBrains conversion (and its cache) can be reused.
+func GeminiToChatRequest(model string, req *core.GeminiGenerateContentRequest) (core.ChatCompletionRequest, error) {
+	messages, err := GeminiToChatMessages(req.SystemInstruction, req.Contents)
+	if err != nil {
+		return core.ChatCompletionRequest{}, err
+	}
+
+	tools, err := GeminiToOpenAITools(req.Tools)
+	if err != nil {
+		return core.ChatCompletionRequest{}, err
+	}
+
+	chatReq := core.ChatCompletionRequest{
+		Model:    model,
+		Messages: messages,
+		Tools:    tools,
+	}
+	if cfg := req.GenerationConfig; cfg != nil {
+		chatReq.Temperature = cfg.Temperature
+		chatReq.TopP = cfg.TopP
+		chatReq.TopK = cfg.TopK
+		chatReq.MaxTokens = cfg.MaxOutputTokens
+		if len(cfg.StopSequences) > 0 {
+			chatReq.Stop = cfg.StopSequences
+		}
+	}
+	return chatReq, nil
+}
+
+// GeminiToChatMessages converts a Gemini system instruction and contents into OpenAI chat messages.
+// Function calls without an id get a generated one, and function responses without an id are
+// paired with the oldest unanswered call of the same name.
+func GeminiToChatMessages(system *core.GeminiContent, contents []core.GeminiContent) ([]core.ChatMessage, error) {
+	var messages []core.ChatMessage
+
+	if system != nil {
+		if text := geminiPartsText(system.Parts); text != "" {
+			messages = append(messages, core.ChatMessage{Role: core.RoleSystem, Content: text})
+		}
+	}
+
+	pending := make(map[string][]string)
+	for i, content := range contents {
+		var converted []core.ChatMessage
+		var err error
+		switch content.Role {
+		case core.GeminiRoleModel:
+			converted = geminiModelContentToChatMessages(content, pending)
+		case "", core.GeminiRoleUser, core.GeminiRoleFunction:
+			converted, err = geminiUserContentToChatMessages(content, pending)
+		default:
+			err = fmt.Errorf("unsupported role: %q", content.Role)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("contents[%d]: %w", i, err)
+		}
+		messages = append(messages, converted...)
+	}
+
+	return messages, nil
+}
+
+func geminiModelContentToChatMessages(content core.GeminiContent, pending map[string][]string) []core.ChatMessage {
+	var text strings.Builder
+	var toolCalls []core.ToolCall
+
+	for _, part := range content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = util.GenerateRandomID(core.ToolCallIDPrefix)
+			}
+			pending[part.FunctionCall.Name] = append(pending[part.FunctionCall.Name], id)
+			toolCalls = append(toolCalls, core.ToolCall{
+				ID:       id,
+				Type:     core.ToolTypeFunction,
+				Function: core.Function{Name: part.FunctionCall.Name, Arguments: marshalJSONObject(part.FunctionCall.Args)},
+			})
+		case !part.Thought:
+			text.WriteString(part.Text)
+		}
+	}
+
+	if text.Len() == 0 && len(toolCalls) == 0 {
+		return nil
+	}
+	msg := core.ChatMessage{Role: core.RoleAssistant, ToolCalls: toolCalls}
+	if text.Len() > 0 {
+		msg.Content = text.String()
+	}
+	return []core.ChatMessage{msg}
+}
+
+// geminiUserContentToChatMessages emits one tool message per function response, followed by
+// a user message holding the remaining text and image parts.
+func geminiUserContentToChatMessages(content core.GeminiContent, pending map[string][]string) ([]core.ChatMessage, error) {
+	var messages []core.ChatMessage
+	var parts []any
+
+	for _, part := range content.Parts {
+		switch {
+		case part.FunctionResponse != nil:
+			id, err := takeGeminiCallID(part.FunctionResponse, pending)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, core.ChatMessage{
+				Role:       core.RoleTool,
+				ToolCallID: id,
+				Content:    marshalJSONObject(part.FunctionResponse.Response),
+			})
+		case part.InlineData != nil:
+			if !strings.HasPrefix(part.InlineData.MimeType, "image/") {
+				return nil, fmt.Errorf("unsupported inlineData mime type: %q", part.InlineData.MimeType)
+			}
+			parts = append(parts, map[string]any{
+				"type":      core.ContentPartTypeImageURL,
+				"image_url": map[string]any{"url": "data:" + part.InlineData.MimeType + ";base64," + part.InlineData.Data},
+			})
+		case part.Text != "":
+			parts = append(parts, map[string]any{"type": core.ContentBlockTypeText, "text": part.Text})
+		}
+	}
+
+	if len(parts) > 0 {
+		messages = append(messages, core.ChatMessage{Role: core.RoleUser, Content: parts})
+	}
+	return messages, nil
+}
+
+func takeGeminiCallID(response *core.GeminiFunctionResponse, pending map[string][]string) (string, error) {
+	ids := pending[response.Name]
+	if response.ID != "" {
+		pending[response.Name] = slices.DeleteFunc(ids, func(id string) bool { return id == response.ID })
+		return response.ID, nil
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("functionResponse %q has no matching functionCall", response.Name)
+	}
+	pending[response.Name] = ids[1:]
+	return ids[0], nil
+}
+
+func geminiPartsText(parts []core.GeminiPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func marshalJSONObject(v map[string]any) string {
+	if v == nil {
+		return "{}"
+	}
+	data, err := util.MarshalJSON(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// GeminiToOpenAITools converts Gemini function declarations to OpenAI chat tools.
+// Other Gemini tool kinds (googleSearch, codeExecution, ...) have no JetBrains equivalent and are rejected.
+func GeminiToOpenAITools(tools []core.GeminiTool) ([]core.Tool, error) {
+	var result []core.Tool
+	for _, tool := range tools {
+		if len(tool.FunctionDeclarations) == 0 {
+			return nil, fmt.Errorf("unsupported tool: only functionDeclarations are supported")
+		}
+		for _, decl := range tool.FunctionDeclarations {
+			if decl.Name == "" {
+				return nil, fmt.Errorf("function declaration requires a name")
+			}
+			parameters := decl.ParametersJSONSchema
+			if parameters == nil && decl.Parameters != nil {
+				parameters = geminiSchemaToJSONSchema(decl.Parameters)
+			}
+			if parameters == nil {
+				parameters = map[string]any{"type": core.SchemaTypeObject, "properties": map[string]any{}}
+			}
+			result = append(result, core.Tool{
+				Type: core.ToolTypeFunction,
+				Function: core.ToolFunction{
+					Name:        decl.Name,
+					Description: decl.Description,
+					Parameters:  parameters,
+				},
+			})
+		}
+	}
+	return result, nil
+}
+
+// geminiSchemaToJSONSchema converts the Gemini OpenAPI schema subset to JSON Schema:
+// type names are lower-cased and Gemini-only keywords are dropped.
+func geminiSchemaToJSONSchema(schema map[string]any) map[string]any {
+	result := make(map[string]any, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "nullable", "propertyOrdering":
+			continue
+		case "type":
+			if typeName, ok := value.(string); ok {
+				value = strings.ToLower(typeName)
+			}
+		case "items":
+			if items, ok := value.(map[string]any); ok {
+				value = geminiSchemaToJSONSchema(items)
+			}
+		case "properties":
+			if properties, ok := value.(map[string]any); ok {
+				converted := make(map[string]any, len(properties))
+				for name, property := range properties {
+					if propertySchema, ok := property.(map[string]any); ok {
+						converted[name] = geminiSchemaToJSONSchema(propertySchema)
+					} else {
+						converted[name] = property
+					}
+				}
+				value = converted
+			}
+		case "anyOf":
+			if variants, ok := value.([]any); ok {
+				converted := make([]any, len(variants))
+				for i, variant := range variants {
+					if variantSchema, ok := variant.(map[string]any); ok {
+						converted[i] = geminiSchemaToJSONSchema(variantSchema)
+					} else {
+						converted[i] = variant
+					}
+				}
+				value = converted
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
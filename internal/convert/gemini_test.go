@@ -0,0 +1,193 @@
+package convert
+
+import (
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestGeminiToChatMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		system        *core.GeminiContent
+		contents      []core.GeminiContent
+		expectedRoles []string
+		wantErr       bool
+	}{
+		{
+			name:          "systemInstruction 作为系统消息",
+			system:        &core.GeminiContent{Parts: []core.GeminiPart{{Text: "be brief"}}},
+			contents:      []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{{Text: "hi"}}}},
+			expectedRoles: []string{core.RoleSystem, core.RoleUser},
+		},
+		{
+			name: "model 角色映射为 assistant",
+			contents: []core.GeminiContent{
+				{Role: "user", Parts: []core.GeminiPart{{Text: "hi"}}},
+				{Role: "model", Parts: []core.GeminiPart{{Text: "hello"}}},
+			},
+			expectedRoles: []string{core.RoleUser, core.RoleAssistant},
+		},
+		{
+			name: "函数调用往返",
+			contents: []core.GeminiContent{
+				{Role: "user", Parts: []core.GeminiPart{{Text: "weather?"}}},
+				{Role: "model", Parts: []core.GeminiPart{{FunctionCall: &core.GeminiFunctionCall{Name: "get_weather", Args: map[string]any{"city": "Beijing"}}}}},
+				{Role: "user", Parts: []core.GeminiPart{{FunctionResponse: &core.GeminiFunctionResponse{Name: "get_weather", Response: map[string]any{"output": "sunny"}}}}},
+			},
+			expectedRoles: []string{core.RoleUser, core.RoleAssistant, core.RoleTool},
+		},
+		{
+			name: "忽略 thought 部分",
+			contents: []core.GeminiContent{
+				{Role: "user", Parts: []core.GeminiPart{{Text: "hi"}}},
+				{Role: "model", Parts: []core.GeminiPart{{Text: "thinking", Thought: true}}},
+			},
+			expectedRoles: []string{core.RoleUser},
+		},
+		{
+			name:     "functionResponse 无对应调用",
+			contents: []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{{FunctionResponse: &core.GeminiFunctionResponse{Name: "f"}}}}},
+			wantErr:  true,
+		},
+		{
+			name:     "不支持的 inlineData 类型",
+			contents: []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{{InlineData: &core.GeminiBlob{MimeType: "application/pdf", Data: "AAAA"}}}}},
+			wantErr:  true,
+		},
+		{
+			name:     "不支持的角色",
+			contents: []core.GeminiContent{{Role: "system", Parts: []core.GeminiPart{{Text: "x"}}}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := GeminiToChatMessages(tt.system, tt.contents)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望错误，实际成功: %+v", messages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(messages) != len(tt.expectedRoles) {
+				t.Fatalf("期望 %d 条消息，实际 %d: %+v", len(tt.expectedRoles), len(messages), messages)
+			}
+			for i, role := range tt.expectedRoles {
+				if messages[i].Role != role {
+					t.Errorf("消息 %d 期望角色 %s，实际 %s", i, role, messages[i].Role)
+				}
+			}
+		})
+	}
+}
+
+func TestGeminiToChatMessages_FunctionCallPairing(t *testing.T) {
+	contents := []core.GeminiContent{
+		{Role: "model", Parts: []core.GeminiPart{
+			{FunctionCall: &core.GeminiFunctionCall{Name: "lookup", Args: map[string]any{"q": "a"}}},
+			{FunctionCall: &core.GeminiFunctionCall{Name: "lookup", Args: map[string]any{"q": "b"}}},
+		}},
+		{Role: "user", Parts: []core.GeminiPart{
+			{FunctionResponse: &core.GeminiFunctionResponse{Name: "lookup", Response: map[string]any{"r": 1}}},
+			{FunctionResponse: &core.GeminiFunctionResponse{Name: "lookup", Response: map[string]any{"r": 2}}},
+		}},
+	}
+
+	messages, err := GeminiToChatMessages(nil, contents)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	calls := messages[0].ToolCalls
+	if len(calls) != 2 || calls[0].ID == "" || calls[0].ID == calls[1].ID {
+		t.Fatalf("应为每个函数调用生成不同的 ID: %+v", calls)
+	}
+	if calls[0].Function.Arguments != `{"q":"a"}` {
+		t.Errorf("参数应序列化为 JSON，实际 %s", calls[0].Function.Arguments)
+	}
+	if messages[1].ToolCallID != calls[0].ID || messages[2].ToolCallID != calls[1].ID {
+		t.Errorf("函数响应应按顺序匹配同名调用")
+	}
+	if messages[1].Content != `{"r":1}` {
+		t.Errorf("函数响应应序列化为 JSON，实际 %v", messages[1].Content)
+	}
+}
+
+func TestGeminiToChatMessages_InlineImage(t *testing.T) {
+	contents := []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{
+		{Text: "what is this?"},
+		{InlineData: &core.GeminiBlob{MimeType: "image/png", Data: "iVBORw0KGgo="}},
+	}}}
+
+	messages, err := GeminiToChatMessages(nil, contents)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	parts, ok := messages[0].Content.([]any)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("期望 2 个内容部分，实际 %+v", messages[0].Content)
+	}
+	imagePart := parts[1].(map[string]any)
+	url := imagePart["image_url"].(map[string]any)["url"]
+	if imagePart["type"] != core.ContentPartTypeImageURL || url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("inlineData 应转换为 data URL 图片，实际 %+v", imagePart)
+	}
+}
+
+func TestGeminiToChatRequest(t *testing.T) {
+	temperature, topK, maxTokens := 0.2, 40, 128
+	req := &core.GeminiGenerateContentRequest{
+		Contents: []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{{Text: "hi"}}}},
+		Tools: []core.GeminiTool{{FunctionDeclarations: []core.GeminiFunctionDeclaration{
+			{Name: "get_weather", Parameters: map[string]any{
+				"type":     "OBJECT",
+				"nullable": false,
+				"properties": map[string]any{
+					"city": map[string]any{"type": "STRING"},
+					"days": map[string]any{"type": "ARRAY", "items": map[string]any{"type": "INTEGER"}},
+				},
+			}},
+			{Name: "get_time"},
+		}}},
+		ToolConfig: &core.GeminiToolConfig{FunctionCallingConfig: &core.GeminiFunctionCallingConfig{
+			Mode: "ANY", AllowedFunctionNames: []string{"get_weather"},
+		}},
+		GenerationConfig: &core.GeminiGenerationConfig{
+			Temperature: &temperature, TopK: &topK, MaxOutputTokens: &maxTokens, StopSequences: []string{"END"},
+		},
+	}
+
+	chatReq, err := GeminiToChatRequest("gemini-2.5-pro", req)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	if len(chatReq.Tools) != 2 || chatReq.Tools[0].Function.Name != "get_weather" {
+		t.Fatalf("应转换全部函数声明，实际 %+v", chatReq.Tools)
+	}
+	params := chatReq.Tools[0].Function.Parameters
+	if params["type"] != "object" {
+		t.Errorf("类型名应转换为小写，实际 %v", params["type"])
+	}
+	if _, ok := params["nullable"]; ok {
+		t.Errorf("应移除 Gemini 专有的 nullable 关键字")
+	}
+	days := params["properties"].(map[string]any)["days"].(map[string]any)
+	if days["type"] != "array" || days["items"].(map[string]any)["type"] != "integer" {
+		t.Errorf("嵌套 schema 应递归转换，实际 %+v", days)
+	}
+
+	sampling := OpenAISamplingParams(&chatReq)
+	if *sampling.Temperature != 0.2 || *sampling.TopK != 40 || *sampling.MaxTokens != 128 || len(sampling.Stop) != 1 {
+		t.Errorf("generationConfig 应映射为采样参数，实际 %+v", sampling)
+	}
+
+	req.ToolConfig = nil
+	req.Tools = []core.GeminiTool{{}}
+	if _, err := GeminiToChatRequest("gemini-2.5-pro", req); err == nil {
+		t.Error("非 functionDeclarations 工具应返回错误")
+	}
+}
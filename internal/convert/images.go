@@ -0,0 +1,152 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/validate"
+)
+
+// ResolveOpenAIImageURLs replaces remote image_url parts with base64 data URLs downloaded through fetcher.
+// The input slice is never mutated; messages without remote images are returned as-is.
+func ResolveOpenAIImageURLs(ctx context.Context, messages []core.ChatMessage, fetcher core.ImageFetcher) ([]core.ChatMessage, error) {
+	var resolved []core.ChatMessage
+
+	for i, msg := range messages {
+		parts, ok := msg.Content.([]any)
+		if !ok {
+			continue
+		}
+
+		var newParts []any
+		for j, part := range parts {
+			partMap, ok := part.(map[string]any)
+			if !ok || partMap["type"] != core.ContentPartTypeImageURL {
+				continue
+			}
+			imageURL, _ := partMap["image_url"].(map[string]any)
+			url, _ := imageURL["url"].(string)
+			if _, _, isData := validate.ParseImageDataURL(url); isData || url == "" {
+				continue
+			}
+
+			mediaType, data, err := fetchImage(ctx, fetcher, url)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d].content[%d]: %w", i, j, err)
+			}
+
+			if newParts == nil {
+				newParts = slices.Clone(parts)
+			}
+			newImageURL := maps.Clone(imageURL)
+			newImageURL["url"] = "data:" + mediaType + ";base64," + data
+			newParts[j] = map[string]any{"type": core.ContentPartTypeImageURL, "image_url": newImageURL}
+		}
+
+		if newParts != nil {
+			if resolved == nil {
+				resolved = slices.Clone(messages)
+			}
+			resolved[i].Content = newParts
+		}
+	}
+
+	if resolved == nil {
+		return messages, nil
+	}
+	return resolved, nil
+}
+
+// ResolveAnthropicImageURLs replaces url-sourced image blocks (including those nested in tool_result)
+// with base64 image blocks downloaded through fetcher. The input slice is never mutated.
+func ResolveAnthropicImageURLs(ctx context.Context, messages []core.AnthropicMessage, fetcher core.ImageFetcher) ([]core.AnthropicMessage, error) {
+	var resolved []core.AnthropicMessage
+
+	for i, msg := range messages {
+		blocks, ok := msg.Content.([]any)
+		if !ok {
+			continue
+		}
+
+		newBlocks, err := resolveAnthropicBlocks(ctx, blocks, fetcher)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if newBlocks != nil {
+			if resolved == nil {
+				resolved = slices.Clone(messages)
+			}
+			resolved[i].Content = newBlocks
+		}
+	}
+
+	if resolved == nil {
+		return messages, nil
+	}
+	return resolved, nil
+}
+
+// resolveAnthropicBlocks returns a rewritten copy of blocks, or nil when nothing needed fetching
+func resolveAnthropicBlocks(ctx context.Context, blocks []any, fetcher core.ImageFetcher) ([]any, error) {
+	var newBlocks []any
+	replace := func(j int, block any) {
+		if newBlocks == nil {
+			newBlocks = slices.Clone(blocks)
+		}
+		newBlocks[j] = block
+	}
+
+	for j, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		switch blockMap["type"] {
+		case core.ContentBlockTypeImage:
+			source, _ := blockMap["source"].(map[string]any)
+			if source["type"] != core.ImageSourceTypeURL {
+				continue
+			}
+			url, _ := source["url"].(string)
+			mediaType, data, err := fetchImage(ctx, fetcher, url)
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", j, err)
+			}
+			newBlock := maps.Clone(blockMap)
+			newBlock["source"] = map[string]any{
+				"type":       core.ImageSourceTypeBase64,
+				"media_type": mediaType,
+				"data":       data,
+			}
+			replace(j, newBlock)
+
+		case core.ContentBlockTypeToolResult:
+			nested, ok := blockMap["content"].([]any)
+			if !ok {
+				continue
+			}
+			newNested, err := resolveAnthropicBlocks(ctx, nested, fetcher)
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", j, err)
+			}
+			if newNested != nil {
+				newBlock := maps.Clone(blockMap)
+				newBlock["content"] = newNested
+				replace(j, newBlock)
+			}
+		}
+	}
+
+	return newBlocks, nil
+}
+
+func fetchImage(ctx context.Context, fetcher core.ImageFetcher, url string) (string, string, error) {
+	if fetcher == nil {
+		return "", "", fmt.Errorf("remote image URLs are not supported")
+	}
+	return fetcher.FetchImage(ctx, url)
+}
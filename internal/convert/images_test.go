@@ -0,0 +1,116 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+type stubImageFetcher struct {
+	calls int
+	err   error
+}
+
+func (f *stubImageFetcher) FetchImage(_ context.Context, _ string) (string, string, error) {
+	f.calls++
+	if f.err != nil {
+		return "", "", f.err
+	}
+	return core.ImageFormatPNG, "iVBORw0KGgo=", nil
+}
+
+func TestResolveOpenAIImageURLs(t *testing.T) {
+	remotePart := map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png", "detail": "high"}}
+	dataPart := map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}}
+	messages := []core.ChatMessage{
+		{Role: core.RoleSystem, Content: "system"},
+		{Role: core.RoleUser, Content: []any{map[string]any{"type": "text", "text": "看图"}, remotePart, dataPart}},
+	}
+
+	fetcher := &stubImageFetcher{}
+	resolved, err := ResolveOpenAIImageURLs(context.Background(), messages, fetcher)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	if fetcher.calls != 1 {
+		t.Errorf("只有远程图片应被下载，实际调用 %d 次", fetcher.calls)
+	}
+
+	parts := resolved[1].Content.([]any)
+	imageURL := parts[1].(map[string]any)["image_url"].(map[string]any)
+	if imageURL["url"] != "data:image/png;base64,iVBORw0KGgo=" || imageURL["detail"] != "high" {
+		t.Errorf("远程图片应被替换为 data URL 并保留其他字段，实际: %v", imageURL)
+	}
+	if remotePart["image_url"].(map[string]any)["url"] != "https://example.com/a.png" {
+		t.Error("原始请求内容不应被修改")
+	}
+
+	jetbrainsMessages, err := OpenAIToJetbrainsMessages(resolved)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	mediaCount := 0
+	for _, msg := range jetbrainsMessages {
+		if msg.Type == core.JetBrainsMessageTypeMedia {
+			mediaCount++
+		}
+	}
+	if mediaCount != 2 {
+		t.Errorf("期望 2 条媒体消息，实际 %d", mediaCount)
+	}
+
+	if _, err := ResolveOpenAIImageURLs(context.Background(), messages, &stubImageFetcher{err: errors.New("boom")}); err == nil {
+		t.Error("下载失败应返回错误")
+	}
+	if _, err := ResolveOpenAIImageURLs(context.Background(), messages, nil); err == nil {
+		t.Error("未配置下载器时远程图片应返回错误")
+	}
+}
+
+func TestResolveAnthropicImageURLs(t *testing.T) {
+	urlImage := map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": "https://example.com/a.png"}}
+	messages := []core.AnthropicMessage{
+		{Role: core.RoleAssistant, Content: []any{
+			map[string]any{"type": "tool_use", "id": "toolu_01", "name": "screenshot", "input": map[string]any{}},
+		}},
+		{Role: core.RoleUser, Content: []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_01", "content": []any{urlImage}},
+			urlImage,
+		}},
+	}
+
+	fetcher := &stubImageFetcher{}
+	resolved, err := ResolveAnthropicImageURLs(context.Background(), messages, fetcher)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	if fetcher.calls != 2 {
+		t.Errorf("顶层和 tool_result 内的图片都应被下载，实际调用 %d 次", fetcher.calls)
+	}
+	if urlImage["source"].(map[string]any)["type"] != "url" {
+		t.Error("原始请求内容不应被修改")
+	}
+
+	jetbrainsMessages, err := AnthropicToJetbrainsMessages(resolved)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	expectedTypes := []string{
+		core.JetBrainsMessageTypeAssistantTool, core.JetBrainsMessageTypeTool,
+		core.JetBrainsMessageTypeMedia, core.JetBrainsMessageTypeMedia,
+	}
+	if len(jetbrainsMessages) != len(expectedTypes) {
+		t.Fatalf("期望 %d 条消息，实际 %d 条", len(expectedTypes), len(jetbrainsMessages))
+	}
+	for i, et := range expectedTypes {
+		if jetbrainsMessages[i].Type != et {
+			t.Errorf("消息 %d 类型错误，期望 '%s'，实际 '%s'", i, et, jetbrainsMessages[i].Type)
+		}
+	}
+
+	if _, err := ResolveAnthropicImageURLs(context.Background(), messages, &stubImageFetcher{err: errors.New("boom")}); err == nil {
+		t.Error("下载失败应返回错误")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/usage"
 	"jetbrainsai2api/internal/util"
 
 	"github.com/bytedance/sonic"
@@ -42,8 +43,7 @@ func ParseJetbrainsToAnthropicDirect(body []byte, model string, logger core.Logg
 		Model:      model,
 		StopReason: stopReason,
 		Usage: core.AnthropicUsage{
-			InputTokens:  0,
-			OutputTokens: util.EstimateTokenCount(GetContentText(content)),
+			OutputTokens: usage.CountText(GetContentText(content)),
 		},
 	}
 
@@ -55,13 +55,9 @@ func ParseJetbrainsToAnthropicDirect(body []byte, model string, logger core.Logg
 
 // ParseJetbrainsStreamToAnthropic parses JetBrains streaming response to Anthropic format
 func ParseJetbrainsStreamToAnthropic(bodyStr, model string, logger core.Logger) (*core.AnthropicMessagesResponse, error) {
-	lines := strings.Split(bodyStr, "\n")
-	var content []core.AnthropicContentBlock
-	var currentToolCall *core.AnthropicContentBlock
-	var textParts []string
-	finishReason := core.StopReasonEndTurn
+	b := NewAnthropicMessageBuilder(model, logger)
 
-	for _, line := range lines {
+	for _, line := range strings.Split(bodyStr, "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || line == core.StreamEndLine {
 			continue
@@ -74,86 +70,140 @@ func ParseJetbrainsStreamToAnthropic(bodyStr, model string, logger core.Logger)
 				logger.Debug("Failed to parse stream JSON: %v", err)
 				continue
 			}
+			b.ObserveEvent(streamData)
+		}
+	}
+
+	return b.Build(), nil
+}
+
+// AnthropicMessageBuilder assembles a complete Anthropic message from JetBrains stream events,
+// so callers can build the message while the upstream body is still being read.
+type AnthropicMessageBuilder struct {
+	model           string
+	logger          core.Logger
+	toolUses        []core.AnthropicContentBlock
+	currentToolCall *core.AnthropicContentBlock
+	text            strings.Builder
+	thinking        strings.Builder
+	signature       string
+	finishReason    string
+	stopSequence    string
+	acc             *usage.Accumulator
+}
 
-			eventType, _ := streamData["type"].(string)
-			switch eventType {
-			case core.JetBrainsEventTypeContent:
-				if text, ok := streamData["content"].(string); ok {
-					textParts = append(textParts, text)
+// NewAnthropicMessageBuilder creates a builder for a message answering the given model.
+func NewAnthropicMessageBuilder(model string, logger core.Logger) *AnthropicMessageBuilder {
+	return &AnthropicMessageBuilder{
+		model:        model,
+		logger:       logger,
+		finishReason: core.StopReasonEndTurn,
+		acc:          usage.NewAccumulator(0),
+	}
+}
+
+// ObserveEvent folds one JetBrains stream event into the message.
+func (b *AnthropicMessageBuilder) ObserveEvent(streamData map[string]any) {
+	b.acc.ObserveEvent(streamData)
+	eventType, _ := streamData["type"].(string)
+	switch eventType {
+	case core.JetBrainsEventTypeContent:
+		if text, ok := streamData["content"].(string); ok {
+			b.text.WriteString(text)
+		}
+	case core.JetBrainsEventTypeReasoning:
+		if text, ok := streamData["content"].(string); ok {
+			b.thinking.WriteString(text)
+		}
+		if sig, ok := streamData["signature"].(string); ok && sig != "" {
+			b.signature = sig
+		}
+	case core.JetBrainsEventTypeToolCall:
+		if upstreamID, ok := streamData["id"].(string); ok && upstreamID != "" {
+			if name, ok := streamData["name"].(string); ok && name != "" {
+				b.currentToolCall = &core.AnthropicContentBlock{
+					Type:  core.ContentBlockTypeToolUse,
+					ID:    upstreamID,
+					Name:  name,
+					Input: make(map[string]any),
 				}
-			case core.JetBrainsEventTypeToolCall:
-				if upstreamID, ok := streamData["id"].(string); ok && upstreamID != "" {
-					if name, ok := streamData["name"].(string); ok && name != "" {
-						currentToolCall = &core.AnthropicContentBlock{
-							Type:  core.ContentBlockTypeToolUse,
-							ID:    upstreamID,
-							Name:  name,
-							Input: make(map[string]any),
-						}
-						logger.Debug("Started tool call: id=%s, name=%s", upstreamID, name)
-					}
-				} else if currentToolCall != nil {
-					if contentStr, ok := streamData["content"].(string); ok {
-						if currentToolCall.Input == nil {
-							currentToolCall.Input = make(map[string]any)
-						}
-						if existing, exists := currentToolCall.Input["_raw_args"]; exists {
-							currentToolCall.Input["_raw_args"] = existing.(string) + contentStr
-						} else {
-							currentToolCall.Input["_raw_args"] = contentStr
-						}
-					}
+				b.logger.Debug("Started tool call: id=%s, name=%s", upstreamID, name)
+			}
+		} else if b.currentToolCall != nil {
+			if contentStr, ok := streamData["content"].(string); ok {
+				if b.currentToolCall.Input == nil {
+					b.currentToolCall.Input = make(map[string]any)
 				}
-			case core.JetBrainsEventTypeFinishMetadata:
-				if reasonStr, ok := streamData["reason"].(string); ok {
-					finishReason = MapJetbrainsFinishReason(reasonStr)
+				if existing, exists := b.currentToolCall.Input["_raw_args"]; exists {
+					b.currentToolCall.Input["_raw_args"] = existing.(string) + contentStr
+				} else {
+					b.currentToolCall.Input["_raw_args"] = contentStr
 				}
+			}
+		}
+	case core.JetBrainsEventTypeFinishMetadata:
+		if reasonStr, ok := streamData["reason"].(string); ok {
+			b.finishReason = MapJetbrainsFinishReason(reasonStr)
+		}
+		// Set by the output guard when it ends the output at a stop sequence
+		if seq, ok := streamData["stop_sequence"].(string); ok {
+			b.stopSequence = seq
+		}
 
-				if currentToolCall != nil {
-					if rawArgs, exists := currentToolCall.Input["_raw_args"]; exists {
-						var parsedArgs map[string]any
-						if err := sonic.Unmarshal([]byte(rawArgs.(string)), &parsedArgs); err == nil {
-							currentToolCall.Input = parsedArgs
-						} else {
-							currentToolCall.Input = map[string]any{"arguments": rawArgs.(string)}
-						}
-					}
-					content = append(content, *currentToolCall)
-					logger.Debug("Completed tool call: id=%s, args=%v", currentToolCall.ID, currentToolCall.Input)
-					currentToolCall = nil
+		if b.currentToolCall != nil {
+			if rawArgs, exists := b.currentToolCall.Input["_raw_args"]; exists {
+				var parsedArgs map[string]any
+				if err := sonic.Unmarshal([]byte(rawArgs.(string)), &parsedArgs); err == nil {
+					b.currentToolCall.Input = parsedArgs
+				} else {
+					b.currentToolCall.Input = map[string]any{"arguments": rawArgs.(string)}
 				}
 			}
+			b.toolUses = append(b.toolUses, *b.currentToolCall)
+			b.logger.Debug("Completed tool call: id=%s, args=%v", b.currentToolCall.ID, b.currentToolCall.Input)
+			b.currentToolCall = nil
 		}
 	}
+}
 
-	if len(textParts) > 0 {
-		fullText := strings.Join(textParts, "")
-		if fullText != "" {
-			textContent := core.AnthropicContentBlock{
-				Type: core.ContentBlockTypeText,
-				Text: fullText,
-			}
-			content = append([]core.AnthropicContentBlock{textContent}, content...)
-		}
+// Build returns the message assembled from the events observed so far.
+func (b *AnthropicMessageBuilder) Build() *core.AnthropicMessagesResponse {
+	var content []core.AnthropicContentBlock
+
+	// Thinking comes first, as in the stream
+	if b.thinking.Len() > 0 {
+		content = append(content, core.AnthropicContentBlock{
+			Type:      core.ContentBlockTypeThinking,
+			Thinking:  b.thinking.String(),
+			Signature: b.signature,
+		})
 	}
+	if b.text.Len() > 0 {
+		content = append(content, core.AnthropicContentBlock{
+			Type: core.ContentBlockTypeText,
+			Text: b.text.String(),
+		})
+	}
+	content = append(content, b.toolUses...)
 
 	response := &core.AnthropicMessagesResponse{
 		ID:         GenerateMessageID(),
 		Type:       core.AnthropicTypeMessage,
 		Role:       core.RoleAssistant,
 		Content:    content,
-		Model:      model,
-		StopReason: finishReason,
-		Usage: core.AnthropicUsage{
-			InputTokens:  0,
-			OutputTokens: 0,
-		},
+		Model:      b.model,
+		StopReason: b.finishReason,
+		Usage:      b.acc.Report().Anthropic(),
+	}
+	if b.stopSequence != "" {
+		stopSequence := b.stopSequence
+		response.StopSequence = &stopSequence
 	}
 
-	logger.Debug("Successfully parsed JetBrains stream to Anthropic: content_blocks=%d, finish_reason=%s",
-		len(content), finishReason)
+	b.logger.Debug("Successfully parsed JetBrains stream to Anthropic: content_blocks=%d, finish_reason=%s",
+		len(content), b.finishReason)
 
-	return response, nil
+	return response
 }
 
 // MapJetbrainsFinishReason maps JetBrains finish reason to Anthropic format
@@ -163,6 +213,8 @@ func MapJetbrainsFinishReason(jetbrainsReason string) string {
 		return core.StopReasonToolUse
 	case core.JetBrainsFinishReasonLength:
 		return core.StopReasonMaxTokens
+	case core.JetBrainsFinishReasonStopSequence:
+		return core.StopReasonStopSequence
 	case core.JetBrainsFinishReasonStop:
 		return core.StopReasonEndTurn
 	default:
@@ -187,10 +239,15 @@ func GenerateAnthropicStreamResponse(responseType string, content string, index
 
 	switch responseType {
 	case core.StreamEventTypeContentBlockStart:
-		resp = core.AnthropicStreamResponse{
-			Type:  core.StreamEventTypeContentBlockStart,
-			Index: &index,
+		data, err := util.MarshalJSON(core.AnthropicContentBlockStartEvent{
+			Type:         core.StreamEventTypeContentBlockStart,
+			Index:        index,
+			ContentBlock: core.AnthropicTextBlockStart{Type: core.ContentBlockTypeText},
+		})
+		if err != nil {
+			return []byte{}
 		}
+		return data
 
 	case core.StreamEventTypeContentBlockDelta:
 		resp = core.AnthropicStreamResponse{
@@ -212,24 +269,18 @@ func GenerateAnthropicStreamResponse(responseType string, content string, index
 		}
 
 	case core.StreamEventTypeMessageStart:
-		resp = core.AnthropicStreamResponse{
-			Type: core.StreamEventTypeMessageStart,
-			Message: &core.AnthropicMessagesResponse{
-				ID:   GenerateMessageID(),
-				Type: core.AnthropicTypeMessage,
-				Role: core.RoleAssistant,
-				Usage: core.AnthropicUsage{
-					InputTokens:  0,
-					OutputTokens: 0,
-				},
-			},
-		}
+		return GenerateAnthropicMessageStart("", 0)
 
 	case core.StreamEventTypeMessageStop:
 		resp = core.AnthropicStreamResponse{
 			Type: core.StreamEventTypeMessageStop,
 		}
 
+	case core.StreamEventTypePing:
+		resp = core.AnthropicStreamResponse{
+			Type: core.StreamEventTypePing,
+		}
+
 	default:
 		resp = core.AnthropicStreamResponse{
 			Type: "error",
@@ -243,6 +294,47 @@ func GenerateAnthropicStreamResponse(responseType string, content string, index
 	return data
 }
 
+// GenerateAnthropicMessageStart generates the message_start event carrying the model and input token count
+func GenerateAnthropicMessageStart(model string, inputTokens int) []byte {
+	resp := core.AnthropicStreamResponse{
+		Type: core.StreamEventTypeMessageStart,
+		Message: &core.AnthropicMessagesResponse{
+			ID:      GenerateMessageID(),
+			Type:    core.AnthropicTypeMessage,
+			Role:    core.RoleAssistant,
+			Content: []core.AnthropicContentBlock{},
+			Model:   model,
+			Usage: core.AnthropicUsage{
+				InputTokens: inputTokens,
+			},
+		},
+	}
+
+	data, err := util.MarshalJSON(resp)
+	if err != nil {
+		return []byte{}
+	}
+	return data
+}
+
+// GenerateAnthropicMessageDelta generates the message_delta event carrying the stop reason and output token count
+func GenerateAnthropicMessageDelta(stopReason string, stopSequence *string, outputTokens int) []byte {
+	resp := core.AnthropicMessageDeltaEvent{
+		Type: core.StreamEventTypeMessageDelta,
+		Delta: core.AnthropicMessageDelta{
+			StopReason:   stopReason,
+			StopSequence: stopSequence,
+		},
+		Usage: core.AnthropicDeltaUsage{OutputTokens: outputTokens},
+	}
+
+	data, err := util.MarshalJSON(resp)
+	if err != nil {
+		return []byte{}
+	}
+	return data
+}
+
 // GenerateMessageID generates an Anthropic message ID
 func GenerateMessageID() string {
 	return util.GenerateID(core.MessageIDPrefix)
@@ -135,6 +135,22 @@ func TestParseJetbrainsStreamToAnthropic(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:  "推理内容生成 thinking 块",
+			input: "data: {\"type\":\"Reasoning\",\"content\":\"2+2\"}\ndata: {\"type\":\"Reasoning\",\"content\":\" is 4\",\"signature\":\"sig_1\"}\ndata: {\"type\":\"Content\",\"content\":\"4\"}\ndata: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+			validateResult: func(t *testing.T, resp *core.AnthropicMessagesResponse) {
+				if len(resp.Content) != 2 {
+					t.Fatalf("期望 thinking 与 text 两个块，实际 %+v", resp.Content)
+				}
+				thinking := resp.Content[0]
+				if thinking.Type != core.ContentBlockTypeThinking || thinking.Thinking != "2+2 is 4" || thinking.Signature != "sig_1" {
+					t.Errorf("thinking 块错误: %+v", thinking)
+				}
+				if resp.Content[1].Text != "4" {
+					t.Errorf("期望文本 '4'，实际 %+v", resp.Content[1])
+				}
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,183 @@
+package convert
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// ollamaSniffLen is the amount of base64 decoded to detect an image type (http.DetectContentType reads 512 bytes)
+const ollamaSniffLen = 684
+
+// OllamaModelID strips the default ":latest" tag Ollama clients append to model names
+func OllamaModelID(name string) string {
+	return strings.TrimSuffix(name, core.OllamaDefaultTag)
+}
+
+// OllamaChatToChatRequest converts an Ollama /api/chat request into an OpenAI chat completion request
+func OllamaChatToChatRequest(req *core.OllamaChatRequest) (core.ChatCompletionRequest, error) {
+	messages, err := OllamaToChatMessages(req.Messages)
+	if err != nil {
+		return core.ChatCompletionRequest{}, err
+	}
+
+	chatReq := core.ChatCompletionRequest{
+		Model:    OllamaModelID(req.Model),
+		Messages: messages,
+		Stream:   req.Stream == nil || *req.Stream,
+		Tools:    req.Tools,
+	}
+	applyOllamaOptions(&chatReq, req.Options)
+	return chatReq, nil
+}
+
+// OllamaGenerateToChatRequest converts an Ollama /api/generate request into an OpenAI chat completion
+// request: system becomes a system message, prompt and images a single user message.
+func OllamaGenerateToChatRequest(req *core.OllamaGenerateRequest) (core.ChatCompletionRequest, error) {
+	if req.Suffix != "" {
+		return core.ChatCompletionRequest{}, fmt.Errorf("suffix is not supported")
+	}
+
+	var messages []core.OllamaMessage
+	if req.System != "" {
+		messages = append(messages, core.OllamaMessage{Role: core.RoleSystem, Content: req.System})
+	}
+	if req.Prompt != "" || len(req.Images) > 0 {
+		messages = append(messages, core.OllamaMessage{Role: core.RoleUser, Content: req.Prompt, Images: req.Images})
+	}
+
+	return OllamaChatToChatRequest(&core.OllamaChatRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Stream:   req.Stream,
+		Options:  req.Options,
+	})
+}
+
+func applyOllamaOptions(chatReq *core.ChatCompletionRequest, opts *core.OllamaOptions) {
+	if opts == nil {
+		return
+	}
+	chatReq.Temperature = opts.Temperature
+	chatReq.TopP = opts.TopP
+	chatReq.TopK = opts.TopK
+	// num_predict -1 (unlimited) and -2 (fill context) mean no limit
+	if opts.NumPredict != nil && *opts.NumPredict > 0 {
+		chatReq.MaxTokens = opts.NumPredict
+	}
+	if len(opts.Stop) > 0 {
+		chatReq.Stop = opts.Stop
+	}
+}
+
+// ollamaPendingCall is an assistant tool call still waiting for its tool message
+type ollamaPendingCall struct {
+	id   string
+	name string
+}
+
+// OllamaToChatMessages converts Ollama chat messages into OpenAI chat messages.
+// Ollama tool calls carry no id, so one is generated for each call and tool messages are
+// paired with the oldest unanswered call of the same tool_name (or the oldest call when
+// tool_name is omitted).
+func OllamaToChatMessages(messages []core.OllamaMessage) ([]core.ChatMessage, error) {
+	var result []core.ChatMessage
+	var pending []ollamaPendingCall
+
+	for i, msg := range messages {
+		switch msg.Role {
+		case core.RoleSystem, core.RoleUser:
+			converted, err := ollamaUserMessage(msg)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			result = append(result, converted)
+		case core.RoleAssistant:
+			if msg.Content == "" && len(msg.ToolCalls) == 0 {
+				continue
+			}
+			converted := core.ChatMessage{Role: core.RoleAssistant}
+			if msg.Content != "" {
+				converted.Content = msg.Content
+			}
+			for _, call := range msg.ToolCalls {
+				id := util.GenerateRandomID(core.ToolCallIDPrefix)
+				pending = append(pending, ollamaPendingCall{id: id, name: call.Function.Name})
+				converted.ToolCalls = append(converted.ToolCalls, core.ToolCall{
+					ID:       id,
+					Type:     core.ToolTypeFunction,
+					Function: core.Function{Name: call.Function.Name, Arguments: marshalJSONObject(call.Function.Arguments)},
+				})
+			}
+			result = append(result, converted)
+		case core.RoleTool:
+			idx := 0
+			if msg.ToolName != "" {
+				idx = -1
+				for j, call := range pending {
+					if call.name == msg.ToolName {
+						idx = j
+						break
+					}
+				}
+			}
+			if idx < 0 || len(pending) == 0 {
+				return nil, fmt.Errorf("messages[%d]: tool message has no matching tool call", i)
+			}
+			result = append(result, core.ChatMessage{Role: core.RoleTool, ToolCallID: pending[idx].id, Content: msg.Content})
+			pending = append(pending[:idx], pending[idx+1:]...)
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role: %q", i, msg.Role)
+		}
+	}
+
+	return result, nil
+}
+
+// ollamaUserMessage converts a system or user message; images become image_url parts
+func ollamaUserMessage(msg core.OllamaMessage) (core.ChatMessage, error) {
+	if len(msg.Images) == 0 {
+		return core.ChatMessage{Role: msg.Role, Content: msg.Content}, nil
+	}
+	if msg.Role != core.RoleUser {
+		return core.ChatMessage{}, fmt.Errorf("images are only supported in user messages")
+	}
+
+	var parts []any
+	if msg.Content != "" {
+		parts = append(parts, map[string]any{"type": core.ContentBlockTypeText, "text": msg.Content})
+	}
+	for _, image := range msg.Images {
+		url, err := ollamaImageDataURL(image)
+		if err != nil {
+			return core.ChatMessage{}, err
+		}
+		parts = append(parts, map[string]any{
+			"type":      core.ContentPartTypeImageURL,
+			"image_url": map[string]any{"url": url},
+		})
+	}
+	return core.ChatMessage{Role: core.RoleUser, Content: parts}, nil
+}
+
+// ollamaImageDataURL turns raw base64 image data into a data URL. Ollama images carry no
+// media type, so it is detected from the leading bytes.
+func ollamaImageDataURL(data string) (string, error) {
+	if strings.HasPrefix(data, "data:") {
+		return data, nil
+	}
+	head := data[:min(len(data), ollamaSniffLen)]
+	decoded, err := base64.StdEncoding.DecodeString(head[:len(head)/4*4])
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 image data: %w", err)
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(decoded), ";")
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("unsupported image type: %s", mediaType)
+	}
+	return "data:" + mediaType + ";base64," + data, nil
+}
@@ -0,0 +1,205 @@
+package convert
+
+import (
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestOllamaToChatMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		messages      []core.OllamaMessage
+		expectedRoles []string
+		wantErr       bool
+	}{
+		{
+			name: "系统与用户消息",
+			messages: []core.OllamaMessage{
+				{Role: "system", Content: "be brief"},
+				{Role: "user", Content: "hi"},
+			},
+			expectedRoles: []string{core.RoleSystem, core.RoleUser},
+		},
+		{
+			name: "工具调用往返",
+			messages: []core.OllamaMessage{
+				{Role: "user", Content: "weather?"},
+				{Role: "assistant", ToolCalls: []core.OllamaToolCall{{Function: core.OllamaToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "Beijing"}}}}},
+				{Role: "tool", Content: "sunny", ToolName: "get_weather"},
+			},
+			expectedRoles: []string{core.RoleUser, core.RoleAssistant, core.RoleTool},
+		},
+		{
+			name: "忽略空的助手消息",
+			messages: []core.OllamaMessage{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant"},
+			},
+			expectedRoles: []string{core.RoleUser},
+		},
+		{
+			name:     "工具消息无对应调用",
+			messages: []core.OllamaMessage{{Role: "tool", Content: "sunny"}},
+			wantErr:  true,
+		},
+		{
+			name: "tool_name 不匹配",
+			messages: []core.OllamaMessage{
+				{Role: "assistant", ToolCalls: []core.OllamaToolCall{{Function: core.OllamaToolCallFunction{Name: "a"}}}},
+				{Role: "tool", Content: "x", ToolName: "b"},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "非图片数据",
+			messages: []core.OllamaMessage{{Role: "user", Content: "what?", Images: []string{"aGVsbG8gd29ybGQ="}}},
+			wantErr:  true,
+		},
+		{
+			name:     "不支持的角色",
+			messages: []core.OllamaMessage{{Role: "developer", Content: "x"}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := OllamaToChatMessages(tt.messages)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望错误，实际成功: %+v", messages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(messages) != len(tt.expectedRoles) {
+				t.Fatalf("期望 %d 条消息，实际 %d: %+v", len(tt.expectedRoles), len(messages), messages)
+			}
+			for i, role := range tt.expectedRoles {
+				if messages[i].Role != role {
+					t.Errorf("消息 %d 期望角色 %s，实际 %s", i, role, messages[i].Role)
+				}
+			}
+		})
+	}
+}
+
+func TestOllamaToChatMessages_ToolCallPairing(t *testing.T) {
+	messages, err := OllamaToChatMessages([]core.OllamaMessage{
+		{Role: "assistant", ToolCalls: []core.OllamaToolCall{
+			{Function: core.OllamaToolCallFunction{Name: "lookup", Arguments: map[string]any{"q": "a"}}},
+			{Function: core.OllamaToolCallFunction{Name: "search", Arguments: map[string]any{"q": "b"}}},
+		}},
+		{Role: "tool", Content: "searched", ToolName: "search"},
+		{Role: "tool", Content: "looked up"},
+	})
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	calls := messages[0].ToolCalls
+	if len(calls) != 2 || calls[0].ID == "" || calls[0].ID == calls[1].ID {
+		t.Fatalf("应为每个工具调用生成不同的 ID: %+v", calls)
+	}
+	if calls[0].Function.Arguments != `{"q":"a"}` {
+		t.Errorf("参数应序列化为 JSON，实际 %s", calls[0].Function.Arguments)
+	}
+	if messages[1].ToolCallID != calls[1].ID {
+		t.Errorf("带 tool_name 的工具消息应匹配同名调用")
+	}
+	if messages[2].ToolCallID != calls[0].ID {
+		t.Errorf("无 tool_name 的工具消息应匹配最早的未响应调用")
+	}
+}
+
+func TestOllamaToChatMessages_Images(t *testing.T) {
+	messages, err := OllamaToChatMessages([]core.OllamaMessage{
+		{Role: "user", Content: "what is this?", Images: []string{"iVBORw0KGgo="}},
+	})
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	parts, ok := messages[0].Content.([]any)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("期望 2 个内容部分，实际 %+v", messages[0].Content)
+	}
+	imagePart := parts[1].(map[string]any)
+	url := imagePart["image_url"].(map[string]any)["url"]
+	if url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("图片类型应从数据中识别，实际 %v", url)
+	}
+}
+
+func TestOllamaGenerateToChatRequest(t *testing.T) {
+	numPredict := 64
+	unlimited := -1
+	streamOff := false
+
+	tests := []struct {
+		name          string
+		req           core.OllamaGenerateRequest
+		expectedRoles []string
+		expectedModel string
+		expectStream  bool
+		expectMax     *int
+		wantErr       bool
+	}{
+		{
+			name:          "默认流式并去除 latest 标签",
+			req:           core.OllamaGenerateRequest{Model: "gpt-4o:latest", Prompt: "hi"},
+			expectedRoles: []string{core.RoleUser},
+			expectedModel: "gpt-4o",
+			expectStream:  true,
+		},
+		{
+			name:          "system 与 num_predict",
+			req:           core.OllamaGenerateRequest{Model: "gpt-4o", System: "be brief", Prompt: "hi", Stream: &streamOff, Options: &core.OllamaOptions{NumPredict: &numPredict}},
+			expectedRoles: []string{core.RoleSystem, core.RoleUser},
+			expectedModel: "gpt-4o",
+			expectMax:     &numPredict,
+		},
+		{
+			name:          "num_predict 为 -1 不限制",
+			req:           core.OllamaGenerateRequest{Model: "gpt-4o", Prompt: "hi", Options: &core.OllamaOptions{NumPredict: &unlimited}},
+			expectedRoles: []string{core.RoleUser},
+			expectedModel: "gpt-4o",
+			expectStream:  true,
+		},
+		{
+			name:    "不支持 suffix",
+			req:     core.OllamaGenerateRequest{Model: "gpt-4o", Prompt: "def f(", Suffix: ")"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatReq, err := OllamaGenerateToChatRequest(&tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望错误，实际成功")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if chatReq.Model != tt.expectedModel || chatReq.Stream != tt.expectStream {
+				t.Errorf("期望模型 %s、stream=%v，实际 %s、%v", tt.expectedModel, tt.expectStream, chatReq.Model, chatReq.Stream)
+			}
+			if len(chatReq.Messages) != len(tt.expectedRoles) {
+				t.Fatalf("期望 %d 条消息，实际 %+v", len(tt.expectedRoles), chatReq.Messages)
+			}
+			for i, role := range tt.expectedRoles {
+				if chatReq.Messages[i].Role != role {
+					t.Errorf("消息 %d 期望角色 %s，实际 %s", i, role, chatReq.Messages[i].Role)
+				}
+			}
+			if (tt.expectMax == nil) != (chatReq.MaxTokens == nil) || (tt.expectMax != nil && *chatReq.MaxTokens != *tt.expectMax) {
+				t.Errorf("期望 max_tokens %v，实际 %v", tt.expectMax, chatReq.MaxTokens)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package convert
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"jetbrainsai2api/internal/core"
@@ -15,6 +17,7 @@ type MessageConverter struct {
 	toolIDToFuncNameMap map[string]string
 	validator           *validate.ImageValidator
 	logger              core.Logger
+	imageBytes          int64
 }
 
 var converterPool = sync.Pool{
@@ -27,26 +30,31 @@ var converterPool = sync.Pool{
 	},
 }
 
-// OpenAIToJetbrainsMessages converts OpenAI chat messages to JetBrains format
-func OpenAIToJetbrainsMessages(messages []core.ChatMessage) []core.JetbrainsMessage {
+// OpenAIToJetbrainsMessages converts OpenAI chat messages to JetBrains format. An image part
+// that cannot be converted fails the whole conversion.
+func OpenAIToJetbrainsMessages(messages []core.ChatMessage) ([]core.JetbrainsMessage, error) {
 	converter := converterPool.Get().(*MessageConverter)
 	defer func() {
 		clear(converter.toolIDToFuncNameMap)
+		converter.imageBytes = 0
 		converterPool.Put(converter)
 	}()
 	return converter.Convert(messages)
 }
 
-// Convert executes message conversion
-func (c *MessageConverter) Convert(messages []core.ChatMessage) []core.JetbrainsMessage {
+// Convert executes message conversion; the error names the offending message part
+func (c *MessageConverter) Convert(messages []core.ChatMessage) ([]core.JetbrainsMessage, error) {
 	c.buildToolIDMap(messages)
 
 	var result []core.JetbrainsMessage
-	for _, msg := range messages {
-		converted := c.convertMessage(msg)
+	for i, msg := range messages {
+		converted, err := c.convertMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d].%w", i, err)
+		}
 		result = append(result, converted...)
 	}
-	return result
+	return result, nil
 }
 
 func (c *MessageConverter) buildToolIDMap(messages []core.ChatMessage) {
@@ -61,62 +69,92 @@ func (c *MessageConverter) buildToolIDMap(messages []core.ChatMessage) {
 	}
 }
 
-func (c *MessageConverter) convertMessage(msg core.ChatMessage) []core.JetbrainsMessage {
+func (c *MessageConverter) convertMessage(msg core.ChatMessage) ([]core.JetbrainsMessage, error) {
 	switch msg.Role {
 	case core.RoleUser:
 		return c.convertUserMessage(msg)
 	case core.RoleSystem:
-		return c.convertSystemMessage(msg)
+		return c.convertSystemMessage(msg), nil
 	case core.RoleAssistant:
-		return c.convertAssistantMessage(msg)
+		return c.convertAssistantMessage(msg), nil
 	case core.RoleTool:
-		return c.convertToolMessage(msg)
+		return c.convertToolMessage(msg), nil
 	default:
-		return c.convertDefaultMessage(msg)
+		return c.convertDefaultMessage(msg), nil
+	}
+}
+
+func (c *MessageConverter) convertUserMessage(msg core.ChatMessage) ([]core.JetbrainsMessage, error) {
+	if contentArray, ok := msg.Content.([]any); ok && hasImagePart(contentArray) {
+		return c.convertMixedContent(contentArray)
 	}
+	return c.convertTextContent(msg.Content), nil
 }
 
-func (c *MessageConverter) convertUserMessage(msg core.ChatMessage) []core.JetbrainsMessage {
+// convertMixedContent converts text and image parts in order, one JetBrains message per part.
+// An image that fails validation or exceeds the per-request size budget is an error.
+func (c *MessageConverter) convertMixedContent(contentArray []any) ([]core.JetbrainsMessage, error) {
 	var result []core.JetbrainsMessage
 
-	mediaType, imageData, hasImage := validate.ExtractImageDataFromContent(msg.Content)
-	if hasImage {
-		result = append(result, c.convertImageContent(mediaType, imageData, msg.Content)...)
-		return result
+	for i, item := range contentArray {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		switch itemMap["type"] {
+		case core.ContentBlockTypeText:
+			if text, ok := itemMap["text"].(string); ok && text != "" {
+				result = append(result, core.JetbrainsMessage{
+					Type:    core.JetBrainsMessageTypeUser,
+					Content: text,
+				})
+			}
+		case core.ContentPartTypeImageURL:
+			mediaMsg, err := c.convertImagePart(itemMap)
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			result = append(result, mediaMsg)
+		}
 	}
 
-	result = append(result, c.convertTextContent(msg.Content)...)
-	return result
+	return result, nil
 }
 
-func (c *MessageConverter) convertImageContent(mediaType, imageData string, content any) []core.JetbrainsMessage {
-	var result []core.JetbrainsMessage
+func (c *MessageConverter) convertImagePart(part map[string]any) (core.JetbrainsMessage, error) {
+	imageURL, _ := part["image_url"].(map[string]any)
+	url, _ := imageURL["url"].(string)
+
+	mediaType, imageData, ok := validate.ParseImageDataURL(url)
+	if !ok {
+		return core.JetbrainsMessage{}, errors.New("image_url must be a base64 data URL")
+	}
 
 	if err := c.validator.ValidateImageData(mediaType, imageData); err != nil {
-		c.logger.Warn("Image validation failed: %v", err)
-		textContent := util.ExtractTextContent(content)
-		result = append(result, core.JetbrainsMessage{
-			Type:    core.JetBrainsMessageTypeUser,
-			Content: textContent,
-		})
-		return result
+		return core.JetbrainsMessage{}, fmt.Errorf("invalid image: %w", err)
 	}
 
-	result = append(result, core.JetbrainsMessage{
+	size := validate.DecodedImageSize(imageData)
+	if c.imageBytes+size > core.MaxRequestImageBytes {
+		return core.JetbrainsMessage{}, fmt.Errorf("images exceed the per-request limit of %d bytes", core.MaxRequestImageBytes)
+	}
+	c.imageBytes += size
+
+	return core.JetbrainsMessage{
 		Type:      core.JetBrainsMessageTypeMedia,
 		MediaType: mediaType,
 		Data:      imageData,
-	})
+	}, nil
+}
 
-	textContent := util.ExtractTextContent(content)
-	if textContent != "" {
-		result = append(result, core.JetbrainsMessage{
-			Type:    core.JetBrainsMessageTypeUser,
-			Content: textContent,
-		})
+func hasImagePart(contentArray []any) bool {
+	for _, item := range contentArray {
+		if itemMap, ok := item.(map[string]any); ok && itemMap["type"] == core.ContentPartTypeImageURL {
+			return true
+		}
 	}
-
-	return result
+	return false
 }
 
 func (c *MessageConverter) convertTextContent(content any) []core.JetbrainsMessage {
@@ -155,8 +193,15 @@ func (c *MessageConverter) convertSystemMessage(msg core.ChatMessage) []core.Jet
 }
 
 func (c *MessageConverter) convertAssistantMessage(msg core.ChatMessage) []core.JetbrainsMessage {
+	var result []core.JetbrainsMessage
+	if msg.ReasoningContent != "" {
+		result = append(result, core.JetbrainsMessage{
+			Type:    core.JetBrainsMessageTypeAssistantReasoning,
+			Content: msg.ReasoningContent,
+		})
+	}
+
 	if len(msg.ToolCalls) > 0 {
-		var result []core.JetbrainsMessage
 
 		// If both text content and tool calls exist, preserve text first
 		textContent := util.ExtractTextContent(msg.Content)
@@ -174,10 +219,10 @@ func (c *MessageConverter) convertAssistantMessage(msg core.ChatMessage) []core.
 	}
 
 	textContent := util.ExtractTextContent(msg.Content)
-	return []core.JetbrainsMessage{{
+	return append(result, core.JetbrainsMessage{
 		Type:    core.JetBrainsMessageTypeAssistantText,
 		Content: textContent,
-	}}
+	})
 }
 
 func (c *MessageConverter) convertAssistantToolCall(toolCall core.ToolCall) []core.JetbrainsMessage {
@@ -1,6 +1,8 @@
 package convert
 
 import (
+	"encoding/base64"
+	"strings"
 	"testing"
 
 	"jetbrainsai2api/internal/core"
@@ -18,7 +20,10 @@ func TestOpenAIToJetbrainsMessages_MultipleTextContent(t *testing.T) {
 			},
 		},
 	}
-	result := OpenAIToJetbrainsMessages(messages)
+	result, err := OpenAIToJetbrainsMessages(messages)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
 	if len(result) != 3 {
 		t.Errorf("期望生成 3 个消息，实际生成 %d 个", len(result))
 	}
@@ -37,7 +42,10 @@ func TestOpenAIToJetbrainsMessages_MultipleTextContent(t *testing.T) {
 
 func TestOpenAIToJetbrainsMessages_SingleTextContent(t *testing.T) {
 	messages := []core.ChatMessage{{Role: core.RoleUser, Content: "单一文本消息"}}
-	result := OpenAIToJetbrainsMessages(messages)
+	result, err := OpenAIToJetbrainsMessages(messages)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
 	if len(result) != 1 {
 		t.Errorf("期望 1 个消息，实际 %d 个", len(result))
 	}
@@ -106,6 +114,21 @@ func TestConvertAssistantMessage_NoToolCalls(t *testing.T) {
 	}
 }
 
+func TestConvertAssistantMessage_ReasoningContent(t *testing.T) {
+	converter := &MessageConverter{toolIDToFuncNameMap: make(map[string]string), logger: &core.NopLogger{}}
+	msg := core.ChatMessage{Role: core.RoleAssistant, Content: "4", ReasoningContent: "2+2=4"}
+	result := converter.convertAssistantMessage(msg)
+	if len(result) != 2 {
+		t.Fatalf("期望推理与文本两个消息，实际 %+v", result)
+	}
+	if result[0].Type != core.JetBrainsMessageTypeAssistantReasoning || result[0].Content != "2+2=4" {
+		t.Errorf("推理内容应先于文本传回，实际 %+v", result[0])
+	}
+	if result[1].Type != core.JetBrainsMessageTypeAssistantText || result[1].Content != "4" {
+		t.Errorf("期望 assistant_message_text，实际 %+v", result[1])
+	}
+}
+
 func TestConvertToolMessage(t *testing.T) {
 	converter := &MessageConverter{toolIDToFuncNameMap: map[string]string{"call_123": "get_weather"}, logger: &core.NopLogger{}}
 	msg := core.ChatMessage{Role: core.RoleTool, ToolCallID: "call_123", Content: `{"temperature": 25}`}
@@ -164,7 +187,10 @@ func TestMessageConverterConvert(t *testing.T) {
 		validator:           validate.NewImageValidator(),
 		logger:              &core.NopLogger{},
 	}
-	result := converter.Convert(messages)
+	result, err := converter.Convert(messages)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
 	if len(result) != 3 {
 		t.Errorf("期望 3 个消息，实际 %d 个", len(result))
 	}
@@ -200,3 +226,86 @@ func TestConvertTextContent(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertUserMessage_MultipleImages(t *testing.T) {
+	imagePart := func(data string) map[string]any {
+		return map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64," + data}}
+	}
+	smallImage := base64.StdEncoding.EncodeToString(make([]byte, 100))
+	// Each image is under MaxImageSizeBytes, but three of them exceed MaxRequestImageBytes
+	largeImage := base64.StdEncoding.EncodeToString(make([]byte, core.MaxRequestImageBytes/3+1))
+
+	tests := []struct {
+		name          string
+		content       []any
+		expectedTypes []string
+		expectedErr   string
+	}{
+		{
+			name: "文本与多张图片交错保序",
+			content: []any{
+				map[string]any{"type": "text", "text": "对比这两张截图"},
+				imagePart(smallImage),
+				map[string]any{"type": "text", "text": "以及"},
+				imagePart(smallImage),
+			},
+			expectedTypes: []string{
+				core.JetBrainsMessageTypeUser, core.JetBrainsMessageTypeMedia,
+				core.JetBrainsMessageTypeUser, core.JetBrainsMessageTypeMedia,
+			},
+		},
+		{
+			name:        "无效图片返回错误",
+			content:     []any{imagePart(smallImage), map[string]any{"type": "text", "text": "说明"}, imagePart("!!!invalid!!!")},
+			expectedErr: "messages[0].content[2]: invalid image",
+		},
+		{
+			name:        "非 data URL 图片返回错误",
+			content:     []any{map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png"}}},
+			expectedErr: "messages[0].content[0]: image_url must be a base64 data URL",
+		},
+		{
+			name:        "超过单请求总大小的图片返回错误",
+			content:     []any{imagePart(largeImage), imagePart(largeImage), imagePart(largeImage)},
+			expectedErr: "messages[0].content[2]: images exceed the per-request limit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := OpenAIToJetbrainsMessages([]core.ChatMessage{{Role: core.RoleUser, Content: tt.content}})
+			if tt.expectedErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.expectedErr) {
+					t.Fatalf("期望错误 %q，实际 %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(result) != len(tt.expectedTypes) {
+				t.Fatalf("期望 %d 个消息，实际 %d 个", len(tt.expectedTypes), len(result))
+			}
+			for i, et := range tt.expectedTypes {
+				if result[i].Type != et {
+					t.Errorf("消息 %d 类型错误，期望 '%s'，实际 '%s'", i, et, result[i].Type)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIToJetbrainsMessages_ImageBudgetIsPerRequest(t *testing.T) {
+	largeImage := base64.StdEncoding.EncodeToString(make([]byte, core.MaxRequestImageBytes/3+1))
+	messages := []core.ChatMessage{{Role: core.RoleUser, Content: []any{
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64," + largeImage}},
+	}}}
+
+	// Pooled converters must not carry the image budget over to the next request
+	for i := 0; i < 3; i++ {
+		result, err := OpenAIToJetbrainsMessages(messages)
+		if err != nil || len(result) != 1 || result[0].Type != core.JetBrainsMessageTypeMedia {
+			t.Fatalf("第 %d 次请求应保留图片，实际: %d 个消息", i+1, len(result))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// JetBrains has no structured output mode, so response_format is phrased as a system instruction
+// and the output is validated afterwards.
+const (
+	jsonObjectInstruction = "Respond with a single valid JSON object and nothing else. Do not add explanations " +
+		"and do not wrap the JSON in Markdown code fences."
+	jsonSchemaInstruction = "Respond with a single JSON value that conforms to the JSON Schema below and nothing else. " +
+		"Do not add explanations and do not wrap the JSON in Markdown code fences."
+	strictSchemaInstruction = "Include every required property and no properties that the schema does not declare."
+)
+
+// ResponseFormatInstruction validates response_format and returns the system instruction that asks
+// for it; "" when the format is absent or text.
+func ResponseFormatInstruction(format *core.ResponseFormat) (string, error) {
+	if format == nil {
+		return "", nil
+	}
+
+	switch format.Type {
+	case core.ResponseFormatText:
+		return "", nil
+	case core.ResponseFormatJSONObject:
+		return jsonObjectInstruction, nil
+	case core.ResponseFormatJSONSchema:
+		schema := format.JSONSchema
+		if schema == nil || schema.Name == "" {
+			return "", fmt.Errorf("response_format.json_schema.name is required")
+		}
+
+		var b strings.Builder
+		b.WriteString(jsonSchemaInstruction)
+		if schema.Strict != nil && *schema.Strict {
+			b.WriteString(" " + strictSchemaInstruction)
+		}
+		b.WriteString("\n\nSchema name: " + schema.Name)
+		if schema.Description != "" {
+			b.WriteString("\nSchema description: " + schema.Description)
+		}
+		if schema.Schema != nil {
+			schemaJSON, err := util.MarshalJSON(schema.Schema)
+			if err != nil {
+				return "", fmt.Errorf("response_format.json_schema.schema is not valid JSON")
+			}
+			b.WriteString("\nJSON Schema:\n" + string(schemaJSON))
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unsupported response_format type %q; expected text, json_object or json_schema", format.Type)
+}
+
+// ApplyResponseFormat adds the response_format instruction to the system message, or prepends a
+// system message if the conversation has none. The messages are copied, not modified.
+func ApplyResponseFormat(messages []core.ChatMessage, format *core.ResponseFormat) ([]core.ChatMessage, error) {
+	instruction, err := ResponseFormatInstruction(format)
+	if err != nil || instruction == "" {
+		return messages, err
+	}
+
+	if len(messages) > 0 && messages[0].Role == core.RoleSystem {
+		if text, ok := messages[0].Content.(string); ok {
+			result := append([]core.ChatMessage(nil), messages...)
+			result[0].Content = text + "\n\n" + instruction
+			return result, nil
+		}
+	}
+	return append([]core.ChatMessage{{Role: core.RoleSystem, Content: instruction}}, messages...), nil
+}
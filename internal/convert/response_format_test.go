@@ -0,0 +1,60 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestApplyResponseFormat(t *testing.T) {
+	strict := true
+	schemaFormat := &core.ResponseFormat{Type: core.ResponseFormatJSONSchema, JSONSchema: &core.JSONSchemaFormat{
+		Name:   "person",
+		Strict: &strict,
+		Schema: map[string]any{"type": "object"},
+	}}
+	user := core.ChatMessage{Role: core.RoleUser, Content: "hi"}
+	system := core.ChatMessage{Role: core.RoleSystem, Content: "Be brief."}
+
+	tests := []struct {
+		name           string
+		messages       []core.ChatMessage
+		format         *core.ResponseFormat
+		expectedCount  int
+		expectedSystem []string
+		wantErr        bool
+	}{
+		{"未指定格式", []core.ChatMessage{user}, nil, 1, nil, false},
+		{"text 格式", []core.ChatMessage{user}, &core.ResponseFormat{Type: core.ResponseFormatText}, 1, nil, false},
+		{"json_object 新增系统消息", []core.ChatMessage{user}, &core.ResponseFormat{Type: core.ResponseFormatJSONObject}, 2, []string{"JSON object"}, false},
+		{"json_schema 合并到系统消息", []core.ChatMessage{system, user}, schemaFormat, 2, []string{"Be brief.", "person", `{"type":"object"}`, "no properties"}, false},
+		{"json_schema 缺少 name", []core.ChatMessage{user}, &core.ResponseFormat{Type: core.ResponseFormatJSONSchema}, 0, nil, true},
+		{"未知类型", []core.ChatMessage{user}, &core.ResponseFormat{Type: "xml"}, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ApplyResponseFormat(tt.messages, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(messages) != tt.expectedCount {
+				t.Fatalf("期望 %d 条消息，实际 %d", tt.expectedCount, len(messages))
+			}
+			for _, want := range tt.expectedSystem {
+				if messages[0].Role != core.RoleSystem || !strings.Contains(messages[0].Content.(string), want) {
+					t.Errorf("系统消息应包含 %q，实际 %+v", want, messages[0])
+				}
+			}
+		})
+	}
+
+	original := []core.ChatMessage{system, user}
+	_, _ = ApplyResponseFormat(original, schemaFormat)
+	if original[0].Content != "Be brief." {
+		t.Errorf("不应修改原始消息，实际 %v", original[0].Content)
+	}
+}
@@ -0,0 +1,171 @@
+package convert
+
+import (
+	"fmt"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// ResponsesToChatMessages converts Responses API instructions and input items into
+// OpenAI chat messages, so the existing OpenAI → JetBrains conversion (and its cache) can be reused.
+func ResponsesToChatMessages(instructions string, input any) ([]core.ChatMessage, error) {
+	var messages []core.ChatMessage
+
+	if instructions != "" {
+		messages = append(messages, core.ChatMessage{Role: core.RoleSystem, Content: instructions})
+	}
+
+	switch v := input.(type) {
+	case nil:
+	case string:
+		messages = append(messages, core.ChatMessage{Role: core.RoleUser, Content: v})
+	case []any:
+		for i, item := range v {
+			itemMap, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("input[%d] must be an object", i)
+			}
+			msg, keep, err := responsesItemToChatMessage(itemMap)
+			if err != nil {
+				return nil, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			if keep {
+				messages = append(messages, msg)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("input must be a string or an array of items")
+	}
+
+	return messages, nil
+}
+
+func responsesItemToChatMessage(item map[string]any) (core.ChatMessage, bool, error) {
+	itemType, _ := item["type"].(string)
+
+	switch itemType {
+	case core.ResponsesItemTypeFunctionCall:
+		callID, _ := item["call_id"].(string)
+		name, _ := item["name"].(string)
+		arguments, _ := item["arguments"].(string)
+		if callID == "" || name == "" {
+			return core.ChatMessage{}, false, fmt.Errorf("function_call requires call_id and name")
+		}
+		return core.ChatMessage{
+			Role: core.RoleAssistant,
+			ToolCalls: []core.ToolCall{{
+				ID:       callID,
+				Type:     core.ToolTypeFunction,
+				Function: core.Function{Name: name, Arguments: arguments},
+			}},
+		}, true, nil
+
+	case core.ResponsesItemTypeFunctionCallOutput:
+		callID, _ := item["call_id"].(string)
+		if callID == "" {
+			return core.ChatMessage{}, false, fmt.Errorf("function_call_output requires call_id")
+		}
+		return core.ChatMessage{
+			Role:       core.RoleTool,
+			ToolCallID: callID,
+			Content:    responsesOutputToString(item["output"]),
+		}, true, nil
+
+	case core.ResponsesItemTypeReasoning:
+		// Reasoning items are opaque to the upstream; drop them from history.
+		return core.ChatMessage{}, false, nil
+
+	case "", core.ResponsesItemTypeMessage:
+		role, _ := item["role"].(string)
+		switch role {
+		case core.RoleDeveloper:
+			role = core.RoleSystem
+		case core.RoleUser, core.RoleSystem, core.RoleAssistant:
+		default:
+			return core.ChatMessage{}, false, fmt.Errorf("unsupported message role: %q", role)
+		}
+		return core.ChatMessage{Role: role, Content: responsesContentToChatContent(item["content"])}, true, nil
+
+	default:
+		return core.ChatMessage{}, false, fmt.Errorf("unsupported input item type: %q", itemType)
+	}
+}
+
+// responsesContentToChatContent maps Responses content parts onto OpenAI chat content parts.
+func responsesContentToChatContent(content any) any {
+	parts, ok := content.([]any)
+	if !ok {
+		return content
+	}
+
+	var result []any
+	for _, part := range parts {
+		partMap, ok := part.(map[string]any)
+		if !ok {
+			continue
+		}
+		partType, _ := partMap["type"].(string)
+		switch partType {
+		case core.ResponsesContentTypeInputText, core.ResponsesContentTypeOutputText, core.ContentBlockTypeText:
+			if text, _ := partMap["text"].(string); text != "" {
+				result = append(result, map[string]any{"type": core.ContentBlockTypeText, "text": text})
+			}
+		case core.ResponsesContentTypeInputImage:
+			if url, _ := partMap["image_url"].(string); url != "" {
+				result = append(result, map[string]any{
+					"type":      core.ContentPartTypeImageURL,
+					"image_url": map[string]any{"url": url},
+				})
+			}
+		}
+	}
+	return result
+}
+
+func responsesOutputToString(output any) string {
+	switch v := output.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		return util.ExtractTextContent(responsesContentToChatContent(v))
+	default:
+		if data, err := util.MarshalJSON(v); err == nil {
+			return string(data)
+		}
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// ResponsesToOpenAITools converts Responses API function tools to OpenAI chat tools.
+// Built-in tool types (web_search, file_search, ...) have no JetBrains equivalent and are rejected.
+func ResponsesToOpenAITools(tools []core.ResponsesTool) ([]core.Tool, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	result := make([]core.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != core.ToolTypeFunction {
+			return nil, fmt.Errorf("unsupported tool type: %q", tool.Type)
+		}
+		if tool.Name == "" {
+			return nil, fmt.Errorf("function tool requires a name")
+		}
+		parameters := tool.Parameters
+		if parameters == nil {
+			parameters = map[string]any{"type": core.SchemaTypeObject, "properties": map[string]any{}}
+		}
+		result = append(result, core.Tool{
+			Type: core.ToolTypeFunction,
+			Function: core.ToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+	return result, nil
+}
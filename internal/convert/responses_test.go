@@ -0,0 +1,114 @@
+package convert
+
+import (
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestResponsesToChatMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		instructions  string
+		input         any
+		expectedRoles []string
+		wantErr       bool
+	}{
+		{name: "字符串输入", input: "你好", expectedRoles: []string{core.RoleUser}},
+		{name: "instructions 作为系统消息", instructions: "be brief", input: "hi", expectedRoles: []string{core.RoleSystem, core.RoleUser}},
+		{
+			name: "developer 角色映射为 system",
+			input: []any{
+				map[string]any{"role": "developer", "content": "rules"},
+				map[string]any{"type": "message", "role": "user", "content": []any{
+					map[string]any{"type": "input_text", "text": "question"},
+				}},
+			},
+			expectedRoles: []string{core.RoleSystem, core.RoleUser},
+		},
+		{
+			name: "函数调用往返",
+			input: []any{
+				map[string]any{"role": "user", "content": "weather?"},
+				map[string]any{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": `{"city":"Beijing"}`},
+				map[string]any{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+			},
+			expectedRoles: []string{core.RoleUser, core.RoleAssistant, core.RoleTool},
+		},
+		{
+			name:          "忽略 reasoning 项",
+			input:         []any{map[string]any{"type": "reasoning", "id": "rs_1"}, map[string]any{"role": "user", "content": "hi"}},
+			expectedRoles: []string{core.RoleUser},
+		},
+		{name: "不支持的项类型", input: []any{map[string]any{"type": "web_search_call"}}, wantErr: true},
+		{name: "function_call 缺少 call_id", input: []any{map[string]any{"type": "function_call", "name": "f"}}, wantErr: true},
+		{name: "非法输入类型", input: 123.0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ResponsesToChatMessages(tt.instructions, tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望错误，实际成功: %+v", messages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if len(messages) != len(tt.expectedRoles) {
+				t.Fatalf("期望 %d 条消息，实际 %d 条", len(tt.expectedRoles), len(messages))
+			}
+			for i, role := range tt.expectedRoles {
+				if messages[i].Role != role {
+					t.Errorf("消息 %d 角色错误，期望 '%s'，实际 '%s'", i, role, messages[i].Role)
+				}
+			}
+		})
+	}
+}
+
+func TestResponsesToChatMessages_FunctionCallPreservesIDs(t *testing.T) {
+	messages, err := ResponsesToChatMessages("", []any{
+		map[string]any{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": `{"city":"Beijing"}`},
+		map[string]any{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+	})
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+
+	jetbrainsMessages, err := OpenAIToJetbrainsMessages(messages)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	if len(jetbrainsMessages) != 2 {
+		t.Fatalf("期望 2 条 JetBrains 消息，实际 %d", len(jetbrainsMessages))
+	}
+	if jetbrainsMessages[0].Type != core.JetBrainsMessageTypeAssistantTool || jetbrainsMessages[0].ID != "call_1" {
+		t.Errorf("第一条应为 assistant_message_tool 且保留 call_id，实际: %+v", jetbrainsMessages[0])
+	}
+	if jetbrainsMessages[1].Type != core.JetBrainsMessageTypeTool || jetbrainsMessages[1].ToolName != "get_weather" || jetbrainsMessages[1].Result != "sunny" {
+		t.Errorf("第二条应为 tool_message 并关联函数名，实际: %+v", jetbrainsMessages[1])
+	}
+}
+
+func TestResponsesToOpenAITools(t *testing.T) {
+	tools, err := ResponsesToOpenAITools([]core.ResponsesTool{
+		{Type: "function", Name: "get_weather", Parameters: map[string]any{"type": "object"}},
+		{Type: "function", Name: "no_params"},
+	})
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	if len(tools) != 2 || tools[0].Function.Name != "get_weather" {
+		t.Fatalf("工具转换错误: %+v", tools)
+	}
+	if tools[1].Function.Parameters["type"] != core.SchemaTypeObject {
+		t.Errorf("缺省参数应补全为 object schema，实际: %v", tools[1].Function.Parameters)
+	}
+
+	if _, err := ResponsesToOpenAITools([]core.ResponsesTool{{Type: "web_search"}}); err == nil {
+		t.Error("内置工具类型应返回错误")
+	}
+}
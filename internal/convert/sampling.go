@@ -0,0 +1,81 @@
+package convert
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+)
+
+// OpenAISamplingParams extracts sampling parameters from an OpenAI chat completion request
+func OpenAISamplingParams(req *core.ChatCompletionRequest) core.SamplingParams {
+	return core.SamplingParams{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		MaxTokens:   req.MaxTokens,
+		Stop:        NormalizeStop(req.Stop),
+	}
+}
+
+// AnthropicSamplingParams extracts sampling parameters from an Anthropic Messages request
+func AnthropicSamplingParams(req *core.AnthropicMessagesRequest) core.SamplingParams {
+	params := core.SamplingParams{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		Stop:        req.StopSequences,
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		params.MaxTokens = &maxTokens
+	}
+	return params
+}
+
+// OpenAIReasoningParams extracts the reasoning setting of an OpenAI chat completion request,
+// rejecting an unknown reasoning_effort
+func OpenAIReasoningParams(req *core.ChatCompletionRequest) (core.ReasoningParams, error) {
+	if req.ReasoningEffort != "" && !slices.Contains(core.ReasoningEfforts, req.ReasoningEffort) {
+		return core.ReasoningParams{}, fmt.Errorf("reasoning_effort must be one of %s", strings.Join(core.ReasoningEfforts, ", "))
+	}
+	return core.ReasoningParams{Effort: req.ReasoningEffort}, nil
+}
+
+// AnthropicReasoningParams extracts the reasoning setting of an Anthropic Messages request:
+// the thinking budget when thinking is enabled, effort none when it is explicitly disabled
+func AnthropicReasoningParams(req *core.AnthropicMessagesRequest) core.ReasoningParams {
+	switch {
+	case req.Thinking.Enabled():
+		return core.ReasoningParams{BudgetTokens: req.Thinking.BudgetTokens}
+	case req.Thinking != nil && req.Thinking.Type == core.ThinkingTypeDisabled:
+		return core.ReasoningParams{Effort: core.ReasoningEffortNone}
+	}
+	return core.ReasoningParams{}
+}
+
+// NormalizeStop converts the OpenAI stop field (string or array of strings) to a slice,
+// dropping empty sequences
+func NormalizeStop(stop any) []string {
+	var result []string
+	switch v := stop.(type) {
+	case string:
+		if v != "" {
+			result = append(result, v)
+		}
+	case []string:
+		for _, s := range v {
+			if s != "" {
+				result = append(result, s)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"slices"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestNormalizeStop(t *testing.T) {
+	tests := []struct {
+		name     string
+		stop     any
+		expected []string
+	}{
+		{"未设置", nil, nil},
+		{"单个字符串", "END", []string{"END"}},
+		{"空字符串", "", nil},
+		{"字符串数组", []any{"a", "", "b", 1}, []string{"a", "b"}},
+		{"类型化数组", []string{"x"}, []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := NormalizeStop(tt.stop); !slices.Equal(result, tt.expected) {
+				t.Errorf("期望 %v，实际 %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestAnthropicSamplingParams(t *testing.T) {
+	topK := 5
+	params := AnthropicSamplingParams(&core.AnthropicMessagesRequest{
+		MaxTokens:     1024,
+		TopK:          &topK,
+		StopSequences: []string{"STOP"},
+	})
+
+	if params.MaxTokens == nil || *params.MaxTokens != 1024 {
+		t.Errorf("max_tokens 应该为 1024，实际 %v", params.MaxTokens)
+	}
+	if params.TopK == nil || *params.TopK != 5 {
+		t.Errorf("top_k 应该为 5，实际 %v", params.TopK)
+	}
+	if params.Temperature != nil {
+		t.Error("未设置的 temperature 应该为 nil")
+	}
+	if !slices.Equal(params.Stop, []string{"STOP"}) {
+		t.Errorf("stop 应该为 [STOP]，实际 %v", params.Stop)
+	}
+}
+
+func TestReasoningParams(t *testing.T) {
+	t.Run("OpenAI reasoning_effort", func(t *testing.T) {
+		params, err := OpenAIReasoningParams(&core.ChatCompletionRequest{ReasoningEffort: core.ReasoningEffortHigh})
+		if err != nil || params.Effort != core.ReasoningEffortHigh {
+			t.Errorf("期望 effort=high，实际 %+v, %v", params, err)
+		}
+		if _, err := OpenAIReasoningParams(&core.ChatCompletionRequest{ReasoningEffort: "extreme"}); err == nil {
+			t.Error("未知的 reasoning_effort 应返回错误")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		thinking *core.AnthropicThinkingConfig
+		expected core.ReasoningParams
+	}{
+		{"未设置 thinking", nil, core.ReasoningParams{}},
+		{"启用 thinking", &core.AnthropicThinkingConfig{Type: core.ThinkingTypeEnabled, BudgetTokens: 4096}, core.ReasoningParams{BudgetTokens: 4096}},
+		{"禁用 thinking", &core.AnthropicThinkingConfig{Type: core.ThinkingTypeDisabled}, core.ReasoningParams{Effort: core.ReasoningEffortNone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if params := AnthropicReasoningParams(&core.AnthropicMessagesRequest{Thinking: tt.thinking}); params != tt.expected {
+				t.Errorf("期望 %+v，实际 %+v", tt.expected, params)
+			}
+		})
+	}
+}
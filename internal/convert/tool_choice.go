@@ -0,0 +1,126 @@
+package convert
+
+import (
+	"fmt"
+	"slices"
+
+	"jetbrainsai2api/internal/core"
+)
+
+// ParseOpenAIToolChoice normalizes an OpenAI chat completions or Responses tool_choice.
+// Accepts "auto", "none", "required", {"type":"function","function":{"name":...}}
+// and the Responses form {"type":"function","name":...}.
+func ParseOpenAIToolChoice(choice any) (core.ToolChoice, error) {
+	switch v := choice.(type) {
+	case nil:
+		return core.ToolChoice{Mode: core.ToolChoiceAuto}, nil
+	case string:
+		switch v {
+		case core.ToolChoiceAuto, core.ToolChoiceNone, core.ToolChoiceRequired:
+			return core.ToolChoice{Mode: v}, nil
+		case core.ToolChoiceAny:
+			return core.ToolChoice{Mode: core.ToolChoiceRequired}, nil
+		}
+	case map[string]any:
+		if choiceType, _ := v["type"].(string); choiceType == core.ToolTypeFunction {
+			name, _ := v["name"].(string)
+			if function, ok := v["function"].(map[string]any); ok {
+				name, _ = function["name"].(string)
+			}
+			if name != "" {
+				return core.ToolChoice{Mode: core.ToolChoiceRequired, Name: name}, nil
+			}
+		}
+	}
+	return core.ToolChoice{}, fmt.Errorf("invalid tool_choice: %v", choice)
+}
+
+// ParseAnthropicToolChoice normalizes an Anthropic tool_choice object
+// ({"type":"auto"|"any"|"none"} or {"type":"tool","name":...}).
+func ParseAnthropicToolChoice(choice any) (core.ToolChoice, error) {
+	if choice == nil {
+		return core.ToolChoice{Mode: core.ToolChoiceAuto}, nil
+	}
+	v, ok := choice.(map[string]any)
+	if !ok {
+		return core.ToolChoice{}, fmt.Errorf("tool_choice must be an object")
+	}
+
+	choiceType, _ := v["type"].(string)
+	switch choiceType {
+	case core.ToolChoiceAuto, core.ToolChoiceNone:
+		return core.ToolChoice{Mode: choiceType}, nil
+	case core.ToolChoiceAny:
+		return core.ToolChoice{Mode: core.ToolChoiceRequired}, nil
+	case core.ToolChoiceTool:
+		if name, _ := v["name"].(string); name != "" {
+			return core.ToolChoice{Mode: core.ToolChoiceRequired, Name: name}, nil
+		}
+		return core.ToolChoice{}, fmt.Errorf("tool_choice.name is required when type is %q", core.ToolChoiceTool)
+	}
+	return core.ToolChoice{}, fmt.Errorf("invalid tool_choice type: %q", choiceType)
+}
+
+// FilterOpenAITools applies a tool choice to the tool list: none drops every tool
+// and a named choice keeps only that function.
+func FilterOpenAITools(tools []core.Tool, choice core.ToolChoice) ([]core.Tool, error) {
+	return filterTools(tools, choice, func(tool core.Tool) string { return tool.Function.Name })
+}
+
+// FilterAnthropicTools is the Anthropic counterpart of FilterOpenAITools.
+func FilterAnthropicTools(tools []core.AnthropicTool, choice core.ToolChoice) ([]core.AnthropicTool, error) {
+	return filterTools(tools, choice, func(tool core.AnthropicTool) string { return tool.Name })
+}
+
+func filterTools[T any](tools []T, choice core.ToolChoice, nameOf func(T) string) ([]T, error) {
+	switch {
+	case choice.Mode == core.ToolChoiceNone:
+		return nil, nil
+	case choice.Name != "":
+		for _, tool := range tools {
+			if nameOf(tool) == choice.Name {
+				return []T{tool}, nil
+			}
+		}
+		return nil, fmt.Errorf("tool_choice references unknown tool %q", choice.Name)
+	case choice.Mode == core.ToolChoiceRequired && len(tools) == 0:
+		return nil, fmt.Errorf("tool_choice %q requires at least one tool", core.ToolChoiceRequired)
+	}
+	return tools, nil
+}
+
+// ParseGeminiToolConfig normalizes a Gemini functionCallingConfig. ANY maps to required and
+// also returns allowedFunctionNames, forcing the function when it names exactly one.
+func ParseGeminiToolConfig(config *core.GeminiToolConfig) (core.ToolChoice, []string, error) {
+	if config == nil || config.FunctionCallingConfig == nil {
+		return core.ToolChoice{Mode: core.ToolChoiceAuto}, nil, nil
+	}
+
+	fcc := config.FunctionCallingConfig
+	switch fcc.Mode {
+	case "", core.GeminiFunctionCallingUnspecified, core.GeminiFunctionCallingAuto, core.GeminiFunctionCallingValidated:
+		return core.ToolChoice{Mode: core.ToolChoiceAuto}, nil, nil
+	case core.GeminiFunctionCallingNone:
+		return core.ToolChoice{Mode: core.ToolChoiceNone}, nil, nil
+	case core.GeminiFunctionCallingAny:
+		if len(fcc.AllowedFunctionNames) == 1 {
+			return core.ToolChoice{Mode: core.ToolChoiceRequired, Name: fcc.AllowedFunctionNames[0]}, fcc.AllowedFunctionNames, nil
+		}
+		return core.ToolChoice{Mode: core.ToolChoiceRequired}, fcc.AllowedFunctionNames, nil
+	}
+	return core.ToolChoice{}, nil, fmt.Errorf("invalid functionCallingConfig mode: %q", fcc.Mode)
+}
+
+// RestrictOpenAITools keeps only the tools named in allowed, as Gemini allowedFunctionNames does.
+// An empty list keeps every tool.
+func RestrictOpenAITools(tools []core.Tool, allowed []string) ([]core.Tool, error) {
+	if len(allowed) == 0 {
+		return tools, nil
+	}
+	for _, name := range allowed {
+		if !slices.ContainsFunc(tools, func(tool core.Tool) bool { return tool.Function.Name == name }) {
+			return nil, fmt.Errorf("allowedFunctionNames references unknown function %q", name)
+		}
+	}
+	return slices.DeleteFunc(tools, func(tool core.Tool) bool { return !slices.Contains(allowed, tool.Function.Name) }), nil
+}
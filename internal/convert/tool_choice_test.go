@@ -0,0 +1,182 @@
+package convert
+
+import (
+	"slices"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestParseOpenAIToolChoice(t *testing.T) {
+	tests := []struct {
+		name     string
+		choice   any
+		expected core.ToolChoice
+		wantErr  bool
+	}{
+		{"未设置默认 auto", nil, core.ToolChoice{Mode: core.ToolChoiceAuto}, false},
+		{"none", "none", core.ToolChoice{Mode: core.ToolChoiceNone}, false},
+		{"required", "required", core.ToolChoice{Mode: core.ToolChoiceRequired}, false},
+		{"指定函数", map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}},
+			core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "get_weather"}, false},
+		{"Responses 格式指定函数", map[string]any{"type": "function", "name": "get_weather"},
+			core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "get_weather"}, false},
+		{"未知字符串", "sometimes", core.ToolChoice{}, true},
+		{"缺少函数名", map[string]any{"type": "function"}, core.ToolChoice{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseOpenAIToolChoice(tt.choice)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误状态不符: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("期望 %+v，实际 %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseAnthropicToolChoice(t *testing.T) {
+	tests := []struct {
+		name     string
+		choice   any
+		expected core.ToolChoice
+		wantErr  bool
+	}{
+		{"未设置默认 auto", nil, core.ToolChoice{Mode: core.ToolChoiceAuto}, false},
+		{"any 映射为 required", map[string]any{"type": "any"}, core.ToolChoice{Mode: core.ToolChoiceRequired}, false},
+		{"none", map[string]any{"type": "none"}, core.ToolChoice{Mode: core.ToolChoiceNone}, false},
+		{"指定工具", map[string]any{"type": "tool", "name": "get_weather"},
+			core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "get_weather"}, false},
+		{"指定工具缺少名称", map[string]any{"type": "tool"}, core.ToolChoice{}, true},
+		{"字符串格式无效", "auto", core.ToolChoice{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseAnthropicToolChoice(tt.choice)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误状态不符: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("期望 %+v，实际 %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseGeminiToolConfig(t *testing.T) {
+	fcc := func(mode string, names ...string) *core.GeminiToolConfig {
+		return &core.GeminiToolConfig{FunctionCallingConfig: &core.GeminiFunctionCallingConfig{Mode: mode, AllowedFunctionNames: names}}
+	}
+	tests := []struct {
+		name            string
+		config          *core.GeminiToolConfig
+		expected        core.ToolChoice
+		expectedAllowed []string
+		wantErr         bool
+	}{
+		{"未设置默认 auto", nil, core.ToolChoice{Mode: core.ToolChoiceAuto}, nil, false},
+		{"AUTO", fcc("AUTO"), core.ToolChoice{Mode: core.ToolChoiceAuto}, nil, false},
+		{"NONE", fcc("NONE"), core.ToolChoice{Mode: core.ToolChoiceNone}, nil, false},
+		{"ANY 映射为 required", fcc("ANY"), core.ToolChoice{Mode: core.ToolChoiceRequired}, nil, false},
+		{"ANY 且允许多个函数", fcc("ANY", "a", "b"), core.ToolChoice{Mode: core.ToolChoiceRequired}, []string{"a", "b"}, false},
+		{"ANY 且仅允许一个函数", fcc("ANY", "get_weather"),
+			core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "get_weather"}, []string{"get_weather"}, false},
+		{"未知模式", fcc("SOMETIMES"), core.ToolChoice{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, allowed, err := ParseGeminiToolConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误状态不符: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("期望 %+v，实际 %+v", tt.expected, result)
+			}
+			if !slices.Equal(allowed, tt.expectedAllowed) {
+				t.Errorf("期望允许的函数 %v，实际 %v", tt.expectedAllowed, allowed)
+			}
+		})
+	}
+}
+
+func TestRestrictOpenAITools_GeminiAllowedFunctionNames(t *testing.T) {
+	req := &core.GeminiGenerateContentRequest{
+		Contents: []core.GeminiContent{{Role: "user", Parts: []core.GeminiPart{{Text: "hi"}}}},
+		Tools: []core.GeminiTool{{FunctionDeclarations: []core.GeminiFunctionDeclaration{
+			{Name: "get_weather"}, {Name: "get_time"}, {Name: "send_email"},
+		}}},
+		ToolConfig: &core.GeminiToolConfig{FunctionCallingConfig: &core.GeminiFunctionCallingConfig{
+			Mode: "ANY", AllowedFunctionNames: []string{"get_weather", "get_time"},
+		}},
+	}
+
+	chatReq, err := GeminiToChatRequest("gemini-2.5-pro", req)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	choice, allowed, err := ParseGeminiToolConfig(req.ToolConfig)
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+	tools, err := RestrictOpenAITools(chatReq.Tools, allowed)
+	if err == nil {
+		tools, err = FilterOpenAITools(tools, choice)
+	}
+	if err != nil {
+		t.Fatalf("意外错误: %v", err)
+	}
+
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	if !slices.Equal(names, []string{"get_weather", "get_time"}) {
+		t.Errorf("应只保留 allowedFunctionNames 中的函数，实际 %v", names)
+	}
+	if choice.Mode != core.ToolChoiceRequired || choice.Name != "" {
+		t.Errorf("多个允许函数应映射为 required 且不指定函数，实际 %+v", choice)
+	}
+
+	if _, err := RestrictOpenAITools(chatReq.Tools, []string{"unknown"}); err == nil {
+		t.Error("allowedFunctionNames 引用未知函数应返回错误")
+	}
+}
+
+func TestFilterOpenAITools(t *testing.T) {
+	tools := []core.Tool{
+		{Type: core.ToolTypeFunction, Function: core.ToolFunction{Name: "get_weather"}},
+		{Type: core.ToolTypeFunction, Function: core.ToolFunction{Name: "get_time"}},
+	}
+
+	tests := []struct {
+		name     string
+		tools    []core.Tool
+		choice   core.ToolChoice
+		expected []string
+		wantErr  bool
+	}{
+		{"auto 保留全部工具", tools, core.ToolChoice{Mode: core.ToolChoiceAuto}, []string{"get_weather", "get_time"}, false},
+		{"none 丢弃全部工具", tools, core.ToolChoice{Mode: core.ToolChoiceNone}, nil, false},
+		{"指定函数只保留该工具", tools, core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "get_time"}, []string{"get_time"}, false},
+		{"指定未知函数", tools, core.ToolChoice{Mode: core.ToolChoiceRequired, Name: "missing"}, nil, true},
+		{"required 但没有工具", nil, core.ToolChoice{Mode: core.ToolChoiceRequired}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := FilterOpenAITools(tt.tools, tt.choice)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误状态不符: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("期望 %d 个工具，实际 %d 个", len(tt.expected), len(result))
+			}
+			for i, name := range tt.expected {
+				if result[i].Function.Name != name {
+					t.Errorf("工具 %d 期望 %s，实际 %s", i, name, result[i].Function.Name)
+				}
+			}
+		})
+	}
+}
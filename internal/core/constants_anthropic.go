@@ -2,15 +2,19 @@ package core
 
 // Anthropic response type constants
 const (
-	AnthropicTypeMessage   = "message"
-	AnthropicDeltaTypeText = "text_delta"
+	AnthropicTypeMessage        = "message"
+	AnthropicDeltaTypeText      = "text_delta"
+	AnthropicDeltaTypeThinking  = "thinking_delta"
+	AnthropicDeltaTypeSignature = "signature_delta"
+	AnthropicDeltaTypeInputJSON = "input_json_delta"
 )
 
 // Anthropic stop reason constants
 const (
-	StopReasonEndTurn   = "end_turn"
-	StopReasonToolUse   = "tool_use"
-	StopReasonMaxTokens = "max_tokens"
+	StopReasonEndTurn      = "end_turn"
+	StopReasonToolUse      = "tool_use"
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonStopSequence = "stop_sequence"
 )
 
 // Anthropic content block type constants
@@ -18,21 +22,56 @@ const (
 	ContentBlockTypeToolUse    = "tool_use"
 	ContentBlockTypeToolResult = "tool_result"
 	ContentBlockTypeText       = "text"
+	ContentBlockTypeImage      = "image"
+	// Thinking blocks precede the text of a reasoning model's answer; redacted ones carry opaque data
+	ContentBlockTypeThinking         = "thinking"
+	ContentBlockTypeRedactedThinking = "redacted_thinking"
+)
+
+// Anthropic extended thinking constants
+const (
+	ThinkingTypeEnabled  = "enabled"
+	ThinkingTypeDisabled = "disabled"
+	// MinThinkingBudgetTokens is the smallest budget_tokens the Messages API accepts
+	MinThinkingBudgetTokens = 1024
+)
+
+// Default thinking budgets at which a profile variant is selected, for variants in models.json
+// that do not set budget_tokens
+const (
+	ThinkingBudgetMinimal = 0
+	ThinkingBudgetLow     = MinThinkingBudgetTokens
+	ThinkingBudgetMedium  = 8192
+	ThinkingBudgetHigh    = 24576
+	ThinkingBudgetXHigh   = 32768
+)
+
+// Anthropic image source type constants
+const (
+	ImageSourceTypeBase64 = "base64"
+	ImageSourceTypeURL    = "url"
 )
 
 // Anthropic error type constants
 const (
 	AnthropicErrorInvalidRequest = "invalid_request_error"
+	AnthropicErrorAuthentication = "authentication_error"
+	AnthropicErrorPermission     = "permission_error"
 	AnthropicErrorRateLimit      = "rate_limit_error"
 	AnthropicErrorAPI            = "api_error"
 	AnthropicErrorModelNotFound  = "model_not_found_error"
+	AnthropicErrorNotFound       = "not_found_error"
+	AnthropicErrorOverloaded     = "overloaded_error"
 )
 
 // Anthropic stream event type constants
 const (
 	StreamEventTypeMessageStart      = "message_start"
+	StreamEventTypeMessageDelta      = "message_delta"
 	StreamEventTypeMessageStop       = "message_stop"
 	StreamEventTypeContentBlockStart = "content_block_start"
 	StreamEventTypeContentBlockDelta = "content_block_delta"
 	StreamEventTypeContentBlockStop  = "content_block_stop"
+	StreamEventTypePing              = "ping"
+	StreamEventTypeError             = "error"
 )
@@ -0,0 +1,7 @@
+package core
+
+// Azure OpenAI request constants
+const (
+	HeaderAzureAPIKey    = "api-key"
+	AzureAPIVersionQuery = "api-version"
+)
@@ -0,0 +1,104 @@
+package core
+
+import "time"
+
+// OpenAI file and batch object type constants
+const (
+	FileObjectType  = "file"
+	BatchObjectType = "batch"
+	// ListObjectType is the object type of file and batch lists and of batch error lists
+	ListObjectType = "list"
+)
+
+// ID prefix constants for files, batches and batch requests
+const (
+	FileIDPrefix         = "file-"
+	BatchIDPrefix        = "batch_"
+	BatchRequestIDPrefix = "batch_req_"
+	RequestIDPrefix      = "req_"
+)
+
+// File purpose constants
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// Batch status constants
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch request constants
+const (
+	BatchEndpointChatCompletions = "/v1/chat/completions"
+	BatchCompletionWindow        = "24h"
+	BatchCompletionWindowTime    = 24 * time.Hour
+	// BatchMaxRequests is the maximum number of request lines in a batch input file
+	BatchMaxRequests = 50000
+	// BatchQuotaRetryInterval is how long a batch waits before retrying a line rejected for rate limit or quota
+	BatchQuotaRetryInterval = 30 * time.Second
+)
+
+// Batch list pagination constants
+const (
+	BatchListDefaultLimit = 20
+	BatchListMaxLimit     = 100
+)
+
+// ContentTypeJSONL is the content type of batch input, output and error files
+const ContentTypeJSONL = "application/jsonl"
+
+// Batch storage and worker constants
+const (
+	DefaultBatchDataDir     = "batches"
+	DefaultBatchConcurrency = 2
+)
+
+// Batch line error code constants (the "error" field of output and error file lines,
+// and the validation errors of a failed batch)
+const (
+	BatchErrorCodeInvalidJSON     = "invalid_json"
+	BatchErrorCodeMissingCustomID = "missing_custom_id"
+	BatchErrorCodeDuplicateID     = "duplicate_custom_id"
+	BatchErrorCodeInvalidMethod   = "invalid_method"
+	BatchErrorCodeInvalidURL      = "invalid_url"
+	BatchErrorCodeEmptyFile       = "empty_file"
+	BatchErrorCodeTooManyRequests = "too_many_requests"
+	BatchErrorCodeExpired         = "batch_expired"
+)
+
+// Anthropic message batch constants
+const (
+	MessageBatchObjectType = "message_batch"
+	MessageBatchIDPrefix   = "msgbatch_"
+	// MessageBatchMaxRequests is the maximum number of requests in a message batch
+	MessageBatchMaxRequests       = 100000
+	MessageBatchCustomIDMaxLength = 64
+	MessageBatchListDefaultLimit  = 20
+	MessageBatchListMaxLimit      = 1000
+	// MessageBatchDirName is the directory, next to the stats file, where FileStorage keeps message batches
+	MessageBatchDirName = "message_batches"
+)
+
+// Message batch processing status constants
+const (
+	MessageBatchStatusInProgress = "in_progress"
+	MessageBatchStatusCanceling  = "canceling"
+	MessageBatchStatusEnded      = "ended"
+)
+
+// Message batch result type constants
+const (
+	MessageBatchResultSucceeded = "succeeded"
+	MessageBatchResultErrored   = "errored"
+	MessageBatchResultCanceled  = "canceled"
+	MessageBatchResultExpired   = "expired"
+)
@@ -0,0 +1,47 @@
+package core
+
+// Gemini API action constants (the part of the path after "models/{model}:")
+const (
+	GeminiActionGenerateContent       = "generateContent"
+	GeminiActionStreamGenerateContent = "streamGenerateContent"
+	// GeminiStreamAltSSE is the alt query value that selects SSE framing for streamed responses
+	GeminiStreamAltSSE = "sse"
+)
+
+// Gemini content role constants
+const (
+	GeminiRoleUser     = "user"
+	GeminiRoleModel    = "model"
+	GeminiRoleFunction = "function"
+)
+
+// Gemini finish reason constants
+const (
+	GeminiFinishReasonStop      = "STOP"
+	GeminiFinishReasonMaxTokens = "MAX_TOKENS"
+)
+
+// Gemini function calling mode constants
+const (
+	GeminiFunctionCallingUnspecified = "MODE_UNSPECIFIED"
+	GeminiFunctionCallingAuto        = "AUTO"
+	GeminiFunctionCallingAny         = "ANY"
+	GeminiFunctionCallingNone        = "NONE"
+	GeminiFunctionCallingValidated   = "VALIDATED"
+)
+
+// Gemini error status constants (google.rpc.Code names)
+const (
+	GeminiStatusInvalidArgument   = "INVALID_ARGUMENT"
+	GeminiStatusUnauthenticated   = "UNAUTHENTICATED"
+	GeminiStatusPermissionDenied  = "PERMISSION_DENIED"
+	GeminiStatusNotFound          = "NOT_FOUND"
+	GeminiStatusResourceExhausted = "RESOURCE_EXHAUSTED"
+	GeminiStatusInternal          = "INTERNAL"
+	GeminiStatusUnavailable       = "UNAVAILABLE"
+)
+
+// Gemini request header constants
+const (
+	HeaderXGoogAPIKey = "x-goog-api-key"
+)
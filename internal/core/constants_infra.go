@@ -4,8 +4,11 @@ import "time"
 
 // Timeout and time constants
 const (
-	QuotaCacheTime = 1 * time.Hour
-	JWTRefreshTime = 12 * time.Hour
+	QuotaCacheTime        = 1 * time.Hour
+	JWTRefreshTime        = 12 * time.Hour
+	AnthropicPingInterval = 10 * time.Second
+	// Polling interval for models.json and the client keys file
+	DefaultConfigWatchInterval = 5 * time.Second
 )
 
 // HTTP client config constants
@@ -44,15 +47,43 @@ const (
 	AccountExpiryWarningTime = 24 * time.Hour
 	JWTExpiryCheckTime       = 1 * time.Hour
 	MaxUpstreamRetries       = 3
+	ToolCallRequiredAttempts = 2
+	// StructuredOutputAttempts is how often a non-streaming request is sent until its output
+	// conforms to response_format
+	StructuredOutputAttempts = 2
 )
 
 // Image validation constants
 const (
 	MaxImageSizeBytes = 10 * 1024 * 1024
-	ImageFormatPNG    = "image/png"
-	ImageFormatJPEG   = "image/jpeg"
-	ImageFormatGIF    = "image/gif"
-	ImageFormatWebP   = "image/webp"
+	// MaxRequestImageBytes caps the combined decoded size of all images in one request
+	MaxRequestImageBytes = 20 * 1024 * 1024
+	ImageFormatPNG       = "image/png"
+	ImageFormatJPEG      = "image/jpeg"
+	ImageFormatGIF       = "image/gif"
+	ImageFormatWebP      = "image/webp"
+)
+
+// Remote image fetch constants
+const (
+	DefaultImageFetchTimeout  = 10 * time.Second
+	DefaultImageFetchMaxBytes = MaxImageSizeBytes
+	ImageFetchCacheTTL        = 30 * time.Minute
+	ImageFetchMaxRedirects    = 3
+	// ImageFetchAllowAnyHost is the allowlist entry that permits every public host
+	ImageFetchAllowAnyHost = "*"
+)
+
+// Token counting constants
+const (
+	TokenizerEncoding       = "o200k_base"
+	MessageOverheadTokens   = 3
+	RequestOverheadTokens   = 3
+	ToolOverheadTokens      = 8
+	ImageTokenPixelDivisor  = 750
+	ImageMaxLongEdgePixels  = 1568
+	ImageMaxTokens          = 1600
+	ImageFallbackTokenCount = ImageMaxTokens
 )
 
 // Response body size limits
@@ -75,9 +106,15 @@ const (
 
 // JSON Schema type constants
 const (
-	SchemaTypeObject = "object"
-	SchemaTypeArray  = "array"
-	SchemaTypeString = "string"
+	SchemaTypeObject  = "object"
+	SchemaTypeArray   = "array"
+	SchemaTypeString  = "string"
+	SchemaTypeNumber  = "number"
+	SchemaTypeInteger = "integer"
+	SchemaTypeBoolean = "boolean"
+	SchemaTypeNull    = "null"
+	// MaxSchemaRefDepth bounds $ref resolution while validating structured output
+	MaxSchemaRefDepth = 32
 )
 
 // Logging config constants
@@ -88,6 +125,7 @@ const (
 // File permission constants
 const (
 	FilePermissionReadWrite = 0644
+	DirPermission           = 0755
 )
 
 // HTTP status code constants
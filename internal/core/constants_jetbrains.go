@@ -21,12 +21,23 @@ const (
 	JetBrainsChatPrompt           = "ij.chat.request.new-chat-on-start"
 )
 
+// Model endpoint types of models.json; an empty endpoint is inferred from the profile name
+const (
+	ModelEndpointChat      = "chat"
+	ModelEndpointResponses = "responses"
+	// ModelDeprecationDateLayout is the format of deprecation_date in models.json
+	ModelDeprecationDateLayout = "2006-01-02"
+)
+
 // JetBrains stream event type constants
 const (
 	JetBrainsEventTypeContent        = "Content"
 	JetBrainsEventTypeToolCall       = "ToolCall"
 	JetBrainsEventTypeFunctionCall   = "FunctionCall"
 	JetBrainsEventTypeFinishMetadata = "FinishMetadata"
+	// JetBrainsEventTypeReasoning carries thinking text of reasoning profiles; the event that
+	// ends a reasoning block may carry its signature
+	JetBrainsEventTypeReasoning = "Reasoning"
 )
 
 // JetBrains message type constants
@@ -35,9 +46,11 @@ const (
 	JetBrainsMessageTypeAssistant     = "assistant_message"
 	JetBrainsMessageTypeAssistantText = "assistant_message_text"
 	JetBrainsMessageTypeAssistantTool = "assistant_message_tool"
-	JetBrainsMessageTypeSystem        = "system_message"
-	JetBrainsMessageTypeTool          = "tool_message"
-	JetBrainsMessageTypeMedia         = "media_message"
+	// JetBrainsMessageTypeAssistantReasoning returns earlier thinking, with its signature, to the upstream
+	JetBrainsMessageTypeAssistantReasoning = "assistant_message_reasoning"
+	JetBrainsMessageTypeSystem             = "system_message"
+	JetBrainsMessageTypeTool               = "tool_message"
+	JetBrainsMessageTypeMedia              = "media_message"
 )
 
 // JetBrains finish reason constants
@@ -45,4 +58,26 @@ const (
 	JetBrainsFinishReasonToolCall = "tool_call"
 	JetBrainsFinishReasonStop     = "stop"
 	JetBrainsFinishReasonLength   = "length"
+	// JetBrainsFinishReasonStopSequence is never sent by the upstream; the output guard
+	// reports it when it cuts the stream at a client stop sequence
+	JetBrainsFinishReasonStopSequence = "stop_sequence"
+)
+
+// JetBrains request parameter FQDN constants. Only the tools FQDN comes from the known
+// upstream request format; the sampling FQDNs follow its naming and are only sent to models
+// that opt in through a models.json parameters rule.
+const (
+	JetBrainsParamTools       = "llm.parameters.tools"
+	JetBrainsParamTemperature = "llm.parameters.temperature"
+	JetBrainsParamTopP        = "llm.parameters.top-p"
+	JetBrainsParamTopK        = "llm.parameters.top-k"
+	JetBrainsParamMaxTokens   = "llm.parameters.length"
+	JetBrainsParamStop        = "llm.parameters.stop-token"
+)
+
+// JetBrains request parameter value type constants
+const (
+	JetBrainsDataTypeJSON   = "json"
+	JetBrainsDataTypeDouble = "double"
+	JetBrainsDataTypeInt    = "int"
 )
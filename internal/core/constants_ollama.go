@@ -0,0 +1,28 @@
+package core
+
+// Ollama done reason constants
+const (
+	OllamaDoneReasonStop   = "stop"
+	OllamaDoneReasonLength = "length"
+)
+
+// Ollama model constants
+const (
+	// OllamaDefaultTag is the tag Ollama clients append to untagged model names
+	OllamaDefaultTag = ":latest"
+	// OllamaVersion is the Ollama server version reported by /api/version; clients use it for feature detection
+	OllamaVersion = "0.6.0"
+)
+
+// Ollama model capability constants (reported by /api/show)
+const (
+	OllamaCapabilityCompletion = "completion"
+	OllamaCapabilityTools      = "tools"
+	OllamaCapabilityVision     = "vision"
+	OllamaCapabilityThinking   = "thinking"
+)
+
+// Ollama streaming constants
+const (
+	ContentTypeNDJSON = "application/x-ndjson"
+)
@@ -6,14 +6,16 @@ const (
 	ModelOwner                    = "jetbrains-ai"
 	ChatCompletionObjectType      = "chat.completion"
 	ChatCompletionChunkObjectType = "chat.completion.chunk"
+	TextCompletionObjectType      = "text_completion"
 	ModelListObjectType           = "list"
 )
 
 // ID prefix constants
 const (
-	ResponseIDPrefix = "chatcmpl-"
-	MessageIDPrefix  = "msg_"
-	ToolCallIDPrefix = "toolu_"
+	ResponseIDPrefix   = "chatcmpl-"
+	CompletionIDPrefix = "cmpl-"
+	MessageIDPrefix    = "msg_"
+	ToolCallIDPrefix   = "toolu_"
 )
 
 // OpenAI tool type constants
@@ -21,6 +23,11 @@ const (
 	ToolTypeFunction = "function"
 )
 
+// OpenAI content part type constants
+const (
+	ContentPartTypeImageURL = "image_url"
+)
+
 // OpenAI finish reason constants
 const (
 	FinishReasonStop      = "stop"
@@ -28,7 +35,44 @@ const (
 	FinishReasonLength    = "length"
 )
 
-// Tool choice constants
+// OpenAI response_format type constants
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// OpenAI reasoning_effort constants; they also name the profile variants in models.json
+const (
+	ReasoningEffortNone    = "none"
+	ReasoningEffortMinimal = "minimal"
+	ReasoningEffortLow     = "low"
+	ReasoningEffortMedium  = "medium"
+	ReasoningEffortHigh    = "high"
+	ReasoningEffortXHigh   = "xhigh"
+)
+
+// ReasoningEfforts lists the reasoning_effort values from lowest to highest
+var ReasoningEfforts = []string{
+	ReasoningEffortNone, ReasoningEffortMinimal, ReasoningEffortLow,
+	ReasoningEffortMedium, ReasoningEffortHigh, ReasoningEffortXHigh,
+}
+
+// OpenAI error type constants
+const (
+	OpenAIErrorTypeInvalidRequest    = "invalid_request_error"
+	OpenAIErrorTypeAuthentication    = "authentication_error"
+	OpenAIErrorTypePermission        = "permission_error"
+	OpenAIErrorTypeRateLimit         = "rate_limit_error"
+	OpenAIErrorTypeInsufficientQuota = "insufficient_quota"
+	OpenAIErrorTypeServer            = "server_error"
+)
+
+// Tool choice constants; "any" and "tool" are the Anthropic spellings
 const (
-	ToolChoiceAny = "any"
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+	ToolChoiceAny      = "any"
+	ToolChoiceTool     = "tool"
 )
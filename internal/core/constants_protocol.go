@@ -20,6 +20,8 @@ const (
 	HeaderCacheControl     = "Cache-Control"
 	HeaderConnection       = "Connection"
 	HeaderXAPIKey          = "x-api-key"
+	HeaderXForwardedProto  = "X-Forwarded-Proto"
+	HeaderServedModel      = "X-Served-Model"
 	AuthBearerPrefix       = "Bearer "
 )
 
@@ -35,12 +37,39 @@ const (
 	RoleUser      = "user"
 	RoleSystem    = "system"
 	RoleTool      = "tool"
+	RoleDeveloper = "developer"
 )
 
 // API format identifier constants
 const (
 	APIFormatOpenAI    = "openai"
 	APIFormatAnthropic = "anthropic"
+	APIFormatGemini    = "gemini"
+	APIFormatOllama    = "ollama"
+	APIFormatAzure     = "azure"
+)
+
+// Error code constants, shared by every protocol so the same condition always yields the same code
+const (
+	ErrorCodeInvalidRequestBody    = "invalid_request_body"
+	ErrorCodeInvalidParameter      = "invalid_parameter"
+	ErrorCodeInvalidTools          = "invalid_tools"
+	ErrorCodeContextLengthExceeded = "context_length_exceeded"
+	ErrorCodeModelNotFound         = "model_not_found"
+	ErrorCodeDeploymentNotFound    = "deployment_not_found"
+	ErrorCodeResourceNotFound      = "resource_not_found"
+	ErrorCodeMissingAPIKey         = "missing_api_key"
+	ErrorCodeInvalidAPIKey         = "invalid_api_key"
+	ErrorCodeAuthNotConfigured     = "auth_not_configured"
+	ErrorCodeRateLimitExceeded     = "rate_limit_exceeded"
+	ErrorCodeInsufficientQuota     = "insufficient_quota"
+	ErrorCodeUpstreamUnauthorized  = "upstream_unauthorized"
+	ErrorCodeUpstreamRejected      = "upstream_rejected"
+	ErrorCodeUpstreamError         = "upstream_error"
+	ErrorCodeToolCallRequired      = "tool_call_required"
+	ErrorCodeStreamInterrupted     = "stream_interrupted"
+	ErrorCodeInternal              = "internal_error"
+	ErrorCodeInvalidConfig         = "invalid_config"
 )
 
 // SSE stream end marker constants
@@ -49,3 +78,14 @@ const (
 	StreamNullValue = "null"
 	StreamEndLine   = StreamChunkPrefix + StreamEndMarker
 )
+
+// Sampling parameter names, as used in models.json parameter rules
+const (
+	SamplingParamTemperature = "temperature"
+	SamplingParamTopP        = "top_p"
+	SamplingParamTopK        = "top_k"
+	SamplingParamMaxTokens   = "max_tokens"
+	SamplingParamStop        = "stop"
+	// ModelRuleDefaultKey is the parameter rule applied to models without their own entry
+	ModelRuleDefaultKey = "*"
+)
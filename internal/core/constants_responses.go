@@ -0,0 +1,52 @@
+package core
+
+// Responses API object type constants
+const (
+	ResponseObjectType = "response"
+)
+
+// Responses API ID prefix constants
+const (
+	ResponsesIDPrefix             = "resp_"
+	ResponsesFunctionCallIDPrefix = "fc_"
+)
+
+// Responses API status constants
+const (
+	ResponsesStatusInProgress = "in_progress"
+	ResponsesStatusCompleted  = "completed"
+	ResponsesStatusIncomplete = "incomplete"
+	ResponsesStatusFailed     = "failed"
+)
+
+// Responses API item type constants
+const (
+	ResponsesItemTypeMessage            = "message"
+	ResponsesItemTypeFunctionCall       = "function_call"
+	ResponsesItemTypeFunctionCallOutput = "function_call_output"
+	ResponsesItemTypeReasoning          = "reasoning"
+)
+
+// Responses API content part type constants
+const (
+	ResponsesContentTypeInputText  = "input_text"
+	ResponsesContentTypeInputImage = "input_image"
+	ResponsesContentTypeOutputText = "output_text"
+	ResponsesContentTypeRefusal    = "refusal"
+)
+
+// Responses API stream event type constants
+const (
+	ResponsesEventCreated                    = "response.created"
+	ResponsesEventInProgress                 = "response.in_progress"
+	ResponsesEventCompleted                  = "response.completed"
+	ResponsesEventFailed                     = "response.failed"
+	ResponsesEventOutputItemAdded            = "response.output_item.added"
+	ResponsesEventOutputItemDone             = "response.output_item.done"
+	ResponsesEventContentPartAdded           = "response.content_part.added"
+	ResponsesEventContentPartDone            = "response.content_part.done"
+	ResponsesEventOutputTextDelta            = "response.output_text.delta"
+	ResponsesEventOutputTextDone             = "response.output_text.done"
+	ResponsesEventFunctionCallArgumentsDelta = "response.function_call_arguments.delta"
+	ResponsesEventFunctionCallArgumentsDone  = "response.function_call_arguments.done"
+)
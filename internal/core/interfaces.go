@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -22,6 +23,11 @@ type Cache interface {
 	Close() error
 }
 
+// ImageFetcher resolves remote image URLs into base64 image data.
+type ImageFetcher interface {
+	FetchImage(ctx context.Context, url string) (mediaType, data string, err error)
+}
+
 // QuotaCache defines the interface for quota-related cache operations.
 type QuotaCache interface {
 	GetQuotaCache(key string) (*JetbrainsQuotaResponse, bool)
@@ -38,6 +44,45 @@ type StorageInterface interface {
 	Close() error
 }
 
+// ErrNotFound is returned by BatchStore when a file or batch does not exist.
+var ErrNotFound = errors.New("not found")
+
+// MessageBatchStorage persists Anthropic message batches. The StorageInterface implementations
+// (file and Redis) implement it, so message batches live wherever the stats are stored.
+type MessageBatchStorage interface {
+	SaveMessageBatch(batch *MessageBatch) error
+	GetMessageBatch(id string) (*MessageBatch, error)
+	// ListMessageBatches returns all message batches, newest first
+	ListMessageBatches() ([]MessageBatch, error)
+
+	// SaveMessageBatchRequests stores the requests of a batch once, at creation
+	SaveMessageBatchRequests(id string, requests []MessageBatchRequest) error
+	GetMessageBatchRequests(id string) ([]MessageBatchRequest, error)
+
+	// AppendMessageBatchResult appends a JSONL result line as soon as a request finishes
+	AppendMessageBatchResult(id string, line []byte) error
+	GetMessageBatchResults(id string) ([]byte, error)
+}
+
+// BatchStore persists Batch API files, batch jobs and the results of running batches.
+// Results are appended line by line so that an interrupted batch can resume where it stopped.
+type BatchStore interface {
+	SaveFile(file *OpenAIFile, content []byte) error
+	GetFile(id string) (*OpenAIFile, error)
+	GetFileContent(id string) ([]byte, error)
+	ListFiles() ([]OpenAIFile, error)
+	DeleteFile(id string) error
+
+	SaveBatch(batch *Batch) error
+	GetBatch(id string) (*Batch, error)
+	ListBatches() ([]Batch, error)
+
+	// AppendBatchResult appends a JSONL line to the output (failed=false) or error (failed=true) results of a batch
+	AppendBatchResult(batchID string, failed bool, line []byte) error
+	GetBatchResults(batchID string, failed bool) ([]byte, error)
+	DeleteBatchResults(batchID string) error
+}
+
 // AccountManager defines the interface for JetBrains account pool management.
 type AccountManager interface {
 	AcquireAccount(ctx context.Context) (*JetbrainsAccount, error)
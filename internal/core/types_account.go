@@ -51,7 +51,7 @@ type RequestRecord struct {
 	Timestamp    time.Time `json:"timestamp"`
 	Success      bool      `json:"success"`
 	ResponseTime int64     `json:"response_time"`
-	Model        string    `json:"model"`
+	Model        string    `json:"model"` // model that served the request, after alias and fallback resolution
 	Account      string    `json:"account"`
 }
 
@@ -15,11 +15,13 @@ type AnthropicMessage struct {
 
 // AnthropicContentBlock represents a content block in an Anthropic message.
 type AnthropicContentBlock struct {
-	Type  string         `json:"type"`
-	Text  string         `json:"text,omitempty"`
-	ID    string         `json:"id,omitempty"`
-	Name  string         `json:"name,omitempty"`
-	Input map[string]any `json:"input,omitempty"`
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Input     map[string]any `json:"input,omitempty"`
+	Thinking  string         `json:"thinking,omitempty"`
+	Signature string         `json:"signature,omitempty"`
 }
 
 // FlexibleString supports string or array form of system field.
@@ -63,19 +65,31 @@ type AnthropicTool struct {
 	InputSchema map[string]any `json:"input_schema"`
 }
 
+// AnthropicThinkingConfig is the extended thinking setting of a Messages API request.
+type AnthropicThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// Enabled reports whether the request asks for thinking blocks; nil means disabled.
+func (t *AnthropicThinkingConfig) Enabled() bool {
+	return t != nil && t.Type == ThinkingTypeEnabled
+}
+
 // AnthropicMessagesRequest is the Anthropic Messages API request payload.
 type AnthropicMessagesRequest struct {
-	Model         string             `json:"model"`
-	MaxTokens     int                `json:"max_tokens"`
-	Messages      []AnthropicMessage `json:"messages"`
-	System        FlexibleString     `json:"system,omitempty"`
-	Temperature   *float64           `json:"temperature,omitempty"`
-	TopP          *float64           `json:"top_p,omitempty"`
-	TopK          *int               `json:"top_k,omitempty"`
-	Stream        *bool              `json:"stream,omitempty"`
-	StopSequences []string           `json:"stop_sequences,omitempty"`
-	Tools         []AnthropicTool    `json:"tools,omitempty"`
-	ToolChoice    any                `json:"tool_choice,omitempty"`
+	Model         string                   `json:"model"`
+	MaxTokens     int                      `json:"max_tokens"`
+	Messages      []AnthropicMessage       `json:"messages"`
+	System        FlexibleString           `json:"system,omitempty"`
+	Temperature   *float64                 `json:"temperature,omitempty"`
+	TopP          *float64                 `json:"top_p,omitempty"`
+	TopK          *int                     `json:"top_k,omitempty"`
+	Stream        *bool                    `json:"stream,omitempty"`
+	StopSequences []string                 `json:"stop_sequences,omitempty"`
+	Tools         []AnthropicTool          `json:"tools,omitempty"`
+	ToolChoice    any                      `json:"tool_choice,omitempty"`
+	Thinking      *AnthropicThinkingConfig `json:"thinking,omitempty"`
 }
 
 // AnthropicUsage holds token usage information for Anthropic API responses.
@@ -84,6 +98,11 @@ type AnthropicUsage struct {
 	OutputTokens int `json:"output_tokens"`
 }
 
+// AnthropicCountTokensResponse is the response of the Anthropic token counting endpoint.
+type AnthropicCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
 // AnthropicMessagesResponse is the Anthropic Messages API non-streaming response.
 type AnthropicMessagesResponse struct {
 	ID           string                  `json:"id"`
@@ -96,6 +115,86 @@ type AnthropicMessagesResponse struct {
 	Usage        AnthropicUsage          `json:"usage"`
 }
 
+// AnthropicErrorDetail describes an error in the Anthropic format.
+type AnthropicErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicErrorEvent is the Anthropic error payload, sent as an error event when a stream fails.
+type AnthropicErrorEvent struct {
+	Type  string               `json:"type"`
+	Error AnthropicErrorDetail `json:"error"`
+}
+
+// AnthropicMessageDelta carries the final stop reason of a streamed message.
+type AnthropicMessageDelta struct {
+	StopReason   string  `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+// AnthropicDeltaUsage holds the cumulative output token count of a message_delta event.
+type AnthropicDeltaUsage struct {
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicMessageDeltaEvent is the message_delta streaming event sent before message_stop.
+type AnthropicMessageDeltaEvent struct {
+	Type  string                `json:"type"`
+	Delta AnthropicMessageDelta `json:"delta"`
+	Usage AnthropicDeltaUsage   `json:"usage"`
+}
+
+// AnthropicContentBlockStartEvent is the content_block_start streaming event.
+// ContentBlock holds an AnthropicTextBlockStart, AnthropicThinkingBlockStart or AnthropicToolUseBlockStart, which always
+// serialize the empty text or input the spec requires and AnthropicContentBlock omits.
+type AnthropicContentBlockStartEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock any    `json:"content_block"`
+}
+
+// AnthropicTextBlockStart is the initial state of a streamed text block.
+type AnthropicTextBlockStart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AnthropicThinkingBlockStart is the initial state of a streamed thinking block.
+type AnthropicThinkingBlockStart struct {
+	Type      string `json:"type"`
+	Thinking  string `json:"thinking"`
+	Signature string `json:"signature"`
+}
+
+// AnthropicToolUseBlockStart is the initial state of a streamed tool_use block.
+type AnthropicToolUseBlockStart struct {
+	Type  string         `json:"type"`
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input"`
+}
+
+// AnthropicContentBlockDeltaEvent is a content_block_delta streaming event whose Delta is an
+// AnthropicThinkingDelta or AnthropicSignatureDelta.
+type AnthropicContentBlockDeltaEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta any    `json:"delta"`
+}
+
+// AnthropicThinkingDelta carries a fragment of a streamed thinking block.
+type AnthropicThinkingDelta struct {
+	Type     string `json:"type"`
+	Thinking string `json:"thinking"`
+}
+
+// AnthropicSignatureDelta carries the signature of a thinking block just before it closes.
+type AnthropicSignatureDelta struct {
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+}
+
 // AnthropicStreamResponse is the Anthropic Messages API streaming response event.
 type AnthropicStreamResponse struct {
 	Type         string                 `json:"type"`
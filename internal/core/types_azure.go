@@ -0,0 +1,15 @@
+package core
+
+// AzureError is the error object of Azure OpenAI error responses. Azure puts the code first
+// and omits param and type when they do not apply.
+type AzureError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// AzureErrorResponse wraps an AzureError.
+type AzureErrorResponse struct {
+	Error AzureError `json:"error"`
+}
@@ -0,0 +1,174 @@
+package core
+
+// OpenAIFile is an uploaded file object of the Files API.
+type OpenAIFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// OpenAIFileList is the GET /v1/files response.
+type OpenAIFileList struct {
+	Object  string       `json:"object"`
+	Data    []OpenAIFile `json:"data"`
+	HasMore bool         `json:"has_more"`
+}
+
+// OpenAIFileDeleted is the DELETE /v1/files/{file_id} response.
+type OpenAIFileDeleted struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// BatchCreateRequest is the POST /v1/batches request payload.
+type BatchCreateRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Batch is an OpenAI batch object. Timestamps are Unix seconds and nil until the batch reaches that state.
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        *int64             `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+// BatchErrors lists the input file validation errors of a failed batch.
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+// BatchError is a single batch validation error; Line is the 1-based input line, if any.
+type BatchError struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Param   *string `json:"param"`
+	Line    *int    `json:"line"`
+}
+
+// BatchRequestCounts tracks the progress of a batch.
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchList is the GET /v1/batches response.
+type BatchList struct {
+	Object  string  `json:"object"`
+	Data    []Batch `json:"data"`
+	FirstID *string `json:"first_id"`
+	LastID  *string `json:"last_id"`
+	HasMore bool    `json:"has_more"`
+}
+
+// BatchRequestLine is one line of a batch input file.
+type BatchRequestLine struct {
+	CustomID string         `json:"custom_id"`
+	Method   string         `json:"method"`
+	URL      string         `json:"url"`
+	Body     map[string]any `json:"body"`
+}
+
+// BatchResultLine is one line of a batch output or error file. Response is set when the request
+// was executed (successfully or not); Error is set when it was not executed at all.
+type BatchResultLine struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *BatchResultResponse `json:"response"`
+	Error    *BatchResultError    `json:"error"`
+}
+
+// BatchResultResponse is the HTTP response of an executed batch request.
+type BatchResultResponse struct {
+	StatusCode int    `json:"status_code"`
+	RequestID  string `json:"request_id"`
+	Body       any    `json:"body"`
+}
+
+// BatchResultError explains why a batch request was not executed.
+type BatchResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// MessageBatchCreateRequest is the POST /v1/messages/batches request payload.
+type MessageBatchCreateRequest struct {
+	Requests []MessageBatchRequest `json:"requests"`
+}
+
+// MessageBatchRequest is one request of a message batch; Params holds Messages API parameters.
+type MessageBatchRequest struct {
+	CustomID string         `json:"custom_id"`
+	Params   map[string]any `json:"params"`
+}
+
+// MessageBatch is an Anthropic message batch object. Timestamps are RFC 3339 strings and nil
+// until the batch reaches that state.
+type MessageBatch struct {
+	ID                string                    `json:"id"`
+	Type              string                    `json:"type"`
+	ProcessingStatus  string                    `json:"processing_status"`
+	RequestCounts     MessageBatchRequestCounts `json:"request_counts"`
+	EndedAt           *string                   `json:"ended_at"`
+	CreatedAt         string                    `json:"created_at"`
+	ExpiresAt         string                    `json:"expires_at"`
+	ArchivedAt        *string                   `json:"archived_at"`
+	CancelInitiatedAt *string                   `json:"cancel_initiated_at"`
+	ResultsURL        *string                   `json:"results_url"`
+}
+
+// MessageBatchRequestCounts tracks the requests of a message batch by state.
+type MessageBatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// MessageBatchList is the GET /v1/messages/batches response.
+type MessageBatchList struct {
+	Data    []MessageBatch `json:"data"`
+	HasMore bool           `json:"has_more"`
+	FirstID *string        `json:"first_id"`
+	LastID  *string        `json:"last_id"`
+}
+
+// MessageBatchResultLine is one line of the message batch results JSONL.
+type MessageBatchResultLine struct {
+	CustomID string             `json:"custom_id"`
+	Result   MessageBatchResult `json:"result"`
+}
+
+// MessageBatchResult is the outcome of one request: the message when it succeeded, the error
+// response when it errored, and nothing else when it was canceled or expired.
+type MessageBatchResult struct {
+	Type    string `json:"type"`
+	Message any    `json:"message,omitempty"`
+	Error   any    `json:"error,omitempty"`
+}
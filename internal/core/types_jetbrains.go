@@ -21,6 +21,7 @@ type JetbrainsMessage struct {
 	ID        string `json:"id,omitempty"`
 	ToolName  string `json:"toolName,omitempty"`
 	Result    string `json:"result,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 // JetbrainsPayload is the top-level request payload sent to JetBrains API.
@@ -36,7 +37,7 @@ type JetbrainsChat struct {
 	Messages []JetbrainsMessage `json:"messages"`
 }
 
-// JetbrainsParameters holds tool definitions for JetBrains API requests.
+// JetbrainsParameters holds tool definitions and sampling parameters for JetBrains API requests.
 type JetbrainsParameters struct {
 	Data []JetbrainsData `json:"data"`
 }
@@ -1,11 +1,30 @@
 package core
 
-// ModelInfo represents a single model entry in the models list.
+import (
+	"fmt"
+
+	"github.com/bytedance/sonic"
+)
+
+// ModelInfo represents a single model entry in the models list. Besides the OpenAI fields it
+// carries the optional metadata of the model's models.json entry.
 type ModelInfo struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	OwnedBy string `json:"owned_by"`
+	ID              string             `json:"id"`
+	Object          string             `json:"object"`
+	Created         int64              `json:"created"`
+	OwnedBy         string             `json:"owned_by"`
+	DisplayName     string             `json:"display_name,omitempty"`
+	ContextWindow   int                `json:"context_window,omitempty"`
+	MaxOutputTokens int                `json:"max_output_tokens,omitempty"`
+	Capabilities    *ModelCapabilities `json:"capabilities,omitempty"`
+	DeprecationDate string             `json:"deprecation_date,omitempty"`
+}
+
+// ModelCapabilities lists the capabilities models.json declares for a model; nil means undeclared.
+type ModelCapabilities struct {
+	Vision    *bool `json:"vision,omitempty"`
+	Tools     *bool `json:"tools,omitempty"`
+	Reasoning *bool `json:"reasoning,omitempty"`
 }
 
 // ModelList is the OpenAI-compatible model list response.
@@ -14,7 +33,90 @@ type ModelList struct {
 	Data   []ModelInfo `json:"data"`
 }
 
-// ModelsConfig holds the model ID mapping configuration from models.json.
+// ModelsConfig holds the model configuration from models.json, keyed by public model ID.
+// Parameters lists the sampling parameters each model accepts, keyed by public model ID,
+// JetBrains profile, or "*"; forwarding is opt-in, so models without a rule get none.
+// Deployments maps Azure OpenAI deployment names to public model IDs.
+// Variants lists the profile variants of a public model keyed by reasoning effort.
+// Aliases map other names, or path.Match patterns such as "gpt-*", to public model IDs.
+// Fallbacks list the models tried in turn when a model fails on every account.
 type ModelsConfig struct {
-	Models map[string]string `json:"models"`
+	Models      map[string]ModelSpec               `json:"models"`
+	Parameters  map[string][]string                `json:"parameters,omitempty"`
+	Deployments map[string]string                  `json:"deployments,omitempty"`
+	Variants    map[string]map[string]ModelVariant `json:"variants,omitempty"`
+	Aliases     map[string]string                  `json:"aliases,omitempty"`
+	Fallbacks   map[string][]string                `json:"fallbacks,omitempty"`
+}
+
+// ModelSpec is the models.json entry of a public model. An entry is either the JetBrains profile
+// as a string or an object; fields left out place no restriction on requests. Endpoint is
+// ModelEndpointChat or ModelEndpointResponses, inferred from the profile when empty.
+type ModelSpec struct {
+	Profile         string `json:"profile,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	ContextWindow   int    `json:"context_window,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+	ModelCapabilities
+	DisplayName     string `json:"display_name,omitempty"`
+	DeprecationDate string `json:"deprecation_date,omitempty"`
+}
+
+// UnmarshalJSON accepts the flat string form as well as the object form.
+func (m *ModelSpec) UnmarshalJSON(data []byte) error {
+	var profile string
+	if err := sonic.Unmarshal(data, &profile); err == nil {
+		*m = ModelSpec{Profile: profile}
+		return nil
+	}
+
+	type modelSpec ModelSpec
+	var spec modelSpec
+	if err := sonic.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("model entry must be a profile string or an object: %w", err)
+	}
+	*m = ModelSpec(spec)
+	return nil
+}
+
+// SupportsVision reports whether the model accepts image input.
+func (m ModelSpec) SupportsVision() bool { return m.Vision == nil || *m.Vision }
+
+// SupportsTools reports whether the model accepts tool definitions.
+func (m ModelSpec) SupportsTools() bool { return m.Tools == nil || *m.Tools }
+
+// SupportsReasoning reports whether the model accepts a reasoning effort or thinking budget.
+func (m ModelSpec) SupportsReasoning() bool { return m.Reasoning == nil || *m.Reasoning }
+
+// ModelVariant is the profile a model uses at one reasoning effort. Profile defaults to the
+// model's own profile; BudgetTokens is the smallest Anthropic thinking budget that selects the
+// variant, defaulting by effort; Parameters are extra JetBrains parameters keyed by FQDN.
+type ModelVariant struct {
+	Profile      string         `json:"profile,omitempty"`
+	BudgetTokens int            `json:"budget_tokens,omitempty"`
+	Parameters   map[string]any `json:"parameters,omitempty"`
+}
+
+// ReasoningParams is the protocol-independent reasoning setting of a request: an OpenAI
+// reasoning_effort, or an Anthropic thinking budget.
+type ReasoningParams struct {
+	Effort       string
+	BudgetTokens int
+}
+
+// SamplingParams holds protocol-independent sampling parameters forwarded to JetBrains.
+type SamplingParams struct {
+	Temperature *float64
+	TopP        *float64
+	TopK        *int
+	MaxTokens   *int
+	Stop        []string
+}
+
+// ToolChoice is the protocol-independent tool_choice of a request.
+// Mode is one of ToolChoiceAuto, ToolChoiceNone or ToolChoiceRequired;
+// Name is set when a specific function is forced, which implies ToolChoiceRequired.
+type ToolChoice struct {
+	Mode string
+	Name string
 }
@@ -0,0 +1,131 @@
+package core
+
+// OllamaChatRequest is the Ollama /api/chat request payload. Stream defaults to true when omitted.
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Stream   *bool           `json:"stream,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+// OllamaGenerateRequest is the Ollama /api/generate request payload. Stream defaults to true when omitted.
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Suffix  string         `json:"suffix,omitempty"`
+	System  string         `json:"system,omitempty"`
+	Images  []string       `json:"images,omitempty"`
+	Stream  *bool          `json:"stream,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+}
+
+// OllamaMessage is a chat message. Images are raw base64 data without a data URL prefix;
+// ToolName names the function a tool message answers.
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// OllamaToolCall is a tool call produced by the model. Ollama tool calls carry no id.
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+// OllamaToolCallFunction holds the function name and its arguments as an object.
+type OllamaToolCallFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// OllamaOptions holds the Ollama model options that map onto sampling parameters; others are ignored.
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaStats holds the completion statistics sent with the final (done) response. Durations are nanoseconds.
+type OllamaStats struct {
+	DoneReason         string `json:"done_reason,omitempty"`
+	TotalDuration      int64  `json:"total_duration,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+	EvalDuration       int64  `json:"eval_duration,omitempty"`
+}
+
+// OllamaChatResponse is an /api/chat response, or one NDJSON line of a streamed response.
+type OllamaChatResponse struct {
+	Model     string        `json:"model"`
+	CreatedAt string        `json:"created_at"`
+	Message   OllamaMessage `json:"message"`
+	Done      bool          `json:"done"`
+	OllamaStats
+}
+
+// OllamaGenerateResponse is an /api/generate response, or one NDJSON line of a streamed response.
+type OllamaGenerateResponse struct {
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Response  string `json:"response"`
+	Done      bool   `json:"done"`
+	OllamaStats
+}
+
+// OllamaTagsResponse is the /api/tags model list.
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+// OllamaModel is a single /api/tags entry.
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaModelDetails describes a model. Proxied models have no local weights, so only the family is known.
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaShowRequest is the /api/show request payload; Name is the legacy spelling of Model.
+type OllamaShowRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"`
+}
+
+// OllamaShowResponse is the /api/show response.
+type OllamaShowResponse struct {
+	Modelfile    string             `json:"modelfile"`
+	Parameters   string             `json:"parameters"`
+	Template     string             `json:"template"`
+	Details      OllamaModelDetails `json:"details"`
+	ModelInfo    map[string]any     `json:"model_info"`
+	Capabilities []string           `json:"capabilities"`
+	ModifiedAt   string             `json:"modified_at"`
+}
+
+// OllamaVersionResponse is the /api/version response.
+type OllamaVersionResponse struct {
+	Version string `json:"version"`
+}
+
+// OllamaErrorResponse is the Ollama error body.
+type OllamaErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -6,6 +6,11 @@ type ChatMessage struct {
 	Content    any        `json:"content,omitempty"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Refusal replaces the content when the output does not conform to response_format
+	Refusal *string `json:"refusal,omitempty"`
+	// ReasoningContent holds the thinking of reasoning profiles, in the de facto format of
+	// OpenAI-compatible reasoning APIs; sent back in history it is returned to the upstream
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
 // ToolCall represents a tool invocation within a chat message.
@@ -23,16 +28,48 @@ type Function struct {
 
 // ChatCompletionRequest is the OpenAI-compatible chat completion request payload.
 type ChatCompletionRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages"`
-	Stream      bool          `json:"stream"`
-	Temperature *float64      `json:"temperature,omitempty"`
-	MaxTokens   *int          `json:"max_tokens,omitempty"`
-	TopP        *float64      `json:"top_p,omitempty"`
-	Tools       []Tool        `json:"tools,omitempty"`
-	ToolChoice  any           `json:"tool_choice,omitempty"`
-	Stop        any           `json:"stop,omitempty"`
-	ServiceTier string        `json:"service_tier,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	Stream         bool            `json:"stream"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	MaxTokens      *int            `json:"max_tokens,omitempty"`
+	TopP           *float64        `json:"top_p,omitempty"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	ToolChoice     any             `json:"tool_choice,omitempty"`
+	Stop           any             `json:"stop,omitempty"`
+	ServiceTier    string          `json:"service_tier,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// ReasoningEffort selects a profile variant of the model, see ModelsConfig.Variants
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// TopK is set only by the Gemini and Ollama converters; the OpenAI API has no top_k,
+	// so it is never read from a chat completion request body
+	TopK *int `json:"-"`
+}
+
+// ResponseFormat is the response_format of a chat completion request: text, json_object or json_schema.
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// IsJSON reports whether the format asks for JSON output.
+func (f *ResponseFormat) IsJSON() bool {
+	return f != nil && (f.Type == ResponseFormatJSONObject || f.Type == ResponseFormatJSONSchema)
+}
+
+// JSONSchemaFormat is the schema a json_schema response must conform to. In strict mode objects
+// accept no properties beyond those declared unless the schema says otherwise.
+type JSONSchemaFormat struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// StreamOptions controls optional streaming behaviour such as the final usage chunk.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Tool represents a tool definition in an OpenAI chat completion request.
@@ -77,6 +114,9 @@ type StreamDelta struct {
 	Role      string  `json:"role,omitempty"`
 	Content   *string `json:"content,omitempty"`
 	ToolCalls []any   `json:"tool_calls,omitempty"`
+	Refusal   *string `json:"refusal,omitempty"`
+	// ReasoningContent streams thinking text ahead of the content
+	ReasoningContent *string `json:"reasoning_content,omitempty"`
 }
 
 // StreamChoice represents a single choice in an OpenAI streaming response chunk.
@@ -93,4 +133,53 @@ type StreamResponse struct {
 	Created int64          `json:"created"`
 	Model   string         `json:"model"`
 	Choices []StreamChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+// CompletionRequest is the legacy OpenAI /v1/completions request payload. Prompt is a string or an
+// array holding a single string; a non-empty Suffix makes it a fill-in-the-middle request.
+type CompletionRequest struct {
+	Model         string         `json:"model"`
+	Prompt        any            `json:"prompt"`
+	Suffix        string         `json:"suffix,omitempty"`
+	Stream        bool           `json:"stream"`
+	Echo          bool           `json:"echo,omitempty"`
+	N             *int           `json:"n,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	MaxTokens     *int           `json:"max_tokens,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	Stop          any            `json:"stop,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// CompletionChoice is a single choice of a legacy completion response or chunk.
+// Logprobs are not supported and always serialize as null.
+type CompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// CompletionResponse is the legacy completion response, or one text_completion chunk of a streamed response.
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *OpenAIUsage       `json:"usage,omitempty"`
+}
+
+// OpenAIError is the error object of OpenAI-style error payloads.
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    string  `json:"code"`
+}
+
+// OpenAIErrorResponse is the body of OpenAI error responses and of the chunk sent when a stream fails.
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
 }
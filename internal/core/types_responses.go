@@ -0,0 +1,125 @@
+package core
+
+import "github.com/bytedance/sonic"
+
+// ResponsesRequest is the OpenAI Responses API request payload.
+// Input is either a plain string or an array of input items.
+type ResponsesRequest struct {
+	Model              string              `json:"model"`
+	Input              any                 `json:"input"`
+	Instructions       string              `json:"instructions,omitempty"`
+	Tools              []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice         any                 `json:"tool_choice,omitempty"`
+	Stream             bool                `json:"stream"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	MaxOutputTokens    *int                `json:"max_output_tokens,omitempty"`
+	PreviousResponseID string              `json:"previous_response_id,omitempty"`
+	Store              *bool               `json:"store,omitempty"`
+	Metadata           map[string]any      `json:"metadata,omitempty"`
+	Reasoning          *ResponsesReasoning `json:"reasoning,omitempty"`
+}
+
+// ResponsesReasoning is the reasoning setting of a Responses API request.
+type ResponsesReasoning struct {
+	Effort string `json:"effort,omitempty"`
+}
+
+// ResponsesTool represents a tool definition in the Responses API (flat function shape).
+type ResponsesTool struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ResponsesContentPart represents a content part inside a Responses API message item.
+type ResponsesContentPart struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponsesOutputItem represents an item in the Responses API output array.
+type ResponsesOutputItem struct {
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Status    string                 `json:"status,omitempty"`
+	Role      string                 `json:"role,omitempty"`
+	Content   []ResponsesContentPart `json:"content,omitempty"`
+	CallID    string                 `json:"call_id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Arguments string                 `json:"arguments,omitempty"`
+}
+
+// MarshalJSON always emits the fields each item type requires, even when empty
+// (message items need "content": [], function_call items need "arguments": "").
+func (i ResponsesOutputItem) MarshalJSON() ([]byte, error) {
+	out := map[string]any{"type": i.Type, "id": i.ID}
+	if i.Status != "" {
+		out["status"] = i.Status
+	}
+
+	switch i.Type {
+	case ResponsesItemTypeMessage:
+		content := i.Content
+		if content == nil {
+			content = []ResponsesContentPart{}
+		}
+		out["role"] = i.Role
+		out["content"] = content
+	case ResponsesItemTypeFunctionCall:
+		out["call_id"] = i.CallID
+		out["name"] = i.Name
+		out["arguments"] = i.Arguments
+	}
+
+	return sonic.Marshal(out)
+}
+
+// ResponsesUsage holds token usage information for Responses API output.
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponsesResponse is the Responses API response object.
+type ResponsesResponse struct {
+	ID                string                      `json:"id"`
+	Object            string                      `json:"object"`
+	CreatedAt         int64                       `json:"created_at"`
+	Status            string                      `json:"status"`
+	Model             string                      `json:"model"`
+	Output            []ResponsesOutputItem       `json:"output"`
+	Usage             *ResponsesUsage             `json:"usage,omitempty"`
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details,omitempty"`
+	Error             *ResponsesError             `json:"error,omitempty"`
+}
+
+// ResponsesError explains why a response ended with status "failed".
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesIncompleteDetails explains why a response ended with status "incomplete".
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+// ResponsesStreamEvent is a single Responses API server-sent event.
+type ResponsesStreamEvent struct {
+	Type           string                `json:"type"`
+	SequenceNumber int                   `json:"sequence_number"`
+	Response       *ResponsesResponse    `json:"response,omitempty"`
+	OutputIndex    *int                  `json:"output_index,omitempty"`
+	ContentIndex   *int                  `json:"content_index,omitempty"`
+	ItemID         string                `json:"item_id,omitempty"`
+	Item           *ResponsesOutputItem  `json:"item,omitempty"`
+	Part           *ResponsesContentPart `json:"part,omitempty"`
+	Delta          string                `json:"delta,omitempty"`
+	Text           *string               `json:"text,omitempty"`
+	Arguments      *string               `json:"arguments,omitempty"`
+}
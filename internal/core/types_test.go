@@ -1,6 +1,7 @@
 package core
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/bytedance/sonic"
@@ -100,6 +101,25 @@ func TestFlexibleString_RealWorldCases(t *testing.T) {
 	}
 }
 
+func TestChatCompletionRequest_IgnoresTopK(t *testing.T) {
+	var request ChatCompletionRequest
+	if err := sonic.Unmarshal([]byte(`{"model":"gpt-4o","top_k":40}`), &request); err != nil {
+		t.Fatalf("意外错误 = %v", err)
+	}
+	if request.TopK != nil {
+		t.Errorf("OpenAI 请求不应接受 top_k，实际 %d", *request.TopK)
+	}
+
+	topK := 40
+	data, err := sonic.Marshal(ChatCompletionRequest{Model: "gpt-4o", TopK: &topK})
+	if err != nil {
+		t.Fatalf("意外错误 = %v", err)
+	}
+	if strings.Contains(string(data), "top_k") {
+		t.Errorf("序列化结果不应包含 top_k: %s", data)
+	}
+}
+
 func BenchmarkFlexibleString_String(b *testing.B) {
 	input := []byte(`"This is a test string"`)
 	var fs FlexibleString
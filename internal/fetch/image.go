@@ -0,0 +1,292 @@
+package fetch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"jetbrainsai2api/internal/cache"
+	"jetbrainsai2api/internal/core"
+)
+
+// ErrRemoteImagesDisabled is returned when no host allowlist is configured
+var ErrRemoteImagesDisabled = errors.New("remote image URLs are disabled; configure IMAGE_FETCH_ALLOWED_HOSTS to enable them")
+
+// ImageFetcherConfig remote image fetcher configuration
+type ImageFetcherConfig struct {
+	// AllowedHosts lists permitted hosts: exact names, "*.example.com" for subdomains,
+	// or "*" for any public host. An empty list disables remote fetching.
+	AllowedHosts []string
+	Timeout      time.Duration
+	MaxBytes     int64
+	Cache        core.Cache
+	Logger       core.Logger
+	// HTTPClient overrides the default client, which refuses private and loopback addresses
+	HTTPClient *http.Client
+}
+
+// ImageFetcher downloads remote images and converts them to base64 media data
+type ImageFetcher struct {
+	allowedHosts []string
+	timeout      time.Duration
+	maxBytes     int64
+	cache        core.Cache
+	logger       core.Logger
+	client       *http.Client
+}
+
+type fetchedImage struct {
+	mediaType string
+	data      string
+}
+
+// NewImageFetcher creates a remote image fetcher
+func NewImageFetcher(cfg ImageFetcherConfig) *ImageFetcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = core.DefaultImageFetchTimeout
+	}
+	if cfg.MaxBytes <= 0 || cfg.MaxBytes > core.MaxImageSizeBytes {
+		cfg.MaxBytes = core.DefaultImageFetchMaxBytes
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = &core.NopLogger{}
+	}
+
+	f := &ImageFetcher{
+		allowedHosts: normalizeHosts(cfg.AllowedHosts),
+		timeout:      cfg.Timeout,
+		maxBytes:     cfg.MaxBytes,
+		cache:        cfg.Cache,
+		logger:       cfg.Logger,
+		client:       cfg.HTTPClient,
+	}
+	if f.client == nil {
+		f.client = newPublicOnlyClient()
+	}
+
+	// Redirect targets must pass the same allowlist as the original URL
+	client := *f.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= core.ImageFetchMaxRedirects {
+			return fmt.Errorf("too many redirects")
+		}
+		return f.checkURL(req.URL)
+	}
+	f.client = &client
+
+	return f
+}
+
+// Enabled reports whether any host is allowed
+func (f *ImageFetcher) Enabled() bool {
+	return len(f.allowedHosts) > 0
+}
+
+// FetchImage downloads an http(s) image, validates it by content sniffing and returns base64 data
+func (f *ImageFetcher) FetchImage(ctx context.Context, rawURL string) (string, string, error) {
+	if !f.Enabled() {
+		return "", "", ErrRemoteImagesDisabled
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid image URL: %w", err)
+	}
+	if err := f.checkURL(parsed); err != nil {
+		return "", "", err
+	}
+
+	var cacheKey string
+	if f.cache != nil {
+		cacheKey = cache.GenerateImageURLCacheKey(rawURL)
+		if cached, found := f.cache.Get(cacheKey); found {
+			if img, ok := cached.(fetchedImage); ok {
+				return img.mediaType, img.data, nil
+			}
+		}
+	}
+
+	body, err := f.download(ctx, parsed.String())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch image from %s: %w", parsed.Host, err)
+	}
+
+	mediaType := sniffImageType(body)
+	if mediaType == "" {
+		return "", "", fmt.Errorf("fetched content from %s is not a supported image (supported: %v)",
+			parsed.Host, core.SupportedImageFormats)
+	}
+
+	img := fetchedImage{mediaType: mediaType, data: base64.StdEncoding.EncodeToString(body)}
+	if f.cache != nil {
+		f.cache.Set(cacheKey, img, core.ImageFetchCacheTTL)
+	}
+	f.logger.Debug("Fetched remote image: host=%s, type=%s, bytes=%d", parsed.Host, mediaType, len(body))
+
+	return img.mediaType, img.data, nil
+}
+
+func (f *ImageFetcher) download(ctx context.Context, target string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(core.HeaderAccept, "image/*")
+
+	resp, err := f.client.Do(req) //nolint:gosec // Target host is restricted by the configured allowlist.
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("image size %d bytes exceeds limit %d", resp.ContentLength, f.maxBytes)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > f.maxBytes {
+		return nil, fmt.Errorf("image exceeds limit of %d bytes", f.maxBytes)
+	}
+	return body, nil
+}
+
+func (f *ImageFetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported image URL scheme: %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("image URL has no host")
+	}
+	if !f.hostAllowed(host) {
+		return fmt.Errorf("image host %q is not in the allowlist", host)
+	}
+	return nil
+}
+
+func (f *ImageFetcher) hostAllowed(host string) bool {
+	for _, allowed := range f.allowedHosts {
+		switch {
+		case allowed == core.ImageFetchAllowAnyHost:
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		case host == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHosts(hosts []string) []string {
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// sniffImageType detects the image type from content, ignoring the server-declared Content-Type
+func sniffImageType(body []byte) string {
+	mediaType, _, _ := strings.Cut(http.DetectContentType(body), ";")
+	for _, supported := range core.SupportedImageFormats {
+		if mediaType == supported {
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// newPublicOnlyClient builds a client whose dialer refuses non-public addresses, so allowlisted
+// names that resolve to internal hosts cannot be used for SSRF. Connections are made directly.
+func newPublicOnlyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: core.DefaultImageFetchTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("refusing to fetch image from non-public address %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: core.HTTPTLSHandshakeTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     core.HTTPIdleConnTimeout,
+		},
+	}
+}
+
+// specialPurposePrefixes are the ranges of the IANA IPv4 and IPv6 Special-Purpose Address
+// Registries not already covered by the net.IP checks in isPublicIP. Translation prefixes
+// (NAT64, 6to4, Teredo) are included because they can embed a private IPv4 address.
+var specialPurposePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // TEST-NET-1
+	netip.MustParsePrefix("192.31.196.0/24"), // AS112-v4
+	netip.MustParsePrefix("192.52.193.0/24"), // AMT
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.175.48.0/24"), // direct delegation AS112 service
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // TEST-NET-2
+	netip.MustParsePrefix("203.0.113.0/24"),  // TEST-NET-3
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including limited broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // IPv4/IPv6 translation
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use IPv4/IPv6 translation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("5f00::/16"),       // segment routing SIDs
+}
+
+func isPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	// IPv4-mapped IPv6 addresses are checked as the IPv4 address they carry
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range specialPurposePrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"jetbrainsai2api/internal/cache"
+	"jetbrainsai2api/internal/core"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("生成测试 PNG 失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTestFetcher(t *testing.T, srv *httptest.Server, allowed []string, maxBytes int64) *ImageFetcher {
+	t.Helper()
+	c := cache.NewCacheService()
+	t.Cleanup(c.Stop)
+	return NewImageFetcher(ImageFetcherConfig{
+		AllowedHosts: allowed,
+		MaxBytes:     maxBytes,
+		Cache:        c,
+		HTTPClient:   srv.Client(),
+	})
+}
+
+func TestImageFetcher_FetchImage(t *testing.T) {
+	pngData := testPNG(t)
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/image.png":
+			// Declared type is wrong on purpose: the fetcher must sniff the content
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(pngData)
+		case "/page.html":
+			_, _ = w.Write([]byte("<html><body>not an image</body></html>"))
+		case "/large.png":
+			_, _ = w.Write(append(pngData, make([]byte, 4096)...))
+		case "/missing.png":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, "http://evil.example.com/image.png", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := mustHostname(t, srv.URL)
+
+	tests := []struct {
+		name      string
+		allowed   []string
+		maxBytes  int64
+		path      string
+		wantMedia string
+		wantErr   string
+	}{
+		{name: "允许的主机并嗅探类型", allowed: []string{host}, path: "/image.png", wantMedia: core.ImageFormatPNG},
+		{name: "通配允许任意主机", allowed: []string{core.ImageFetchAllowAnyHost}, path: "/image.png", wantMedia: core.ImageFormatPNG},
+		{name: "未配置允许列表", allowed: nil, path: "/image.png", wantErr: "disabled"},
+		{name: "主机不在允许列表", allowed: []string{"example.com"}, path: "/image.png", wantErr: "not in the allowlist"},
+		{name: "非图片内容", allowed: []string{host}, path: "/page.html", wantErr: "not a supported image"},
+		{name: "超过大小上限", allowed: []string{host}, maxBytes: 1024, path: "/large.png", wantErr: "exceeds limit"},
+		{name: "上游非 200", allowed: []string{host}, path: "/missing.png", wantErr: "unexpected status 404"},
+		{name: "重定向到未允许主机", allowed: []string{host}, path: "/redirect", wantErr: "not in the allowlist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFetcher(t, srv, tt.allowed, tt.maxBytes)
+			mediaType, data, err := f.FetchImage(context.Background(), srv.URL+tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("期望包含 %q 的错误，实际: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if mediaType != tt.wantMedia {
+				t.Errorf("期望类型 %s，实际 %s", tt.wantMedia, mediaType)
+			}
+			if data != base64.StdEncoding.EncodeToString(pngData) {
+				t.Error("返回的 base64 数据与原图不一致")
+			}
+		})
+	}
+
+	t.Run("命中缓存不再请求上游", func(t *testing.T) {
+		f := newTestFetcher(t, srv, []string{host}, 0)
+		before := hits.Load()
+		for i := 0; i < 3; i++ {
+			if _, _, err := f.FetchImage(context.Background(), srv.URL+"/image.png"); err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+		}
+		if got := hits.Load() - before; got != 1 {
+			t.Errorf("期望上游只被请求 1 次，实际 %d 次", got)
+		}
+	})
+}
+
+func TestImageFetcher_RejectsNonHTTPScheme(t *testing.T) {
+	f := NewImageFetcher(ImageFetcherConfig{AllowedHosts: []string{core.ImageFetchAllowAnyHost}})
+	if _, _, err := f.FetchImage(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("file:// 协议应被拒绝")
+	}
+}
+
+func TestImageFetcher_DefaultClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testPNG(t))
+	}))
+	defer srv.Close()
+
+	f := NewImageFetcher(ImageFetcherConfig{AllowedHosts: []string{core.ImageFetchAllowAnyHost}})
+	_, _, err := f.FetchImage(context.Background(), srv.URL+"/image.png")
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("默认客户端应拒绝回环地址，实际: %v", err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected bool
+	}{
+		{"公网 IPv4", "93.184.216.34", true},
+		{"公网 IPv6", "2606:4700::1111", true},
+		{"回环地址", "127.0.0.1", false},
+		{"私有地址", "10.1.2.3", false},
+		{"链路本地地址", "169.254.169.254", false},
+		{"CGNAT 地址", "100.64.0.1", false},
+		{"IETF 协议分配", "192.0.0.8", false},
+		{"基准测试地址", "198.19.255.1", false},
+		{"文档地址", "203.0.113.7", false},
+		{"保留地址", "240.0.0.1", false},
+		{"受限广播", "255.255.255.255", false},
+		{"本网络地址", "0.1.2.3", false},
+		{"IPv4 映射的私有地址", "::ffff:192.168.1.1", false},
+		{"NAT64 内嵌地址", "64:ff9b::a00:1", false},
+		{"6to4 地址", "2002:a00:1::1", false},
+		{"唯一本地地址", "fd00::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.expected {
+				t.Errorf("isPublicIP(%s) 期望 %v，实际 %v", tt.ip, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestImageFetcher_HostAllowed(t *testing.T) {
+	f := NewImageFetcher(ImageFetcherConfig{AllowedHosts: []string{"Images.Example.com", "*.cdn.example.org"}})
+
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"images.example.com", true},
+		{"example.com", false},
+		{"a.cdn.example.org", true},
+		{"a.b.cdn.example.org", true},
+		{"cdn.example.org", false},
+		{"evilcdn.example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := f.hostAllowed(tt.host); got != tt.expected {
+				t.Errorf("hostAllowed(%s) 期望 %v，实际 %v", tt.host, tt.expected, got)
+			}
+		})
+	}
+}
+
+func mustHostname(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("解析 URL 失败: %v", err)
+	}
+	return u.Hostname()
+}
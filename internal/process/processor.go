@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,23 +20,22 @@ import (
 	"jetbrainsai2api/internal/validate"
 )
 
-// RequestProcessor handles request processing
+// RequestProcessor handles request processing. It holds no models config: the payload builders
+// take the snapshot of the request they build, so a reload never changes a request half way.
 type RequestProcessor struct {
-	modelsConfig core.ModelsConfig
-	httpClient   *http.Client
-	cache        core.Cache
-	metrics      core.MetricsCollector
-	logger       core.Logger
+	httpClient *http.Client
+	cache      core.Cache
+	metrics    core.MetricsCollector
+	logger     core.Logger
 }
 
 // NewRequestProcessor creates a new request processor
-func NewRequestProcessor(modelsConfig core.ModelsConfig, httpClient *http.Client, c core.Cache, metrics core.MetricsCollector, logger core.Logger) *RequestProcessor {
+func NewRequestProcessor(httpClient *http.Client, c core.Cache, metrics core.MetricsCollector, logger core.Logger) *RequestProcessor {
 	return &RequestProcessor{
-		modelsConfig: modelsConfig,
-		httpClient:   httpClient,
-		cache:        c,
-		metrics:      metrics,
-		logger:       logger,
+		httpClient: httpClient,
+		cache:      c,
+		metrics:    metrics,
+		logger:     logger,
 	}
 }
 
@@ -40,6 +43,7 @@ func NewRequestProcessor(modelsConfig core.ModelsConfig, httpClient *http.Client
 type ProcessMessagesResult struct {
 	JetbrainsMessages []core.JetbrainsMessage
 	CacheHit          bool
+	Error             error
 }
 
 // ProcessMessages processes message conversion with cache
@@ -58,7 +62,10 @@ func (p *RequestProcessor) ProcessMessages(messages []core.ChatMessage) ProcessM
 	}
 
 	p.metrics.RecordCacheMiss()
-	jetbrainsMessages := convert.OpenAIToJetbrainsMessages(messages)
+	jetbrainsMessages, err := convert.OpenAIToJetbrainsMessages(messages)
+	if err != nil {
+		return ProcessMessagesResult{Error: err}
+	}
 
 	p.cache.Set(cacheKey, jetbrainsMessages, core.MessageConversionCacheTTL)
 
@@ -150,9 +157,9 @@ func (p *RequestProcessor) buildToolsData(validatedTools []core.Tool) ([]core.Je
 
 	modifiedTime := time.Now().UnixMilli()
 	data := []core.JetbrainsData{
-		{Type: "json", FQDN: "llm.parameters.tools"},
+		{Type: core.JetBrainsDataTypeJSON, FQDN: core.JetBrainsParamTools},
 		{
-			Type:     "json",
+			Type:     core.JetBrainsDataTypeJSON,
 			Value:    string(toolsJSON),
 			Modified: modifiedTime,
 		},
@@ -161,32 +168,119 @@ func (p *RequestProcessor) buildToolsData(validatedTools []core.Tool) ([]core.Je
 	return data, nil
 }
 
-// BuildJetbrainsPayload builds JetBrains API payload from an OpenAI request
+// BuildSamplingData converts sampling parameters into JetBrains parameter entries,
+// dropping the ones the model's rule in the models config does not accept
+func (p *RequestProcessor) BuildSamplingData(config core.ModelsConfig, model string, params core.SamplingParams) ([]core.JetbrainsData, error) {
+	accepted := AcceptedSamplingParams(config, model)
+	var data []core.JetbrainsData
+
+	add := func(name, fqdn, valueType, value string) {
+		if !accepted(name) {
+			p.logger.Debug("Dropping sampling parameter %s: not supported by model %s", name, model)
+			return
+		}
+		data = append(data,
+			core.JetbrainsData{Type: valueType, FQDN: fqdn},
+			core.JetbrainsData{Type: valueType, Value: value},
+		)
+	}
+
+	if params.Temperature != nil {
+		add(core.SamplingParamTemperature, core.JetBrainsParamTemperature, core.JetBrainsDataTypeDouble, strconv.FormatFloat(*params.Temperature, 'f', -1, 64))
+	}
+	if params.TopP != nil {
+		add(core.SamplingParamTopP, core.JetBrainsParamTopP, core.JetBrainsDataTypeDouble, strconv.FormatFloat(*params.TopP, 'f', -1, 64))
+	}
+	if params.TopK != nil {
+		add(core.SamplingParamTopK, core.JetBrainsParamTopK, core.JetBrainsDataTypeInt, strconv.Itoa(*params.TopK))
+	}
+	if params.MaxTokens != nil {
+		add(core.SamplingParamMaxTokens, core.JetBrainsParamMaxTokens, core.JetBrainsDataTypeInt, strconv.Itoa(*params.MaxTokens))
+	}
+	if len(params.Stop) > 0 {
+		stopJSON, err := util.MarshalJSON(params.Stop)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal stop sequences: %w", err)
+		}
+		add(core.SamplingParamStop, core.JetBrainsParamStop, core.JetBrainsDataTypeJSON, string(stopJSON))
+	}
+
+	return data, nil
+}
+
+// AcceptedSamplingParams returns a predicate reporting whether the model accepts a sampling parameter.
+// Rules are looked up by public model ID, then JetBrains profile, then the "*" default.
+// Forwarding is opt-in: without any rule no sampling parameter is sent upstream.
+func AcceptedSamplingParams(config core.ModelsConfig, model string) func(name string) bool {
+	rule, found := config.Parameters[model]
+	if !found {
+		rule, found = config.Parameters[GetInternalModelName(config, model)]
+	}
+	if !found {
+		rule, found = config.Parameters[core.ModelRuleDefaultKey]
+	}
+	if !found {
+		return func(string) bool { return false }
+	}
+	return func(name string) bool {
+		return slices.Contains(rule, name)
+	}
+}
+
+// BuildJetbrainsPayload builds JetBrains API payload from an OpenAI request,
+// appending the request's sampling parameters to data. The caller validates reasoning_effort.
 func (p *RequestProcessor) BuildJetbrainsPayload(
+	config core.ModelsConfig,
 	request *core.ChatCompletionRequest,
 	messages []core.JetbrainsMessage,
 	data []core.JetbrainsData,
 ) ([]byte, error) {
-	return p.buildPayload(request.Model, messages, data, len(request.Tools))
+	samplingData, err := p.BuildSamplingData(config, request.Model, convert.OpenAISamplingParams(request))
+	if err != nil {
+		return nil, err
+	}
+	reasoning := core.ReasoningParams{Effort: request.ReasoningEffort}
+	return p.buildPayload(config, request.Model, reasoning, messages, append(slices.Clip(data), samplingData...), len(request.Tools))
 }
 
 // BuildPayloadDirect builds JetBrains API payload from pre-converted messages and data.
 // Used by the Anthropic path where messages/tools are already in JetBrains format.
 func (p *RequestProcessor) BuildPayloadDirect(
+	config core.ModelsConfig,
 	model string,
+	reasoning core.ReasoningParams,
 	messages []core.JetbrainsMessage,
 	data []core.JetbrainsData,
 ) ([]byte, error) {
-	return p.buildPayload(model, messages, data, len(data)/2)
+	toolCount := 0
+	for _, d := range data {
+		if d.FQDN == core.JetBrainsParamTools {
+			toolCount++
+		}
+	}
+	return p.buildPayload(config, model, reasoning, messages, data, toolCount)
 }
 
 func (p *RequestProcessor) buildPayload(
+	config core.ModelsConfig,
 	model string,
+	reasoning core.ReasoningParams,
 	messages []core.JetbrainsMessage,
 	data []core.JetbrainsData,
 	toolCount int,
 ) ([]byte, error) {
-	internalModel := GetInternalModelName(p.modelsConfig, model)
+	internalModel := GetInternalModelName(config, model)
+	if effort, variant, ok := ResolveVariant(config, model, reasoning); ok {
+		if variant.Profile != "" {
+			internalModel = variant.Profile
+		}
+		variantData, err := VariantParameterData(variant)
+		if err != nil {
+			return nil, err
+		}
+		data = append(slices.Clip(data), variantData...)
+		p.logger.Debug("Using %s reasoning variant of model %s: profile=%s", effort, model, internalModel)
+	}
 
 	payload := core.JetbrainsPayload{
 		Prompt:  core.JetBrainsChatPrompt,
@@ -252,15 +346,135 @@ func (p *RequestProcessor) SendUpstreamRequest(
 
 // GetInternalModelName gets internal model name by config mapping
 func GetInternalModelName(config core.ModelsConfig, modelID string) string {
-	if internalModel, exists := config.Models[modelID]; exists {
-		return internalModel
+	if spec, exists := config.Models[modelID]; exists && spec.Profile != "" {
+		return spec.Profile
 	}
 	return modelID
 }
 
-// ResolveEndpoint returns the appropriate JetBrains API endpoint for the given model.
-// Codex models use the Responses endpoint; all others use the Chat endpoint.
+// ResolveModelAlias returns the public model a requested name stands for. Configured models
+// resolve to themselves, then exact aliases apply, then the most specific wildcard alias (the
+// longest pattern, ties broken alphabetically); names nothing matches are returned unchanged.
+func ResolveModelAlias(config core.ModelsConfig, name string) string {
+	if _, ok := config.Models[name]; ok {
+		return name
+	}
+	if modelID, ok := config.Aliases[name]; ok {
+		return modelID
+	}
+
+	best := ""
+	for pattern := range config.Aliases {
+		if matched, _ := path.Match(pattern, name); !matched {
+			continue
+		}
+		if best == "" || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return name
+	}
+	return config.Aliases[best]
+}
+
+// ResolveVariant picks the profile variant of a model for the reasoning setting of a request.
+// An effort selects the variant of that name; a thinking budget selects the variant with the
+// highest budget threshold the budget reaches. ok is false when no variant applies, and the
+// request uses the model's own profile.
+func ResolveVariant(config core.ModelsConfig, model string, reasoning core.ReasoningParams) (string, core.ModelVariant, bool) {
+	variants := config.Variants[model]
+	if len(variants) == 0 {
+		return "", core.ModelVariant{}, false
+	}
+	if reasoning.Effort != "" {
+		variant, ok := variants[reasoning.Effort]
+		return reasoning.Effort, variant, ok
+	}
+	if reasoning.BudgetTokens <= 0 {
+		return "", core.ModelVariant{}, false
+	}
+
+	selected, threshold := "", -1
+	for _, effort := range core.ReasoningEfforts {
+		variant, ok := variants[effort]
+		if !ok || effort == core.ReasoningEffortNone {
+			continue
+		}
+		budget := VariantBudgetTokens(effort, variant)
+		if budget <= reasoning.BudgetTokens && budget >= threshold {
+			selected, threshold = effort, budget
+		}
+	}
+	if selected == "" {
+		return "", core.ModelVariant{}, false
+	}
+	return selected, variants[selected], true
+}
+
+// VariantBudgetTokens returns the smallest thinking budget that selects a variant.
+func VariantBudgetTokens(effort string, variant core.ModelVariant) int {
+	if variant.BudgetTokens > 0 {
+		return variant.BudgetTokens
+	}
+	switch effort {
+	case core.ReasoningEffortLow:
+		return core.ThinkingBudgetLow
+	case core.ReasoningEffortMedium:
+		return core.ThinkingBudgetMedium
+	case core.ReasoningEffortHigh:
+		return core.ThinkingBudgetHigh
+	case core.ReasoningEffortXHigh:
+		return core.ThinkingBudgetXHigh
+	default:
+		return core.ThinkingBudgetMinimal
+	}
+}
+
+// VariantParameterData converts the parameters of a variant into JetBrains parameter entries in
+// FQDN order. Integers are sent as int, other numbers as double, everything else as JSON.
+func VariantParameterData(variant core.ModelVariant) ([]core.JetbrainsData, error) {
+	fqdns := make([]string, 0, len(variant.Parameters))
+	for fqdn := range variant.Parameters {
+		fqdns = append(fqdns, fqdn)
+	}
+	slices.Sort(fqdns)
+
+	var data []core.JetbrainsData
+	for _, fqdn := range fqdns {
+		valueType, value := core.JetBrainsDataTypeJSON, ""
+		switch v := variant.Parameters[fqdn].(type) {
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				valueType, value = core.JetBrainsDataTypeInt, strconv.FormatInt(int64(v), 10)
+			} else {
+				valueType, value = core.JetBrainsDataTypeDouble, strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		default:
+			encoded, err := util.MarshalJSON(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal variant parameter %s: %w", fqdn, err)
+			}
+			value = string(encoded)
+		}
+		data = append(data,
+			core.JetbrainsData{Type: valueType, FQDN: fqdn},
+			core.JetbrainsData{Type: valueType, Value: value},
+		)
+	}
+	return data, nil
+}
+
+// ResolveEndpoint returns the appropriate JetBrains API endpoint for the given model: the
+// endpoint its models.json entry names, otherwise the Responses endpoint for Codex profiles and
+// the Chat endpoint for all others.
 func ResolveEndpoint(config core.ModelsConfig, model string) string {
+	switch config.Models[model].Endpoint {
+	case core.ModelEndpointResponses:
+		return core.JetBrainsResponsesEndpoint
+	case core.ModelEndpointChat:
+		return core.JetBrainsChatEndpoint
+	}
 	internal := GetInternalModelName(config, model)
 	if strings.Contains(internal, "-codex") {
 		return core.JetBrainsResponsesEndpoint
@@ -30,7 +30,7 @@ func (rt *countingRoundTripper) RoundTrip(_ *http.Request) (*http.Response, erro
 func TestRequestProcessor_ProcessMessages(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	tests := []struct {
 		name           string
@@ -70,7 +70,7 @@ func TestRequestProcessor_ProcessMessages(t *testing.T) {
 func TestRequestProcessor_ProcessTools_NoTools(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	request := &core.ChatCompletionRequest{
 		Model:    "gpt-4",
@@ -93,7 +93,7 @@ func TestRequestProcessor_ProcessTools_NoTools(t *testing.T) {
 func TestRequestProcessor_ProcessTools_WithTools(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	request := &core.ChatCompletionRequest{
 		Model:    "gpt-4",
@@ -131,7 +131,7 @@ func TestRequestProcessor_ProcessTools_WithTools(t *testing.T) {
 func TestRequestProcessor_BuildJetbrainsPayload(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	request := &core.ChatCompletionRequest{
 		Model:    "gpt-4",
@@ -140,7 +140,7 @@ func TestRequestProcessor_BuildJetbrainsPayload(t *testing.T) {
 	jetbrainsMessages := []core.JetbrainsMessage{{Type: core.JetBrainsMessageTypeUser, Content: "Hello"}}
 	data := []core.JetbrainsData{}
 
-	payloadBytes, err := processor.BuildJetbrainsPayload(request, jetbrainsMessages, data)
+	payloadBytes, err := processor.BuildJetbrainsPayload(core.ModelsConfig{}, request, jetbrainsMessages, data)
 	if err != nil {
 		t.Errorf("构建 payload 不应该失败: %v", err)
 	}
@@ -163,7 +163,7 @@ func TestRequestProcessor_BuildJetbrainsPayload(t *testing.T) {
 func TestRequestProcessor_ProcessMessages_WithImageContent(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	messages := []core.ChatMessage{
 		{
@@ -172,22 +172,29 @@ func TestRequestProcessor_ProcessMessages_WithImageContent(t *testing.T) {
 				map[string]any{"type": core.ContentBlockTypeText, "text": "What's in this image?"},
 				map[string]any{
 					"type":      "image_url",
-					"image_url": map[string]any{"url": "data:image/png;base64,iVBORw0KGgoAAAANSU"},
+					"image_url": map[string]any{"url": "data:image/png;base64,iVBORw0KGgo="},
 				},
 			},
 		},
 	}
 
 	result := processor.ProcessMessages(messages)
-	if len(result.JetbrainsMessages) == 0 {
-		t.Error("期望至少有一个消息被处理")
+	if result.Error != nil || len(result.JetbrainsMessages) != 2 {
+		t.Errorf("期望文本与图片两个消息，实际 %+v", result)
+	}
+
+	invalid := []core.ChatMessage{{Role: core.RoleUser, Content: []any{
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,!!!"}},
+	}}}
+	if result := processor.ProcessMessages(invalid); result.Error == nil {
+		t.Error("无效图片应返回错误")
 	}
 }
 
 func TestRequestProcessor_ProcessTools_Caching(t *testing.T) {
 	testCache := cache.NewCacheService()
 	defer func() { _ = testCache.Close() }()
-	processor := NewRequestProcessor(core.ModelsConfig{}, nil, testCache, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(nil, testCache, &core.NopMetrics{}, &core.NopLogger{})
 
 	request := &core.ChatCompletionRequest{
 		Model: "gpt-4",
@@ -227,11 +234,234 @@ func TestRequestProcessor_ProcessTools_Caching(t *testing.T) {
 	}
 }
 
+func TestRequestProcessor_BuildSamplingData(t *testing.T) {
+	c := cache.NewCacheService()
+	defer func() { _ = c.Close() }()
+	config := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{
+			"gpt-4o": {Profile: "openai-gpt-4o"},
+			"o3":     {Profile: "openai-o3"},
+			"claude": {Profile: "anthropic-claude"},
+			"gpt-5":  {Profile: "openai-gpt-5"},
+		},
+		Parameters: map[string][]string{
+			"gpt-5": {
+				core.SamplingParamTemperature, core.SamplingParamTopP, core.SamplingParamTopK,
+				core.SamplingParamMaxTokens, core.SamplingParamStop,
+			},
+			"openai-o3": {core.SamplingParamMaxTokens},
+			"claude":    {core.SamplingParamTemperature, core.SamplingParamStop},
+		},
+	}
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
+
+	temperature, topP, topK, maxTokens := 0.7, 0.9, 40, 256
+	params := core.SamplingParams{
+		Temperature: &temperature,
+		TopP:        &topP,
+		TopK:        &topK,
+		MaxTokens:   &maxTokens,
+		Stop:        []string{"END", "###"},
+	}
+
+	tests := []struct {
+		name     string
+		model    string
+		expected map[string]core.JetbrainsData
+	}{
+		{"无规则的模型不转发采样参数", "gpt-4o", map[string]core.JetbrainsData{}},
+		{"规则允许全部参数", "gpt-5", map[string]core.JetbrainsData{
+			core.JetBrainsParamTemperature: {Type: core.JetBrainsDataTypeDouble, Value: "0.7"},
+			core.JetBrainsParamTopP:        {Type: core.JetBrainsDataTypeDouble, Value: "0.9"},
+			core.JetBrainsParamTopK:        {Type: core.JetBrainsDataTypeInt, Value: "40"},
+			core.JetBrainsParamMaxTokens:   {Type: core.JetBrainsDataTypeInt, Value: "256"},
+			core.JetBrainsParamStop:        {Type: core.JetBrainsDataTypeJSON, Value: `["END","###"]`},
+		}},
+		{"按 profile 匹配规则", "o3", map[string]core.JetbrainsData{
+			core.JetBrainsParamMaxTokens: {Type: core.JetBrainsDataTypeInt, Value: "256"},
+		}},
+		{"按模型ID匹配规则", "claude", map[string]core.JetbrainsData{
+			core.JetBrainsParamTemperature: {Type: core.JetBrainsDataTypeDouble, Value: "0.7"},
+			core.JetBrainsParamStop:        {Type: core.JetBrainsDataTypeJSON, Value: `["END","###"]`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := processor.BuildSamplingData(config, tt.model, params)
+			if err != nil {
+				t.Fatalf("构建采样参数不应该失败: %v", err)
+			}
+			if len(data) != len(tt.expected)*2 {
+				t.Fatalf("期望 %d 个条目，实际 %d 个", len(tt.expected)*2, len(data))
+			}
+			for i := 0; i < len(data); i += 2 {
+				want, ok := tt.expected[data[i].FQDN]
+				if !ok {
+					t.Errorf("不应该包含参数 %s", data[i].FQDN)
+					continue
+				}
+				if data[i].Type != want.Type || data[i+1].Type != want.Type || data[i+1].Value != want.Value {
+					t.Errorf("参数 %s 期望 %s=%s，实际 %s=%s", data[i].FQDN, want.Type, want.Value, data[i+1].Type, data[i+1].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceptedSamplingParams_DefaultRule(t *testing.T) {
+	config := core.ModelsConfig{
+		Models:     map[string]core.ModelSpec{"gpt-4o": {Profile: "openai-gpt-4o"}, "o1": {Profile: "openai-o1"}},
+		Parameters: map[string][]string{core.ModelRuleDefaultKey: {core.SamplingParamTemperature}, "o1": {}},
+	}
+
+	if accepted := AcceptedSamplingParams(config, "gpt-4o"); !accepted(core.SamplingParamTemperature) || accepted(core.SamplingParamTopK) {
+		t.Error("未配置规则的模型应该使用默认规则")
+	}
+	if accepted := AcceptedSamplingParams(config, "o1"); accepted(core.SamplingParamTemperature) {
+		t.Error("空规则应该拒绝所有参数")
+	}
+}
+
+func TestRequestProcessor_BuildJetbrainsPayload_SamplingParams(t *testing.T) {
+	c := cache.NewCacheService()
+	defer func() { _ = c.Close() }()
+	config := core.ModelsConfig{
+		Parameters: map[string][]string{core.ModelRuleDefaultKey: {core.SamplingParamTemperature, core.SamplingParamStop}},
+	}
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
+
+	temperature := 0.2
+	request := &core.ChatCompletionRequest{
+		Model:       "gpt-4",
+		Messages:    []core.ChatMessage{{Role: core.RoleUser, Content: "Hello"}},
+		Temperature: &temperature,
+		Stop:        "END",
+	}
+	jetbrainsMessages := []core.JetbrainsMessage{{Type: core.JetBrainsMessageTypeUser, Content: "Hello"}}
+
+	payloadBytes, err := processor.BuildJetbrainsPayload(config, request, jetbrainsMessages, nil)
+	if err != nil {
+		t.Fatalf("构建 payload 不应该失败: %v", err)
+	}
+
+	var payload core.JetbrainsPayload
+	if err := sonic.Unmarshal(payloadBytes, &payload); err != nil {
+		t.Fatalf("payload 应该是有效的 JSON: %v", err)
+	}
+	if payload.Parameters == nil || len(payload.Parameters.Data) != 4 {
+		t.Fatalf("期望4个参数条目，实际 %+v", payload.Parameters)
+	}
+	if payload.Parameters.Data[0].FQDN != core.JetBrainsParamTemperature || payload.Parameters.Data[1].Value != "0.2" {
+		t.Errorf("temperature 参数错误: %+v", payload.Parameters.Data[:2])
+	}
+	if payload.Parameters.Data[2].FQDN != core.JetBrainsParamStop || payload.Parameters.Data[3].Value != `["END"]` {
+		t.Errorf("stop 参数错误: %+v", payload.Parameters.Data[2:])
+	}
+}
+
+func TestResolveVariant(t *testing.T) {
+	config := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{"claude": {Profile: "anthropic-claude"}, "gpt": {Profile: "openai-gpt"}},
+		Variants: map[string]map[string]core.ModelVariant{
+			"claude": {
+				core.ReasoningEffortNone:   {Profile: "anthropic-claude-fast"},
+				core.ReasoningEffortLow:    {Profile: "anthropic-claude-thinking"},
+				core.ReasoningEffortHigh:   {Profile: "anthropic-claude-thinking-max", BudgetTokens: 16000},
+				core.ReasoningEffortMedium: {Profile: "anthropic-claude-thinking"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		model          string
+		reasoning      core.ReasoningParams
+		expectedEffort string
+		expectedOK     bool
+	}{
+		{"未设置推理", "claude", core.ReasoningParams{}, "", false},
+		{"按推理强度选择", "claude", core.ReasoningParams{Effort: core.ReasoningEffortNone}, core.ReasoningEffortNone, true},
+		{"推理强度无对应变体", "claude", core.ReasoningParams{Effort: core.ReasoningEffortXHigh}, core.ReasoningEffortXHigh, false},
+		{"最小预算选择 low", "claude", core.ReasoningParams{BudgetTokens: 1024}, core.ReasoningEffortLow, true},
+		{"默认阈值选择 medium", "claude", core.ReasoningParams{BudgetTokens: 10000}, core.ReasoningEffortMedium, true},
+		{"自定义阈值选择 high", "claude", core.ReasoningParams{BudgetTokens: 16000}, core.ReasoningEffortHigh, true},
+		{"模型无变体", "gpt", core.ReasoningParams{Effort: core.ReasoningEffortHigh}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effort, _, ok := ResolveVariant(config, tt.model, tt.reasoning)
+			if ok != tt.expectedOK || (ok && effort != tt.expectedEffort) {
+				t.Errorf("期望 %s/%v，实际 %s/%v", tt.expectedEffort, tt.expectedOK, effort, ok)
+			}
+		})
+	}
+}
+
+func TestRequestProcessor_BuildJetbrainsPayload_Variant(t *testing.T) {
+	c := cache.NewCacheService()
+	defer func() { _ = c.Close() }()
+	config := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{"o3": {Profile: "openai-o3"}},
+		Variants: map[string]map[string]core.ModelVariant{
+			"o3": {core.ReasoningEffortHigh: {
+				Profile:    "openai-o3-high",
+				Parameters: map[string]any{"llm.parameters.reasoning-effort": "high", "llm.parameters.reasoning-budget": float64(8192)},
+			}},
+		},
+	}
+	processor := NewRequestProcessor(nil, c, &core.NopMetrics{}, &core.NopLogger{})
+	jetbrainsMessages := []core.JetbrainsMessage{{Type: core.JetBrainsMessageTypeUser, Content: "Hello"}}
+
+	tests := []struct {
+		name            string
+		effort          string
+		expectedProfile string
+		expectedData    []core.JetbrainsData
+	}{
+		{"默认 profile", "", "openai-o3", nil},
+		{"high 变体", core.ReasoningEffortHigh, "openai-o3-high", []core.JetbrainsData{
+			{Type: core.JetBrainsDataTypeInt, FQDN: "llm.parameters.reasoning-budget"},
+			{Type: core.JetBrainsDataTypeInt, Value: "8192"},
+			{Type: core.JetBrainsDataTypeJSON, FQDN: "llm.parameters.reasoning-effort"},
+			{Type: core.JetBrainsDataTypeJSON, Value: `"high"`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &core.ChatCompletionRequest{Model: "o3", ReasoningEffort: tt.effort}
+			payloadBytes, err := processor.BuildJetbrainsPayload(config, request, jetbrainsMessages, nil)
+			if err != nil {
+				t.Fatalf("构建 payload 不应该失败: %v", err)
+			}
+
+			var payload core.JetbrainsPayload
+			if err := sonic.Unmarshal(payloadBytes, &payload); err != nil {
+				t.Fatalf("payload 应该是有效的 JSON: %v", err)
+			}
+			if payload.Profile != tt.expectedProfile {
+				t.Errorf("期望 profile %s，实际 %s", tt.expectedProfile, payload.Profile)
+			}
+			var data []core.JetbrainsData
+			if payload.Parameters != nil {
+				data = payload.Parameters.Data
+			}
+			if len(data) != len(tt.expectedData) {
+				t.Fatalf("期望参数 %+v，实际 %+v", tt.expectedData, data)
+			}
+			for i := range data {
+				if data[i] != tt.expectedData[i] {
+					t.Errorf("参数 %d 期望 %+v，实际 %+v", i, tt.expectedData[i], data[i])
+				}
+			}
+		})
+	}
+}
+
 func TestGetInternalModelName(t *testing.T) {
 	config := core.ModelsConfig{
-		Models: map[string]string{
-			"gpt-4":    "openai-gpt-4",
-			"claude-3": "anthropic-claude-3",
+		Models: map[string]core.ModelSpec{
+			"gpt-4":    {Profile: "openai-gpt-4"},
+			"claude-3": {Profile: "anthropic-claude-3"},
 		},
 	}
 
@@ -252,13 +482,74 @@ func TestGetInternalModelName(t *testing.T) {
 	}
 }
 
+func TestResolveEndpoint(t *testing.T) {
+	config := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{
+			"gpt-4o":        {Profile: "openai-gpt-4o"},
+			"gpt-5.3-codex": {Profile: "openai-gpt-5-3-codex"},
+			"gpt-5-pro":     {Profile: "openai-gpt-5-pro", Endpoint: core.ModelEndpointResponses},
+			"codex-chat":    {Profile: "openai-codex-chat-codex", Endpoint: core.ModelEndpointChat},
+		},
+	}
+
+	tests := []struct {
+		name, modelID, expected string
+	}{
+		{"默认使用 Chat 端点", "gpt-4o", core.JetBrainsChatEndpoint},
+		{"Codex 模型推断为 Responses 端点", "gpt-5.3-codex", core.JetBrainsResponsesEndpoint},
+		{"显式配置 Responses 端点", "gpt-5-pro", core.JetBrainsResponsesEndpoint},
+		{"显式端点优先于名称推断", "codex-chat", core.JetBrainsChatEndpoint},
+		{"未配置的模型", "unknown-model", core.JetBrainsChatEndpoint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := ResolveEndpoint(config, tt.modelID); result != tt.expected {
+				t.Errorf("期望 '%s'，实际 '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestResolveModelAlias(t *testing.T) {
+	config := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{
+			"claude-opus-4-6": {Profile: "anthropic-claude-4-6-opus"},
+			"gpt-5.4":         {Profile: "openai-gpt-5-4"},
+			"gpt-5.4-mini":    {Profile: "openai-gpt-5-4-mini"},
+		},
+		Aliases: map[string]string{
+			"claude-latest": "claude-opus-4-6",
+			"gpt-*":         "gpt-5.4",
+			"gpt-*-mini":    "gpt-5.4-mini",
+			"claude-*":      "gpt-5.4",
+		},
+	}
+
+	tests := []struct {
+		name, input, expected string
+	}{
+		{"已配置模型不受别名影响", "gpt-5.4-mini", "gpt-5.4-mini"},
+		{"精确别名优先于通配符", "claude-latest", "claude-opus-4-6"},
+		{"通配符别名", "gpt-4o", "gpt-5.4"},
+		{"更具体的通配符优先", "gpt-4o-mini", "gpt-5.4-mini"},
+		{"无匹配时原样返回", "qwen-max", "qwen-max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := ResolveModelAlias(config, tt.input); result != tt.expected {
+				t.Errorf("期望 '%s'，实际 '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestRequestProcessor_SendUpstreamRequest_BlocksNonJetBrainsHost(t *testing.T) {
 	c := cache.NewCacheService()
 	defer func() { _ = c.Close() }()
 
 	rt := &countingRoundTripper{}
 	httpClient := &http.Client{Transport: rt}
-	processor := NewRequestProcessor(core.ModelsConfig{}, httpClient, c, &core.NopMetrics{}, &core.NopLogger{})
+	processor := NewRequestProcessor(httpClient, c, &core.NopMetrics{}, &core.NopLogger{})
 
 	account := &core.JetbrainsAccount{JWT: "dummy-jwt"}
 	resp, err := processor.SendUpstreamRequest(context.Background(), "http://example.com/llm", []byte(`{}`), account)
@@ -48,8 +48,9 @@ func TestHandleAnthropicStreamingResponseWithMetrics_ShouldEmitToolUseBlock(t *t
 	if !strings.Contains(body, "\"input_json_delta\"") {
 		t.Fatalf("应通过 input_json_delta 发送工具参数，实际: %s", body)
 	}
-	if !strings.Contains(body, "\"partial_json\":\"{\\\"city\\\":\\\"Beijing\\\"}\"") {
-		t.Fatalf("input_json_delta 应包含完整 JSON 参数，实际: %s", body)
+	if !strings.Contains(body, "\"partial_json\":\"{\"") ||
+		!strings.Contains(body, "\"partial_json\":\"\\\"city\\\":\\\"Beijing\\\"}\"") {
+		t.Fatalf("每个上游参数片段应作为独立的 input_json_delta 发送，实际: %s", body)
 	}
 	if !strings.Contains(body, "event: message_stop") {
 		t.Fatalf("应包含 message_stop 事件，实际: %s", body)
@@ -217,3 +218,42 @@ func TestHandleAnthropicStreamingResponseWithMetrics_ToolThenTextShouldKeepSeque
 		t.Fatalf("tool->text 事件顺序错误，实际: %s", body)
 	}
 }
+
+func TestHandleAnthropicStreamingResponseWithMetrics_IncrementalToolInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"ToolCall\",\"id\":\"toolu_write\",\"name\":\"write_file\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"{\\\"path\\\":\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"\\\"a.txt\\\",\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"\\\"body\\\":\\\"x\\\"}\"}",
+		"data: {\"type\":\"ToolCall\",\"id\":\"toolu_list\",\"name\":\"list_files\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"tool_call\"}",
+		"",
+	}, "\n")
+
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	handleAnthropicStreamingResponseWithMetrics(c, resp, &core.AnthropicMessagesRequest{Model: "gpt-4o"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if n := strings.Count(body, "\"input_json_delta\""); n != 4 {
+		t.Fatalf("期望 4 个 input_json_delta（3 个片段 + 空参数的 {}），实际 %d: %s", n, body)
+	}
+
+	// Fragments of the first tool must all be sent before the second block starts
+	lastFragment := strings.Index(body, "\"partial_json\":\"\\\"body\\\":\\\"x\\\"}\"")
+	secondStart := strings.Index(body, "\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_list\"")
+	firstStop := strings.Index(body, "{\"type\":\"content_block_stop\",\"index\":0}")
+	if lastFragment < 0 || firstStop < 0 || secondStart < 0 || !(lastFragment < firstStop && firstStop < secondStart) {
+		t.Fatalf("片段应在首个工具块关闭前发送，且第二个工具块 index 应为 1，实际: %s", body)
+	}
+	if !strings.Contains(body, "\"partial_json\":\"{}\"") {
+		t.Fatalf("无参数的工具应补发 {}，实际: %s", body)
+	}
+}
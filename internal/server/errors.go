@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errNoAccountQuota is returned by sendWithRetry when no account with remaining quota can serve the request.
+var errNoAccountQuota = errors.New("no available accounts with quota")
+
+// apiError is a client-facing failure. Its code is stable across protocols; the OpenAI and
+// Anthropic error types (and the Gemini status) are derived when the error is rendered.
+type apiError struct {
+	status  int
+	code    string
+	param   string
+	message string
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+// withParam names the request parameter that caused the error
+func (e *apiError) withParam(param string) *apiError {
+	e.param = param
+	return e
+}
+
+func errInvalidRequestBody() *apiError {
+	return newAPIError(http.StatusBadRequest, core.ErrorCodeInvalidRequestBody, "invalid request body")
+}
+
+func errInvalidParameter(param, message string) *apiError {
+	return newAPIError(http.StatusBadRequest, core.ErrorCodeInvalidParameter, message).withParam(param)
+}
+
+func errInvalidTools() *apiError {
+	return newAPIError(http.StatusBadRequest, core.ErrorCodeInvalidTools, "invalid tool parameters").withParam("tools")
+}
+
+func errModelNotFound(model string) *apiError {
+	return newAPIError(http.StatusNotFound, core.ErrorCodeModelNotFound, fmt.Sprintf("Model %s not found", model)).withParam("model")
+}
+
+func errDeploymentNotFound(deployment string) *apiError {
+	return newAPIError(http.StatusNotFound, core.ErrorCodeDeploymentNotFound, fmt.Sprintf("Deployment %s not found", deployment))
+}
+
+func errResourceNotFound(kind, id string) *apiError {
+	return newAPIError(http.StatusNotFound, core.ErrorCodeResourceNotFound, fmt.Sprintf("No such %s object: %s", kind, id))
+}
+
+func errInternal() *apiError {
+	return newAPIError(http.StatusInternalServerError, core.ErrorCodeInternal, "internal server error")
+}
+
+// openAIType maps the error code to the OpenAI error type
+func (e *apiError) openAIType() string {
+	switch e.code {
+	case core.ErrorCodeInvalidRequestBody, core.ErrorCodeInvalidParameter, core.ErrorCodeInvalidTools,
+		core.ErrorCodeContextLengthExceeded, core.ErrorCodeModelNotFound, core.ErrorCodeDeploymentNotFound,
+		core.ErrorCodeResourceNotFound, core.ErrorCodeUpstreamRejected:
+		return core.OpenAIErrorTypeInvalidRequest
+	case core.ErrorCodeMissingAPIKey:
+		return core.OpenAIErrorTypeAuthentication
+	case core.ErrorCodeInvalidAPIKey:
+		return core.OpenAIErrorTypePermission
+	case core.ErrorCodeRateLimitExceeded:
+		return core.OpenAIErrorTypeRateLimit
+	case core.ErrorCodeInsufficientQuota:
+		return core.OpenAIErrorTypeInsufficientQuota
+	default:
+		return core.OpenAIErrorTypeServer
+	}
+}
+
+// anthropicType maps the error code to the Anthropic error type
+func (e *apiError) anthropicType() string {
+	switch e.code {
+	case core.ErrorCodeInvalidRequestBody, core.ErrorCodeInvalidParameter, core.ErrorCodeInvalidTools,
+		core.ErrorCodeContextLengthExceeded, core.ErrorCodeUpstreamRejected:
+		return core.AnthropicErrorInvalidRequest
+	case core.ErrorCodeModelNotFound, core.ErrorCodeDeploymentNotFound:
+		return core.AnthropicErrorModelNotFound
+	case core.ErrorCodeResourceNotFound:
+		return core.AnthropicErrorNotFound
+	case core.ErrorCodeMissingAPIKey:
+		return core.AnthropicErrorAuthentication
+	case core.ErrorCodeInvalidAPIKey:
+		return core.AnthropicErrorPermission
+	case core.ErrorCodeRateLimitExceeded, core.ErrorCodeInsufficientQuota:
+		return core.AnthropicErrorRateLimit
+	default:
+		return core.AnthropicErrorAPI
+	}
+}
+
+// openAIBody renders the error as an OpenAI error object
+func (e *apiError) openAIBody() core.OpenAIErrorResponse {
+	body := core.OpenAIErrorResponse{Error: core.OpenAIError{
+		Message: e.message,
+		Type:    e.openAIType(),
+		Code:    e.code,
+	}}
+	if e.param != "" {
+		body.Error.Param = &e.param
+	}
+	return body
+}
+
+// anthropicBody renders the error as an Anthropic error event
+func (e *apiError) anthropicBody() core.AnthropicErrorEvent {
+	return core.AnthropicErrorEvent{
+		Type:  core.StreamEventTypeError,
+		Error: core.AnthropicErrorDetail{Type: e.anthropicType(), Message: e.message},
+	}
+}
+
+// geminiStatus maps the HTTP status to the google.rpc status name used by Gemini errors
+func (e *apiError) geminiStatus() string {
+	switch e.status {
+	case http.StatusBadRequest:
+		return core.GeminiStatusInvalidArgument
+	case http.StatusUnauthorized:
+		return core.GeminiStatusUnauthenticated
+	case http.StatusForbidden:
+		return core.GeminiStatusPermissionDenied
+	case http.StatusNotFound:
+		return core.GeminiStatusNotFound
+	case http.StatusTooManyRequests:
+		return core.GeminiStatusResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return core.GeminiStatusUnavailable
+	default:
+		return core.GeminiStatusInternal
+	}
+}
+
+// geminiBody renders the error as a Gemini error object
+func (e *apiError) geminiBody() core.GeminiErrorResponse {
+	return core.GeminiErrorResponse{Error: core.GeminiError{
+		Code:    e.status,
+		Message: e.message,
+		Status:  e.geminiStatus(),
+	}}
+}
+
+// azureBody renders the error as an Azure OpenAI error object
+func (e *apiError) azureBody() core.AzureErrorResponse {
+	return core.AzureErrorResponse{Error: core.AzureError{
+		Code:    e.code,
+		Message: e.message,
+		Param:   e.param,
+		Type:    e.openAIType(),
+	}}
+}
+
+// ollamaBody renders the error as an Ollama error object, which carries only the message
+func (e *apiError) ollamaBody() core.OllamaErrorResponse {
+	return core.OllamaErrorResponse{Error: e.message}
+}
+
+// respondWithError renders the error in the given API format
+func respondWithError(c *gin.Context, format string, e *apiError) {
+	switch format {
+	case core.APIFormatAnthropic:
+		respondWithAnthropicError(c, e)
+	case core.APIFormatGemini:
+		respondWithGeminiError(c, e)
+	case core.APIFormatOllama:
+		respondWithOllamaError(c, e)
+	case core.APIFormatAzure:
+		respondWithAzureError(c, e)
+	default:
+		respondWithOpenAIError(c, e)
+	}
+}
+
+// apiFormatForPath returns the API format of a route, used where the handler is not yet known
+func apiFormatForPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"):
+		return core.APIFormatAnthropic
+	case strings.HasPrefix(path, "/v1beta/"):
+		return core.APIFormatGemini
+	case strings.HasPrefix(path, "/api/"):
+		return core.APIFormatOllama
+	case strings.HasPrefix(path, "/openai/"):
+		return core.APIFormatAzure
+	}
+	return core.APIFormatOpenAI
+}
+
+// sendError converts a sendWithRetry or sendWithToolChoice failure into a client error
+func sendError(err error) *apiError {
+	switch {
+	case errors.Is(err, errToolCallRequired):
+		return newAPIError(http.StatusBadGateway, core.ErrorCodeToolCallRequired, err.Error())
+	case errors.Is(err, errNoAccountQuota):
+		return newAPIError(http.StatusTooManyRequests, core.ErrorCodeInsufficientQuota, errNoAccountQuota.Error())
+	default:
+		return newAPIError(http.StatusBadGateway, core.ErrorCodeUpstreamError, "failed to reach upstream service")
+	}
+}
+
+// upstreamError reads a non-200 upstream response and maps it to a client error.
+// 4xx responses keep the upstream message (transparent to the client); 5xx responses
+// get a generic message (no internal details leaked). Upstream credential failures are
+// the proxy's problem, not the client's, so they surface as 502.
+func upstreamError(resp *http.Response, logger core.Logger) *apiError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, core.MaxResponseBodySize))
+	logger.Error("JetBrains API Error: status=%d, body=%s", resp.StatusCode, string(body))
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = fmt.Sprintf("upstream client error (status %d)", resp.StatusCode)
+	}
+
+	switch {
+	case resp.StatusCode == core.JetBrainsStatusQuotaExhausted:
+		return newAPIError(http.StatusTooManyRequests, core.ErrorCodeInsufficientQuota, errNoAccountQuota.Error())
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return newAPIError(http.StatusBadGateway, core.ErrorCodeUpstreamUnauthorized, "upstream rejected the account credentials")
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return newAPIError(http.StatusTooManyRequests, core.ErrorCodeRateLimitExceeded, message)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return newAPIError(resp.StatusCode, core.ErrorCodeUpstreamRejected, message)
+	default:
+		return newAPIError(http.StatusBadGateway, core.ErrorCodeUpstreamError, "upstream service error")
+	}
+}
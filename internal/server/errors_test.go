@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpstreamError(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		body           string
+		expectedStatus int
+		expectedCode   string
+		expectedType   string
+		expectedMsg    string
+	}{
+		{"配额耗尽", core.JetBrainsStatusQuotaExhausted, "", http.StatusTooManyRequests, core.ErrorCodeInsufficientQuota, core.OpenAIErrorTypeInsufficientQuota, "no available accounts with quota"},
+		{"上游凭证失效", http.StatusUnauthorized, "jwt expired", http.StatusBadGateway, core.ErrorCodeUpstreamUnauthorized, core.OpenAIErrorTypeServer, "upstream rejected the account credentials"},
+		{"上游限流", http.StatusTooManyRequests, "slow down", http.StatusTooManyRequests, core.ErrorCodeRateLimitExceeded, core.OpenAIErrorTypeRateLimit, "slow down"},
+		{"上游拒绝请求保留原始消息", http.StatusBadRequest, " prompt too long\n", http.StatusBadRequest, core.ErrorCodeUpstreamRejected, core.OpenAIErrorTypeInvalidRequest, "prompt too long"},
+		{"上游拒绝请求且无响应体", http.StatusUnprocessableEntity, "", http.StatusUnprocessableEntity, core.ErrorCodeUpstreamRejected, core.OpenAIErrorTypeInvalidRequest, "upstream client error (status 422)"},
+		{"上游服务错误不泄露细节", http.StatusInternalServerError, "stack trace", http.StatusBadGateway, core.ErrorCodeUpstreamError, core.OpenAIErrorTypeServer, "upstream service error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}
+			apiErr := upstreamError(resp, &core.NopLogger{})
+			if apiErr.status != tt.expectedStatus {
+				t.Errorf("期望状态码 %d，实际 %d", tt.expectedStatus, apiErr.status)
+			}
+			if apiErr.code != tt.expectedCode {
+				t.Errorf("期望错误码 %s，实际 %s", tt.expectedCode, apiErr.code)
+			}
+			if apiErr.openAIType() != tt.expectedType {
+				t.Errorf("期望错误类型 %s，实际 %s", tt.expectedType, apiErr.openAIType())
+			}
+			if apiErr.message != tt.expectedMsg {
+				t.Errorf("期望消息 %q，实际 %q", tt.expectedMsg, apiErr.message)
+			}
+		})
+	}
+}
+
+func TestSendError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"必须调用工具", errToolCallRequired, http.StatusBadGateway, core.ErrorCodeToolCallRequired},
+		{"无可用账户", fmt.Errorf("%w: all accounts unavailable", errNoAccountQuota), http.StatusTooManyRequests, core.ErrorCodeInsufficientQuota},
+		{"网络错误", errors.New("failed to make request: connection refused"), http.StatusBadGateway, core.ErrorCodeUpstreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := sendError(tt.err)
+			if apiErr.status != tt.expectedStatus || apiErr.code != tt.expectedCode {
+				t.Errorf("期望 %d/%s，实际 %d/%s", tt.expectedStatus, tt.expectedCode, apiErr.status, apiErr.code)
+			}
+		})
+	}
+}
+
+func TestRespondWithError_SharedCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		err           *apiError
+		openAIType    string
+		anthropicType string
+	}{
+		{"模型不存在", errModelNotFound("x"), core.OpenAIErrorTypeInvalidRequest, core.AnthropicErrorModelNotFound},
+		{"缺少 API key", newAPIError(http.StatusUnauthorized, core.ErrorCodeMissingAPIKey, "missing"), core.OpenAIErrorTypeAuthentication, core.AnthropicErrorAuthentication},
+		{"配额不足", sendError(errNoAccountQuota), core.OpenAIErrorTypeInsufficientQuota, core.AnthropicErrorRateLimit},
+		{"内部错误", errInternal(), core.OpenAIErrorTypeServer, core.AnthropicErrorAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			respondWithError(c, core.APIFormatOpenAI, tt.err)
+
+			var openAIResp core.OpenAIErrorResponse
+			if err := sonic.Unmarshal(w.Body.Bytes(), &openAIResp); err != nil {
+				t.Fatalf("OpenAI 错误响应应为合法 JSON: %v", err)
+			}
+			if openAIResp.Error.Type != tt.openAIType || openAIResp.Error.Code != tt.err.code {
+				t.Errorf("OpenAI 错误类型/错误码不符，实际 %s", w.Body.String())
+			}
+
+			w = httptest.NewRecorder()
+			c, _ = gin.CreateTestContext(w)
+			respondWithError(c, core.APIFormatAnthropic, tt.err)
+
+			var anthropicResp core.AnthropicErrorEvent
+			if err := sonic.Unmarshal(w.Body.Bytes(), &anthropicResp); err != nil {
+				t.Fatalf("Anthropic 错误响应应为合法 JSON: %v", err)
+			}
+			if anthropicResp.Type != core.StreamEventTypeError || anthropicResp.Error.Type != tt.anthropicType {
+				t.Errorf("Anthropic 错误类型不符，实际 %s", w.Body.String())
+			}
+			if w.Code != tt.err.status {
+				t.Errorf("两种协议应使用相同状态码，期望 %d，实际 %d", tt.err.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestServerRoutes_OpenAIErrorObject(t *testing.T) {
+	server := newTestServer(t)
+
+	body := []byte(`{"model":"not-exist","messages":[{"role":"user","content":"hi"}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set(core.HeaderAuthorization, core.AuthBearerPrefix+"test-key")
+	req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	expected := `{"error":{"message":"Model not-exist not found","type":"invalid_request_error","param":"model","code":"model_not_found"}}`
+	if w.Body.String() != expected {
+		t.Fatalf("期望 %s，实际 %s", expected, w.Body.String())
+	}
+
+	// 中间件错误按路由协议输出
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"code":"missing_api_key"`)) {
+		t.Fatalf("OpenAI 路由缺少 key 应返回 missing_api_key，实际 %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`{"type":"error","error":{"type":"authentication_error"`)) {
+		t.Fatalf("Anthropic 路由缺少 key 应返回 authentication_error，实际 %s", w.Body.String())
+	}
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"time"
 
+	"jetbrainsai2api/internal/config"
 	"jetbrainsai2api/internal/convert"
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/usage"
 	"jetbrainsai2api/internal/util"
 
 	"github.com/gin-gonic/gin"
@@ -23,38 +27,117 @@ func (s *Server) anthropicMessages(c *gin.Context) {
 	var anthReq core.AnthropicMessagesRequest
 	if err := c.ShouldBindJSON(&anthReq); err != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
-		respondWithAnthropicError(c, http.StatusBadRequest, core.AnthropicErrorInvalidRequest, "invalid request body")
+		respondWithAnthropicError(c, errInvalidRequestBody())
 		return
 	}
 
 	logger.Debug("Anthropic request: model=%s, messages=%d, tools=%d, stream=%v",
 		anthReq.Model, len(anthReq.Messages), len(anthReq.Tools), anthReq.Stream)
 
-	if anthReq.Model == "" {
+	if apiErr := validateAnthropicRequest(&anthReq); apiErr != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-		respondWithAnthropicError(c, http.StatusBadRequest, core.AnthropicErrorInvalidRequest, "model is required")
+		respondWithAnthropicError(c, apiErr)
+		return
+	}
+
+	state := s.requestState(c)
+	anthReq.Model = process.ResolveModelAlias(state.modelsConfig, anthReq.Model)
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, anthReq.Model, startTime, core.APIFormatAnthropic)
+	if modelConfig == nil {
 		return
 	}
 
-	if anthReq.MaxTokens <= 0 {
+	// Phase 1: Build payload — no account needed
+	payloadBytes, toolChoice, apiErr := s.prepareAnthropicMessages(c.Request.Context(), state.modelsConfig, &anthReq)
+	if apiErr != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-		respondWithAnthropicError(c, http.StatusBadRequest, core.AnthropicErrorInvalidRequest, "max_tokens must be positive")
+		respondWithAnthropicError(c, apiErr)
 		return
 	}
 
-	if len(anthReq.Messages) == 0 {
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithToolChoice(ctx, endpoint, payloadBytes, toolChoice, logger)
+	}
+	build := s.anthropicPayloadBuilder(c.Request.Context(), state.modelsConfig, anthReq)
+	var acct *core.JetbrainsAccount
+	var err error
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, anthReq.Model, err = s.sendWithFallback(c.Request.Context(), state.modelsConfig, anthReq.Model, payloadBytes, build, send, logger)
+	c.Header(core.HeaderServedModel, anthReq.Model)
+	if err != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-		respondWithAnthropicError(c, http.StatusBadRequest, core.AnthropicErrorInvalidRequest, "messages cannot be empty")
+		respondWithAnthropicError(c, sendError(err))
 		return
 	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
 
-	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, s.modelsData, anthReq.Model, startTime, core.APIFormatAnthropic)
-	if modelConfig == nil {
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	// Phase 3: Handle upstream response
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, accountIdentifier)
+		respondWithAnthropicError(c, apiErr)
 		return
 	}
 
-	// Phase 1: Build payload — no account needed
-	jetbrainsMessages := convert.AnthropicToJetbrainsMessages(anthReq.Messages)
+	isStream := anthReq.Stream != nil && *anthReq.Stream
+	if isStream {
+		handleAnthropicStreamingResponseWithMetrics(c, resp, &anthReq, startTime, accountIdentifier, s.metricsService, logger)
+	} else {
+		handleAnthropicNonStreamingResponseWithMetrics(c, resp, &anthReq, startTime, accountIdentifier, s.metricsService, logger)
+	}
+}
+
+// validateAnthropicRequest checks the required Messages API fields
+func validateAnthropicRequest(anthReq *core.AnthropicMessagesRequest) *apiError {
+	switch {
+	case anthReq.Model == "":
+		return errInvalidParameter("model", "model is required")
+	case anthReq.MaxTokens <= 0:
+		return errInvalidParameter("max_tokens", "max_tokens must be positive")
+	case len(anthReq.Messages) == 0:
+		return errInvalidParameter("messages", "messages cannot be empty")
+	}
+	return validateThinkingConfig(anthReq.Thinking, anthReq.MaxTokens)
+}
+
+// validateThinkingConfig checks the thinking field the way the Messages API does: an enabled
+// budget must be at least core.MinThinkingBudgetTokens and leave room for the answer in max_tokens.
+func validateThinkingConfig(thinking *core.AnthropicThinkingConfig, maxTokens int) *apiError {
+	if thinking == nil {
+		return nil
+	}
+	switch thinking.Type {
+	case core.ThinkingTypeDisabled:
+		return nil
+	case core.ThinkingTypeEnabled:
+		if thinking.BudgetTokens < core.MinThinkingBudgetTokens {
+			return errInvalidParameter("thinking.budget_tokens", fmt.Sprintf("thinking.budget_tokens must be at least %d", core.MinThinkingBudgetTokens))
+		}
+		if thinking.BudgetTokens >= maxTokens {
+			return errInvalidParameter("thinking.budget_tokens", "thinking.budget_tokens must be less than max_tokens")
+		}
+		return nil
+	}
+	return errInvalidParameter("thinking.type", fmt.Sprintf("thinking.type must be %q or %q", core.ThinkingTypeEnabled, core.ThinkingTypeDisabled))
+}
+
+// prepareAnthropicMessages resolves remote images, converts messages and tools, and builds the
+// JetBrains payload of a Messages API request. Shared by anthropicMessages and message batches.
+func (s *Server) prepareAnthropicMessages(ctx context.Context, modelsConfig core.ModelsConfig, anthReq *core.AnthropicMessagesRequest) ([]byte, core.ToolChoice, *apiError) {
+	resolvedMessages, err := convert.ResolveAnthropicImageURLs(ctx, anthReq.Messages, s.imageFetcher)
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("messages", err.Error())
+	}
+	anthReq.Messages = resolvedMessages
+
+	jetbrainsMessages, err := convert.AnthropicToJetbrainsMessages(anthReq.Messages)
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("messages", err.Error())
+	}
 
 	if anthReq.System != "" {
 		systemMsg := core.JetbrainsMessage{
@@ -64,58 +147,76 @@ func (s *Server) anthropicMessages(c *gin.Context) {
 		jetbrainsMessages = append([]core.JetbrainsMessage{systemMsg}, jetbrainsMessages...)
 	}
 
+	toolChoice, err := convert.ParseAnthropicToolChoice(anthReq.ToolChoice)
+	if err == nil {
+		anthReq.Tools, err = convert.FilterAnthropicTools(anthReq.Tools, toolChoice)
+	}
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("tool_choice", err.Error())
+	}
+
 	var data []core.JetbrainsData
 	if len(anthReq.Tools) > 0 {
 		jetbrainsTools := convert.AnthropicToJetbrainsTools(anthReq.Tools)
 
 		toolsJSON, marshalErr := util.MarshalJSON(jetbrainsTools)
 		if marshalErr != nil {
-			recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-			respondWithAnthropicError(c, http.StatusInternalServerError, core.AnthropicErrorAPI, "failed to marshal tools")
-			return
+			return nil, core.ToolChoice{}, newAPIError(http.StatusInternalServerError, core.ErrorCodeInternal, "failed to marshal tools")
 		}
 		data = append(data,
-			core.JetbrainsData{Type: "json", FQDN: "llm.parameters.tools"},
-			core.JetbrainsData{Type: "json", Value: string(toolsJSON)},
+			core.JetbrainsData{Type: core.JetBrainsDataTypeJSON, FQDN: core.JetBrainsParamTools},
+			core.JetbrainsData{Type: core.JetBrainsDataTypeJSON, Value: string(toolsJSON)},
 		)
 	}
 
-	payloadBytes, err := s.requestProcessor.BuildPayloadDirect(anthReq.Model, jetbrainsMessages, data)
-	if err != nil {
-		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-		logger.Error("Failed to build payload: %v", err)
-		respondWithAnthropicError(c, http.StatusInternalServerError, core.AnthropicErrorAPI, "internal server error")
-		return
+	samplingParams := convert.AnthropicSamplingParams(anthReq)
+	reasoning := convert.AnthropicReasoningParams(anthReq)
+	promptTokens := func() int { return usage.CountAnthropicRequest(anthReq) }
+	if apiErr := checkModelCapabilities(modelsConfig, anthReq.Model, jetbrainsMessages, len(anthReq.Tools) > 0, reasoning, samplingParams.MaxTokens, promptTokens); apiErr != nil {
+		return nil, core.ToolChoice{}, apiErr
 	}
 
-	endpoint := process.ResolveEndpoint(s.modelsConfig, anthReq.Model)
+	samplingData, err := s.requestProcessor.BuildSamplingData(modelsConfig, anthReq.Model, samplingParams)
+	if err != nil {
+		return nil, core.ToolChoice{}, newAPIError(http.StatusInternalServerError, core.ErrorCodeInternal, "failed to build sampling parameters")
+	}
+	data = append(data, samplingData...)
 
-	// Phase 2: Send with retry on 477 quota exhaustion
-	var acct *core.JetbrainsAccount
-	//nolint:bodyclose // resp.Body closed below via defer
-	resp, acct, err = s.sendWithRetry(c.Request.Context(), endpoint, payloadBytes, logger)
+	payloadBytes, err := s.requestProcessor.BuildPayloadDirect(modelsConfig, anthReq.Model, reasoning, jetbrainsMessages, data)
 	if err != nil {
-		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
-		respondWithAnthropicError(c, http.StatusTooManyRequests, core.AnthropicErrorRateLimit, "no available accounts with quota")
+		s.config.Logger.Error("Failed to build payload: %v", err)
+		return nil, core.ToolChoice{}, errInternal()
+	}
+	return payloadBytes, toolChoice, nil
+}
+
+// anthropicCountTokens implements POST /v1/messages/count_tokens.
+// Counting is done locally, so no account is acquired and no request metrics are recorded.
+func (s *Server) anthropicCountTokens(c *gin.Context) {
+	var anthReq core.AnthropicMessagesRequest
+	if err := c.ShouldBindJSON(&anthReq); err != nil {
+		respondWithAnthropicError(c, errInvalidRequestBody())
 		return
 	}
-	defer s.accountManager.ReleaseAccount(acct)
-	defer func() { _ = resp.Body.Close() }()
 
-	accountIdentifier := util.GetTokenDisplayName(acct)
+	if anthReq.Model == "" {
+		respondWithAnthropicError(c, errInvalidParameter("model", "model is required"))
+		return
+	}
 
-	// Phase 3: Handle upstream response
-	if resp.StatusCode != http.StatusOK {
-		errMsg := extractUpstreamErrorMessage(resp, logger)
-		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, accountIdentifier)
-		respondWithAnthropicError(c, resp.StatusCode, core.AnthropicErrorAPI, errMsg)
+	if len(anthReq.Messages) == 0 {
+		respondWithAnthropicError(c, errInvalidParameter("messages", "messages cannot be empty"))
 		return
 	}
 
-	isStream := anthReq.Stream != nil && *anthReq.Stream
-	if isStream {
-		handleAnthropicStreamingResponseWithMetrics(c, resp, &anthReq, startTime, accountIdentifier, s.metricsService, logger)
-	} else {
-		handleAnthropicNonStreamingResponseWithMetrics(c, resp, &anthReq, startTime, accountIdentifier, s.metricsService, logger)
+	state := s.requestState(c)
+	anthReq.Model = process.ResolveModelAlias(state.modelsConfig, anthReq.Model)
+	if config.GetModelItem(state.modelsData, anthReq.Model) == nil {
+		respondWithAnthropicError(c, errModelNotFound(anthReq.Model))
+		return
 	}
+
+	c.JSON(http.StatusOK, core.AnthropicCountTokensResponse{
+		InputTokens: usage.CountAnthropicRequest(&anthReq),
+	})
 }
@@ -81,6 +81,88 @@ func TestAnthropicMessages_UnknownModel(t *testing.T) {
 	}
 }
 
+func TestAnthropicMessages_InvalidImage(t *testing.T) {
+	server := newTestServer(t)
+
+	body := `{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"user","content":[` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/bmp","data":"AAAA"}},` +
+		`{"type":"text","text":"what is this?"}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported image format should return 400, got %d", w.Code)
+	}
+}
+
+func TestAnthropicMessages_RemoteImageDisabled(t *testing.T) {
+	server := newTestServer(t)
+
+	body := `{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"user","content":[` +
+		`{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("remote image without allowlist should return 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte("invalid_request_error")) {
+		t.Errorf("error type should be invalid_request_error, got %s", w.Body.String())
+	}
+}
+
+func TestAnthropicMessages_ToolChoiceUnknownTool(t *testing.T) {
+	server := newTestServer(t)
+
+	body := `{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"user","content":"hi"}],` +
+		`"tools":[{"name":"get_weather","input_schema":{"type":"object"}}],` +
+		`"tool_choice":{"type":"tool","name":"get_time"}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("tool_choice naming an unknown tool should return 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte("invalid_request_error")) {
+		t.Errorf("error type should be invalid_request_error, got %s", w.Body.String())
+	}
+}
+
+func TestAnthropicMessages_InvalidThinking(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		thinking string
+		param    string
+	}{
+		{"unknown type", `{"type":"auto"}`, "thinking.type"},
+		{"budget below minimum", `{"type":"enabled","budget_tokens":512}`, "thinking.budget_tokens"},
+		{"budget not below max_tokens", `{"type":"enabled","budget_tokens":4096}`, "thinking.budget_tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model":"gpt-4o","max_tokens":2048,"messages":[{"role":"user","content":"hi"}],"thinking":` + tt.thinking + `}`
+			w := serveTestRequest(server, http.MethodPost, "/v1/messages", body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("invalid thinking should return 400, got %d", w.Code)
+			}
+			if !bytes.Contains(w.Body.Bytes(), []byte(tt.param)) {
+				t.Errorf("error should name %s, got %s", tt.param, w.Body.String())
+			}
+		})
+	}
+}
+
 func TestAnthropicMessages_RequiresAuth(t *testing.T) {
 	server := newTestServer(t)
 
@@ -94,3 +176,36 @@ func TestAnthropicMessages_RequiresAuth(t *testing.T) {
 		t.Errorf("missing auth should return 401, got %d", w.Code)
 	}
 }
+
+func TestAnthropicCountTokens(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"正常计数", `{"model":"gpt-4o","system":"be brief","messages":[{"role":"user","content":"hello"}]}`, http.StatusOK},
+		{"无需 max_tokens", `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`, http.StatusOK},
+		{"缺少模型", `{"messages":[{"role":"user","content":"hello"}]}`, http.StatusBadRequest},
+		{"空消息", `{"model":"gpt-4o","messages":[]}`, http.StatusBadRequest},
+		{"模型不存在", `{"model":"nonexistent-model","messages":[{"role":"user","content":"hello"}]}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", bytes.NewBufferString(tt.body))
+			req.Header.Set("Authorization", "Bearer test-key")
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Fatalf("期望状态码 %d，实际 %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			if tt.expected == http.StatusOK && !bytes.Contains(w.Body.Bytes(), []byte(`"input_tokens":`)) {
+				t.Errorf("响应应包含 input_tokens，实际: %s", w.Body.String())
+			}
+		})
+	}
+}
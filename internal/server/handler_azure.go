@@ -0,0 +1,47 @@
+package server
+
+import (
+	"time"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+// azureDeploymentModelKey is the gin context key holding the model an Azure deployment resolves to
+const azureDeploymentModelKey = "azureDeploymentModel"
+
+// azureChatCompletions implements POST /openai/deployments/{deployment}/chat/completions.
+// The deployment selects the model (overriding any model in the body) and the request is then
+// served by chatCompletions, which renders Azure errors on /openai/ paths.
+func (s *Server) azureChatCompletions(c *gin.Context) {
+	deployment := c.Param("deployment")
+
+	if c.Query(core.AzureAPIVersionQuery) == "" {
+		recordRequestResultWithMetrics(s.metricsService, false, time.Now(), "", "")
+		respondWithAzureError(c, errInvalidParameter(core.AzureAPIVersionQuery, "api-version query parameter is required"))
+		return
+	}
+
+	model, ok := resolveDeployment(s.requestState(c).modelsConfig, deployment)
+	if !ok {
+		recordRequestResultWithMetrics(s.metricsService, false, time.Now(), "", "")
+		respondWithAzureError(c, errDeploymentNotFound(deployment))
+		return
+	}
+
+	c.Set(azureDeploymentModelKey, model)
+	s.chatCompletions(c)
+}
+
+// resolveDeployment maps a deployment name to a model ID through the deployments section of
+// models.json. Deployments named after a configured model resolve to that model.
+func resolveDeployment(modelsConfig core.ModelsConfig, deployment string) (string, bool) {
+	if model, ok := modelsConfig.Deployments[deployment]; ok {
+		return model, true
+	}
+	if _, ok := modelsConfig.Models[deployment]; ok {
+		return deployment, true
+	}
+	return "", false
+}
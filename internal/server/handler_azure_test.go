@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestResolveDeployment(t *testing.T) {
+	modelsConfig := core.ModelsConfig{
+		Models:      map[string]core.ModelSpec{"gpt-4o": {Profile: "openai-gpt-4o"}},
+		Deployments: map[string]string{"prod-chat": "gpt-4o"},
+	}
+
+	tests := []struct {
+		name          string
+		deployment    string
+		expectedModel string
+		expectedOK    bool
+	}{
+		{"deployments 中的映射", "prod-chat", "gpt-4o", true},
+		{"与模型同名的部署", "gpt-4o", "gpt-4o", true},
+		{"未知部署", "staging-chat", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, ok := resolveDeployment(modelsConfig, tt.deployment)
+			if model != tt.expectedModel || ok != tt.expectedOK {
+				t.Errorf("期望 (%q, %v)，实际 (%q, %v)", tt.expectedModel, tt.expectedOK, model, ok)
+			}
+		})
+	}
+}
+
+func TestServerRoutes_AzureDeployments(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		apiKey         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"缺少 key", "/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", `{}`, "", http.StatusUnauthorized, `{"error":{"code":"missing_api_key","message":`},
+		{"api-key 无效", "/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", `{}`, "wrong-key", http.StatusForbidden, `Invalid client API key (api-key)`},
+		{"缺少 api-version", "/openai/deployments/gpt-4o/chat/completions", `{}`, "test-key", http.StatusBadRequest, `"param":"api-version"`},
+		{"未知部署", "/openai/deployments/staging/chat/completions?api-version=2024-10-21", `{}`, "test-key", http.StatusNotFound, `{"error":{"code":"deployment_not_found","message":"Deployment staging not found","type":"invalid_request_error"}}`},
+		{"chatCompletions 的错误使用 Azure 格式", "/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", `{`, "test-key", http.StatusBadRequest, `{"error":{"code":"invalid_request_body","message":"invalid request body","type":"invalid_request_error"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader([]byte(tt.body)))
+			req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+			if tt.apiKey != "" {
+				req.Header.Set(core.HeaderAzureAPIKey, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("期望状态码 %d，实际 %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望包含 %s，实际 %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
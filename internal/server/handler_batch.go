@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"jetbrainsai2api/internal/batch"
+	"jetbrainsai2api/internal/config"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+// uploadFile implements POST /v1/files (multipart form with "file" and "purpose")
+func (s *Server) uploadFile(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		respondWithOpenAIError(c, errInvalidParameter("file", "file is required"))
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		respondWithOpenAIError(c, errInvalidParameter("file", "file could not be read"))
+		return
+	}
+	defer func() { _ = f.Close() }()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		respondWithOpenAIError(c, errInvalidParameter("file", "file could not be read"))
+		return
+	}
+
+	file, err := s.batches.CreateFile(header.Filename, c.PostForm("purpose"), content)
+	if err != nil {
+		respondWithBatchError(c, err, "File", "")
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+// listFiles implements GET /v1/files, optionally filtered by ?purpose=
+func (s *Server) listFiles(c *gin.Context) {
+	files, err := s.batches.Files(c.Query("purpose"))
+	if err != nil {
+		respondWithBatchError(c, err, "File", "")
+		return
+	}
+	c.JSON(http.StatusOK, core.OpenAIFileList{Object: core.ListObjectType, Data: files})
+}
+
+// getFile implements GET /v1/files/:file_id
+func (s *Server) getFile(c *gin.Context) {
+	id := c.Param("file_id")
+	file, err := s.batches.File(id)
+	if err != nil {
+		respondWithBatchError(c, err, "File", id)
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+// getFileContent implements GET /v1/files/:file_id/content
+func (s *Server) getFileContent(c *gin.Context) {
+	id := c.Param("file_id")
+	content, err := s.batches.FileContent(id)
+	if err != nil {
+		respondWithBatchError(c, err, "File", id)
+		return
+	}
+	c.Data(http.StatusOK, core.ContentTypeJSONL, content)
+}
+
+// deleteFile implements DELETE /v1/files/:file_id
+func (s *Server) deleteFile(c *gin.Context) {
+	id := c.Param("file_id")
+	if err := s.batches.DeleteFile(id); err != nil {
+		respondWithBatchError(c, err, "File", id)
+		return
+	}
+	c.JSON(http.StatusOK, core.OpenAIFileDeleted{ID: id, Object: core.FileObjectType, Deleted: true})
+}
+
+// createBatch implements POST /v1/batches
+func (s *Server) createBatch(c *gin.Context) {
+	var request core.BatchCreateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondWithOpenAIError(c, errInvalidRequestBody())
+		return
+	}
+
+	created, err := s.batches.Create(request)
+	if err != nil {
+		respondWithBatchError(c, err, "Batch", "")
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// getBatch implements GET /v1/batches/:batch_id
+func (s *Server) getBatch(c *gin.Context) {
+	id := c.Param("batch_id")
+	found, err := s.batches.Get(id)
+	if err != nil {
+		respondWithBatchError(c, err, "Batch", id)
+		return
+	}
+	c.JSON(http.StatusOK, found)
+}
+
+// listBatches implements GET /v1/batches with ?after= and ?limit= pagination
+func (s *Server) listBatches(c *gin.Context) {
+	limit := core.BatchListDefaultLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > core.BatchListMaxLimit {
+			respondWithOpenAIError(c, errInvalidParameter("limit", "limit must be between 1 and 100"))
+			return
+		}
+		limit = parsed
+	}
+
+	list, err := s.batches.List(c.Query("after"), limit)
+	if err != nil {
+		respondWithBatchError(c, err, "Batch", "")
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// cancelBatch implements POST /v1/batches/:batch_id/cancel
+func (s *Server) cancelBatch(c *gin.Context) {
+	id := c.Param("batch_id")
+	cancelled, err := s.batches.Cancel(id)
+	if err != nil {
+		respondWithBatchError(c, err, "Batch", id)
+		return
+	}
+	c.JSON(http.StatusOK, cancelled)
+}
+
+// respondWithBatchError renders a batch manager error; kind and id name the object for not-found errors.
+func respondWithBatchError(c *gin.Context, err error, kind, id string) {
+	var requestErr *batch.RequestError
+	switch {
+	case errors.As(err, &requestErr):
+		respondWithOpenAIError(c, errInvalidParameter(requestErr.Param, requestErr.Message))
+	case errors.Is(err, core.ErrNotFound):
+		respondWithOpenAIError(c, errResourceNotFound(kind, id))
+	default:
+		respondWithOpenAIError(c, errInternal())
+	}
+}
+
+// executeBatchRequest runs the body of one batch line through the chat completion pipeline; it is
+// the batch.Executor of the server's batch manager. Errors are returned as OpenAI error bodies with
+// the status code the endpoint would have responded with.
+func (s *Server) executeBatchRequest(ctx context.Context, body map[string]any) (int, any) {
+	startTime := time.Now()
+	logger := s.config.Logger
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var request core.ChatCompletionRequest
+	if err := decodeBatchBody(body, &request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		return batchErrorResult(errInvalidRequestBody())
+	}
+	request.Stream = false
+	request.StreamOptions = nil
+
+	state := s.state.Load()
+	request.Model = process.ResolveModelAlias(state.modelsConfig, request.Model)
+	if config.GetModelItem(state.modelsData, request.Model) == nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		return batchErrorResult(errModelNotFound(request.Model))
+	}
+
+	payloadBytes, toolChoice, apiErr := s.prepareChatCompletion(ctx, state.modelsConfig, &request)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		return batchErrorResult(apiErr)
+	}
+
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendChatCompletion(ctx, endpoint, payloadBytes, toolChoice, &request, logger)
+	}
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, model, err := s.sendWithFallback(ctx, state.modelsConfig, request.Model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, request), send, logger)
+	request.Model = model
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		return batchErrorResult(sendError(err))
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, accountIdentifier)
+		return batchErrorResult(apiErr)
+	}
+
+	response, err := collectChatCompletion(ctx, resp.Body, request, logger)
+	s.metricsService.RecordRequest(err == nil, time.Since(startTime).Milliseconds(), request.Model, accountIdentifier)
+	if err != nil {
+		logger.Error("Stream processing error in batch request: %v", err)
+		return batchErrorResult(newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message))
+	}
+	return http.StatusOK, response
+}
+
+// decodeBatchBody decodes the body of a batch line into a typed request
+func decodeBatchBody(body map[string]any, v any) error {
+	data, err := util.MarshalJSON(body)
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(data, v)
+}
+
+func batchErrorResult(e *apiError) (int, any) {
+	return e.status, e.openAIBody()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+)
+
+func uploadTestFile(t *testing.T, server *Server, purpose, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("purpose", purpose)
+	part, _ := writer.CreateFormFile("file", "input.jsonl")
+	_, _ = part.Write([]byte(content))
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/files", &body)
+	req.Header.Set(core.HeaderContentType, writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer test-key")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func serveTestRequest(server *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+	req.Header.Set("Authorization", "Bearer test-key")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestServerRoutes_Files(t *testing.T) {
+	server := newTestServer(t)
+
+	w := uploadTestFile(t, server, "fine-tune", "{}\n")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"param":"purpose"`) {
+		t.Fatalf("不支持的 purpose 应返回 400，实际 %d: %s", w.Code, w.Body.String())
+	}
+
+	w = uploadTestFile(t, server, core.FilePurposeBatch, "{}\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("上传失败 %d: %s", w.Code, w.Body.String())
+	}
+	var file core.OpenAIFile
+	if err := sonic.Unmarshal(w.Body.Bytes(), &file); err != nil || !strings.HasPrefix(file.ID, core.FileIDPrefix) || file.Bytes != 3 {
+		t.Fatalf("文件对象错误: %s", w.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"获取文件", http.MethodGet, "/v1/files/" + file.ID, http.StatusOK, `"filename":"input.jsonl"`},
+		{"列出文件", http.MethodGet, "/v1/files?purpose=batch", http.StatusOK, file.ID},
+		{"文件内容", http.MethodGet, "/v1/files/" + file.ID + "/content", http.StatusOK, "{}\n"},
+		{"删除文件", http.MethodDelete, "/v1/files/" + file.ID, http.StatusOK, `"deleted":true`},
+		{"删除后不存在", http.MethodGet, "/v1/files/" + file.ID, http.StatusNotFound, `"code":"resource_not_found"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, tt.method, tt.path, "")
+			if w.Code != tt.expectedStatus || !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望 %d 且包含 %s，实际 %d: %s", tt.expectedStatus, tt.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerRoutes_Batches(t *testing.T) {
+	server := newTestServer(t)
+
+	// The input fails validation, so the batch never reaches the upstream
+	w := uploadTestFile(t, server, core.FilePurposeBatch, `{"custom_id":"a","method":"GET","url":"/v1/chat/completions","body":{}}`)
+	var file core.OpenAIFile
+	_ = sonic.Unmarshal(w.Body.Bytes(), &file)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"不支持的 endpoint", `{"input_file_id":"` + file.ID + `","endpoint":"/v1/embeddings","completion_window":"24h"}`, http.StatusBadRequest, `"param":"endpoint"`},
+		{"输入文件不存在", `{"input_file_id":"file-missing","endpoint":"/v1/chat/completions","completion_window":"24h"}`, http.StatusBadRequest, `"param":"input_file_id"`},
+		{"创建成功", `{"input_file_id":"` + file.ID + `","endpoint":"/v1/chat/completions","completion_window":"24h","metadata":{"run":"nightly"}}`, http.StatusOK, `"status":"validating"`},
+	}
+	var created core.Batch
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, http.MethodPost, "/v1/batches", tt.body)
+			if w.Code != tt.expectedStatus || !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Fatalf("期望 %d 且包含 %s，实际 %d: %s", tt.expectedStatus, tt.expectedBody, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusOK {
+				_ = sonic.Unmarshal(w.Body.Bytes(), &created)
+			}
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var got core.Batch
+	for time.Now().Before(deadline) {
+		w = serveTestRequest(server, http.MethodGet, "/v1/batches/"+created.ID, "")
+		_ = sonic.Unmarshal(w.Body.Bytes(), &got)
+		if got.Status == core.BatchStatusFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got.Status != core.BatchStatusFailed || got.Errors == nil || got.Errors.Data[0].Code != core.BatchErrorCodeInvalidMethod {
+		t.Fatalf("校验失败的批处理应为 failed，实际 %+v", got)
+	}
+
+	w = serveTestRequest(server, http.MethodGet, "/v1/batches?limit=1", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"first_id":"`+created.ID+`"`) {
+		t.Errorf("列表错误 %d: %s", w.Code, w.Body.String())
+	}
+	w = serveTestRequest(server, http.MethodPost, "/v1/batches/"+created.ID+"/cancel", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("已失败的批处理不能取消，实际 %d: %s", w.Code, w.Body.String())
+	}
+	w = serveTestRequest(server, http.MethodGet, "/v1/batches/batch_missing", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("不存在的批处理应返回 404，实际 %d", w.Code)
+	}
+}
+
+func TestExecuteBatchRequest_Errors(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		body           map[string]any
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"模型不存在", map[string]any{"model": "unknown", "messages": []any{}}, http.StatusNotFound, core.ErrorCodeModelNotFound},
+		{"请求体无法解析", map[string]any{"model": "gpt-4o", "messages": "hello"}, http.StatusBadRequest, core.ErrorCodeInvalidRequestBody},
+		{"tool_choice 无效", map[string]any{"model": "gpt-4o", "messages": []any{map[string]any{"role": "user", "content": "hi"}}, "tool_choice": "sometimes"}, http.StatusBadRequest, core.ErrorCodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := server.executeBatchRequest(context.Background(), tt.body)
+			errBody, ok := body.(core.OpenAIErrorResponse)
+			if status != tt.expectedStatus || !ok || errBody.Error.Code != tt.expectedCode {
+				t.Errorf("期望 %d/%s，实际 %d/%+v", tt.expectedStatus, tt.expectedCode, status, body)
+			}
+		})
+	}
+}
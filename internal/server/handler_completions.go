@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// completions implements the legacy POST /v1/completions endpoint, including fill-in-the-middle
+// requests (prompt plus suffix) sent by editor autocomplete plugins.
+func (s *Server) completions(c *gin.Context) {
+	startTime := time.Now()
+	logger := s.config.Logger
+
+	var resp *http.Response
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, &resp, core.APIFormatOpenAI, logger)()
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var request core.CompletionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		respondWithOpenAIError(c, errInvalidRequestBody())
+		return
+	}
+
+	if request.N != nil && *request.N != 1 {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("n", "only n=1 is supported"))
+		return
+	}
+
+	state := s.requestState(c)
+	request.Model = process.ResolveModelAlias(state.modelsConfig, request.Model)
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, request.Model, startTime, core.APIFormatOpenAI)
+	if modelConfig == nil {
+		return
+	}
+
+	// Phase 1: Build payload — no account needed
+	chatRequest, err := convert.CompletionToChatRequest(&request)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("prompt", err.Error()))
+		return
+	}
+
+	var echo string
+	if request.Echo {
+		echo, _ = convert.CompletionPrompt(request.Prompt)
+	}
+
+	payloadBytes, apiErr := s.buildChatPayload(state.modelsConfig, &chatRequest)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, apiErr)
+		return
+	}
+
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithRetry(ctx, endpoint, payloadBytes, logger)
+	}
+	var acct *core.JetbrainsAccount
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, request.Model, err = s.sendWithFallback(c.Request.Context(), state.modelsConfig, request.Model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, chatRequest), send, logger)
+	chatRequest.Model = request.Model
+	c.Header(core.HeaderServedModel, request.Model)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, sendError(err))
+		return
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	// Phase 3: Handle upstream response
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, accountIdentifier)
+		respondWithOpenAIError(c, apiErr)
+		return
+	}
+
+	if request.Stream {
+		handleCompletionStreamingResponseWithMetrics(c, resp, &chatRequest, echo, startTime, accountIdentifier, s.metricsService, logger)
+	} else {
+		handleCompletionNonStreamingResponseWithMetrics(c, resp, &chatRequest, echo, startTime, accountIdentifier, s.metricsService, logger)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiGenerateContent implements POST /v1beta/models/{model}:generateContent and
+// :streamGenerateContent. Gin cannot route on the ":action" suffix, so both arrive on
+// one path parameter and are split here.
+func (s *Server) geminiGenerateContent(c *gin.Context) {
+	startTime := time.Now()
+	logger := s.config.Logger
+
+	var resp *http.Response
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, &resp, core.APIFormatGemini, logger)()
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	model, action, _ := strings.Cut(c.Param("modelAction"), ":")
+	stream := action == core.GeminiActionStreamGenerateContent
+	if !stream && action != core.GeminiActionGenerateContent {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, newAPIError(http.StatusNotFound, core.ErrorCodeInvalidParameter, "unsupported method: "+action))
+		return
+	}
+
+	var request core.GeminiGenerateContentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, errInvalidRequestBody())
+		return
+	}
+
+	state := s.requestState(c)
+	model = process.ResolveModelAlias(state.modelsConfig, model)
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, model, startTime, core.APIFormatGemini)
+	if modelConfig == nil {
+		return
+	}
+
+	// Phase 1: Build payload — no account needed
+	chatRequest, err := convert.GeminiToChatRequest(model, &request)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, errInvalidParameter("contents", err.Error()))
+		return
+	}
+	if len(chatRequest.Messages) == 0 {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, errInvalidParameter("contents", "contents cannot be empty"))
+		return
+	}
+	chatRequest.Stream = stream
+
+	toolChoice, allowed, err := convert.ParseGeminiToolConfig(request.ToolConfig)
+	if err == nil {
+		chatRequest.Tools, err = convert.RestrictOpenAITools(chatRequest.Tools, allowed)
+	}
+	if err == nil {
+		chatRequest.Tools, err = convert.FilterOpenAITools(chatRequest.Tools, toolChoice)
+	}
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, errInvalidParameter("toolConfig", err.Error()))
+		return
+	}
+
+	payloadBytes, apiErr := s.buildChatPayload(state.modelsConfig, &chatRequest)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, apiErr)
+		return
+	}
+
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithToolChoice(ctx, endpoint, payloadBytes, toolChoice, logger)
+	}
+	var acct *core.JetbrainsAccount
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, model, err = s.sendWithFallback(c.Request.Context(), state.modelsConfig, model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, chatRequest), send, logger)
+	chatRequest.Model = model
+	c.Header(core.HeaderServedModel, model)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithGeminiError(c, sendError(err))
+		return
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	// Phase 3: Handle upstream response
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, accountIdentifier)
+		respondWithGeminiError(c, apiErr)
+		return
+	}
+
+	if stream {
+		sse := c.Query("alt") == core.GeminiStreamAltSSE
+		handleGeminiStreamingResponseWithMetrics(c, resp, &chatRequest, sse, startTime, accountIdentifier, s.metricsService, logger)
+	} else {
+		handleGeminiNonStreamingResponseWithMetrics(c, resp, &chatRequest, startTime, accountIdentifier, s.metricsService, logger)
+	}
+}
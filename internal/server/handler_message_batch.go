@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"jetbrainsai2api/internal/batch"
+	"jetbrainsai2api/internal/config"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createMessageBatch implements POST /v1/messages/batches
+func (s *Server) createMessageBatch(c *gin.Context) {
+	var request core.MessageBatchCreateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondWithAnthropicError(c, errInvalidRequestBody())
+		return
+	}
+
+	created, err := s.messageBatches.Create(request)
+	if err != nil {
+		respondWithMessageBatchError(c, err, "")
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// getMessageBatch implements GET /v1/messages/batches/:batch_id
+func (s *Server) getMessageBatch(c *gin.Context) {
+	id := c.Param("batch_id")
+	found, err := s.messageBatches.Get(id)
+	if err != nil {
+		respondWithMessageBatchError(c, err, id)
+		return
+	}
+	c.JSON(http.StatusOK, withResultsURL(c, found))
+}
+
+// listMessageBatches implements GET /v1/messages/batches with ?before_id=, ?after_id= and ?limit= pagination
+func (s *Server) listMessageBatches(c *gin.Context) {
+	limit := core.MessageBatchListDefaultLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > core.MessageBatchListMaxLimit {
+			respondWithAnthropicError(c, errInvalidParameter("limit", "limit must be between 1 and 1000"))
+			return
+		}
+		limit = parsed
+	}
+
+	list, err := s.messageBatches.List(c.Query("before_id"), c.Query("after_id"), limit)
+	if err != nil {
+		respondWithMessageBatchError(c, err, "")
+		return
+	}
+	for i := range list.Data {
+		withResultsURL(c, &list.Data[i])
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// cancelMessageBatch implements POST /v1/messages/batches/:batch_id/cancel
+func (s *Server) cancelMessageBatch(c *gin.Context) {
+	id := c.Param("batch_id")
+	canceling, err := s.messageBatches.Cancel(id)
+	if err != nil {
+		respondWithMessageBatchError(c, err, id)
+		return
+	}
+	c.JSON(http.StatusOK, canceling)
+}
+
+// getMessageBatchResults implements GET /v1/messages/batches/:batch_id/results
+func (s *Server) getMessageBatchResults(c *gin.Context) {
+	id := c.Param("batch_id")
+	results, err := s.messageBatches.Results(id)
+	if err != nil {
+		respondWithMessageBatchError(c, err, id)
+		return
+	}
+	c.Data(http.StatusOK, core.ContentTypeJSONL, results)
+}
+
+// withResultsURL sets results_url on an ended batch; the URL points back at this server.
+func withResultsURL(c *gin.Context, b *core.MessageBatch) *core.MessageBatch {
+	if b.ProcessingStatus != core.MessageBatchStatusEnded {
+		return b
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader(core.HeaderXForwardedProto); proto != "" {
+		scheme = proto
+	}
+	resultsURL := scheme + "://" + c.Request.Host + "/v1/messages/batches/" + b.ID + "/results"
+	b.ResultsURL = &resultsURL
+	return b
+}
+
+// respondWithMessageBatchError renders a message batch manager error in the Anthropic format.
+func respondWithMessageBatchError(c *gin.Context, err error, id string) {
+	var requestErr *batch.RequestError
+	switch {
+	case errors.As(err, &requestErr):
+		respondWithAnthropicError(c, errInvalidParameter(requestErr.Param, requestErr.Message))
+	case errors.Is(err, core.ErrNotFound):
+		respondWithAnthropicError(c, errResourceNotFound("MessageBatch", id))
+	default:
+		respondWithAnthropicError(c, errInternal())
+	}
+}
+
+// executeMessageBatchRequest runs the params of one message batch request through the Messages
+// API pipeline; it is the batch.Executor of the server's message batch manager. Errors are
+// returned as Anthropic error bodies with the status code the endpoint would have responded with.
+func (s *Server) executeMessageBatchRequest(ctx context.Context, params map[string]any) (int, any) {
+	startTime := time.Now()
+	logger := s.config.Logger
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var anthReq core.AnthropicMessagesRequest
+	if err := decodeBatchBody(params, &anthReq); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		return messageBatchErrorResult(errInvalidRequestBody())
+	}
+	anthReq.Stream = nil
+
+	if apiErr := validateAnthropicRequest(&anthReq); apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
+		return messageBatchErrorResult(apiErr)
+	}
+	state := s.state.Load()
+	anthReq.Model = process.ResolveModelAlias(state.modelsConfig, anthReq.Model)
+	if config.GetModelItem(state.modelsData, anthReq.Model) == nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
+		return messageBatchErrorResult(errModelNotFound(anthReq.Model))
+	}
+
+	payloadBytes, toolChoice, apiErr := s.prepareAnthropicMessages(ctx, state.modelsConfig, &anthReq)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
+		return messageBatchErrorResult(apiErr)
+	}
+
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithToolChoice(ctx, endpoint, payloadBytes, toolChoice, logger)
+	}
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, model, err := s.sendWithFallback(ctx, state.modelsConfig, anthReq.Model, payloadBytes, s.anthropicPayloadBuilder(ctx, state.modelsConfig, anthReq), send, logger)
+	anthReq.Model = model
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, "")
+		return messageBatchErrorResult(sendError(err))
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, anthReq.Model, accountIdentifier)
+		return messageBatchErrorResult(apiErr)
+	}
+
+	anthResp, apiErr := collectAnthropicMessage(ctx, resp.Body, &anthReq, logger)
+	s.metricsService.RecordRequest(apiErr == nil, time.Since(startTime).Milliseconds(), anthReq.Model, accountIdentifier)
+	if apiErr != nil {
+		return messageBatchErrorResult(apiErr)
+	}
+	return http.StatusOK, anthResp
+}
+
+func messageBatchErrorResult(e *apiError) (int, any) {
+	return e.status, e.anthropicBody()
+}
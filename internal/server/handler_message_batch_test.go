@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestServerRoutes_MessageBatches(t *testing.T) {
+	server := newTestServer(t)
+
+	// An ended batch saved directly, so that no request reaches the upstream
+	created := time.Now().UTC().Format(time.RFC3339)
+	ended := &core.MessageBatch{ID: "msgbatch_ended", Type: core.MessageBatchObjectType, ProcessingStatus: core.MessageBatchStatusEnded, CreatedAt: created, ExpiresAt: created}
+	store := server.config.Storage.(core.MessageBatchStorage)
+	if err := store.SaveMessageBatch(ended); err != nil {
+		t.Fatalf("保存失败: %v", err)
+	}
+	if err := store.AppendMessageBatchResult(ended.ID, []byte(`{"custom_id":"a","result":{"type":"canceled"}}`)); err != nil {
+		t.Fatalf("保存结果失败: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"请求体无效", http.MethodPost, "/v1/messages/batches", `{"requests":"x"}`, http.StatusBadRequest, `"type":"invalid_request_error"`},
+		{"请求为空", http.MethodPost, "/v1/messages/batches", `{"requests":[]}`, http.StatusBadRequest, `"type":"invalid_request_error"`},
+		{"custom_id 非法", http.MethodPost, "/v1/messages/batches", `{"requests":[{"custom_id":"a/b","params":{}}]}`, http.StatusBadRequest, "custom_id"},
+		{"获取批处理", http.MethodGet, "/v1/messages/batches/" + ended.ID, "", http.StatusOK, `"results_url":"http://example.com/v1/messages/batches/msgbatch_ended/results"`},
+		{"列出批处理", http.MethodGet, "/v1/messages/batches?limit=1", "", http.StatusOK, `"first_id":"msgbatch_ended"`},
+		{"limit 越界", http.MethodGet, "/v1/messages/batches?limit=1001", "", http.StatusBadRequest, "limit"},
+		{"获取结果", http.MethodGet, "/v1/messages/batches/" + ended.ID + "/results", "", http.StatusOK, `"custom_id":"a"`},
+		{"已结束不能取消", http.MethodPost, "/v1/messages/batches/" + ended.ID + "/cancel", "", http.StatusBadRequest, `"type":"invalid_request_error"`},
+		{"批处理不存在", http.MethodGet, "/v1/messages/batches/msgbatch_missing", "", http.StatusNotFound, `"type":"not_found_error"`},
+		{"结果不存在", http.MethodGet, "/v1/messages/batches/msgbatch_missing/results", "", http.StatusNotFound, `"type":"not_found_error"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, tt.method, tt.path, tt.body)
+			if w.Code != tt.expectedStatus || !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望 %d 且包含 %s，实际 %d: %s", tt.expectedStatus, tt.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestExecuteMessageBatchRequest_Errors(t *testing.T) {
+	server := newTestServer(t)
+	messages := []any{map[string]any{"role": "user", "content": "hi"}}
+
+	tests := []struct {
+		name           string
+		params         map[string]any
+		expectedStatus int
+		expectedType   string
+	}{
+		{"缺少 max_tokens", map[string]any{"model": "gpt-4o", "messages": messages}, http.StatusBadRequest, core.AnthropicErrorInvalidRequest},
+		{"模型不存在", map[string]any{"model": "unknown", "max_tokens": 16, "messages": messages}, http.StatusNotFound, core.AnthropicErrorModelNotFound},
+		{"请求体无法解析", map[string]any{"model": "gpt-4o", "max_tokens": 16, "messages": "hello"}, http.StatusBadRequest, core.AnthropicErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := server.executeMessageBatchRequest(context.Background(), tt.params)
+			errBody, ok := body.(core.AnthropicErrorEvent)
+			if status != tt.expectedStatus || !ok || errBody.Error.Type != tt.expectedType {
+				t.Errorf("期望 %d/%s，实际 %d/%+v", tt.expectedStatus, tt.expectedType, status, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ollamaTags implements GET /api/tags, listing the configured models as Ollama models
+func (s *Server) ollamaTags(c *gin.Context) {
+	modelsData := s.requestState(c).modelsData
+	models := make([]core.OllamaModel, 0, len(modelsData.Data))
+	for _, model := range modelsData.Data {
+		digest := sha256.Sum256([]byte(model.ID))
+		models = append(models, core.OllamaModel{
+			Name:       model.ID,
+			Model:      model.ID,
+			ModifiedAt: ollamaTimestamp(model.Created),
+			Digest:     hex.EncodeToString(digest[:]),
+			Details:    ollamaModelDetails(model),
+		})
+	}
+	c.JSON(http.StatusOK, core.OllamaTagsResponse{Models: models})
+}
+
+// ollamaShow implements POST /api/show for a configured model
+func (s *Server) ollamaShow(c *gin.Context) {
+	var request core.OllamaShowRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondWithOllamaError(c, errInvalidRequestBody())
+		return
+	}
+	name := request.Model
+	if name == "" {
+		name = request.Name
+	}
+
+	modelID := convert.OllamaModelID(name)
+	state := s.requestState(c)
+	for _, model := range state.modelsData.Data {
+		if model.ID != modelID {
+			continue
+		}
+		details := ollamaModelDetails(model)
+		modelInfo := map[string]any{"general.architecture": details.Family, "general.basename": model.ID}
+		if model.ContextWindow > 0 {
+			modelInfo[details.Family+".context_length"] = model.ContextWindow
+		}
+		c.JSON(http.StatusOK, core.OllamaShowResponse{
+			Details:      details,
+			ModelInfo:    modelInfo,
+			Capabilities: ollamaCapabilities(state.modelsConfig.Models[modelID]),
+			ModifiedAt:   ollamaTimestamp(model.Created),
+		})
+		return
+	}
+	respondWithOllamaError(c, errModelNotFound(name))
+}
+
+// ollamaVersion implements GET /api/version, which Ollama clients probe before anything else
+func (s *Server) ollamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, core.OllamaVersionResponse{Version: core.OllamaVersion})
+}
+
+func ollamaModelDetails(model core.ModelInfo) core.OllamaModelDetails {
+	return core.OllamaModelDetails{
+		Family:   model.OwnedBy,
+		Families: []string{model.OwnedBy},
+	}
+}
+
+// ollamaCapabilities lists the Ollama capabilities of a model; vision and thinking only when
+// models.json declares them
+func ollamaCapabilities(spec core.ModelSpec) []string {
+	capabilities := []string{core.OllamaCapabilityCompletion}
+	if spec.SupportsTools() {
+		capabilities = append(capabilities, core.OllamaCapabilityTools)
+	}
+	if spec.Vision != nil && *spec.Vision {
+		capabilities = append(capabilities, core.OllamaCapabilityVision)
+	}
+	if spec.Reasoning != nil && *spec.Reasoning {
+		capabilities = append(capabilities, core.OllamaCapabilityThinking)
+	}
+	return capabilities
+}
+
+func ollamaTimestamp(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// ollamaChat implements POST /api/chat
+func (s *Server) ollamaChat(c *gin.Context) {
+	startTime := time.Now()
+
+	// The upstream body is closed by completeOllama's own defers, even on panic
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, nil, core.APIFormatOllama, s.config.Logger)()
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var request core.OllamaChatRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		respondWithOllamaError(c, errInvalidRequestBody())
+		return
+	}
+
+	chatRequest, err := convert.OllamaChatToChatRequest(&request)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOllamaError(c, errInvalidParameter("messages", err.Error()))
+		return
+	}
+
+	s.completeOllama(c, &chatRequest, false, startTime)
+}
+
+// ollamaGenerate implements POST /api/generate
+func (s *Server) ollamaGenerate(c *gin.Context) {
+	startTime := time.Now()
+
+	// The upstream body is closed by completeOllama's own defers, even on panic
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, nil, core.APIFormatOllama, s.config.Logger)()
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var request core.OllamaGenerateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		respondWithOllamaError(c, errInvalidRequestBody())
+		return
+	}
+
+	chatRequest, err := convert.OllamaGenerateToChatRequest(&request)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOllamaError(c, errInvalidParameter("prompt", err.Error()))
+		return
+	}
+
+	s.completeOllama(c, &chatRequest, true, startTime)
+}
+
+// completeOllama runs a converted /api/chat or /api/generate request through the shared
+// JetBrains pipeline and writes the Ollama response. Ollama has no tool_choice, so the request
+// goes through sendWithRetry directly.
+func (s *Server) completeOllama(c *gin.Context, chatRequest *core.ChatCompletionRequest, generate bool, startTime time.Time) {
+	logger := s.config.Logger
+	state := s.requestState(c)
+	chatRequest.Model = process.ResolveModelAlias(state.modelsConfig, chatRequest.Model)
+	model := chatRequest.Model
+
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, model, startTime, core.APIFormatOllama)
+	if modelConfig == nil {
+		return
+	}
+	if len(chatRequest.Messages) == 0 {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithOllamaError(c, errInvalidParameter("messages", "messages cannot be empty"))
+		return
+	}
+
+	// Phase 1: Build payload — no account needed
+	payloadBytes, apiErr := s.buildChatPayload(state.modelsConfig, chatRequest)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithOllamaError(c, apiErr)
+		return
+	}
+
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithRetry(ctx, endpoint, payloadBytes, logger)
+	}
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, model, err := s.sendWithFallback(c.Request.Context(), state.modelsConfig, model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, *chatRequest), send, logger)
+	chatRequest.Model = model
+	c.Header(core.HeaderServedModel, model)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, "")
+		respondWithOllamaError(c, sendError(err))
+		return
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	// Phase 3: Handle upstream response
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, model, accountIdentifier)
+		respondWithOllamaError(c, apiErr)
+		return
+	}
+
+	if chatRequest.Stream {
+		handleOllamaStreamingResponseWithMetrics(c, resp, chatRequest, generate, startTime, accountIdentifier, s.metricsService, logger)
+	} else {
+		handleOllamaNonStreamingResponseWithMetrics(c, resp, chatRequest, generate, startTime, accountIdentifier, s.metricsService, logger)
+	}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"time"
 
+	"jetbrainsai2api/internal/convert"
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/process"
 	"jetbrainsai2api/internal/util"
@@ -12,61 +14,56 @@ import (
 )
 
 func (s *Server) listModels(c *gin.Context) {
-	c.JSON(http.StatusOK, s.modelsData)
+	c.JSON(http.StatusOK, s.requestState(c).modelsData)
 }
 
+// chatCompletions serves /v1/chat/completions and, via azureChatCompletions, Azure deployment
+// routes; errors are rendered in the format of the route being called.
 func (s *Server) chatCompletions(c *gin.Context) {
 	startTime := time.Now()
+	errorFormat := apiFormatForPath(c.Request.URL.Path)
 
 	var resp *http.Response
-	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, &resp, core.APIFormatOpenAI, s.config.Logger)()
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, &resp, errorFormat, s.config.Logger)()
 	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
 
 	var request core.ChatCompletionRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
-		respondWithOpenAIError(c, http.StatusBadRequest, "invalid request body")
+		respondWithError(c, errorFormat, errInvalidRequestBody())
 		return
 	}
+	if model := c.GetString(azureDeploymentModelKey); model != "" {
+		request.Model = model
+	}
 
-	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, s.modelsData, request.Model, startTime, core.APIFormatOpenAI)
+	state := s.requestState(c)
+	request.Model = process.ResolveModelAlias(state.modelsConfig, request.Model)
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, request.Model, startTime, errorFormat)
 	if modelConfig == nil {
 		return
 	}
 
 	// Phase 1: Build payload — no account needed
-	messagesResult := s.requestProcessor.ProcessMessages(request.Messages)
-	jetbrainsMessages := messagesResult.JetbrainsMessages
-
-	if len(request.Tools) > 0 && request.ToolChoice == nil {
-		request.ToolChoice = core.ToolChoiceAny
-		s.config.Logger.Debug("Setting tool_choice to '%s' for tool usage guarantee", core.ToolChoiceAny)
-	}
-
-	toolsResult := s.requestProcessor.ProcessTools(&request)
-	if toolsResult.Error != nil {
+	payloadBytes, toolChoice, apiErr := s.prepareChatCompletion(c.Request.Context(), state.modelsConfig, &request)
+	if apiErr != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
-		respondWithOpenAIError(c, http.StatusBadRequest, "invalid tool parameters")
+		respondWithError(c, errorFormat, apiErr)
 		return
 	}
 
-	payloadBytes, err := s.requestProcessor.BuildJetbrainsPayload(&request, jetbrainsMessages, toolsResult.Data)
-	if err != nil {
-		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
-		s.config.Logger.Error("Failed to build payload: %v", err)
-		respondWithOpenAIError(c, http.StatusInternalServerError, "internal server error")
-		return
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendChatCompletion(ctx, endpoint, payloadBytes, toolChoice, &request, s.config.Logger)
 	}
-
-	endpoint := process.ResolveEndpoint(s.modelsConfig, request.Model)
-
-	// Phase 2: Send with retry on 477 quota exhaustion
 	var account *core.JetbrainsAccount
+	var err error
 	//nolint:bodyclose // resp.Body closed below via defer
-	resp, account, err = s.sendWithRetry(c.Request.Context(), endpoint, payloadBytes, s.config.Logger)
+	resp, account, request.Model, err = s.sendWithFallback(c.Request.Context(), state.modelsConfig, request.Model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, request), send, s.config.Logger)
+	c.Header(core.HeaderServedModel, request.Model)
 	if err != nil {
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
-		respondWithOpenAIError(c, http.StatusTooManyRequests, "no available accounts with quota")
+		respondWithError(c, errorFormat, sendError(err))
 		return
 	}
 	defer s.accountManager.ReleaseAccount(account)
@@ -76,9 +73,9 @@ func (s *Server) chatCompletions(c *gin.Context) {
 
 	// Phase 3: Handle upstream response
 	if resp.StatusCode != http.StatusOK {
-		errMsg := extractUpstreamErrorMessage(resp, s.config.Logger)
+		apiErr := upstreamError(resp, s.config.Logger)
 		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, accountIdentifier)
-		respondWithOpenAIError(c, resp.StatusCode, errMsg)
+		respondWithError(c, errorFormat, apiErr)
 		return
 	}
 
@@ -88,3 +85,59 @@ func (s *Server) chatCompletions(c *gin.Context) {
 		handleNonStreamingResponseWithMetrics(c, resp, request, startTime, accountIdentifier, s.metricsService, s.config.Logger)
 	}
 }
+
+// prepareChatCompletion resolves remote images, converts messages and tools, and builds the
+// JetBrains payload of a chat completion request. Shared by chatCompletions and batch requests.
+func (s *Server) prepareChatCompletion(ctx context.Context, modelsConfig core.ModelsConfig, request *core.ChatCompletionRequest) ([]byte, core.ToolChoice, *apiError) {
+	if _, err := convert.OpenAIReasoningParams(request); err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("reasoning_effort", err.Error())
+	}
+
+	resolvedMessages, err := convert.ResolveOpenAIImageURLs(ctx, request.Messages, s.imageFetcher)
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("messages", err.Error())
+	}
+	request.Messages, err = convert.ApplyResponseFormat(resolvedMessages, request.ResponseFormat)
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("response_format", err.Error())
+	}
+
+	toolChoice, err := convert.ParseOpenAIToolChoice(request.ToolChoice)
+	if err == nil {
+		request.Tools, err = convert.FilterOpenAITools(request.Tools, toolChoice)
+	}
+	if err != nil {
+		return nil, core.ToolChoice{}, errInvalidParameter("tool_choice", err.Error())
+	}
+
+	payloadBytes, apiErr := s.buildChatPayload(modelsConfig, request)
+	if apiErr != nil {
+		return nil, core.ToolChoice{}, apiErr
+	}
+	return payloadBytes, toolChoice, nil
+}
+
+// buildChatPayload converts the messages and tools of a chat completion request, checks them
+// against the capabilities of its model and builds the JetBrains payload. The OpenAI-shaped
+// handlers share it, and rebuild requests with it for fallback models.
+func (s *Server) buildChatPayload(modelsConfig core.ModelsConfig, request *core.ChatCompletionRequest) ([]byte, *apiError) {
+	messagesResult := s.requestProcessor.ProcessMessages(request.Messages)
+	if messagesResult.Error != nil {
+		return nil, errInvalidParameter("messages", messagesResult.Error.Error())
+	}
+
+	toolsResult := s.requestProcessor.ProcessTools(request)
+	if toolsResult.Error != nil {
+		return nil, errInvalidTools()
+	}
+	if apiErr := checkChatCapabilities(modelsConfig, request, messagesResult.JetbrainsMessages); apiErr != nil {
+		return nil, apiErr
+	}
+
+	payloadBytes, err := s.requestProcessor.BuildJetbrainsPayload(modelsConfig, request, messagesResult.JetbrainsMessages, toolsResult.Data)
+	if err != nil {
+		s.config.Logger.Error("Failed to build payload: %v", err)
+		return nil, errInternal()
+	}
+	return payloadBytes, nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) responses(c *gin.Context) {
+	startTime := time.Now()
+	logger := s.config.Logger
+
+	var resp *http.Response
+	defer withPanicRecoveryWithMetrics(c, s.metricsService, startTime, &resp, core.APIFormatOpenAI, logger)()
+	defer trackPerformanceWithMetrics(s.metricsService, startTime)()
+
+	var request core.ResponsesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, "", "")
+		respondWithOpenAIError(c, errInvalidRequestBody())
+		return
+	}
+
+	if request.PreviousResponseID != "" {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("previous_response_id", "previous_response_id is not supported; send the full conversation in input"))
+		return
+	}
+
+	state := s.requestState(c)
+	request.Model = process.ResolveModelAlias(state.modelsConfig, request.Model)
+	modelConfig := getModelConfigOrErrorWithMetrics(c, s.metricsService, state.modelsData, request.Model, startTime, core.APIFormatOpenAI)
+	if modelConfig == nil {
+		return
+	}
+
+	// Phase 1: Build payload — no account needed
+	chatMessages, err := convert.ResponsesToChatMessages(request.Instructions, request.Input)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("input", err.Error()))
+		return
+	}
+	if len(chatMessages) == 0 {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("input", "input cannot be empty"))
+		return
+	}
+	chatMessages, err = convert.ResolveOpenAIImageURLs(c.Request.Context(), chatMessages, s.imageFetcher)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("input", err.Error()))
+		return
+	}
+
+	tools, err := convert.ResponsesToOpenAITools(request.Tools)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("tools", err.Error()))
+		return
+	}
+
+	toolChoice, err := convert.ParseOpenAIToolChoice(request.ToolChoice)
+	if err == nil {
+		tools, err = convert.FilterOpenAITools(tools, toolChoice)
+	}
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("tool_choice", err.Error()))
+		return
+	}
+
+	chatRequest := core.ChatCompletionRequest{
+		Model:       request.Model,
+		Messages:    chatMessages,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		MaxTokens:   request.MaxOutputTokens,
+		Tools:       tools,
+		ToolChoice:  request.ToolChoice,
+	}
+	if request.Reasoning != nil {
+		chatRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+	if _, err := convert.OpenAIReasoningParams(&chatRequest); err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, errInvalidParameter("reasoning.effort", err.Error()))
+		return
+	}
+
+	payloadBytes, apiErr := s.buildChatPayload(state.modelsConfig, &chatRequest)
+	if apiErr != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, apiErr)
+		return
+	}
+
+	// Phase 2: Send with retry on 477 quota exhaustion, then along the model's fallback chain
+	send := func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+		return s.sendWithToolChoice(ctx, endpoint, payloadBytes, toolChoice, logger)
+	}
+	var acct *core.JetbrainsAccount
+	//nolint:bodyclose // resp.Body closed below via defer
+	resp, acct, request.Model, err = s.sendWithFallback(c.Request.Context(), state.modelsConfig, request.Model, payloadBytes, s.chatPayloadBuilder(state.modelsConfig, chatRequest), send, logger)
+	c.Header(core.HeaderServedModel, request.Model)
+	if err != nil {
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, "")
+		respondWithOpenAIError(c, sendError(err))
+		return
+	}
+	defer s.accountManager.ReleaseAccount(acct)
+	defer func() { _ = resp.Body.Close() }()
+
+	accountIdentifier := util.GetTokenDisplayName(acct)
+
+	// Phase 3: Handle upstream response
+	if resp.StatusCode != http.StatusOK {
+		apiErr := upstreamError(resp, logger)
+		recordRequestResultWithMetrics(s.metricsService, false, startTime, request.Model, accountIdentifier)
+		respondWithOpenAIError(c, apiErr)
+		return
+	}
+
+	if request.Stream {
+		handleResponsesStreamingResponseWithMetrics(c, resp, &request, startTime, accountIdentifier, s.metricsService, logger)
+	} else {
+		handleResponsesNonStreamingResponseWithMetrics(c, resp, &request, startTime, accountIdentifier, s.metricsService, logger)
+	}
+}
@@ -34,20 +34,28 @@ func writeSSEDone(w io.Writer) (int, error) {
 }
 
 // respondWithOpenAIError returns OpenAI format error response
-func respondWithOpenAIError(c *gin.Context, code int, message string) {
-	c.JSON(code, gin.H{"error": message})
+func respondWithOpenAIError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, e.openAIBody())
 }
 
 // respondWithAnthropicError returns Anthropic format error response
-func respondWithAnthropicError(c *gin.Context, statusCode int, errorType, message string) {
-	errorResp := gin.H{
-		"type": "error",
-		"error": gin.H{
-			"type":    errorType,
-			"message": message,
-		},
-	}
-	c.JSON(statusCode, errorResp)
+func respondWithAnthropicError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, e.anthropicBody())
+}
+
+// respondWithGeminiError returns Gemini format error response
+func respondWithGeminiError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, e.geminiBody())
+}
+
+// respondWithOllamaError returns Ollama format error response
+func respondWithOllamaError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, e.ollamaBody())
+}
+
+// respondWithAzureError returns Azure OpenAI format error response
+func respondWithAzureError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, e.azureBody())
 }
 
 // trackPerformanceWithMetrics records performance metrics
@@ -77,12 +85,7 @@ func getModelConfigOrErrorWithMetrics(c *gin.Context, m *metrics.MetricsService,
 
 	metrics.RecordFailureWithMetrics(m, startTime, modelName, "")
 
-	if errorFormat == core.APIFormatAnthropic {
-		respondWithAnthropicError(c, http.StatusNotFound, core.AnthropicErrorModelNotFound,
-			fmt.Sprintf("Model %s not found", modelName))
-	} else {
-		respondWithOpenAIError(c, http.StatusNotFound, fmt.Sprintf("Model %s not found", modelName))
-	}
+	respondWithError(c, errorFormat, errModelNotFound(modelName))
 	return nil
 }
 
@@ -105,11 +108,7 @@ func withPanicRecoveryWithMetrics(
 
 			metrics.RecordFailureWithMetrics(m, startTime, "", "")
 
-			if errorFormat == core.APIFormatAnthropic {
-				respondWithAnthropicError(c, http.StatusInternalServerError, core.AnthropicErrorAPI, "internal server error")
-			} else {
-				respondWithOpenAIError(c, http.StatusInternalServerError, "internal server error")
-			}
+			respondWithError(c, errorFormat, errInternal())
 		}
 	}
 }
@@ -118,9 +117,7 @@ func withPanicRecoveryWithMetrics(
 // Returns the response, the account used (caller must release), or an error if all attempts fail.
 //
 // Design note: handler_openai and handler_anthropic share the retry loop via sendWithRetry but
-// intentionally keep format-specific error responses separate (OpenAI uses 2-param, Anthropic uses
-// 3-param error helpers with different JSON shapes). Abstracting further would require callback/
-// interface machinery that adds complexity without meaningful DRY benefit (~15 lines overlap).
+// render its failures themselves, through sendError and the format-specific error helpers.
 func (s *Server) sendWithRetry(ctx context.Context, endpoint string, payloadBytes []byte, logger core.Logger) (*http.Response, *core.JetbrainsAccount, error) {
 	maxRetries := min(s.accountManager.GetAccountCount(), core.MaxUpstreamRetries)
 
@@ -131,7 +128,7 @@ func (s *Server) sendWithRetry(ctx context.Context, endpoint string, payloadByte
 
 		acct, err := s.accountManager.AcquireAccount(ctx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("no available accounts: %w", err)
+			return nil, nil, fmt.Errorf("%w: %w", errNoAccountQuota, err)
 		}
 
 		resp, err := s.requestProcessor.SendUpstreamRequest(ctx, endpoint, payloadBytes, acct)
@@ -149,21 +146,5 @@ func (s *Server) sendWithRetry(ctx context.Context, endpoint string, payloadByte
 		logger.Warn("Account quota exhausted (attempt %d/%d), trying next account", attempt+1, maxRetries)
 	}
 
-	return nil, nil, fmt.Errorf("all accounts quota exhausted after %d attempts", maxRetries)
-}
-
-// extractUpstreamErrorMessage reads the upstream response body and returns an appropriate error message.
-// 4xx responses get the original upstream message (transparent to the client).
-// 5xx responses get a generic message (no internal details leaked).
-func extractUpstreamErrorMessage(resp *http.Response, logger core.Logger) string {
-	body, _ := io.ReadAll(io.LimitReader(resp.Body, core.MaxResponseBodySize))
-	logger.Error("JetBrains API Error: status=%d, body=%s", resp.StatusCode, string(body))
-
-	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-		if len(body) > 0 {
-			return string(body)
-		}
-		return fmt.Sprintf("upstream client error (status %d)", resp.StatusCode)
-	}
-	return "upstream service error"
+	return nil, nil, fmt.Errorf("%w: all accounts quota exhausted after %d attempts", errNoAccountQuota, maxRetries)
 }
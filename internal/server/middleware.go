@@ -94,8 +94,7 @@ func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 		if !s.rateLimiter.allow(ip) {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
-			c.Abort()
+			abortWithError(c, newAPIError(http.StatusTooManyRequests, core.ErrorCodeRateLimitExceeded, "rate limit exceeded"))
 			return
 		}
 		c.Next()
@@ -104,7 +103,7 @@ func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
 
 func (s *Server) isValidClientKey(providedKey string) bool {
 	providedBytes := []byte(providedKey)
-	for validKey := range s.validClientKeys {
+	for validKey := range s.clientKeys() {
 		validBytes := []byte(validKey)
 		if len(providedBytes) == len(validBytes) && subtle.ConstantTimeCompare(providedBytes, validBytes) == 1 {
 			return true
@@ -121,8 +120,8 @@ func (s *Server) corsMiddleware() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", allowOrigin)
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key, x-goog-api-key, api-key")
 		c.Header("Access-Control-Max-Age", core.CORSMaxAge)
 
 		if c.Request.Method == "OPTIONS" {
@@ -135,21 +134,27 @@ func (s *Server) corsMiddleware() gin.HandlerFunc {
 }
 
 func (s *Server) authenticateClient(c *gin.Context) {
-	if len(s.validClientKeys) == 0 {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable: no client API keys configured"})
-		c.Abort()
+	if len(s.clientKeys()) == 0 {
+		abortWithError(c, newAPIError(http.StatusServiceUnavailable, core.ErrorCodeAuthNotConfigured, "Service unavailable: no client API keys configured"))
 		return
 	}
 
 	authHeader := c.GetHeader(core.HeaderAuthorization)
-	apiKey := c.GetHeader(core.HeaderXAPIKey)
+	keyHeader := core.HeaderXAPIKey
+	apiKey := c.GetHeader(keyHeader)
+	for _, header := range []string{core.HeaderXGoogAPIKey, core.HeaderAzureAPIKey} {
+		if apiKey != "" {
+			break
+		}
+		keyHeader = header
+		apiKey = c.GetHeader(keyHeader)
+	}
 
 	if apiKey != "" {
 		if s.isValidClientKey(apiKey) {
 			return
 		}
-		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid client API key (x-api-key)"})
-		c.Abort()
+		abortWithError(c, newAPIError(http.StatusForbidden, core.ErrorCodeInvalidAPIKey, "Invalid client API key ("+keyHeader+")"))
 		return
 	}
 
@@ -158,11 +163,15 @@ func (s *Server) authenticateClient(c *gin.Context) {
 		if s.isValidClientKey(token) {
 			return
 		}
-		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid client API key (Bearer token)"})
-		c.Abort()
+		abortWithError(c, newAPIError(http.StatusForbidden, core.ErrorCodeInvalidAPIKey, "Invalid client API key (Bearer token)"))
 		return
 	}
 
-	c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required in Authorization header (Bearer) or x-api-key header"})
+	abortWithError(c, newAPIError(http.StatusUnauthorized, core.ErrorCodeMissingAPIKey, "API key required in Authorization header (Bearer) or x-api-key header"))
+}
+
+// abortWithError aborts the request with an error in the format of the route being called
+func abortWithError(c *gin.Context, e *apiError) {
+	respondWithError(c, apiFormatForPath(c.Request.URL.Path), e)
 	c.Abort()
 }
@@ -5,18 +5,16 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"jetbrainsai2api/internal/core"
+
 	"github.com/gin-gonic/gin"
 )
 
 func newTestServerForMiddleware(clientKeys []string) *Server {
 	gin.SetMode(gin.TestMode)
-	keyMap := make(map[string]bool)
-	for _, k := range clientKeys {
-		keyMap[k] = true
-	}
-	return &Server{
-		validClientKeys: keyMap,
-	}
+	s := &Server{}
+	s.state.Store(newServerState(core.ModelList{}, core.ModelsConfig{}, clientKeys))
+	return s
 }
 
 func TestAuthenticateClient_ValidBearerToken(t *testing.T) {
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/usage"
+)
+
+// checkModelCapabilities rejects a request that needs something the models.json entry of its
+// model rules out. The messages are already converted, so images are found whatever the client
+// protocol; a reasoning budget comes from an Anthropic thinking block, an effort from OpenAI.
+// promptTokens is only called for a model with a context window, as counting is not free.
+func checkModelCapabilities(modelsConfig core.ModelsConfig, model string, messages []core.JetbrainsMessage, hasTools bool, reasoning core.ReasoningParams, maxTokens *int, promptTokens func() int) *apiError {
+	spec, ok := modelsConfig.Models[model]
+	if !ok {
+		return nil
+	}
+
+	if !spec.SupportsVision() {
+		for _, msg := range messages {
+			if msg.Type == core.JetBrainsMessageTypeMedia {
+				return errInvalidParameter("messages", fmt.Sprintf("model %s does not support image input", model))
+			}
+		}
+	}
+	if hasTools && !spec.SupportsTools() {
+		return errInvalidParameter("tools", fmt.Sprintf("model %s does not support tools", model))
+	}
+	if !spec.SupportsReasoning() {
+		if reasoning.BudgetTokens > 0 {
+			return errInvalidParameter("thinking", fmt.Sprintf("model %s does not support extended thinking", model))
+		}
+		if reasoning.Effort != "" && reasoning.Effort != core.ReasoningEffortNone {
+			return errInvalidParameter("reasoning_effort", fmt.Sprintf("model %s does not support reasoning_effort", model))
+		}
+	}
+	if maxTokens != nil && spec.MaxOutputTokens > 0 && *maxTokens > spec.MaxOutputTokens {
+		return errInvalidParameter("max_tokens", fmt.Sprintf("max_tokens %d exceeds the maximum output of model %s (%d tokens)", *maxTokens, model, spec.MaxOutputTokens))
+	}
+	if spec.ContextWindow > 0 {
+		prompt, output := promptTokens(), 0
+		if maxTokens != nil {
+			output = *maxTokens
+		}
+		if prompt+output > spec.ContextWindow {
+			message := fmt.Sprintf("model %s has a context window of %d tokens, but the request needs %d (%d in the prompt, %d for the output)",
+				model, spec.ContextWindow, prompt+output, prompt, output)
+			return newAPIError(http.StatusBadRequest, core.ErrorCodeContextLengthExceeded, message).withParam("messages")
+		}
+	}
+	return nil
+}
+
+// checkChatCapabilities applies checkModelCapabilities to a chat completion request, which the
+// OpenAI, Responses, Gemini and Ollama handlers all build.
+func checkChatCapabilities(modelsConfig core.ModelsConfig, request *core.ChatCompletionRequest, messages []core.JetbrainsMessage) *apiError {
+	reasoning, _ := convert.OpenAIReasoningParams(request)
+	return checkModelCapabilities(modelsConfig, request.Model, messages, len(request.Tools) > 0, reasoning, request.MaxTokens, func() int {
+		return usage.CountOpenAIRequest(request)
+	})
+}
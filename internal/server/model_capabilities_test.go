@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestCheckModelCapabilities(t *testing.T) {
+	no := false
+	modelsConfig := core.ModelsConfig{
+		Models: map[string]core.ModelSpec{
+			"gpt-4o": {Profile: "openai-gpt-4o"},
+			"text-only": {
+				Profile:           "text-only",
+				MaxOutputTokens:   4096,
+				ModelCapabilities: core.ModelCapabilities{Vision: &no, Tools: &no, Reasoning: &no},
+			},
+			"small-context": {Profile: "small-context", ContextWindow: 10000},
+		},
+	}
+	image := []core.JetbrainsMessage{{Type: core.JetBrainsMessageTypeMedia, MediaType: "image/png", Data: "AAAA"}}
+	text := []core.JetbrainsMessage{{Type: core.JetBrainsMessageTypeUser, Content: "hi"}}
+	small, large := 1024, 8192
+
+	tests := []struct {
+		name      string
+		model     string
+		messages  []core.JetbrainsMessage
+		hasTools  bool
+		reasoning core.ReasoningParams
+		maxTokens *int
+		prompt    int
+		param     string
+		code      string
+	}{
+		{"未声明能力的模型不受限制", "gpt-4o", image, true, core.ReasoningParams{Effort: core.ReasoningEffortHigh}, &large, 0, "", ""},
+		{"纯文本请求通过", "text-only", text, false, core.ReasoningParams{Effort: core.ReasoningEffortNone}, &small, 0, "", ""},
+		{"拒绝图片输入", "text-only", image, false, core.ReasoningParams{}, nil, 0, "messages", core.ErrorCodeInvalidParameter},
+		{"拒绝工具", "text-only", text, true, core.ReasoningParams{}, nil, 0, "tools", core.ErrorCodeInvalidParameter},
+		{"拒绝推理强度", "text-only", text, false, core.ReasoningParams{Effort: core.ReasoningEffortLow}, nil, 0, "reasoning_effort", core.ErrorCodeInvalidParameter},
+		{"拒绝思考预算", "text-only", text, false, core.ReasoningParams{BudgetTokens: 2048}, nil, 0, "thinking", core.ErrorCodeInvalidParameter},
+		{"拒绝超出最大输出", "text-only", text, false, core.ReasoningParams{}, &large, 0, "max_tokens", core.ErrorCodeInvalidParameter},
+		{"未超出上下文窗口", "small-context", text, false, core.ReasoningParams{}, &small, 8976, "", ""},
+		{"提示与输出超出上下文窗口", "small-context", text, false, core.ReasoningParams{}, &small, 8977, "messages", core.ErrorCodeContextLengthExceeded},
+		{"仅提示超出上下文窗口", "small-context", text, false, core.ReasoningParams{}, nil, 10001, "messages", core.ErrorCodeContextLengthExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := checkModelCapabilities(modelsConfig, tt.model, tt.messages, tt.hasTools, tt.reasoning, tt.maxTokens, func() int { return tt.prompt })
+			if tt.param == "" {
+				if apiErr != nil {
+					t.Fatalf("期望通过，实际错误: %+v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil {
+				t.Fatal("期望错误，实际通过")
+			}
+			if apiErr.status != http.StatusBadRequest || apiErr.param != tt.param || apiErr.code != tt.code {
+				t.Errorf("期望 400 且参数为 %s、错误码为 %s，实际 %d %s %s", tt.param, tt.code, apiErr.status, apiErr.param, apiErr.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/process"
+)
+
+// payloadBuilder builds the JetBrains payload of an already converted request for another model.
+type payloadBuilder func(model string) ([]byte, *apiError)
+
+// upstreamSender sends a payload the way the handler does, through sendWithRetry or one of its
+// wrappers.
+type upstreamSender func(ctx context.Context, endpoint string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error)
+
+// sendWithFallback sends the payload built for model and, while the model fails on every
+// account or the upstream answers 5xx, rebuilds and sends the request for each model of its
+// fallback chain in turn. A fallback the request cannot be built for, such as one without
+// vision for a request with images, is skipped. It returns the model that produced the result.
+func (s *Server) sendWithFallback(ctx context.Context, modelsConfig core.ModelsConfig, model string, payloadBytes []byte, build payloadBuilder, send upstreamSender, logger core.Logger) (*http.Response, *core.JetbrainsAccount, string, error) {
+	chain := modelsConfig.Fallbacks[model]
+
+	resp, acct, err := send(ctx, process.ResolveEndpoint(modelsConfig, model), payloadBytes)
+	for _, fallback := range chain {
+		if !shouldFallback(resp, err) {
+			break
+		}
+		fallbackPayload, apiErr := build(fallback)
+		if apiErr != nil {
+			logger.Warn("Skipping fallback model %s for %s: %s", fallback, model, apiErr.message)
+			continue
+		}
+
+		logger.Warn("Model %s failed (%s), falling back to %s", model, fallbackReason(resp, err), fallback)
+		if resp != nil {
+			_ = resp.Body.Close()
+			s.accountManager.ReleaseAccount(acct)
+		}
+		model = fallback
+		resp, acct, err = send(ctx, process.ResolveEndpoint(modelsConfig, model), fallbackPayload)
+	}
+	return resp, acct, model, err
+}
+
+// shouldFallback reports whether a send result means the model is unavailable: every account
+// is out of quota for it, or the upstream failed with a server error.
+func shouldFallback(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, errNoAccountQuota)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+func fallbackReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+// chatPayloadBuilder rebuilds a prepared chat completion request for fallback models.
+func (s *Server) chatPayloadBuilder(modelsConfig core.ModelsConfig, request core.ChatCompletionRequest) payloadBuilder {
+	return func(model string) ([]byte, *apiError) {
+		request.Model = model
+		return s.buildChatPayload(modelsConfig, &request)
+	}
+}
+
+// anthropicPayloadBuilder rebuilds a prepared Messages API request for fallback models.
+func (s *Server) anthropicPayloadBuilder(ctx context.Context, modelsConfig core.ModelsConfig, anthReq core.AnthropicMessagesRequest) payloadBuilder {
+	return func(model string) ([]byte, *apiError) {
+		anthReq.Model = model
+		payloadBytes, _, apiErr := s.prepareAnthropicMessages(ctx, modelsConfig, &anthReq)
+		return payloadBytes, apiErr
+	}
+}
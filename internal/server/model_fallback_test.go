@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+// setTestModelsConfig replaces the models config of a test server, keeping its model list and keys
+func setTestModelsConfig(server *Server, modelsConfig core.ModelsConfig) {
+	state := *server.state.Load()
+	state.modelsConfig = modelsConfig
+	server.state.Store(&state)
+}
+
+func TestSendWithFallback(t *testing.T) {
+	server := newTestServer(t)
+	no := false
+	setTestModelsConfig(server, core.ModelsConfig{
+		Models: map[string]core.ModelSpec{
+			"primary":   {Profile: "openai-primary"},
+			"text-only": {Profile: "openai-text-only", ModelCapabilities: core.ModelCapabilities{Vision: &no}},
+			"secondary": {Profile: "openai-secondary"},
+		},
+		Fallbacks: map[string][]string{"primary": {"text-only", "secondary"}},
+	})
+
+	tests := []struct {
+		name     string
+		results  map[string]any // model profile -> status code or error
+		skip     string
+		expected string
+		status   int
+		wantErr  bool
+		sent     []string
+	}{
+		{"成功时不回退", map[string]any{"openai-primary": http.StatusOK}, "", "primary", http.StatusOK, false, []string{"openai-primary"}},
+		{"5xx 时回退", map[string]any{"openai-primary": http.StatusBadGateway, "openai-text-only": http.StatusOK}, "", "text-only", http.StatusOK, false, []string{"openai-primary", "openai-text-only"}},
+		{"配额耗尽时回退", map[string]any{"openai-primary": errNoAccountQuota, "openai-text-only": http.StatusOK}, "", "text-only", http.StatusOK, false, []string{"openai-primary", "openai-text-only"}},
+		{"跳过无法构建的回退模型", map[string]any{"openai-primary": http.StatusServiceUnavailable, "openai-secondary": http.StatusOK}, "text-only", "secondary", http.StatusOK, false, []string{"openai-primary", "openai-secondary"}},
+		{"4xx 不回退", map[string]any{"openai-primary": http.StatusBadRequest}, "", "primary", http.StatusBadRequest, false, []string{"openai-primary"}},
+		{"回退链耗尽返回最后结果", map[string]any{"openai-primary": http.StatusBadGateway, "openai-text-only": errNoAccountQuota, "openai-secondary": http.StatusInternalServerError}, "", "secondary", http.StatusInternalServerError, false, []string{"openai-primary", "openai-text-only", "openai-secondary"}},
+		{"其他错误不回退", map[string]any{"openai-primary": fmt.Errorf("connection reset")}, "", "primary", 0, true, []string{"openai-primary"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			build := func(model string) ([]byte, *apiError) {
+				if model == tt.skip {
+					return nil, errInvalidParameter("messages", "unsupported")
+				}
+				return []byte(server.state.Load().modelsConfig.Models[model].Profile), nil
+			}
+			send := func(_ context.Context, _ string, payloadBytes []byte) (*http.Response, *core.JetbrainsAccount, error) {
+				sent = append(sent, string(payloadBytes))
+				switch result := tt.results[string(payloadBytes)].(type) {
+				case int:
+					return &http.Response{StatusCode: result, Body: io.NopCloser(strings.NewReader(""))}, nil, nil
+				case error:
+					return nil, nil, result
+				}
+				t.Fatalf("意外的请求: %s", payloadBytes)
+				return nil, nil, nil
+			}
+
+			resp, _, model, err := server.sendWithFallback(context.Background(), server.state.Load().modelsConfig, "primary", []byte("openai-primary"), build, send, &core.NopLogger{})
+			if model != tt.expected {
+				t.Errorf("期望由 %s 响应，实际 %s", tt.expected, model)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("错误不符合预期: %v", err)
+			}
+			if !tt.wantErr && err == nil && resp.StatusCode != tt.status {
+				t.Errorf("期望状态码 %d，实际 %d", tt.status, resp.StatusCode)
+			}
+			if strings.Join(sent, ",") != strings.Join(tt.sent, ",") {
+				t.Errorf("期望依次发送 %v，实际 %v", tt.sent, sent)
+			}
+		})
+	}
+}
+
+func TestChatCompletions_ModelAlias(t *testing.T) {
+	server := newTestServer(t)
+	modelsConfig := server.state.Load().modelsConfig
+	modelsConfig.Aliases = map[string]string{"gpt-*": "gpt-4o"}
+	setTestModelsConfig(server, modelsConfig)
+
+	tests := []struct {
+		name   string
+		model  string
+		status int
+	}{
+		{"通配符别名解析到已配置模型", "gpt-latest", http.StatusBadRequest},
+		{"无匹配的模型", "claude-latest", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An unknown reasoning_effort is rejected after the model lookup, so a 400 proves the alias resolved
+			body := `{"model":"` + tt.model + `","messages":[{"role":"user","content":"hi"}],"reasoning_effort":"turbo"}`
+			w := serveTestRequest(server, http.MethodPost, "/v1/chat/completions", body)
+			if w.Code != tt.status {
+				t.Errorf("期望状态码 %d，实际 %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
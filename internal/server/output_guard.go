@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/usage"
+)
+
+// outputGuard enforces stop sequences and the max_tokens budget on upstream text,
+// since the upstream does not always honour the forwarded sampling parameters.
+// Text that could be the start of a stop sequence is held back until the next chunk decides it.
+type outputGuard struct {
+	stops     []string
+	maxTokens int
+	tokens    int
+	held      string
+
+	reason       string
+	stopSequence string
+}
+
+// newOutputGuard returns nil when the request sets neither stop sequences nor max_tokens.
+func newOutputGuard(params core.SamplingParams) *outputGuard {
+	g := &outputGuard{}
+	for _, stop := range params.Stop {
+		if stop != "" {
+			g.stops = append(g.stops, stop)
+		}
+	}
+	if params.MaxTokens != nil && *params.MaxTokens > 0 {
+		g.maxTokens = *params.MaxTokens
+	}
+	if len(g.stops) == 0 && g.maxTokens == 0 {
+		return nil
+	}
+	return g
+}
+
+// tripped reports whether a stop sequence or the token budget has ended the output.
+func (g *outputGuard) tripped() bool {
+	return g.reason != ""
+}
+
+// push accepts the next text chunk and returns the part that is safe to emit.
+func (g *outputGuard) push(text string) string {
+	if g.tripped() {
+		return ""
+	}
+	buf := g.held + text
+	g.held = ""
+
+	if idx, stop := g.findStop(buf); idx >= 0 {
+		g.reason = core.JetBrainsFinishReasonStopSequence
+		g.stopSequence = stop
+		return g.spend(buf[:idx])
+	}
+	if keep := g.partialStopSuffix(buf); keep > 0 {
+		g.held = buf[len(buf)-keep:]
+		buf = buf[:len(buf)-keep]
+	}
+	return g.spend(buf)
+}
+
+// flush releases held-back text once it is clear no stop sequence follows.
+func (g *outputGuard) flush() string {
+	if g.tripped() {
+		return ""
+	}
+	text := g.held
+	g.held = ""
+	return g.spend(text)
+}
+
+// spend charges text against the token budget, truncating it when the budget runs out.
+func (g *outputGuard) spend(text string) string {
+	if g.maxTokens == 0 || text == "" {
+		return text
+	}
+	n := usage.CountText(text)
+	if g.tokens+n <= g.maxTokens {
+		g.tokens += n
+		return text
+	}
+	remaining := g.maxTokens - g.tokens
+	g.tokens = g.maxTokens
+	g.reason = core.JetBrainsFinishReasonLength
+	g.stopSequence = ""
+	g.held = ""
+	return usage.TruncateText(text, remaining)
+}
+
+// findStop returns the position of the earliest stop sequence in text, or -1.
+func (g *outputGuard) findStop(text string) (int, string) {
+	first, match := -1, ""
+	for _, stop := range g.stops {
+		if idx := strings.Index(text, stop); idx >= 0 && (first < 0 || idx < first) {
+			first, match = idx, stop
+		}
+	}
+	return first, match
+}
+
+// partialStopSuffix returns the length of the longest suffix of text that is a proper prefix of a stop sequence.
+func (g *outputGuard) partialStopSuffix(text string) int {
+	longest := 0
+	for _, stop := range g.stops {
+		for n := min(len(stop)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, stop[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// finishEvent builds the FinishMetadata event reported in place of the upstream's own.
+func (g *outputGuard) finishEvent() map[string]any {
+	event := map[string]any{
+		"type":   core.JetBrainsEventTypeFinishMetadata,
+		"reason": g.reason,
+	}
+	if g.stopSequence != "" {
+		event["stop_sequence"] = g.stopSequence
+	}
+	return event
+}
+
+// processGuardedStream runs ProcessJetbrainsStream with Content events passed through the guard.
+// Once the guard trips, onEvent receives the truncated text and a synthetic FinishMetadata event,
+// and the upstream body is closed without reading the rest of the generation.
+func processGuardedStream(ctx context.Context, body io.ReadCloser, logger core.Logger, guard *outputGuard, onEvent func(event map[string]any) bool) error {
+	if guard == nil {
+		return ProcessJetbrainsStream(ctx, body, logger, onEvent)
+	}
+
+	consumerDone := false
+	emit := func(text string) bool {
+		if text != "" && !onEvent(map[string]any{"type": core.JetBrainsEventTypeContent, "content": text}) {
+			consumerDone = true
+			return false
+		}
+		if guard.tripped() {
+			onEvent(guard.finishEvent())
+			consumerDone = true
+			return false
+		}
+		return true
+	}
+
+	err := ProcessJetbrainsStream(ctx, body, logger, func(data map[string]any) bool {
+		switch eventType, _ := data["type"].(string); eventType {
+		case core.JetBrainsEventTypeContent:
+			content, _ := data["content"].(string)
+			return emit(guard.push(content))
+		case core.JetBrainsEventTypeReasoning:
+			// Thinking is not part of the text the guard watches, and does not release held-back text
+			if !onEvent(data) {
+				consumerDone = true
+				return false
+			}
+			return true
+		}
+		// Held-back text precedes any tool call or finish event
+		if !emit(guard.flush()) {
+			return false
+		}
+		if !onEvent(data) {
+			consumerDone = true
+			return false
+		}
+		return true
+	})
+
+	if guard.tripped() {
+		logger.Debug("Output guard ended stream early: reason=%s, stop_sequence=%q", guard.reason, guard.stopSequence)
+		_ = body.Close()
+		return err
+	}
+	if err == nil && !consumerDone {
+		emit(guard.flush())
+	}
+	return err
+}
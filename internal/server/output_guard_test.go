@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+type closeTrackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestOutputGuard_StopSequences(t *testing.T) {
+	tests := []struct {
+		name         string
+		stops        []string
+		chunks       []string
+		expected     string
+		stopSequence string
+	}{
+		{"单个块内的停止序列", []string{"END"}, []string{"hello END world"}, "hello ", "END"},
+		{"跨块拆分的停止序列", []string{"<stop>"}, []string{"foo <st", "op> bar"}, "foo ", "<stop>"},
+		{"拆成多块的停止序列", []string{"###"}, []string{"a#", "#", "#b"}, "a", "###"},
+		{"取最早出现的停止序列", []string{"b", "a"}, []string{"xxab"}, "xx", "a"},
+		{"部分匹配后未命中", []string{"<stop>"}, []string{"foo <st", "ay>"}, "foo <stay>", ""},
+		{"结尾的部分匹配被释放", []string{"END"}, []string{"the EN"}, "the EN", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := newOutputGuard(core.SamplingParams{Stop: tt.stops})
+			var out strings.Builder
+			for _, chunk := range tt.chunks {
+				out.WriteString(guard.push(chunk))
+			}
+			out.WriteString(guard.flush())
+
+			if out.String() != tt.expected {
+				t.Errorf("期望输出 %q，实际 %q", tt.expected, out.String())
+			}
+			if guard.stopSequence != tt.stopSequence {
+				t.Errorf("期望停止序列 %q，实际 %q", tt.stopSequence, guard.stopSequence)
+			}
+			if guard.tripped() != (tt.stopSequence != "") {
+				t.Errorf("触发状态错误: %v", guard.tripped())
+			}
+		})
+	}
+}
+
+func TestOutputGuard_MaxTokens(t *testing.T) {
+	guard := newOutputGuard(core.SamplingParams{MaxTokens: intPtr(3)})
+	out := guard.push("one two") + guard.push(" three four five")
+
+	if out != "one two three" {
+		t.Errorf("期望截断为 %q，实际 %q", "one two three", out)
+	}
+	if guard.reason != core.JetBrainsFinishReasonLength {
+		t.Errorf("期望原因为 length，实际 %q", guard.reason)
+	}
+	if guard.push("more") != "" {
+		t.Error("触发后不应再输出内容")
+	}
+}
+
+func TestNewOutputGuard_Inactive(t *testing.T) {
+	if newOutputGuard(core.SamplingParams{Stop: []string{""}, MaxTokens: intPtr(0)}) != nil {
+		t.Error("没有停止序列和 max_tokens 时不应创建 guard")
+	}
+}
+
+func TestProcessGuardedStream_ClosesUpstreamOnStop(t *testing.T) {
+	body := &closeTrackingBody{Reader: strings.NewReader(strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"answer: 4\\nUs\"}",
+		"data: {\"type\":\"Content\",\"content\":\"er: next\"}",
+		"data: {\"type\":\"Content\",\"content\":\"never sent\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"",
+	}, "\n"))}
+
+	var events []map[string]any
+	guard := newOutputGuard(core.SamplingParams{Stop: []string{"\nUser:"}})
+	err := processGuardedStream(context.Background(), body, &core.NopLogger{}, guard, func(event map[string]any) bool {
+		events = append(events, event)
+		return true
+	})
+
+	if err != nil {
+		t.Fatalf("不应返回错误: %v", err)
+	}
+	if !body.closed {
+		t.Error("触发停止序列后应关闭上游响应体")
+	}
+	if len(events) != 2 {
+		t.Fatalf("期望2个事件，实际 %d: %v", len(events), events)
+	}
+	if events[0]["content"] != "answer: 4" {
+		t.Errorf("内容应截断在停止序列之前，实际 %q", events[0]["content"])
+	}
+	if events[1]["reason"] != core.JetBrainsFinishReasonStopSequence || events[1]["stop_sequence"] != "\nUser:" {
+		t.Errorf("应生成 stop_sequence 结束事件，实际 %v", events[1])
+	}
+}
+
+func TestProcessGuardedStream_ReasoningKeepsHeldText(t *testing.T) {
+	body := io.NopCloser(strings.NewReader(strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"done\\nUs\"}",
+		"data: {\"type\":\"Reasoning\",\"content\":\"thinking\"}",
+		"data: {\"type\":\"Content\",\"content\":\"er: next\"}",
+		"",
+	}, "\n")))
+
+	var events []map[string]any
+	guard := newOutputGuard(core.SamplingParams{Stop: []string{"\nUser:"}})
+	err := processGuardedStream(context.Background(), body, &core.NopLogger{}, guard, func(event map[string]any) bool {
+		events = append(events, event)
+		return true
+	})
+
+	if err != nil {
+		t.Fatalf("不应返回错误: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("期望3个事件，实际 %d: %v", len(events), events)
+	}
+	if events[0]["content"] != "done" || events[1]["type"] != core.JetBrainsEventTypeReasoning {
+		t.Errorf("推理事件应直接透传且不释放暂存文本，实际 %v", events)
+	}
+	if events[2]["stop_sequence"] != "\nUser:" {
+		t.Errorf("跨推理事件的停止序列仍应生效，实际 %v", events[2])
+	}
+}
+
+func TestHandleNonStreamingResponseWithMetrics_StopSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"1, 2, 3\"}",
+		"data: {\"type\":\"Content\",\"content\":\", 4\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"",
+	}, "\n")
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	request := core.ChatCompletionRequest{Model: "gpt-4o", Stop: []any{", 3"}}
+	handleNonStreamingResponseWithMetrics(c, resp, request, time.Now(), "acc", m, &core.NopLogger{})
+
+	var result core.ChatCompletionResponse
+	if err := sonic.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("响应应为有效 JSON: %v", err)
+	}
+	if result.Choices[0].Message.Content != "1, 2" {
+		t.Errorf("期望内容 %q，实际 %v", "1, 2", result.Choices[0].Message.Content)
+	}
+	if result.Choices[0].FinishReason != core.FinishReasonStop {
+		t.Errorf("期望 finish_reason 为 stop，实际 %s", result.Choices[0].FinishReason)
+	}
+}
+
+func TestHandleAnthropicStreamingResponseWithMetrics_StopSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"Hello wor\"}",
+		"data: {\"type\":\"Content\",\"content\":\"ld, again\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"",
+	}, "\n")
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	anthReq := &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 100, StopSequences: []string{"world"}}
+	handleAnthropicStreamingResponseWithMetrics(c, resp, anthReq, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if strings.Contains(body, "again") || strings.Contains(body, "wor\"") {
+		t.Fatalf("停止序列之后的内容不应输出，实际: %s", body)
+	}
+	if !strings.Contains(body, `"text":"Hello "`) {
+		t.Fatalf("应输出停止序列之前的内容，实际: %s", body)
+	}
+	if !strings.Contains(body, `"stop_reason":"stop_sequence","stop_sequence":"world"`) {
+		t.Fatalf("message_delta 应包含 stop_sequence，实际: %s", body)
+	}
+	if strings.Index(body, "event: message_delta") > strings.Index(body, "event: message_stop") {
+		t.Fatalf("message_delta 应在 message_stop 之前，实际: %s", body)
+	}
+}
+
+func collectGuardedMessage(t *testing.T, anthReq *core.AnthropicMessagesRequest, lines ...string) (*core.AnthropicMessagesResponse, *closeTrackingBody) {
+	t.Helper()
+	body := &closeTrackingBody{Reader: strings.NewReader(strings.Join(lines, "\n") + "\n")}
+	anthResp, apiErr := collectAnthropicMessage(context.Background(), body, anthReq, &core.NopLogger{})
+	if apiErr != nil {
+		t.Fatalf("不应返回错误: %+v", apiErr)
+	}
+	return anthResp, body
+}
+
+func TestCollectAnthropicMessage_MaxTokens(t *testing.T) {
+	anthResp, body := collectGuardedMessage(t, &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 2},
+		"data: {\"type\":\"Content\",\"content\":\"one two three four\"}",
+		"data: {\"type\":\"ToolCall\",\"id\":\"toolu_1\",\"name\":\"noop\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"tool_call\"}",
+	)
+
+	if !body.closed {
+		t.Error("输出守卫触发后应关闭上游响应体")
+	}
+	if len(anthResp.Content) != 1 || anthResp.Content[0].Text != "one two" {
+		t.Fatalf("应截断文本并丢弃之后的内容块，实际 %+v", anthResp.Content)
+	}
+	if anthResp.StopReason != core.StopReasonMaxTokens || anthResp.StopSequence != nil {
+		t.Errorf("期望 stop_reason 为 max_tokens，实际 %s", anthResp.StopReason)
+	}
+	if anthResp.Usage.OutputTokens != 2 {
+		t.Errorf("期望 output_tokens 为 2，实际 %d", anthResp.Usage.OutputTokens)
+	}
+}
+
+func TestCollectAnthropicMessage_StopSequenceAcrossChunks(t *testing.T) {
+	anthReq := &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 2048, StopSequences: []string{"world"}}
+	anthResp, body := collectGuardedMessage(t, anthReq,
+		"data: {\"type\":\"Content\",\"content\":\"Hello wor\"}",
+		"data: {\"type\":\"Content\",\"content\":\"ld again\"}",
+		"data: {\"type\":\"Content\",\"content\":\"never read\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+	)
+
+	if !body.closed {
+		t.Error("触发停止序列后应关闭上游响应体")
+	}
+	if len(anthResp.Content) != 1 || anthResp.Content[0].Text != "Hello " {
+		t.Fatalf("应在跨分块的停止序列处截断，实际 %+v", anthResp.Content)
+	}
+	if anthResp.StopReason != core.StopReasonStopSequence || anthResp.StopSequence == nil || *anthResp.StopSequence != "world" {
+		t.Errorf("期望 stop_reason 为 stop_sequence，实际 %s", anthResp.StopReason)
+	}
+}
+
+func TestCollectAnthropicMessage_UsageCoversRetainedContent(t *testing.T) {
+	anthReq := &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 2048, StopSequences: []string{"STOP"}}
+	anthResp, _ := collectGuardedMessage(t, anthReq,
+		"data: {\"type\":\"Content\",\"content\":\"Checking.\"}",
+		"data: {\"type\":\"ToolCall\",\"id\":\"toolu_1\",\"name\":\"get_weather\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"{\\\"city\\\":\\\"Beijing\\\"}\"}",
+		"data: {\"type\":\"Content\",\"content\":\"It is sunny. STOP and more\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+	)
+
+	if len(anthResp.Content) != 2 || anthResp.Content[0].Text != "Checking.It is sunny. " || anthResp.Content[1].Name != "get_weather" {
+		t.Fatalf("应保留截断点之前的文本和工具调用，实际 %+v", anthResp.Content)
+	}
+	want := usage.CountText("Checking." + "get_weather" + `{"city":"Beijing"}` + "It is sunny. ")
+	if anthResp.Usage.OutputTokens != want {
+		t.Errorf("output_tokens 应覆盖所有保留的内容，期望 %d，实际 %d", want, anthResp.Usage.OutputTokens)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"jetbrainsai2api/internal/config"
+	"jetbrainsai2api/internal/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverStateKey is the gin context key holding the configuration snapshot of a request
+const serverStateKey = "serverState"
+
+// serverState is the part of the server configuration that can be reloaded at runtime. A
+// snapshot is never modified once stored; a reload builds a new one and swaps it in, so a
+// request sees either the old configuration or the new one, never a mix.
+type serverState struct {
+	modelsData   core.ModelList
+	modelsConfig core.ModelsConfig
+	clientKeys   map[string]bool
+}
+
+func newServerState(modelsData core.ModelList, modelsConfig core.ModelsConfig, clientKeys []string) *serverState {
+	keys := make(map[string]bool, len(clientKeys))
+	for _, key := range clientKeys {
+		keys[key] = true
+	}
+	return &serverState{modelsData: modelsData, modelsConfig: modelsConfig, clientKeys: keys}
+}
+
+// requestState returns the configuration snapshot of a request, taking it on first use. Every
+// step of the request, including handlers that delegate to another one, then works with the same
+// models config and model list even if a reload happens meanwhile.
+func (s *Server) requestState(c *gin.Context) *serverState {
+	if state, ok := c.Get(serverStateKey); ok {
+		return state.(*serverState)
+	}
+	state := s.state.Load()
+	c.Set(serverStateKey, state)
+	return state
+}
+
+func (s *Server) clientKeys() map[string]bool {
+	return s.state.Load().clientKeys
+}
+
+// Reload re-reads models.json and the client API keys and applies them when they are valid.
+// An invalid configuration is rejected as a whole and the running one is kept. It returns
+// the applied changes.
+func (s *Server) Reload() ([]string, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	next, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffState(s.state.Load(), next)
+	if len(changes) == 0 {
+		s.config.Logger.Info("Configuration reloaded: no changes")
+		return changes, nil
+	}
+
+	s.state.Store(next)
+	for _, change := range changes {
+		s.config.Logger.Info("Configuration reloaded: %s", change)
+	}
+	return changes, nil
+}
+
+func (s *Server) loadState() (*serverState, error) {
+	modelsData, modelsConfig, err := config.GetModelsConfig(s.config.ModelsConfigPath, s.config.Logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(modelsConfig.Models) == 0 {
+		return nil, fmt.Errorf("%s defines no models", s.config.ModelsConfigPath)
+	}
+
+	clientKeys, err := config.LoadClientAPIKeys(s.config.ClientAPIKeys, s.config.ClientAPIKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client API keys: %w", err)
+	}
+	// Applying an empty key set would lock every client out, including from /reload itself
+	if len(clientKeys) == 0 {
+		return nil, errors.New("no client API keys configured")
+	}
+
+	return newServerState(modelsData, modelsConfig, clientKeys), nil
+}
+
+// diffState describes what changes between two snapshots. Client keys are only counted so
+// that they never end up in the log.
+func diffState(prev, next *serverState) []string {
+	var changes []string
+	changes = append(changes, diffKeys("model", prev.modelsConfig.Models, next.modelsConfig.Models)...)
+	changes = append(changes, diffKeys("parameter rule", prev.modelsConfig.Parameters, next.modelsConfig.Parameters)...)
+	changes = append(changes, diffKeys("deployment", prev.modelsConfig.Deployments, next.modelsConfig.Deployments)...)
+	changes = append(changes, diffKeys("variants of", prev.modelsConfig.Variants, next.modelsConfig.Variants)...)
+	changes = append(changes, diffKeys("alias", prev.modelsConfig.Aliases, next.modelsConfig.Aliases)...)
+	changes = append(changes, diffKeys("fallbacks of", prev.modelsConfig.Fallbacks, next.modelsConfig.Fallbacks)...)
+
+	added, removed := 0, 0
+	for key := range next.clientKeys {
+		if !prev.clientKeys[key] {
+			added++
+		}
+	}
+	for key := range prev.clientKeys {
+		if !next.clientKeys[key] {
+			removed++
+		}
+	}
+	if added > 0 || removed > 0 {
+		changes = append(changes, fmt.Sprintf("client API keys: %d added, %d removed", added, removed))
+	}
+	return changes
+}
+
+// diffKeys lists the entries added to, removed from and changed between two maps, in key order
+func diffKeys[V any](kind string, prev, next map[string]V) []string {
+	keys := make([]string, 0, len(prev)+len(next))
+	for key := range prev {
+		keys = append(keys, key)
+	}
+	for key := range next {
+		if _, ok := prev[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var changes []string
+	for _, key := range keys {
+		prevValue, inPrev := prev[key]
+		nextValue, inNext := next[key]
+		switch {
+		case !inPrev:
+			changes = append(changes, fmt.Sprintf("%s %s added", kind, key))
+		case !inNext:
+			changes = append(changes, fmt.Sprintf("%s %s removed", kind, key))
+		case !reflect.DeepEqual(prevValue, nextValue):
+			changes = append(changes, fmt.Sprintf("%s %s changed", kind, key))
+		}
+	}
+	return changes
+}
+
+// setupConfigReload reloads the configuration on SIGHUP and, unless disabled, whenever
+// models.json or the client keys file changes on disk.
+func (s *Server) setupConfigReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				s.config.Logger.Info("SIGHUP received, reloading configuration...")
+				s.reloadAndLog()
+			case <-s.shutdownCtx.Done():
+				return
+			}
+		}
+	}()
+
+	if s.config.ConfigWatchInterval > 0 {
+		go s.watchConfigFiles(s.config.ConfigWatchInterval)
+	}
+}
+
+// watchConfigFiles polls the modification time and size of the configuration files and
+// reloads when either changes.
+func (s *Server) watchConfigFiles(interval time.Duration) {
+	paths := []string{s.config.ModelsConfigPath}
+	if s.config.ClientAPIKeysFile != "" {
+		paths = append(paths, s.config.ClientAPIKeysFile)
+	}
+	last := configFileStamps(paths)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current := configFileStamps(paths)
+			if slices.Equal(current, last) {
+				continue
+			}
+			last = current
+			s.config.Logger.Info("Configuration files changed, reloading configuration...")
+			s.reloadAndLog()
+		case <-s.shutdownCtx.Done():
+			return
+		}
+	}
+}
+
+// configFileStamps identifies the current version of each file; a missing file has an empty stamp
+func configFileStamps(paths []string) []string {
+	stamps := make([]string, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamps
+}
+
+func (s *Server) reloadAndLog() {
+	if _, err := s.Reload(); err != nil {
+		s.config.Logger.Error("Configuration reload failed, keeping the running configuration: %v", err)
+	}
+}
+
+// reloadConfig handles POST /reload
+func (s *Server) reloadConfig(c *gin.Context) {
+	changes, err := s.Reload()
+	if err != nil {
+		s.config.Logger.Error("Configuration reload failed, keeping the running configuration: %v", err)
+		respondWithOpenAIError(c, newAPIError(http.StatusInternalServerError, core.ErrorCodeInvalidConfig, err.Error()))
+		return
+	}
+	if changes == nil {
+		changes = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded", "changes": changes})
+}
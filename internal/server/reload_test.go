@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantErr  bool
+		expected []string
+	}{
+		{"无变化", `{"models":{"gpt-4o":"openai-gpt-4o"}}`, false, nil},
+		{"新增模型与别名", `{"models":{"gpt-4o":"openai-gpt-4o","o3":"openai-o3"},"aliases":{"latest":"o3"}}`, false,
+			[]string{"model o3 added", "alias latest added"}},
+		{"修改模型", `{"models":{"gpt-4o":"openai-gpt-4o-mini"}}`, false, []string{"model gpt-4o changed"}},
+		{"非法 JSON", `{"models":`, true, nil},
+		{"校验失败", `{"models":{"gpt-4o":"openai-gpt-4o"},"aliases":{"latest":"o3"}}`, true, nil},
+		{"没有模型", `{"models":{}}`, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			if err := os.WriteFile(server.config.ModelsConfigPath, []byte(tt.content), core.FilePermissionReadWrite); err != nil {
+				t.Fatalf("写入临时文件失败: %v", err)
+			}
+			prev := server.state.Load()
+
+			changes, err := server.Reload()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望重载失败")
+				}
+				if server.state.Load() != prev {
+					t.Error("重载失败时应保留原配置")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("不期望错误: %v", err)
+			}
+			if strings.Join(changes, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("期望变更 %v，实际 %v", tt.expected, changes)
+			}
+		})
+	}
+}
+
+func TestRequestState_KeepsSnapshotAcrossReload(t *testing.T) {
+	server := newTestServer(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	state := server.requestState(c)
+	if err := os.WriteFile(server.config.ModelsConfigPath, []byte(`{"models":{"o3":"openai-o3"}}`), core.FilePermissionReadWrite); err != nil {
+		t.Fatalf("写入临时文件失败: %v", err)
+	}
+	if _, err := server.Reload(); err != nil {
+		t.Fatalf("不期望错误: %v", err)
+	}
+
+	if got := server.requestState(c); got != state || got.modelsConfig.Models["gpt-4o"].Profile != "openai-gpt-4o" {
+		t.Errorf("进行中的请求应继续使用旧配置: %v", got.modelsConfig.Models)
+	}
+	if _, ok := server.state.Load().modelsConfig.Models["o3"]; !ok {
+		t.Error("新请求应使用新配置")
+	}
+}
+
+func TestReload_ClientAPIKeysFile(t *testing.T) {
+	server := newTestServer(t)
+	keysPath := writeTempTestFile(t, "keys.txt", []byte("file-key\n"))
+	server.config.ClientAPIKeysFile = keysPath
+
+	changes, err := server.Reload()
+	if err != nil {
+		t.Fatalf("不期望错误: %v", err)
+	}
+	// Keys are only counted so they never reach the log
+	if len(changes) != 1 || changes[0] != "client API keys: 1 added, 0 removed" || strings.Contains(changes[0], "file-key") {
+		t.Errorf("密钥变更描述错误: %v", changes)
+	}
+	if !server.isValidClientKey("file-key") || !server.isValidClientKey("test-key") {
+		t.Error("重载后应接受文件与环境变量中的密钥")
+	}
+
+	if err := os.Remove(keysPath); err != nil {
+		t.Fatalf("删除密钥文件失败: %v", err)
+	}
+	if _, err := server.Reload(); err == nil {
+		t.Error("密钥文件缺失时应重载失败")
+	}
+	if !server.isValidClientKey("file-key") {
+		t.Error("重载失败时应保留原密钥")
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	server := newTestServer(t)
+
+	w := serveTestRequest(server, http.MethodPost, "/reload", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"changes":[]`) {
+		t.Errorf("期望 200 且无变更，实际 %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := os.WriteFile(server.config.ModelsConfigPath, []byte(`{"models":`), core.FilePermissionReadWrite); err != nil {
+		t.Fatalf("写入临时文件失败: %v", err)
+	}
+	w = serveTestRequest(server, http.MethodPost, "/reload", "")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), core.ErrorCodeInvalidConfig) {
+		t.Errorf("期望 500 与 %s，实际 %d: %s", core.ErrorCodeInvalidConfig, w.Code, w.Body.String())
+	}
+}
@@ -1,42 +1,54 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"jetbrainsai2api/internal/convert"
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
 	"jetbrainsai2api/internal/util"
 
+	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
 )
 
-// anthropicStreamWriter manages Anthropic SSE stream state including text blocks and tool use blocks.
+// anthropicStreamWriter manages Anthropic SSE stream state including text, thinking and tool use blocks.
 type anthropicStreamWriter struct {
 	c      *gin.Context
 	logger core.Logger
+	mu     sync.Mutex
 
 	textBlockOpen  bool
 	textBlockIndex int
 	nextBlockIndex int
 
+	thinking struct {
+		index     int
+		open      bool
+		signature string
+	}
+
 	tool struct {
 		id      string
-		name    string
-		rawArgs strings.Builder
-		started bool
-		stopped bool
 		index   int
+		open    bool
+		rawArgs strings.Builder
 	}
+	toolUsed bool
 
 	writeErr error
 }
 
 func (w *anthropicStreamWriter) writeEvent(eventName string, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if _, err := w.c.Writer.Write([]byte("event: " + eventName + "\n")); err != nil {
 		return err
 	}
@@ -47,10 +59,46 @@ func (w *anthropicStreamWriter) writeEvent(eventName string, payload []byte) err
 	return nil
 }
 
+// startPing sends a ping event on every interval until the returned stop function is called,
+// keeping the connection alive while the upstream is slow. stop waits for the pinger to exit.
+func (w *anthropicStreamWriter) startPing(interval time.Duration) (stop func()) {
+	payload := convert.GenerateAnthropicStreamResponse(core.StreamEventTypePing, "", 0)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := w.writeEvent(core.StreamEventTypePing, payload); err != nil {
+					w.logger.Debug("Failed to write ping: %v", err)
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
 func (w *anthropicStreamWriter) startTextBlock() error {
 	if w.textBlockOpen {
 		return nil
 	}
+	if err := w.closeThinkingBlock(); err != nil {
+		return err
+	}
 	w.textBlockIndex = w.nextBlockIndex
 	w.nextBlockIndex++
 	payload := convert.GenerateAnthropicStreamResponse(core.StreamEventTypeContentBlockStart, "", w.textBlockIndex)
@@ -73,18 +121,109 @@ func (w *anthropicStreamWriter) closeTextBlock() error {
 	return nil
 }
 
-func (w *anthropicStreamWriter) startToolBlock() error {
-	if w.tool.started || w.tool.id == "" || w.tool.name == "" {
+// sendThinking forwards an upstream reasoning event as thinking_delta events, opening a thinking
+// block first if needed. The signature is kept until the block closes.
+func (w *anthropicStreamWriter) sendThinking(event map[string]any) error {
+	if text, _ := event["content"].(string); text != "" {
+		if err := w.startThinkingBlock(); err != nil {
+			return err
+		}
+		data, err := util.MarshalJSON(core.AnthropicContentBlockDeltaEvent{
+			Type:  core.StreamEventTypeContentBlockDelta,
+			Index: w.thinking.index,
+			Delta: core.AnthropicThinkingDelta{Type: core.AnthropicDeltaTypeThinking, Thinking: text},
+		})
+		if err != nil {
+			return err
+		}
+		if err := w.writeEvent(core.StreamEventTypeContentBlockDelta, data); err != nil {
+			return err
+		}
+	}
+	if signature, _ := event["signature"].(string); signature != "" && w.thinking.open {
+		w.thinking.signature = signature
+	}
+	return nil
+}
+
+func (w *anthropicStreamWriter) startThinkingBlock() error {
+	if w.thinking.open {
+		return nil
+	}
+	if err := w.closeTextBlock(); err != nil {
+		return err
+	}
+	if err := w.stopToolBlock(); err != nil {
+		return err
+	}
+
+	index := w.nextBlockIndex
+	w.nextBlockIndex++
+	data, err := util.MarshalJSON(core.AnthropicContentBlockStartEvent{
+		Type:         core.StreamEventTypeContentBlockStart,
+		Index:        index,
+		ContentBlock: core.AnthropicThinkingBlockStart{Type: core.ContentBlockTypeThinking},
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.writeEvent(core.StreamEventTypeContentBlockStart, data); err != nil {
+		return err
+	}
+	w.thinking.index = index
+	w.thinking.open = true
+	w.thinking.signature = ""
+	return nil
+}
+
+// closeThinkingBlock sends the signature of the open thinking block, when the upstream gave one,
+// as a signature_delta and closes the block.
+func (w *anthropicStreamWriter) closeThinkingBlock() error {
+	if !w.thinking.open {
 		return nil
 	}
+	if w.thinking.signature != "" {
+		data, err := util.MarshalJSON(core.AnthropicContentBlockDeltaEvent{
+			Type:  core.StreamEventTypeContentBlockDelta,
+			Index: w.thinking.index,
+			Delta: core.AnthropicSignatureDelta{Type: core.AnthropicDeltaTypeSignature, Signature: w.thinking.signature},
+		})
+		if err != nil {
+			return err
+		}
+		if err := w.writeEvent(core.StreamEventTypeContentBlockDelta, data); err != nil {
+			return err
+		}
+	}
+	payload := convert.GenerateAnthropicStreamResponse(core.StreamEventTypeContentBlockStop, "", w.thinking.index)
+	if err := w.writeEvent(core.StreamEventTypeContentBlockStop, payload); err != nil {
+		return err
+	}
+	w.thinking.open = false
+	return nil
+}
 
-	startPayload := core.AnthropicStreamResponse{
+// startToolBlock closes any open block and opens a tool_use block whose input is streamed as it arrives.
+func (w *anthropicStreamWriter) startToolBlock(id, name string) error {
+	if err := w.closeThinkingBlock(); err != nil {
+		return err
+	}
+	if err := w.closeTextBlock(); err != nil {
+		return err
+	}
+	if err := w.stopToolBlock(); err != nil {
+		return err
+	}
+
+	index := w.nextBlockIndex
+	w.nextBlockIndex++
+	startPayload := core.AnthropicContentBlockStartEvent{
 		Type:  core.StreamEventTypeContentBlockStart,
-		Index: &w.tool.index,
-		ContentBlock: &core.AnthropicContentBlock{
+		Index: index,
+		ContentBlock: core.AnthropicToolUseBlockStart{
 			Type:  core.ContentBlockTypeToolUse,
-			ID:    w.tool.id,
-			Name:  w.tool.name,
+			ID:    id,
+			Name:  name,
 			Input: map[string]any{},
 		},
 	}
@@ -98,26 +237,27 @@ func (w *anthropicStreamWriter) startToolBlock() error {
 		return err
 	}
 
-	w.tool.started = true
+	w.tool.id = id
+	w.tool.index = index
+	w.tool.open = true
+	w.tool.rawArgs.Reset()
+	w.toolUsed = true
 	return nil
 }
 
-func (w *anthropicStreamWriter) sendToolInputDelta() error {
-	if !w.tool.started {
+// sendToolInputDelta forwards one upstream argument fragment as an input_json_delta.
+func (w *anthropicStreamWriter) sendToolInputDelta(fragment string) error {
+	if !w.tool.open || fragment == "" {
 		return nil
 	}
-
-	inputJSON := strings.TrimSpace(w.tool.rawArgs.String())
-	if inputJSON == "" {
-		inputJSON = "{}"
-	}
+	w.tool.rawArgs.WriteString(fragment)
 
 	deltaPayload := map[string]any{
 		"type":  core.StreamEventTypeContentBlockDelta,
 		"index": w.tool.index,
 		"delta": map[string]any{
-			"type":         "input_json_delta",
-			"partial_json": inputJSON,
+			"type":         core.AnthropicDeltaTypeInputJSON,
+			"partial_json": fragment,
 		},
 	}
 
@@ -129,11 +269,22 @@ func (w *anthropicStreamWriter) sendToolInputDelta() error {
 	return w.writeEvent(core.StreamEventTypeContentBlockDelta, data)
 }
 
+// stopToolBlock closes the open tool_use block. A tool that received no arguments
+// gets "{}" so the input assembled by the client is always a JSON object.
 func (w *anthropicStreamWriter) stopToolBlock() error {
-	if !w.tool.started || w.tool.stopped {
+	if !w.tool.open {
 		return nil
 	}
 
+	rawArgs := strings.TrimSpace(w.tool.rawArgs.String())
+	if rawArgs == "" {
+		if err := w.sendToolInputDelta("{}"); err != nil {
+			return err
+		}
+	} else if !sonic.ValidString(rawArgs) {
+		w.logger.Warn("Tool call %s arguments are not valid JSON: %s", w.tool.id, rawArgs)
+	}
+
 	stopPayload := core.AnthropicStreamResponse{
 		Type:  core.StreamEventTypeContentBlockStop,
 		Index: &w.tool.index,
@@ -145,79 +296,50 @@ func (w *anthropicStreamWriter) stopToolBlock() error {
 	if err := w.writeEvent(core.StreamEventTypeContentBlockStop, data); err != nil {
 		return err
 	}
-	w.tool.stopped = true
+	w.tool.open = false
 	return nil
 }
 
-func (w *anthropicStreamWriter) flushCurrentTool() error {
-	if w.tool.id == "" {
-		return nil
-	}
-	if err := w.closeTextBlock(); err != nil {
-		return err
-	}
-	if err := w.startToolBlock(); err != nil {
-		return err
-	}
-	if err := w.sendToolInputDelta(); err != nil {
-		return err
-	}
-	if err := w.stopToolBlock(); err != nil {
-		return err
-	}
-	w.tool.id = ""
-	return nil
-}
-
-func (w *anthropicStreamWriter) resetTool(id, name string) {
-	w.tool.id = id
-	w.tool.name = name
-	w.tool.index = w.nextBlockIndex
-	w.nextBlockIndex++
-	w.tool.rawArgs.Reset()
-	w.tool.started = false
-	w.tool.stopped = false
-}
-
-func (w *anthropicStreamWriter) appendToolArgs(delta string) {
-	if delta == "" {
-		return
-	}
-	w.tool.rawArgs.WriteString(delta)
-}
-
-func (w *anthropicStreamWriter) hasToolPending() bool {
-	return w.tool.id != "" && !w.tool.stopped
-}
-
 func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, anthReq *core.AnthropicMessagesRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
 	setStreamingHeaders(c, core.APIFormatAnthropic)
 
 	w := &anthropicStreamWriter{c: c, logger: logger}
 
-	messageStartData := convert.GenerateAnthropicStreamResponse(core.StreamEventTypeMessageStart, "", 0)
+	acc := usage.NewAccumulator(usage.CountAnthropicRequest(anthReq))
+	stopReason := core.StopReasonEndTurn
+	var stopSequence *string
+
+	messageStartData := convert.GenerateAnthropicMessageStart(anthReq.Model, acc.Report().InputTokens)
 	if err := w.writeEvent(core.StreamEventTypeMessageStart, messageStartData); err != nil {
 		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
 		logger.Debug("Failed to write message_start: %v", err)
 		return
 	}
+	if err := w.writeEvent(core.StreamEventTypePing, convert.GenerateAnthropicStreamResponse(core.StreamEventTypePing, "", 0)); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
+		logger.Debug("Failed to write ping: %v", err)
+		return
+	}
+	stopPing := w.startPing(core.AnthropicPingInterval)
+	defer stopPing()
 
 	var fullContent strings.Builder
 	var hasContent bool
+	thinkingEnabled := anthReq.Thinking.Enabled()
 
 	logger.Debug("=== JetBrains Streaming Response Debug ===")
 
 	ctx := c.Request.Context()
-	streamErr := ProcessJetbrainsStream(ctx, resp.Body, logger, func(streamData map[string]any) bool {
+	guard := newOutputGuard(convert.AnthropicSamplingParams(anthReq))
+	streamErr := processGuardedStream(ctx, resp.Body, logger, guard, func(streamData map[string]any) bool {
+		acc.ObserveEvent(streamData)
 		eventType, _ := streamData["type"].(string)
 		switch eventType {
 		case core.JetBrainsEventTypeContent:
-			if w.hasToolPending() {
-				if err := w.flushCurrentTool(); err != nil {
-					logger.Debug("Failed to flush pending tool before text: %v", err)
-					w.writeErr = err
-					return false
-				}
+			if err := w.stopToolBlock(); err != nil {
+				logger.Debug("Failed to close tool block before text: %v", err)
+				w.writeErr = err
+				return false
 			}
 
 			content, _ := streamData["content"].(string)
@@ -240,23 +362,45 @@ func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Resp
 				return false
 			}
 
+		case core.JetBrainsEventTypeReasoning:
+			// Thinking is only shown to clients that asked for it
+			if !thinkingEnabled {
+				return true
+			}
+			if err := w.sendThinking(streamData); err != nil {
+				logger.Debug("Failed to write thinking delta: %v", err)
+				w.writeErr = err
+				return false
+			}
+
 		case core.JetBrainsEventTypeToolCall:
 			if upstreamID, ok := streamData["id"].(string); ok && upstreamID != "" {
 				if toolName, ok := streamData["name"].(string); ok && toolName != "" {
-					if err := w.flushCurrentTool(); err != nil {
-						logger.Debug("Failed to flush previous tool block: %v", err)
+					if err := w.startToolBlock(upstreamID, toolName); err != nil {
+						logger.Debug("Failed to start tool block: %v", err)
 						w.writeErr = err
 						return false
 					}
-					w.resetTool(upstreamID, toolName)
 				}
 			} else if contentPart, ok := streamData["content"].(string); ok {
-				w.appendToolArgs(contentPart)
+				if err := w.sendToolInputDelta(contentPart); err != nil {
+					logger.Debug("Failed to write tool input delta: %v", err)
+					w.writeErr = err
+					return false
+				}
 			}
 
 		case core.JetBrainsEventTypeFinishMetadata:
-			if err := w.flushCurrentTool(); err != nil {
-				logger.Debug("Failed to flush tool block at finish: %v", err)
+			if reason, ok := streamData["reason"].(string); ok && reason != "" {
+				stopReason = convert.MapJetbrainsFinishReason(reason)
+			} else if w.toolUsed {
+				stopReason = core.StopReasonToolUse
+			}
+			if seq, ok := streamData["stop_sequence"].(string); ok && seq != "" {
+				stopSequence = &seq
+			}
+			if err := w.stopToolBlock(); err != nil {
+				logger.Debug("Failed to close tool block at finish: %v", err)
 				w.writeErr = err
 				return false
 			}
@@ -264,21 +408,36 @@ func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Resp
 		return true
 	})
 
+	stopPing()
 	if w.writeErr != nil {
 		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
 		return
 	}
 	if streamErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
 		if ctx.Err() != nil {
 			logger.Debug("Client disconnected during streaming: %v", streamErr)
-		} else {
-			logger.Error("Stream read error: %v", streamErr)
+			return
+		}
+		logger.Error("Stream read error: %v", streamErr)
+		errorData, err := anthropicStreamErrorPayload(streamErr)
+		if err == nil {
+			err = w.writeEvent(core.StreamEventTypeError, errorData)
 		}
+		if err != nil {
+			logger.Debug("Failed to write error event: %v", err)
+		}
+		return
 	}
 
-	if err := w.flushCurrentTool(); err != nil {
+	if err := w.stopToolBlock(); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
+		logger.Debug("Failed to close trailing tool block: %v", err)
+		return
+	}
+	if err := w.closeThinkingBlock(); err != nil {
 		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
-		logger.Debug("Failed to flush trailing tool block: %v", err)
+		logger.Debug("Failed to close trailing thinking block: %v", err)
 		return
 	}
 
@@ -293,6 +452,13 @@ func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Resp
 		return
 	}
 
+	messageDeltaData := convert.GenerateAnthropicMessageDelta(stopReason, stopSequence, acc.Report().OutputTokens)
+	if err := w.writeEvent(core.StreamEventTypeMessageDelta, messageDeltaData); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
+		logger.Debug("Failed to write message_delta: %v", err)
+		return
+	}
+
 	messageStopData := convert.GenerateAnthropicStreamResponse(core.StreamEventTypeMessageStop, "", 0)
 	if err := w.writeEvent(core.StreamEventTypeMessageStop, messageStopData); err != nil {
 		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
@@ -300,7 +466,7 @@ func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Resp
 		return
 	}
 
-	if hasContent || w.tool.started {
+	if hasContent || w.toolUsed {
 		metrics.RecordSuccessWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
 		logger.Debug("Anthropic streaming response completed successfully")
 	} else {
@@ -310,27 +476,55 @@ func handleAnthropicStreamingResponseWithMetrics(c *gin.Context, resp *http.Resp
 }
 
 func handleAnthropicNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, anthReq *core.AnthropicMessagesRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
-	body, err := io.ReadAll(io.LimitReader(resp.Body, core.MaxResponseBodySize))
-	if err != nil {
+	anthResp, apiErr := collectAnthropicMessage(c.Request.Context(), resp.Body, anthReq, logger)
+	if apiErr != nil {
 		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
-		respondWithAnthropicError(c, http.StatusInternalServerError, core.AnthropicErrorAPI,
-			"Failed to read response body")
+		respondWithAnthropicError(c, apiErr)
 		return
 	}
 
-	logger.Debug("JetBrains API Response Body: %s", string(body))
+	metrics.RecordSuccessWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
+	c.JSON(http.StatusOK, anthResp)
+
+	logger.Debug("Anthropic non-streaming response completed successfully: id=%s", anthResp.ID)
+}
 
-	anthResp, err := convert.ParseJetbrainsToAnthropicDirect(body, anthReq.Model, logger)
+// collectAnthropicMessage reads a complete upstream response into an Anthropic message. The output
+// guard runs while the body is read, so once it trips the body is closed without reading the rest
+// of the generation. Shared by the non-streaming handler and message batches.
+func collectAnthropicMessage(ctx context.Context, body io.ReadCloser, anthReq *core.AnthropicMessagesRequest, logger core.Logger) (*core.AnthropicMessagesResponse, *apiError) {
+	b := convert.NewAnthropicMessageBuilder(anthReq.Model, logger)
+	guard := newOutputGuard(convert.AnthropicSamplingParams(anthReq))
+	err := processGuardedStream(ctx, body, logger, guard, func(data map[string]any) bool {
+		b.ObserveEvent(data)
+		return true
+	})
 	if err != nil {
-		metrics.RecordFailureWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
-		logger.Error("Failed to parse response: %v", err)
-		respondWithAnthropicError(c, http.StatusInternalServerError, core.AnthropicErrorAPI,
-			"internal server error")
-		return
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during non-streaming response: %v", err)
+		} else {
+			logger.Error("Stream processing error in non-streaming handler: %v", err)
+		}
+		return nil, newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message)
 	}
 
-	metrics.RecordSuccessWithMetrics(m, startTime, anthReq.Model, accountIdentifier)
-	c.JSON(http.StatusOK, anthResp)
+	anthResp := b.Build()
+	if !anthReq.Thinking.Enabled() {
+		anthResp.Content = withoutThinking(anthResp.Content)
+	}
+	if anthResp.Usage.InputTokens == 0 {
+		anthResp.Usage.InputTokens = usage.CountAnthropicRequest(anthReq)
+	}
+	return anthResp, nil
+}
 
-	logger.Debug("Anthropic non-streaming response completed successfully: id=%s", anthResp.ID)
+// withoutThinking drops the thinking blocks of a response to a request without extended thinking.
+func withoutThinking(content []core.AnthropicContentBlock) []core.AnthropicContentBlock {
+	kept := content[:0]
+	for _, block := range content {
+		if block.Type != core.ContentBlockTypeThinking {
+			kept = append(kept, block)
+		}
+	}
+	return kept
 }
@@ -1 +1,217 @@
 package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sseEventNamePattern = regexp.MustCompile(`(?m)^event: (\S+)$`)
+
+func TestHandleAnthropicStreamingResponseWithMetrics_EventSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		lines          []string
+		expectedEvents []string
+		expectedDelta  string
+	}{
+		{
+			name: "文本响应",
+			lines: []string{
+				"data: {\"type\":\"Content\",\"content\":\"Hello\"}",
+				"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+			},
+			expectedEvents: []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			expectedDelta:  `"delta":{"stop_reason":"end_turn","stop_sequence":null}`,
+		},
+		{
+			name: "工具调用响应",
+			lines: []string{
+				"data: {\"type\":\"ToolCall\",\"id\":\"toolu_1\",\"name\":\"get_weather\"}",
+				"data: {\"type\":\"ToolCall\",\"content\":\"{}\"}",
+				"data: {\"type\":\"FinishMetadata\",\"reason\":\"tool_call\"}",
+			},
+			expectedEvents: []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			expectedDelta:  `"delta":{"stop_reason":"tool_use","stop_sequence":null}`,
+		},
+		{
+			name: "长度截断",
+			lines: []string{
+				"data: {\"type\":\"Content\",\"content\":\"Hel\"}",
+				"data: {\"type\":\"FinishMetadata\",\"reason\":\"length\",\"usage\":{\"output_tokens\":7}}",
+			},
+			expectedEvents: []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			expectedDelta:  `"delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":7}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(strings.Join(tt.lines, "\n") + "\n"))}
+			m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+			defer func() { _ = m.Close() }()
+
+			handleAnthropicStreamingResponseWithMetrics(c, resp, &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 1024}, time.Now(), "acc", m, &core.NopLogger{})
+
+			body := w.Body.String()
+			var events []string
+			for _, match := range sseEventNamePattern.FindAllStringSubmatch(body, -1) {
+				events = append(events, match[1])
+			}
+			if strings.Join(events, ",") != strings.Join(tt.expectedEvents, ",") {
+				t.Fatalf("事件顺序错误\n期望: %v\n实际: %v", tt.expectedEvents, events)
+			}
+			if !strings.Contains(body, tt.expectedDelta) {
+				t.Errorf("message_delta 应包含 %s，实际: %s", tt.expectedDelta, body)
+			}
+		})
+	}
+}
+
+func TestHandleAnthropicStreamingResponseWithMetrics_Thinking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lines := []string{
+		"data: {\"type\":\"Reasoning\",\"content\":\"2+2\"}",
+		"data: {\"type\":\"Reasoning\",\"content\":\" is 4\",\"signature\":\"sig_1\"}",
+		"data: {\"type\":\"Content\",\"content\":\"4\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+	}
+
+	tests := []struct {
+		name           string
+		thinking       *core.AnthropicThinkingConfig
+		expectedEvents []string
+		expectThinking bool
+	}{
+		{
+			name:     "启用 thinking",
+			thinking: &core.AnthropicThinkingConfig{Type: core.ThinkingTypeEnabled, BudgetTokens: 1024},
+			expectedEvents: []string{"message_start", "ping",
+				"content_block_start", "content_block_delta", "content_block_delta", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			expectThinking: true,
+		},
+		{
+			name:           "未启用 thinking",
+			expectedEvents: []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))}
+			m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+			defer func() { _ = m.Close() }()
+
+			anthReq := &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 2048, Thinking: tt.thinking}
+			handleAnthropicStreamingResponseWithMetrics(c, resp, anthReq, time.Now(), "acc", m, &core.NopLogger{})
+
+			body := w.Body.String()
+			var events []string
+			for _, match := range sseEventNamePattern.FindAllStringSubmatch(body, -1) {
+				events = append(events, match[1])
+			}
+			if strings.Join(events, ",") != strings.Join(tt.expectedEvents, ",") {
+				t.Fatalf("事件顺序错误\n期望: %v\n实际: %v", tt.expectedEvents, events)
+			}
+
+			fragments := []string{
+				`"content_block":{"type":"thinking","thinking":"","signature":""}`,
+				`"delta":{"type":"thinking_delta","thinking":"2+2"}`,
+				`"delta":{"type":"signature_delta","signature":"sig_1"}`,
+			}
+			for _, fragment := range fragments {
+				if strings.Contains(body, fragment) != tt.expectThinking {
+					t.Errorf("片段 %s 存在性期望 %v，实际: %s", fragment, tt.expectThinking, body)
+				}
+			}
+			if tt.expectThinking && !strings.Contains(body, `"index":1,"delta":{"type":"text_delta","text":"4"}`) {
+				t.Errorf("文本块应位于 thinking 块之后，实际: %s", body)
+			}
+		})
+	}
+}
+
+func TestCollectAnthropicMessage_Thinking(t *testing.T) {
+	body := strings.Join([]string{
+		"data: {\"type\":\"Reasoning\",\"content\":\"2+2 is 4\",\"signature\":\"sig_1\"}",
+		"data: {\"type\":\"Content\",\"content\":\"4\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+	}, "\n")
+
+	tests := []struct {
+		name           string
+		thinking       *core.AnthropicThinkingConfig
+		expectedBlocks []string
+	}{
+		{"启用 thinking", &core.AnthropicThinkingConfig{Type: core.ThinkingTypeEnabled, BudgetTokens: 1024}, []string{core.ContentBlockTypeThinking, core.ContentBlockTypeText}},
+		{"禁用 thinking", &core.AnthropicThinkingConfig{Type: core.ThinkingTypeDisabled}, []string{core.ContentBlockTypeText}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anthReq := &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 2048, Thinking: tt.thinking}
+			anthResp, apiErr := collectAnthropicMessage(context.Background(), io.NopCloser(strings.NewReader(body)), anthReq, &core.NopLogger{})
+			if apiErr != nil {
+				t.Fatalf("不应返回错误: %+v", apiErr)
+			}
+			var blocks []string
+			for _, block := range anthResp.Content {
+				blocks = append(blocks, block.Type)
+			}
+			if strings.Join(blocks, ",") != strings.Join(tt.expectedBlocks, ",") {
+				t.Errorf("期望内容块 %v，实际 %v", tt.expectedBlocks, blocks)
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamWriter_TextBlockStartIncludesEmptyText(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	writer := &anthropicStreamWriter{c: c, logger: &core.NopLogger{}}
+	if err := writer.startTextBlock(); err != nil {
+		t.Fatalf("写入 content_block_start 失败: %v", err)
+	}
+	if !strings.Contains(w.Body.String(), `"content_block":{"type":"text","text":""}`) {
+		t.Errorf("文本块的 content_block_start 应包含空 text，实际: %s", w.Body.String())
+	}
+}
+
+func TestAnthropicStreamWriter_StartPing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	writer := &anthropicStreamWriter{c: c, logger: &core.NopLogger{}}
+	stop := writer.startPing(5 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	stop()
+	stop()
+
+	writer.mu.Lock()
+	body := w.Body.String()
+	writer.mu.Unlock()
+	if !strings.Contains(body, "event: ping\ndata: {\"type\":\"ping\"}") {
+		t.Errorf("应定期发送 ping 事件，实际: %s", body)
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// completionBuilder assembles a legacy text completion from JetBrains stream events.
+// When emit is set, text deltas are also sent as text_completion chunks; the non-streaming
+// path leaves emit nil and only uses the final response. Tool events are ignored, since
+// completion requests never declare tools.
+type completionBuilder struct {
+	id      string
+	model   string
+	created int64
+	emit    func(chunk core.CompletionResponse) error
+
+	text strings.Builder
+
+	upstreamFinishReason string
+	usage                *usage.Accumulator
+}
+
+func newCompletionBuilder(model string, inputTokens int, emit func(chunk core.CompletionResponse) error) *completionBuilder {
+	return &completionBuilder{
+		id:      core.CompletionIDPrefix + uuid.New().String(),
+		model:   model,
+		created: time.Now().Unix(),
+		emit:    emit,
+		usage:   usage.NewAccumulator(inputTokens),
+	}
+}
+
+func (b *completionBuilder) chunk(text string, finishReason *string) core.CompletionResponse {
+	return core.CompletionResponse{
+		ID:      b.id,
+		Object:  core.TextCompletionObjectType,
+		Created: b.created,
+		Model:   b.model,
+		Choices: []core.CompletionChoice{{Text: text, FinishReason: finishReason}},
+	}
+}
+
+// appendText records generated (or, with echo, prompt) text and streams it
+func (b *completionBuilder) appendText(text string) error {
+	if text == "" {
+		return nil
+	}
+	b.text.WriteString(text)
+	if b.emit == nil {
+		return nil
+	}
+	return b.emit(b.chunk(text, nil))
+}
+
+// handleEvent applies a single JetBrains stream event; returns false once the upstream has finished.
+func (b *completionBuilder) handleEvent(data map[string]any) (bool, error) {
+	b.usage.ObserveEvent(data)
+	eventType, _ := data["type"].(string)
+
+	switch eventType {
+	case core.JetBrainsEventTypeContent:
+		content, _ := data["content"].(string)
+		return true, b.appendText(content)
+	case core.JetBrainsEventTypeFinishMetadata:
+		if reason, ok := data["reason"].(string); ok {
+			b.upstreamFinishReason = reason
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func (b *completionBuilder) finishReason() string {
+	if b.upstreamFinishReason == core.JetBrainsFinishReasonLength {
+		return core.FinishReasonLength
+	}
+	return core.FinishReasonStop
+}
+
+// finish sends the closing chunk with the finish reason and, if requested, the usage chunk.
+func (b *completionBuilder) finish(includeUsage bool) error {
+	if b.emit == nil {
+		return nil
+	}
+	if err := b.emit(b.chunk("", stringPtr(b.finishReason()))); err != nil {
+		return err
+	}
+	if !includeUsage {
+		return nil
+	}
+	report := b.usage.Report().OpenAI()
+	usageChunk := b.chunk("", nil)
+	usageChunk.Choices = []core.CompletionChoice{}
+	usageChunk.Usage = &report
+	return b.emit(usageChunk)
+}
+
+// response returns the complete non-streaming response.
+func (b *completionBuilder) response() core.CompletionResponse {
+	resp := b.chunk(b.text.String(), stringPtr(b.finishReason()))
+	report := b.usage.Report().OpenAI()
+	resp.Usage = &report
+	return resp
+}
+
+// writeCompletionChunk writes a text_completion chunk as an SSE event
+func writeCompletionChunk(c *gin.Context, chunk core.CompletionResponse) error {
+	data, err := util.MarshalJSON(chunk)
+	if err != nil {
+		return err
+	}
+	if _, err := writeSSEData(c.Writer, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func handleCompletionStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, echo string, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	setStreamingHeaders(c, core.APIFormatOpenAI)
+
+	b := newCompletionBuilder(chatReq.Model, usage.CountOpenAIRequest(chatReq), func(chunk core.CompletionResponse) error {
+		return writeCompletionChunk(c, chunk)
+	})
+
+	writeErr := b.appendText(echo)
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	var streamErr error
+	if writeErr == nil {
+		streamErr = processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+			cont, err := b.handleEvent(data)
+			if err != nil {
+				writeErr = err
+				return false
+			}
+			return cont
+		})
+	}
+
+	if writeErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write completion chunk: %v", writeErr)
+		return
+	}
+	if streamErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during streaming: %v", streamErr)
+			return
+		}
+		logger.Error("Stream processing error: %v", streamErr)
+		if err := writeOpenAIStreamError(c.Writer, streamErr); err != nil {
+			logger.Debug("Failed to write stream error chunk: %v", err)
+		}
+		c.Writer.Flush()
+		return
+	}
+
+	includeUsage := chatReq.StreamOptions != nil && chatReq.StreamOptions.IncludeUsage
+	if err := b.finish(includeUsage); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write final completion chunk: %v", err)
+		return
+	}
+	_, _ = writeSSEDone(c.Writer)
+	c.Writer.Flush()
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+}
+
+func handleCompletionNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, echo string, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	b := newCompletionBuilder(chatReq.Model, usage.CountOpenAIRequest(chatReq), nil)
+	_ = b.appendText(echo)
+
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	err := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, _ := b.handleEvent(data)
+		return cont
+	})
+
+	if err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during non-streaming response: %v", err)
+			return
+		}
+		logger.Error("Stream processing error in non-streaming handler: %v", err)
+		respondWithOpenAIError(c, newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message))
+		return
+	}
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+	c.JSON(http.StatusOK, b.response())
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+const completionTestStream = "data: {\"type\":\"Content\",\"content\":\"return a\"}\n" +
+	"data: {\"type\":\"Content\",\"content\":\" + b\"}\n" +
+	"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}\n" +
+	"data: end\n"
+
+func runCompletionHandler(t *testing.T, handle func(c *gin.Context, resp *http.Response, m *metrics.MetricsService)) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/completions", nil)
+
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	handle(c, &http.Response{Body: io.NopCloser(strings.NewReader(completionTestStream))}, m)
+	return w.Body.String()
+}
+
+func TestHandleCompletionStreamingResponseWithMetrics(t *testing.T) {
+	chatReq := &core.ChatCompletionRequest{Model: "gpt-4o", StreamOptions: &core.StreamOptions{IncludeUsage: true}}
+	body := runCompletionHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+		handleCompletionStreamingResponseWithMetrics(c, resp, chatReq, "def add(a, b):\n    ", time.Now(), "acc", m, &core.NopLogger{})
+	})
+
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	if events[len(events)-1] != "data: [DONE]" {
+		t.Fatalf("流应以 [DONE] 结束，实际 %s", body)
+	}
+
+	var chunks []core.CompletionResponse
+	for _, event := range events[:len(events)-1] {
+		var chunk core.CompletionResponse
+		if err := sonic.UnmarshalString(strings.TrimPrefix(event, core.StreamChunkPrefix), &chunk); err != nil {
+			t.Fatalf("每个 SSE 事件应为合法 JSON: %v, 实际: %s", err, event)
+		}
+		if chunk.Object != core.TextCompletionObjectType {
+			t.Errorf("期望 object %s，实际 %s", core.TextCompletionObjectType, chunk.Object)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 5 {
+		t.Fatalf("期望 echo 块、2 个文本块、结束块和用量块，实际 %d: %s", len(chunks), body)
+	}
+	if chunks[0].Choices[0].Text != "def add(a, b):\n    " {
+		t.Errorf("echo 时应先输出 prompt，实际 %q", chunks[0].Choices[0].Text)
+	}
+	if chunks[1].Choices[0].Text != "return a" || chunks[1].Choices[0].FinishReason != nil {
+		t.Errorf("文本块应增量输出且 finish_reason 为 null，实际 %+v", chunks[1].Choices[0])
+	}
+	if reason := chunks[3].Choices[0].FinishReason; reason == nil || *reason != core.FinishReasonStop {
+		t.Errorf("结束块应带 finish_reason stop，实际 %+v", chunks[3].Choices[0])
+	}
+	if len(chunks[4].Choices) != 0 || chunks[4].Usage == nil || chunks[4].Usage.CompletionTokens == 0 {
+		t.Errorf("include_usage 时最后一块应只含用量，实际 %+v", chunks[4])
+	}
+}
+
+func TestHandleCompletionNonStreamingResponseWithMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		echo         string
+		expectedText string
+	}{
+		{"仅补全内容", "", "return a + b"},
+		{"echo 拼接 prompt", "def add(a, b):\n    ", "def add(a, b):\n    return a + b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatReq := &core.ChatCompletionRequest{Model: "gpt-4o"}
+			body := runCompletionHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+				handleCompletionNonStreamingResponseWithMetrics(c, resp, chatReq, tt.echo, time.Now(), "acc", m, &core.NopLogger{})
+			})
+
+			var result core.CompletionResponse
+			if err := sonic.UnmarshalString(body, &result); err != nil {
+				t.Fatalf("响应应为合法 JSON: %v", err)
+			}
+			if !strings.HasPrefix(result.ID, core.CompletionIDPrefix) || result.Object != core.TextCompletionObjectType {
+				t.Errorf("期望 cmpl- ID 和 text_completion 对象，实际 %s %s", result.ID, result.Object)
+			}
+			if result.Choices[0].Text != tt.expectedText {
+				t.Errorf("期望文本 %q，实际 %q", tt.expectedText, result.Choices[0].Text)
+			}
+			if result.Usage == nil || !strings.Contains(body, `"logprobs":null`) {
+				t.Errorf("响应应包含 usage 且 logprobs 为 null，实际 %s", body)
+			}
+		})
+	}
+}
+
+func TestServerRoutes_CompletionsErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"模型不存在", `{"model":"not-exist","prompt":"hi"}`, http.StatusNotFound, `"code":"model_not_found"`},
+		{"n 大于 1", `{"model":"gpt-4o","prompt":"hi","n":2}`, http.StatusBadRequest, `"param":"n"`},
+		{"token 数组 prompt", `{"model":"gpt-4o","prompt":[[1,2,3]]}`, http.StatusBadRequest, `"param":"prompt"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/completions", bytes.NewReader([]byte(tt.body)))
+			req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+			req.Header.Set(core.HeaderAuthorization, core.AuthBearerPrefix+"test-key")
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("期望状态码 %d，实际 %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望包含 %s，实际 %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+// geminiBuilder assembles a Gemini candidate from JetBrains stream events.
+// When emit is set, text deltas and completed function calls are also sent as response chunks;
+// the non-streaming path leaves emit nil and only uses the final response.
+type geminiBuilder struct {
+	model      string
+	responseID string
+	emit       func(chunk core.GeminiGenerateContentResponse) error
+	logger     core.Logger
+
+	parts []core.GeminiPart
+
+	// Gemini function calls carry their arguments as one object, so streamed
+	// argument fragments are buffered until the call is complete.
+	toolOpen bool
+	toolID   string
+	toolName string
+	toolArgs strings.Builder
+
+	upstreamFinishReason string
+	usage                *usage.Accumulator
+}
+
+func newGeminiBuilder(model string, inputTokens int, logger core.Logger, emit func(chunk core.GeminiGenerateContentResponse) error) *geminiBuilder {
+	return &geminiBuilder{
+		model:      model,
+		responseID: util.GenerateRandomID(""),
+		emit:       emit,
+		logger:     logger,
+		usage:      usage.NewAccumulator(inputTokens),
+	}
+}
+
+func (b *geminiBuilder) chunk(parts []core.GeminiPart, finishReason string) core.GeminiGenerateContentResponse {
+	if parts == nil {
+		parts = []core.GeminiPart{}
+	}
+	return core.GeminiGenerateContentResponse{
+		Candidates: []core.GeminiCandidate{{
+			Content:      core.GeminiContent{Role: core.GeminiRoleModel, Parts: parts},
+			FinishReason: finishReason,
+		}},
+		ModelVersion: b.model,
+		ResponseID:   b.responseID,
+	}
+}
+
+func (b *geminiBuilder) send(part core.GeminiPart) error {
+	if b.emit == nil {
+		return nil
+	}
+	return b.emit(b.chunk([]core.GeminiPart{part}, ""))
+}
+
+func (b *geminiBuilder) appendText(delta string) error {
+	if delta == "" {
+		return nil
+	}
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	if last := len(b.parts) - 1; last >= 0 && b.parts[last].FunctionCall == nil {
+		b.parts[last].Text += delta
+	} else {
+		b.parts = append(b.parts, core.GeminiPart{Text: delta})
+	}
+	return b.send(core.GeminiPart{Text: delta})
+}
+
+func (b *geminiBuilder) startTool(id, name string) error {
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	b.toolOpen = true
+	b.toolID = id
+	b.toolName = name
+	b.toolArgs.Reset()
+	return nil
+}
+
+func (b *geminiBuilder) closeTool() error {
+	if !b.toolOpen {
+		return nil
+	}
+	b.toolOpen = false
+
+	args := map[string]any{}
+	if raw := b.toolArgs.String(); raw != "" {
+		if err := sonic.UnmarshalString(raw, &args); err != nil {
+			b.logger.Warn("Tool %s produced invalid JSON arguments, sending empty args: %v", b.toolName, err)
+			args = map[string]any{}
+		}
+	}
+
+	part := core.GeminiPart{FunctionCall: &core.GeminiFunctionCall{ID: b.toolID, Name: b.toolName, Args: args}}
+	b.parts = append(b.parts, part)
+	return b.send(part)
+}
+
+// handleEvent applies a single JetBrains stream event; returns false once the upstream has finished.
+func (b *geminiBuilder) handleEvent(data map[string]any) (bool, error) {
+	b.usage.ObserveEvent(data)
+	eventType, _ := data["type"].(string)
+
+	switch eventType {
+	case core.JetBrainsEventTypeContent:
+		content, _ := data["content"].(string)
+		return true, b.appendText(content)
+	case core.JetBrainsEventTypeToolCall:
+		if upstreamID, ok := data["id"].(string); ok && upstreamID != "" {
+			if name, ok := data["name"].(string); ok && name != "" {
+				return true, b.startTool(upstreamID, name)
+			}
+		} else if content, ok := data["content"].(string); ok && b.toolOpen {
+			b.toolArgs.WriteString(content)
+		}
+	case core.JetBrainsEventTypeFunctionCall:
+		funcName, _ := data["name"].(string)
+		funcArgs, _ := data["content"].(string)
+		if funcName != "" {
+			if err := b.startTool(util.GenerateRandomID(core.ToolCallIDPrefix), funcName); err != nil {
+				return false, err
+			}
+		}
+		if b.toolOpen {
+			b.toolArgs.WriteString(funcArgs)
+		}
+	case core.JetBrainsEventTypeFinishMetadata:
+		if reason, ok := data["reason"].(string); ok {
+			b.upstreamFinishReason = reason
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func (b *geminiBuilder) finishReason() string {
+	if b.upstreamFinishReason == core.JetBrainsFinishReasonLength {
+		return core.GeminiFinishReasonMaxTokens
+	}
+	return core.GeminiFinishReasonStop
+}
+
+// finish completes any pending function call and sends the closing chunk with finish reason and usage.
+func (b *geminiBuilder) finish() error {
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	if b.emit == nil {
+		return nil
+	}
+	final := b.chunk(nil, b.finishReason())
+	final.UsageMetadata = b.usage.Report().Gemini()
+	return b.emit(final)
+}
+
+// response returns the complete non-streaming response.
+func (b *geminiBuilder) response() core.GeminiGenerateContentResponse {
+	resp := b.chunk(b.parts, b.finishReason())
+	resp.UsageMetadata = b.usage.Report().Gemini()
+	return resp
+}
+
+// geminiStreamWriter frames streamed chunks either as SSE (alt=sse) or, as the Gemini API
+// does by default, as the elements of one JSON array.
+type geminiStreamWriter struct {
+	c     *gin.Context
+	sse   bool
+	wrote bool
+}
+
+func (w *geminiStreamWriter) write(v any) error {
+	data, err := util.MarshalJSON(v)
+	if err != nil {
+		return err
+	}
+	if w.sse {
+		_, err = writeSSEData(w.c.Writer, data)
+	} else {
+		separator := ",\r\n"
+		if !w.wrote {
+			separator = "["
+		}
+		_, err = fmt.Fprintf(w.c.Writer, "%s%s", separator, data)
+	}
+	if err != nil {
+		return err
+	}
+	w.wrote = true
+	w.c.Writer.Flush()
+	return nil
+}
+
+// close terminates the JSON array; SSE streams simply end.
+func (w *geminiStreamWriter) close() {
+	if w.sse {
+		return
+	}
+	if !w.wrote {
+		_, _ = fmt.Fprint(w.c.Writer, "[")
+	}
+	_, _ = fmt.Fprint(w.c.Writer, "]")
+	w.c.Writer.Flush()
+}
+
+func handleGeminiStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, sse bool, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	if sse {
+		setStreamingHeaders(c, core.APIFormatGemini)
+	} else {
+		c.Header(core.HeaderContentType, core.ContentTypeJSON)
+	}
+
+	w := &geminiStreamWriter{c: c, sse: sse}
+	defer w.close()
+
+	b := newGeminiBuilder(chatReq.Model, usage.CountOpenAIRequest(chatReq), logger, func(chunk core.GeminiGenerateContentResponse) error {
+		return w.write(chunk)
+	})
+
+	var writeErr error
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	streamErr := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, err := b.handleEvent(data)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		return cont
+	})
+
+	if writeErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write Gemini stream chunk: %v", writeErr)
+		return
+	}
+	if streamErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during streaming: %v", streamErr)
+			return
+		}
+		logger.Error("Stream processing error: %v", streamErr)
+		apiErr := newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(streamErr).message)
+		if err := w.write(apiErr.geminiBody()); err != nil {
+			logger.Debug("Failed to write Gemini stream error: %v", err)
+		}
+		return
+	}
+
+	if err := b.finish(); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write final Gemini chunk: %v", err)
+		return
+	}
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+}
+
+func handleGeminiNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	b := newGeminiBuilder(chatReq.Model, usage.CountOpenAIRequest(chatReq), logger, nil)
+
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	err := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, _ := b.handleEvent(data)
+		return cont
+	})
+
+	if err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during non-streaming response: %v", err)
+			return
+		}
+		logger.Error("Stream processing error in non-streaming handler: %v", err)
+		respondWithGeminiError(c, newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message))
+		return
+	}
+
+	_ = b.finish()
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+	c.JSON(http.StatusOK, b.response())
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+const geminiTestStream = "data: {\"type\":\"Content\",\"content\":\"Let me \"}\n" +
+	"data: {\"type\":\"Content\",\"content\":\"check\"}\n" +
+	"data: {\"type\":\"ToolCall\",\"id\":\"call_abc\",\"name\":\"get_weather\"}\n" +
+	"data: {\"type\":\"ToolCall\",\"content\":\"{\\\"city\\\":\"}\n" +
+	"data: {\"type\":\"ToolCall\",\"content\":\"\\\"Beijing\\\"}\"}\n" +
+	"data: {\"type\":\"FinishMetadata\",\"reason\":\"tool_call\"}\n" +
+	"data: end\n"
+
+func runGeminiHandler(t *testing.T, handle func(c *gin.Context, resp *http.Response, m *metrics.MetricsService)) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-pro:streamGenerateContent", nil)
+
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	handle(c, &http.Response{Body: io.NopCloser(strings.NewReader(geminiTestStream))}, m)
+	return w.Body.String()
+}
+
+func TestHandleGeminiStreamingResponseWithMetrics_SSE(t *testing.T) {
+	chatReq := &core.ChatCompletionRequest{Model: "gemini-2.5-pro"}
+	body := runGeminiHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+		handleGeminiStreamingResponseWithMetrics(c, resp, chatReq, true, time.Now(), "acc", m, &core.NopLogger{})
+	})
+
+	var chunks []core.GeminiGenerateContentResponse
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var chunk core.GeminiGenerateContentResponse
+		if err := sonic.UnmarshalString(strings.TrimPrefix(event, core.StreamChunkPrefix), &chunk); err != nil {
+			t.Fatalf("每个 SSE 事件应为合法 JSON: %v, 实际: %s", err, event)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 4 {
+		t.Fatalf("期望 2 个文本块、1 个函数调用块和 1 个结束块，实际 %d: %s", len(chunks), body)
+	}
+	if chunks[0].Candidates[0].Content.Parts[0].Text != "Let me " || chunks[0].Candidates[0].Content.Role != "model" {
+		t.Errorf("文本块应增量输出，实际 %+v", chunks[0])
+	}
+	call := chunks[2].Candidates[0].Content.Parts[0].FunctionCall
+	if call == nil || call.Name != "get_weather" || call.Args["city"] != "Beijing" {
+		t.Fatalf("函数调用应在参数完整后作为一个部分输出，实际 %+v", chunks[2])
+	}
+	final := chunks[3]
+	if final.Candidates[0].FinishReason != core.GeminiFinishReasonStop || final.UsageMetadata == nil {
+		t.Errorf("结束块应包含 finishReason 和 usageMetadata，实际 %+v", final)
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Errorf("Gemini SSE 不应输出 [DONE]")
+	}
+}
+
+func TestHandleGeminiStreamingResponseWithMetrics_JSONArray(t *testing.T) {
+	chatReq := &core.ChatCompletionRequest{Model: "gemini-2.5-pro"}
+	body := runGeminiHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+		handleGeminiStreamingResponseWithMetrics(c, resp, chatReq, false, time.Now(), "acc", m, &core.NopLogger{})
+	})
+
+	var chunks []core.GeminiGenerateContentResponse
+	if err := sonic.UnmarshalString(body, &chunks); err != nil {
+		t.Fatalf("未指定 alt=sse 时应输出 JSON 数组: %v, 实际: %s", err, body)
+	}
+	if len(chunks) != 4 {
+		t.Errorf("期望 4 个块，实际 %d", len(chunks))
+	}
+}
+
+func TestHandleGeminiNonStreamingResponseWithMetrics(t *testing.T) {
+	maxTokens := 1
+	tests := []struct {
+		name           string
+		chatReq        *core.ChatCompletionRequest
+		expectedParts  int
+		expectedFinish string
+	}{
+		{"文本与函数调用", &core.ChatCompletionRequest{Model: "gemini-2.5-pro"}, 2, core.GeminiFinishReasonStop},
+		{"max_tokens 截断", &core.ChatCompletionRequest{Model: "gemini-2.5-pro", MaxTokens: &maxTokens}, 1, core.GeminiFinishReasonMaxTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := runGeminiHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+				handleGeminiNonStreamingResponseWithMetrics(c, resp, tt.chatReq, time.Now(), "acc", m, &core.NopLogger{})
+			})
+
+			var result core.GeminiGenerateContentResponse
+			if err := sonic.UnmarshalString(body, &result); err != nil {
+				t.Fatalf("响应应为合法 JSON: %v", err)
+			}
+			candidate := result.Candidates[0]
+			if len(candidate.Content.Parts) != tt.expectedParts {
+				t.Fatalf("期望 %d 个部分，实际 %+v", tt.expectedParts, candidate.Content.Parts)
+			}
+			if candidate.FinishReason != tt.expectedFinish {
+				t.Errorf("期望 finishReason %s，实际 %s", tt.expectedFinish, candidate.FinishReason)
+			}
+			if tt.expectedParts == 2 && candidate.Content.Parts[0].Text != "Let me check" {
+				t.Errorf("连续文本应合并为一个部分，实际 %q", candidate.Content.Parts[0].Text)
+			}
+		})
+	}
+}
+
+func TestServerRoutes_GeminiErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		apiKey         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"缺少 key", "/v1beta/models/gpt-4o:generateContent", "", http.StatusUnauthorized, `"status":"UNAUTHENTICATED"`},
+		{"x-goog-api-key 认证且模型不存在", "/v1beta/models/not-exist:generateContent", "test-key", http.StatusNotFound, `{"error":{"code":404,"message":"Model not-exist not found","status":"NOT_FOUND"}}`},
+		{"不支持的方法", "/v1beta/models/gpt-4o:embedContent", "test-key", http.StatusNotFound, `"status":"NOT_FOUND"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(`{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`)
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body))
+			req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+			if tt.apiKey != "" {
+				req.Header.Set(core.HeaderXGoogAPIKey, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("期望状态码 %d，实际 %d", tt.expectedStatus, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望包含 %s，实际 %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+// ollamaBuilder assembles an Ollama /api/chat or /api/generate response from JetBrains stream events.
+// When emit is set, text deltas and completed tool calls are also sent as response lines;
+// the non-streaming path leaves emit nil and only uses the final response.
+type ollamaBuilder struct {
+	model     string
+	generate  bool
+	startTime time.Time
+	emit      func(line any) error
+	logger    core.Logger
+
+	content   strings.Builder
+	toolCalls []core.OllamaToolCall
+	// firstOutput marks the end of prompt evaluation for the reported durations
+	firstOutput time.Time
+
+	// Ollama tool calls carry their arguments as one object, so streamed
+	// argument fragments are buffered until the call is complete.
+	toolOpen bool
+	toolName string
+	toolArgs strings.Builder
+
+	upstreamFinishReason string
+	usage                *usage.Accumulator
+}
+
+func newOllamaBuilder(model string, generate bool, inputTokens int, startTime time.Time, logger core.Logger, emit func(line any) error) *ollamaBuilder {
+	return &ollamaBuilder{
+		model:     model,
+		generate:  generate,
+		startTime: startTime,
+		emit:      emit,
+		logger:    logger,
+		usage:     usage.NewAccumulator(inputTokens),
+	}
+}
+
+// line renders a chat or generate response line; done lines carry the completion statistics
+func (b *ollamaBuilder) line(message core.OllamaMessage, done bool) any {
+	createdAt := time.Now().UTC().Format(time.RFC3339Nano)
+	var stats core.OllamaStats
+	if done {
+		stats = b.stats()
+	}
+	if b.generate {
+		return core.OllamaGenerateResponse{Model: b.model, CreatedAt: createdAt, Response: message.Content, Done: done, OllamaStats: stats}
+	}
+	message.Role = core.RoleAssistant
+	return core.OllamaChatResponse{Model: b.model, CreatedAt: createdAt, Message: message, Done: done, OllamaStats: stats}
+}
+
+func (b *ollamaBuilder) send(message core.OllamaMessage) error {
+	if b.firstOutput.IsZero() {
+		b.firstOutput = time.Now()
+	}
+	if b.emit == nil {
+		return nil
+	}
+	return b.emit(b.line(message, false))
+}
+
+func (b *ollamaBuilder) appendText(delta string) error {
+	if delta == "" {
+		return nil
+	}
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	b.content.WriteString(delta)
+	return b.send(core.OllamaMessage{Content: delta})
+}
+
+func (b *ollamaBuilder) startTool(name string) error {
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	b.toolOpen = true
+	b.toolName = name
+	b.toolArgs.Reset()
+	return nil
+}
+
+func (b *ollamaBuilder) closeTool() error {
+	if !b.toolOpen {
+		return nil
+	}
+	b.toolOpen = false
+
+	args := map[string]any{}
+	if raw := b.toolArgs.String(); raw != "" {
+		if err := sonic.UnmarshalString(raw, &args); err != nil {
+			b.logger.Warn("Tool %s produced invalid JSON arguments, sending empty args: %v", b.toolName, err)
+			args = map[string]any{}
+		}
+	}
+
+	call := core.OllamaToolCall{Function: core.OllamaToolCallFunction{Name: b.toolName, Arguments: args}}
+	b.toolCalls = append(b.toolCalls, call)
+	return b.send(core.OllamaMessage{ToolCalls: []core.OllamaToolCall{call}})
+}
+
+// handleEvent applies a single JetBrains stream event; returns false once the upstream has finished.
+func (b *ollamaBuilder) handleEvent(data map[string]any) (bool, error) {
+	b.usage.ObserveEvent(data)
+	eventType, _ := data["type"].(string)
+
+	switch eventType {
+	case core.JetBrainsEventTypeContent:
+		content, _ := data["content"].(string)
+		return true, b.appendText(content)
+	case core.JetBrainsEventTypeToolCall:
+		if upstreamID, ok := data["id"].(string); ok && upstreamID != "" {
+			if name, ok := data["name"].(string); ok && name != "" {
+				return true, b.startTool(name)
+			}
+		} else if content, ok := data["content"].(string); ok && b.toolOpen {
+			b.toolArgs.WriteString(content)
+		}
+	case core.JetBrainsEventTypeFunctionCall:
+		funcName, _ := data["name"].(string)
+		funcArgs, _ := data["content"].(string)
+		if funcName != "" {
+			if err := b.startTool(funcName); err != nil {
+				return false, err
+			}
+		}
+		if b.toolOpen {
+			b.toolArgs.WriteString(funcArgs)
+		}
+	case core.JetBrainsEventTypeFinishMetadata:
+		if reason, ok := data["reason"].(string); ok {
+			b.upstreamFinishReason = reason
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func (b *ollamaBuilder) doneReason() string {
+	if b.upstreamFinishReason == core.JetBrainsFinishReasonLength {
+		return core.OllamaDoneReasonLength
+	}
+	return core.OllamaDoneReasonStop
+}
+
+func (b *ollamaBuilder) stats() core.OllamaStats {
+	report := b.usage.Report()
+	now := time.Now()
+	firstOutput := b.firstOutput
+	if firstOutput.IsZero() {
+		firstOutput = now
+	}
+	return core.OllamaStats{
+		DoneReason:         b.doneReason(),
+		TotalDuration:      now.Sub(b.startTime).Nanoseconds(),
+		PromptEvalCount:    report.InputTokens,
+		PromptEvalDuration: firstOutput.Sub(b.startTime).Nanoseconds(),
+		EvalCount:          report.OutputTokens,
+		EvalDuration:       now.Sub(firstOutput).Nanoseconds(),
+	}
+}
+
+// finish completes any pending tool call and sends the closing line with done reason and statistics.
+func (b *ollamaBuilder) finish() error {
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+	if b.emit == nil {
+		return nil
+	}
+	return b.emit(b.line(core.OllamaMessage{}, true))
+}
+
+// response returns the complete non-streaming response.
+func (b *ollamaBuilder) response() any {
+	return b.line(core.OllamaMessage{Content: b.content.String(), ToolCalls: b.toolCalls}, true)
+}
+
+// writeNDJSONLine writes one newline-delimited JSON object and flushes it to the client
+func writeNDJSONLine(c *gin.Context, v any) error {
+	data, err := util.MarshalJSON(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "%s\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func handleOllamaStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, generate bool, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	c.Header(core.HeaderContentType, core.ContentTypeNDJSON)
+	c.Header(core.HeaderCacheControl, core.CacheControlNoCache)
+
+	b := newOllamaBuilder(chatReq.Model, generate, usage.CountOpenAIRequest(chatReq), startTime, logger, func(line any) error {
+		return writeNDJSONLine(c, line)
+	})
+
+	var writeErr error
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	streamErr := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, err := b.handleEvent(data)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		return cont
+	})
+
+	if writeErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write Ollama stream line: %v", writeErr)
+		return
+	}
+	if streamErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during streaming: %v", streamErr)
+			return
+		}
+		logger.Error("Stream processing error: %v", streamErr)
+		apiErr := newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(streamErr).message)
+		if err := writeNDJSONLine(c, apiErr.ollamaBody()); err != nil {
+			logger.Debug("Failed to write Ollama stream error: %v", err)
+		}
+		return
+	}
+
+	if err := b.finish(); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		logger.Debug("Failed to write final Ollama line: %v", err)
+		return
+	}
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+}
+
+func handleOllamaNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, chatReq *core.ChatCompletionRequest, generate bool, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	b := newOllamaBuilder(chatReq.Model, generate, usage.CountOpenAIRequest(chatReq), startTime, logger, nil)
+
+	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(chatReq))
+	err := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, _ := b.handleEvent(data)
+		return cont
+	})
+
+	if err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during non-streaming response: %v", err)
+			return
+		}
+		logger.Error("Stream processing error in non-streaming handler: %v", err)
+		respondWithOllamaError(c, newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message))
+		return
+	}
+
+	_ = b.finish()
+
+	metrics.RecordSuccessWithMetrics(m, startTime, chatReq.Model, accountIdentifier)
+	c.JSON(http.StatusOK, b.response())
+}
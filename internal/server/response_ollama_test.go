@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+func runOllamaHandler(t *testing.T, handle func(c *gin.Context, resp *http.Response, m *metrics.MetricsService)) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	// The Gemini test stream covers text deltas followed by a streamed tool call
+	handle(c, &http.Response{Body: io.NopCloser(strings.NewReader(geminiTestStream))}, m)
+	return w
+}
+
+func TestHandleOllamaStreamingResponseWithMetrics_Chat(t *testing.T) {
+	chatReq := &core.ChatCompletionRequest{Model: "gpt-4o"}
+	w := runOllamaHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+		handleOllamaStreamingResponseWithMetrics(c, resp, chatReq, false, time.Now(), "acc", m, &core.NopLogger{})
+	})
+
+	if ct := w.Header().Get(core.HeaderContentType); ct != core.ContentTypeNDJSON {
+		t.Errorf("期望 Content-Type %s，实际 %s", core.ContentTypeNDJSON, ct)
+	}
+
+	var lines []core.OllamaChatResponse
+	for _, raw := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var line core.OllamaChatResponse
+		if err := sonic.UnmarshalString(raw, &line); err != nil {
+			t.Fatalf("每行应为合法 JSON: %v, 实际: %s", err, raw)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 4 {
+		t.Fatalf("期望 2 个文本行、1 个工具调用行和 1 个结束行，实际 %d: %s", len(lines), w.Body.String())
+	}
+	if lines[0].Message.Content != "Let me " || lines[0].Message.Role != core.RoleAssistant || lines[0].Done {
+		t.Errorf("文本应增量输出，实际 %+v", lines[0])
+	}
+	calls := lines[2].Message.ToolCalls
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments["city"] != "Beijing" {
+		t.Fatalf("工具调用应在参数完整后作为一行输出，实际 %+v", lines[2])
+	}
+	final := lines[3]
+	if !final.Done || final.DoneReason != core.OllamaDoneReasonStop || final.EvalCount == 0 || final.TotalDuration == 0 {
+		t.Errorf("结束行应包含 done、done_reason 和统计信息，实际 %+v", final)
+	}
+}
+
+func TestHandleOllamaStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	chatReq := &core.ChatCompletionRequest{Model: "gpt-4o"}
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleOllamaStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, chatReq, true, time.Now(), "acc", m, &core.NopLogger{})
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if !strings.Contains(lines[0], `"response":"partial"`) {
+		t.Errorf("中断前的内容应已输出，实际 %s", lines[0])
+	}
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, `{"error":`) {
+		t.Errorf("中断时应输出 Ollama 错误行，实际 %s", last)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleOllamaNonStreamingResponseWithMetrics_Generate(t *testing.T) {
+	maxTokens := 1
+	chatReq := &core.ChatCompletionRequest{Model: "gpt-4o", MaxTokens: &maxTokens}
+	w := runOllamaHandler(t, func(c *gin.Context, resp *http.Response, m *metrics.MetricsService) {
+		handleOllamaNonStreamingResponseWithMetrics(c, resp, chatReq, true, time.Now(), "acc", m, &core.NopLogger{})
+	})
+
+	var result core.OllamaGenerateResponse
+	if err := sonic.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("响应应为合法 JSON: %v", err)
+	}
+	if !result.Done || result.DoneReason != core.OllamaDoneReasonLength {
+		t.Errorf("max_tokens 截断时 done_reason 应为 length，实际 %+v", result)
+	}
+	if result.Response == "" {
+		t.Errorf("generate 响应应包含 response 文本")
+	}
+}
+
+func TestServerRoutes_Ollama(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		apiKey         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"tags 列出模型", http.MethodGet, "/api/tags", "", "test-key", http.StatusOK, `"name":"gpt-4o"`},
+		{"show 接受 latest 标签", http.MethodPost, "/api/show", `{"model":"gpt-4o:latest"}`, "test-key", http.StatusOK, `"capabilities":["completion","tools"]`},
+		{"show 兼容 name 字段", http.MethodPost, "/api/show", `{"name":"gpt-4o"}`, "test-key", http.StatusOK, `"details"`},
+		{"show 模型不存在", http.MethodPost, "/api/show", `{"model":"not-exist"}`, "test-key", http.StatusNotFound, `{"error":"Model not-exist not found"}`},
+		{"chat 模型不存在", http.MethodPost, "/api/chat", `{"model":"not-exist","messages":[{"role":"user","content":"hi"}]}`, "test-key", http.StatusNotFound, `{"error":"Model not-exist not found"}`},
+		{"generate 请求体无效", http.MethodPost, "/api/generate", `{`, "test-key", http.StatusBadRequest, `{"error":"invalid request body"}`},
+		{"缺少 key", http.MethodGet, "/api/tags", "", "", http.StatusUnauthorized, `{"error":`},
+		{"stats 仍为公开路由", http.MethodGet, "/api/stats", "", "", http.StatusOK, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader([]byte(tt.body)))
+			req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+			if tt.apiKey != "" {
+				req.Header.Set(core.HeaderAuthorization, core.AuthBearerPrefix+tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("期望状态码 %d，实际 %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("期望包含 %s，实际 %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
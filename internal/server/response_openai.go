@@ -9,8 +9,10 @@ import (
 	"strings"
 	"time"
 
+	"jetbrainsai2api/internal/convert"
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
 	"jetbrainsai2api/internal/util"
 	"jetbrainsai2api/internal/validate"
 
@@ -26,7 +28,7 @@ func MapJetbrainsToOpenAIFinishReason(jetbrainsReason string) string {
 		return core.FinishReasonToolCalls
 	case core.JetBrainsFinishReasonLength:
 		return core.FinishReasonLength
-	case core.JetBrainsFinishReasonStop:
+	case core.JetBrainsFinishReasonStop, core.JetBrainsFinishReasonStopSequence:
 		return core.FinishReasonStop
 	default:
 		return core.FinishReasonStop
@@ -82,9 +84,17 @@ type openaiStreamFinisher struct {
 	streamID       string
 	model          string
 	firstChunkSent *bool
+	usage          *usage.Accumulator
+	includeUsage   bool
+	// responseFormat and content let the finisher flag output that does not conform to a JSON response_format
+	responseFormat *core.ResponseFormat
+	content        *strings.Builder
 }
 
 func (f *openaiStreamFinisher) sendToolCallsAndFinish(toolCalls []any, finishReason string) {
+	if len(toolCalls) == 0 && f.responseFormat.IsJSON() {
+		f.flagNonConformingOutput()
+	}
 	if len(toolCalls) > 0 {
 		delta := core.StreamDelta{
 			ToolCalls: toolCalls,
@@ -122,10 +132,59 @@ func (f *openaiStreamFinisher) sendToolCallsAndFinish(toolCalls []any, finishRea
 	} else {
 		_, _ = writeSSEData(f.writer, respJSON)
 	}
+	if f.includeUsage {
+		f.sendUsage()
+	}
 	_, _ = writeSSEDone(f.writer)
 	f.writer.Flush()
 }
 
+// flagNonConformingOutput checks the streamed text against the JSON response_format once it is
+// complete; the text has already been sent, so a failure is reported as a refusal delta.
+func (f *openaiStreamFinisher) flagNonConformingOutput() {
+	_, refusal := structuredOutputRefusal(f.content.String(), f.responseFormat, f.logger)
+	if refusal == nil {
+		return
+	}
+	delta := core.StreamDelta{Refusal: refusal}
+	if !*f.firstChunkSent {
+		delta.Role = core.RoleAssistant
+		*f.firstChunkSent = true
+	}
+	streamResp := core.StreamResponse{
+		ID:      f.streamID,
+		Object:  core.ChatCompletionChunkObjectType,
+		Created: time.Now().Unix(),
+		Model:   f.model,
+		Choices: []core.StreamChoice{{Delta: delta}},
+	}
+	respJSON, err := util.MarshalJSON(streamResp)
+	if err != nil {
+		f.logger.Warn("Failed to marshal refusal response: %v", err)
+		return
+	}
+	_, _ = writeSSEData(f.writer, respJSON)
+}
+
+// sendUsage writes the stream_options.include_usage chunk, which carries usage and no choices.
+func (f *openaiStreamFinisher) sendUsage() {
+	report := f.usage.Report().OpenAI()
+	usageResp := core.StreamResponse{
+		ID:      f.streamID,
+		Object:  core.ChatCompletionChunkObjectType,
+		Created: time.Now().Unix(),
+		Model:   f.model,
+		Choices: []core.StreamChoice{},
+		Usage:   &report,
+	}
+	respJSON, err := util.MarshalJSON(usageResp)
+	if err != nil {
+		f.logger.Warn("Failed to marshal usage response: %v", err)
+		return
+	}
+	_, _ = writeSSEData(f.writer, respJSON)
+}
+
 func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, request core.ChatCompletionRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
 	setStreamingHeaders(c, core.APIFormatOpenAI)
 
@@ -135,6 +194,8 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 	var currentTool map[string]any
 	var toolCalls []any
 	streamFinished := false
+	acc := usage.NewAccumulator(usage.CountOpenAIRequest(&request))
+	var contentBuilder strings.Builder
 
 	finisher := &openaiStreamFinisher{
 		writer:         c.Writer,
@@ -142,6 +203,10 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 		streamID:       streamID,
 		model:          request.Model,
 		firstChunkSent: &firstChunkSent,
+		usage:          acc,
+		includeUsage:   request.StreamOptions != nil && request.StreamOptions.IncludeUsage,
+		responseFormat: request.ResponseFormat,
+		content:        &contentBuilder,
 	}
 
 	finalizeCurrentTool := func() {
@@ -163,8 +228,10 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 	}
 
 	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(&request))
 
-	err := ProcessJetbrainsStream(ctx, resp.Body, logger, func(data map[string]any) bool {
+	err := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		acc.ObserveEvent(data)
 		eventType, _ := data["type"].(string)
 
 		switch eventType {
@@ -173,6 +240,7 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 			if content == "" {
 				return true
 			}
+			contentBuilder.WriteString(content)
 
 			var delta core.StreamDelta
 			if !firstChunkSent {
@@ -202,6 +270,32 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 			}
 			_, _ = writeSSEData(c.Writer, respJSON)
 			c.Writer.Flush()
+		case core.JetBrainsEventTypeReasoning:
+			reasoning, _ := data["content"].(string)
+			if reasoning == "" {
+				return true
+			}
+
+			delta := core.StreamDelta{ReasoningContent: &reasoning}
+			if !firstChunkSent {
+				delta.Role = core.RoleAssistant
+				firstChunkSent = true
+			}
+			streamResp := core.StreamResponse{
+				ID:      streamID,
+				Object:  core.ChatCompletionChunkObjectType,
+				Created: created,
+				Model:   request.Model,
+				Choices: []core.StreamChoice{{Delta: delta}},
+			}
+
+			respJSON, err := util.MarshalJSON(streamResp)
+			if err != nil {
+				logger.Warn("Failed to marshal reasoning response: %v", err)
+				return true
+			}
+			_, _ = writeSSEData(c.Writer, respJSON)
+			c.Writer.Flush()
 		case core.JetBrainsEventTypeToolCall:
 			if upstreamID, ok := data["id"].(string); ok && upstreamID != "" {
 				finalizeCurrentTool()
@@ -277,6 +371,10 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 			logger.Debug("Client disconnected during streaming: %v", err)
 		} else {
 			logger.Error("Stream processing error: %v", err)
+			if writeErr := writeOpenAIStreamError(c.Writer, err); writeErr != nil {
+				logger.Debug("Failed to write stream error chunk: %v", writeErr)
+			}
+			c.Writer.Flush()
 		}
 	}
 
@@ -295,11 +393,33 @@ func handleStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, req
 }
 
 func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, request core.ChatCompletionRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	ctx := c.Request.Context()
+	response, err := collectChatCompletion(ctx, resp.Body, request, logger)
+	if err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, request.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during non-streaming response: %v", err)
+			return
+		}
+		logger.Error("Stream processing error in non-streaming handler: %v", err)
+		respondWithOpenAIError(c, newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, classifyStreamError(err).message))
+		return
+	}
+
+	metrics.RecordSuccessWithMetrics(m, startTime, request.Model, accountIdentifier)
+	c.JSON(http.StatusOK, response)
+}
+
+// collectChatCompletion reads a JetBrains event stream into a chat completion. If the stream
+// fails, the completion built so far is returned together with the error.
+func collectChatCompletion(ctx context.Context, body io.ReadCloser, request core.ChatCompletionRequest, logger core.Logger) (core.ChatCompletionResponse, error) {
 	var contentBuilder strings.Builder
+	var reasoningBuilder strings.Builder
 	var toolCalls []core.ToolCall
 	var currentFuncName string
 	var currentFuncArgs string
 	var upstreamFinishReason string
+	acc := usage.NewAccumulator(usage.CountOpenAIRequest(&request))
 
 	finalizeLegacyFunctionCall := func(reason string) {
 		if currentFuncName == "" {
@@ -319,9 +439,10 @@ func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response,
 		currentFuncArgs = ""
 	}
 
-	ctx := c.Request.Context()
+	guard := newOutputGuard(convert.OpenAISamplingParams(&request))
 
-	err := ProcessJetbrainsStream(ctx, resp.Body, logger, func(data map[string]any) bool {
+	err := processGuardedStream(ctx, body, logger, guard, func(data map[string]any) bool {
+		acc.ObserveEvent(data)
 		eventType, _ := data["type"].(string)
 
 		switch eventType {
@@ -329,6 +450,10 @@ func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response,
 			if content, ok := data["content"].(string); ok {
 				contentBuilder.WriteString(content)
 			}
+		case core.JetBrainsEventTypeReasoning:
+			if reasoning, ok := data["content"].(string); ok {
+				reasoningBuilder.WriteString(reasoning)
+			}
 		case core.JetBrainsEventTypeToolCall:
 			if upstreamID, ok := data["id"].(string); ok && upstreamID != "" {
 				finalizeLegacyFunctionCall("switch_to_tool_call")
@@ -381,14 +506,6 @@ func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response,
 		return true
 	})
 
-	if err != nil {
-		if ctx.Err() != nil {
-			logger.Debug("Client disconnected during non-streaming response: %v", err)
-		} else {
-			logger.Error("Stream processing error in non-streaming handler: %v", err)
-		}
-	}
-
 	if currentFuncName != "" {
 		finalizeLegacyFunctionCall("missing_finish_metadata")
 	}
@@ -401,8 +518,17 @@ func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response,
 	}
 
 	message := core.ChatMessage{
-		Role:    core.RoleAssistant,
-		Content: contentBuilder.String(),
+		Role:             core.RoleAssistant,
+		Content:          contentBuilder.String(),
+		ReasoningContent: reasoningBuilder.String(),
+	}
+	if request.ResponseFormat.IsJSON() && len(toolCalls) == 0 && err == nil {
+		content, refusal := structuredOutputRefusal(contentBuilder.String(), request.ResponseFormat, logger)
+		message.Content = content
+		if refusal != nil {
+			message.Content = nil
+			message.Refusal = refusal
+		}
 	}
 
 	finishReason := core.FinishReasonStop
@@ -426,10 +552,9 @@ func handleNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response,
 			Index:        0,
 			FinishReason: finishReason,
 		}},
+		Usage: acc.Report().OpenAI(),
 	}
-
-	m.RecordRequest(err == nil, time.Since(startTime).Milliseconds(), request.Model, accountIdentifier)
-	c.JSON(http.StatusOK, response)
+	return response, err
 }
 
 func stringPtr(s string) *string {
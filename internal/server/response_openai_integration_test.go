@@ -196,3 +196,130 @@ func TestHandleNonStreamingResponseWithMetrics_FunctionCallWithoutFinishMetadata
 		t.Fatalf("缺失 FinishMetadata 时也应保留 legacy function call，实际: %s", body)
 	}
 }
+
+func TestHandleStreamingResponseWithMetrics_IncludeUsage(t *testing.T) {
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"hello\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\",\"usage\":{\"promptTokens\":11,\"completionTokens\":1}}",
+		"data: end",
+		"",
+	}, "\n")
+
+	tests := []struct {
+		name          string
+		streamOptions *core.StreamOptions
+		expectUsage   bool
+	}{
+		{"未设置 stream_options", nil, false},
+		{"include_usage 为 true", &core.StreamOptions{IncludeUsage: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+			m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+			defer func() { _ = m.Close() }()
+
+			request := core.ChatCompletionRequest{Model: "gpt-4o", Stream: true, StreamOptions: tt.streamOptions}
+			handleStreamingResponseWithMetrics(c, resp, request, time.Now(), "acc", m, &core.NopLogger{})
+
+			body := w.Body.String()
+			usageChunk := `"usage":{"prompt_tokens":11,"completion_tokens":1,"total_tokens":12}`
+			if got := strings.Contains(body, usageChunk); got != tt.expectUsage {
+				t.Fatalf("usage 块存在性期望 %v，实际 %v: %s", tt.expectUsage, got, body)
+			}
+			if tt.expectUsage && strings.Index(body, usageChunk) > strings.Index(body, "[DONE]") {
+				t.Errorf("usage 块应在 [DONE] 之前，实际: %s", body)
+			}
+		})
+	}
+}
+
+func TestHandleNonStreamingResponseWithMetrics_Usage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"hello world\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"data: end",
+		"",
+	}, "\n")
+
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	request := core.ChatCompletionRequest{Model: "gpt-4o", Messages: []core.ChatMessage{{Role: core.RoleUser, Content: "hi"}}}
+	handleNonStreamingResponseWithMetrics(c, resp, request, time.Now(), "acc", m, &core.NopLogger{})
+
+	var result core.ChatCompletionResponse
+	if err := sonic.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("响应不是有效 JSON: %v", err)
+	}
+	if result.Usage.PromptTokens == 0 || result.Usage.CompletionTokens != 2 {
+		t.Errorf("用量应由本地分词器计算，实际: %+v", result.Usage)
+	}
+	if result.Usage.TotalTokens != result.Usage.PromptTokens+result.Usage.CompletionTokens {
+		t.Errorf("total_tokens 应为两者之和，实际: %+v", result.Usage)
+	}
+}
+
+func TestHandleResponseWithMetrics_ReasoningContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Reasoning\",\"content\":\"2+2\"}",
+		"data: {\"type\":\"Reasoning\",\"content\":\" is 4\"}",
+		"data: {\"type\":\"Content\",\"content\":\"4\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"data: end",
+		"",
+	}, "\n")
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+	request := core.ChatCompletionRequest{Model: "gpt-4o", Messages: []core.ChatMessage{{Role: core.RoleUser, Content: "2+2?"}}}
+
+	t.Run("非流式", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+		resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+		handleNonStreamingResponseWithMetrics(c, resp, request, time.Now(), "acc", m, &core.NopLogger{})
+
+		var result core.ChatCompletionResponse
+		if err := sonic.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("响应不是有效 JSON: %v", err)
+		}
+		message := result.Choices[0].Message
+		if message.ReasoningContent != "2+2 is 4" || message.Content != "4" {
+			t.Errorf("期望 reasoning_content 与 content 分开，实际: %+v", message)
+		}
+	})
+
+	t.Run("流式", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+		resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+		streamRequest := request
+		streamRequest.Stream = true
+		handleStreamingResponseWithMetrics(c, resp, streamRequest, time.Now(), "acc", m, &core.NopLogger{})
+
+		body := w.Body.String()
+		first := strings.Index(body, `"reasoning_content":"2+2"`)
+		content := strings.Index(body, `"content":"4"`)
+		if first < 0 || !strings.Contains(body, `"reasoning_content":" is 4"`) || content < first {
+			t.Errorf("reasoning_content 增量应先于 content 发送，实际: %s", body)
+		}
+		if !strings.Contains(body, `"role":"assistant","reasoning_content"`) {
+			t.Errorf("首个推理增量应携带 role，实际: %s", body)
+		}
+	})
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/convert"
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/usage"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responsesBuilder assembles Responses API output items from JetBrains stream events.
+// When emit is set, every state transition is also written as a Responses SSE event;
+// the non-streaming path leaves emit nil and only uses the final response.
+type responsesBuilder struct {
+	response core.ResponsesResponse
+	emit     func(event core.ResponsesStreamEvent) error
+	seq      int
+
+	textIndex int
+	text      strings.Builder
+	toolIndex int
+
+	upstreamFinishReason string
+	usage                *usage.Accumulator
+}
+
+func newResponsesBuilder(model string, inputTokens int, emit func(event core.ResponsesStreamEvent) error) *responsesBuilder {
+	return &responsesBuilder{
+		response: core.ResponsesResponse{
+			ID:        util.GenerateRandomID(core.ResponsesIDPrefix),
+			Object:    core.ResponseObjectType,
+			CreatedAt: time.Now().Unix(),
+			Status:    core.ResponsesStatusInProgress,
+			Model:     model,
+			Output:    []core.ResponsesOutputItem{},
+		},
+		emit:      emit,
+		textIndex: -1,
+		toolIndex: -1,
+		usage:     usage.NewAccumulator(inputTokens),
+	}
+}
+
+// countResponsesInputTokens counts the request through its chat completion equivalent.
+func countResponsesInputTokens(request *core.ResponsesRequest) int {
+	messages, err := convert.ResponsesToChatMessages(request.Instructions, request.Input)
+	if err != nil {
+		return 0
+	}
+	chatReq := core.ChatCompletionRequest{Model: request.Model, Messages: messages}
+	if tools, err := convert.ResponsesToOpenAITools(request.Tools); err == nil {
+		chatReq.Tools = tools
+	}
+	return usage.CountOpenAIRequest(&chatReq)
+}
+
+func (b *responsesBuilder) send(event core.ResponsesStreamEvent) error {
+	if b.emit == nil {
+		return nil
+	}
+	event.SequenceNumber = b.seq
+	b.seq++
+	return b.emit(event)
+}
+
+func (b *responsesBuilder) snapshot() *core.ResponsesResponse {
+	snap := b.response
+	snap.Output = append([]core.ResponsesOutputItem(nil), b.response.Output...)
+	if snap.Output == nil {
+		snap.Output = []core.ResponsesOutputItem{}
+	}
+	return &snap
+}
+
+func (b *responsesBuilder) start() error {
+	if err := b.send(core.ResponsesStreamEvent{Type: core.ResponsesEventCreated, Response: b.snapshot()}); err != nil {
+		return err
+	}
+	return b.send(core.ResponsesStreamEvent{Type: core.ResponsesEventInProgress, Response: b.snapshot()})
+}
+
+func (b *responsesBuilder) appendText(delta string) error {
+	if delta == "" {
+		return nil
+	}
+	if b.textIndex < 0 {
+		if err := b.closeTool(); err != nil {
+			return err
+		}
+		b.response.Output = append(b.response.Output, core.ResponsesOutputItem{
+			Type:   core.ResponsesItemTypeMessage,
+			ID:     util.GenerateRandomID(core.MessageIDPrefix),
+			Status: core.ResponsesStatusInProgress,
+			Role:   core.RoleAssistant,
+		})
+		b.textIndex = len(b.response.Output) - 1
+		b.text.Reset()
+
+		item := b.response.Output[b.textIndex]
+		outputIndex, contentIndex := b.textIndex, 0
+		if err := b.send(core.ResponsesStreamEvent{
+			Type:        core.ResponsesEventOutputItemAdded,
+			OutputIndex: &outputIndex,
+			Item:        &item,
+		}); err != nil {
+			return err
+		}
+		if err := b.send(core.ResponsesStreamEvent{
+			Type:         core.ResponsesEventContentPartAdded,
+			OutputIndex:  &outputIndex,
+			ContentIndex: &contentIndex,
+			ItemID:       item.ID,
+			Part:         newOutputTextPart(""),
+		}); err != nil {
+			return err
+		}
+	}
+
+	b.text.WriteString(delta)
+	outputIndex, contentIndex := b.textIndex, 0
+	return b.send(core.ResponsesStreamEvent{
+		Type:         core.ResponsesEventOutputTextDelta,
+		OutputIndex:  &outputIndex,
+		ContentIndex: &contentIndex,
+		ItemID:       b.response.Output[b.textIndex].ID,
+		Delta:        delta,
+	})
+}
+
+func (b *responsesBuilder) closeText() error {
+	if b.textIndex < 0 {
+		return nil
+	}
+	index := b.textIndex
+	b.textIndex = -1
+
+	text := b.text.String()
+	part := newOutputTextPart(text)
+	item := &b.response.Output[index]
+	item.Content = []core.ResponsesContentPart{*part}
+	item.Status = core.ResponsesStatusCompleted
+
+	outputIndex, contentIndex := index, 0
+	if err := b.send(core.ResponsesStreamEvent{
+		Type:         core.ResponsesEventOutputTextDone,
+		OutputIndex:  &outputIndex,
+		ContentIndex: &contentIndex,
+		ItemID:       item.ID,
+		Text:         &text,
+	}); err != nil {
+		return err
+	}
+	if err := b.send(core.ResponsesStreamEvent{
+		Type:         core.ResponsesEventContentPartDone,
+		OutputIndex:  &outputIndex,
+		ContentIndex: &contentIndex,
+		ItemID:       item.ID,
+		Part:         part,
+	}); err != nil {
+		return err
+	}
+	done := *item
+	return b.send(core.ResponsesStreamEvent{
+		Type:        core.ResponsesEventOutputItemDone,
+		OutputIndex: &outputIndex,
+		Item:        &done,
+	})
+}
+
+func (b *responsesBuilder) startTool(callID, name string) error {
+	if err := b.closeText(); err != nil {
+		return err
+	}
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+
+	b.response.Output = append(b.response.Output, core.ResponsesOutputItem{
+		Type:   core.ResponsesItemTypeFunctionCall,
+		ID:     util.GenerateRandomID(core.ResponsesFunctionCallIDPrefix),
+		Status: core.ResponsesStatusInProgress,
+		CallID: callID,
+		Name:   name,
+	})
+	b.toolIndex = len(b.response.Output) - 1
+
+	item := b.response.Output[b.toolIndex]
+	outputIndex := b.toolIndex
+	return b.send(core.ResponsesStreamEvent{
+		Type:        core.ResponsesEventOutputItemAdded,
+		OutputIndex: &outputIndex,
+		Item:        &item,
+	})
+}
+
+func (b *responsesBuilder) appendToolArgs(delta string) error {
+	if b.toolIndex < 0 || delta == "" {
+		return nil
+	}
+	item := &b.response.Output[b.toolIndex]
+	item.Arguments += delta
+
+	outputIndex := b.toolIndex
+	return b.send(core.ResponsesStreamEvent{
+		Type:        core.ResponsesEventFunctionCallArgumentsDelta,
+		OutputIndex: &outputIndex,
+		ItemID:      item.ID,
+		Delta:       delta,
+	})
+}
+
+func (b *responsesBuilder) closeTool() error {
+	if b.toolIndex < 0 {
+		return nil
+	}
+	index := b.toolIndex
+	b.toolIndex = -1
+
+	item := &b.response.Output[index]
+	item.Status = core.ResponsesStatusCompleted
+
+	outputIndex := index
+	arguments := item.Arguments
+	if err := b.send(core.ResponsesStreamEvent{
+		Type:        core.ResponsesEventFunctionCallArgumentsDone,
+		OutputIndex: &outputIndex,
+		ItemID:      item.ID,
+		Arguments:   &arguments,
+	}); err != nil {
+		return err
+	}
+	done := *item
+	return b.send(core.ResponsesStreamEvent{
+		Type:        core.ResponsesEventOutputItemDone,
+		OutputIndex: &outputIndex,
+		Item:        &done,
+	})
+}
+
+// handleEvent applies a single JetBrains stream event; returns false once the upstream has finished.
+func (b *responsesBuilder) handleEvent(data map[string]any) (bool, error) {
+	b.usage.ObserveEvent(data)
+	eventType, _ := data["type"].(string)
+
+	switch eventType {
+	case core.JetBrainsEventTypeContent:
+		content, _ := data["content"].(string)
+		return true, b.appendText(content)
+	case core.JetBrainsEventTypeToolCall:
+		if upstreamID, ok := data["id"].(string); ok && upstreamID != "" {
+			if name, ok := data["name"].(string); ok && name != "" {
+				return true, b.startTool(upstreamID, name)
+			}
+		} else if content, ok := data["content"].(string); ok {
+			return true, b.appendToolArgs(content)
+		}
+	case core.JetBrainsEventTypeFunctionCall:
+		funcName, _ := data["name"].(string)
+		funcArgs, _ := data["content"].(string)
+		if funcName != "" {
+			if err := b.startTool(util.GenerateRandomID(core.ToolCallIDPrefix), funcName); err != nil {
+				return false, err
+			}
+		}
+		return true, b.appendToolArgs(funcArgs)
+	case core.JetBrainsEventTypeFinishMetadata:
+		if reason, ok := data["reason"].(string); ok {
+			b.upstreamFinishReason = reason
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// finish closes any open items and marks the response completed (or incomplete on length cut-off).
+func (b *responsesBuilder) finish() error {
+	if err := b.closeText(); err != nil {
+		return err
+	}
+	if err := b.closeTool(); err != nil {
+		return err
+	}
+
+	b.response.Status = core.ResponsesStatusCompleted
+	if b.upstreamFinishReason == core.JetBrainsFinishReasonLength {
+		b.response.Status = core.ResponsesStatusIncomplete
+		b.response.IncompleteDetails = &core.ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	}
+	b.response.Usage = b.usage.Report().Responses()
+
+	return b.send(core.ResponsesStreamEvent{Type: core.ResponsesEventCompleted, Response: b.snapshot()})
+}
+
+// fail marks the response failed after the upstream stream broke off.
+func (b *responsesBuilder) fail(message string) error {
+	b.response.Status = core.ResponsesStatusFailed
+	b.response.Error = &core.ResponsesError{Code: core.OpenAIErrorTypeServer, Message: message}
+	b.response.Usage = b.usage.Report().Responses()
+
+	return b.send(core.ResponsesStreamEvent{Type: core.ResponsesEventFailed, Response: b.snapshot()})
+}
+
+func newOutputTextPart(text string) *core.ResponsesContentPart {
+	return &core.ResponsesContentPart{
+		Type:        core.ResponsesContentTypeOutputText,
+		Text:        text,
+		Annotations: []any{},
+	}
+}
+
+func writeResponsesEvent(c *gin.Context, event core.ResponsesStreamEvent) error {
+	data, err := util.MarshalJSON(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\n%s%s\n\n", event.Type, core.StreamChunkPrefix, string(data)); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func handleResponsesStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, request *core.ResponsesRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	setStreamingHeaders(c, core.APIFormatOpenAI)
+
+	b := newResponsesBuilder(request.Model, countResponsesInputTokens(request), func(event core.ResponsesStreamEvent) error {
+		return writeResponsesEvent(c, event)
+	})
+
+	if err := b.start(); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, request.Model, accountIdentifier)
+		logger.Debug("Failed to write response.created: %v", err)
+		return
+	}
+
+	var writeErr error
+	ctx := c.Request.Context()
+	guard := newOutputGuard(core.SamplingParams{MaxTokens: request.MaxOutputTokens})
+	streamErr := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, err := b.handleEvent(data)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		return cont
+	})
+
+	if writeErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, request.Model, accountIdentifier)
+		logger.Debug("Failed to write responses stream event: %v", writeErr)
+		return
+	}
+	if streamErr != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, request.Model, accountIdentifier)
+		if ctx.Err() != nil {
+			logger.Debug("Client disconnected during streaming: %v", streamErr)
+			return
+		}
+		logger.Error("Stream processing error: %v", streamErr)
+		if err := b.fail(classifyStreamError(streamErr).message); err != nil {
+			logger.Debug("Failed to write response.failed: %v", err)
+		}
+		return
+	}
+
+	if err := b.finish(); err != nil {
+		metrics.RecordFailureWithMetrics(m, startTime, request.Model, accountIdentifier)
+		logger.Debug("Failed to write response.completed: %v", err)
+		return
+	}
+
+	metrics.RecordSuccessWithMetrics(m, startTime, request.Model, accountIdentifier)
+}
+
+func handleResponsesNonStreamingResponseWithMetrics(c *gin.Context, resp *http.Response, request *core.ResponsesRequest, startTime time.Time, accountIdentifier string, m *metrics.MetricsService, logger core.Logger) {
+	b := newResponsesBuilder(request.Model, countResponsesInputTokens(request), nil)
+
+	ctx := c.Request.Context()
+	guard := newOutputGuard(core.SamplingParams{MaxTokens: request.MaxOutputTokens})
+	err := processGuardedStream(ctx, resp.Body, logger, guard, func(data map[string]any) bool {
+		cont, _ := b.handleEvent(data)
+		return cont
+	})
+
+	switch {
+	case err == nil:
+		_ = b.finish()
+	case ctx.Err() != nil:
+		logger.Debug("Client disconnected during non-streaming response: %v", err)
+	default:
+		logger.Error("Stream processing error in non-streaming handler: %v", err)
+		_ = b.fail(classifyStreamError(err).message)
+	}
+
+	m.RecordRequest(err == nil, time.Since(startTime).Milliseconds(), request.Model, accountIdentifier)
+	c.JSON(http.StatusOK, b.response)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleResponsesStreamingResponseWithMetrics_TextAndToolCall(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"Let me check\"}",
+		"data: {\"type\":\"ToolCall\",\"id\":\"call_abc\",\"name\":\"get_weather\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"{\\\"city\\\":\"}",
+		"data: {\"type\":\"ToolCall\",\"content\":\"\\\"Beijing\\\"}\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"tool_call\"}",
+		"data: end",
+		"",
+	}, "\n")
+
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	handleResponsesStreamingResponseWithMetrics(c, resp, &core.ResponsesRequest{Model: "gpt-4o"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	expectedOrder := []string{
+		"event: response.created",
+		"event: response.in_progress",
+		"event: response.output_item.added",
+		"event: response.content_part.added",
+		"event: response.output_text.delta",
+		"event: response.output_text.done",
+		"event: response.content_part.done",
+		"event: response.output_item.done",
+		"event: response.output_item.added",
+		"event: response.function_call_arguments.delta",
+		"event: response.function_call_arguments.done",
+		"event: response.output_item.done",
+		"event: response.completed",
+	}
+	pos := 0
+	for _, marker := range expectedOrder {
+		idx := strings.Index(body[pos:], marker)
+		if idx < 0 {
+			t.Fatalf("事件顺序错误，缺少 %q（从偏移 %d 起），实际: %s", marker, pos, body)
+		}
+		pos += idx + len(marker)
+	}
+
+	if !strings.Contains(body, `"call_id":"call_abc"`) {
+		t.Errorf("function_call 应保留上游 call_id，实际: %s", body)
+	}
+	if !strings.Contains(body, `"arguments":"{\"city\":\"Beijing\"}"`) {
+		t.Errorf("arguments.done 应包含完整参数，实际: %s", body)
+	}
+}
+
+func TestHandleResponsesNonStreamingResponseWithMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", bytes.NewBufferString(`{}`))
+
+	streamBody := strings.Join([]string{
+		"data: {\"type\":\"Content\",\"content\":\"Hello\"}",
+		"data: {\"type\":\"Content\",\"content\":\" world\"}",
+		"data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}",
+		"data: end",
+		"",
+	}, "\n")
+
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(streamBody))}
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	defer func() { _ = m.Close() }()
+
+	handleResponsesNonStreamingResponseWithMetrics(c, resp, &core.ResponsesRequest{Model: "gpt-4o"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	var result map[string]any
+	if err := sonic.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("响应不是有效 JSON: %v", err)
+	}
+	if result["object"] != core.ResponseObjectType || result["status"] != core.ResponsesStatusCompleted {
+		t.Fatalf("响应对象或状态错误: %v", result)
+	}
+	output, _ := result["output"].([]any)
+	if len(output) != 1 {
+		t.Fatalf("期望 1 个输出项，实际 %d", len(output))
+	}
+	item, _ := output[0].(map[string]any)
+	content, _ := item["content"].([]any)
+	if item["type"] != core.ResponsesItemTypeMessage || len(content) != 1 {
+		t.Fatalf("输出项应为包含一个内容块的 message，实际: %v", item)
+	}
+	part, _ := content[0].(map[string]any)
+	if part["text"] != "Hello world" {
+		t.Errorf("文本应拼接完整，实际: %v", part["text"])
+	}
+}
+
+func TestResponses_Validation(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"非法 JSON", `{invalid`, http.StatusBadRequest},
+		{"模型不存在", `{"model":"not-exist","input":"hi"}`, http.StatusNotFound},
+		{"previous_response_id 不支持", `{"model":"gpt-4o","input":"hi","previous_response_id":"resp_1"}`, http.StatusBadRequest},
+		{"空输入", `{"model":"gpt-4o","input":[]}`, http.StatusBadRequest},
+		{"内置工具不支持", `{"model":"gpt-4o","input":"hi","tools":[{"type":"web_search"}]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/responses", bytes.NewBufferString(tt.body))
+			req.Header.Set(core.HeaderAuthorization, core.AuthBearerPrefix+"test-key")
+			req.Header.Set(core.HeaderContentType, core.ContentTypeJSON)
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+			if w.Code != tt.expected {
+				t.Errorf("期望状态码 %d，实际 %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -25,6 +25,7 @@ func (s *Server) setupRoutes() {
 	admin := s.router.Group("/")
 	admin.Use(s.authenticateClient)
 	admin.GET("/log", metrics.StreamLog)
+	admin.POST("/reload", s.reloadConfig)
 
 	// API routes (auth required)
 	api := s.router.Group("/v1")
@@ -32,6 +33,53 @@ func (s *Server) setupRoutes() {
 	{
 		api.GET("/models", s.listModels)
 		api.POST("/chat/completions", s.chatCompletions)
+		api.POST("/completions", s.completions)
 		api.POST("/messages", s.anthropicMessages)
+		api.POST("/messages/count_tokens", s.anthropicCountTokens)
+		api.POST("/responses", s.responses)
+
+		api.POST("/files", s.uploadFile)
+		api.GET("/files", s.listFiles)
+		api.GET("/files/:file_id", s.getFile)
+		api.GET("/files/:file_id/content", s.getFileContent)
+		api.DELETE("/files/:file_id", s.deleteFile)
+
+		api.POST("/batches", s.createBatch)
+		api.GET("/batches", s.listBatches)
+		api.GET("/batches/:batch_id", s.getBatch)
+		api.POST("/batches/:batch_id/cancel", s.cancelBatch)
+
+		if s.messageBatches != nil {
+			api.POST("/messages/batches", s.createMessageBatch)
+			api.GET("/messages/batches", s.listMessageBatches)
+			api.GET("/messages/batches/:batch_id", s.getMessageBatch)
+			api.POST("/messages/batches/:batch_id/cancel", s.cancelMessageBatch)
+			api.GET("/messages/batches/:batch_id/results", s.getMessageBatchResults)
+		}
+	}
+
+	// Gemini API routes (auth required)
+	gemini := s.router.Group("/v1beta")
+	gemini.Use(s.authenticateClient)
+	{
+		gemini.POST("/models/:modelAction", s.geminiGenerateContent)
+	}
+
+	// Azure OpenAI deployment routes (auth required)
+	azure := s.router.Group("/openai")
+	azure.Use(s.authenticateClient)
+	{
+		azure.POST("/deployments/:deployment/chat/completions", s.azureChatCompletions)
+	}
+
+	// Ollama API routes (auth required); /api/stats above stays public
+	ollama := s.router.Group("/api")
+	ollama.Use(s.authenticateClient)
+	{
+		ollama.GET("/version", s.ollamaVersion)
+		ollama.GET("/tags", s.ollamaTags)
+		ollama.POST("/show", s.ollamaShow)
+		ollama.POST("/chat", s.ollamaChat)
+		ollama.POST("/generate", s.ollamaGenerate)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -24,6 +25,15 @@ func writeTempTestFile(t *testing.T, fileName string, content []byte) string {
 	return filePath
 }
 
+func newTestBatchStore(t *testing.T) core.BatchStore {
+	t.Helper()
+	store, err := storage.NewFileBatchStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建批处理存储失败: %v", err)
+	}
+	return store
+}
+
 func newTestServer(t *testing.T) *Server {
 	t.Helper()
 
@@ -45,8 +55,9 @@ func newTestServer(t *testing.T) *Server {
 			TLSHandshakeTimeout: time.Second,
 			RequestTimeout:      time.Second,
 		},
-		Storage: st,
-		Logger:  &core.NopLogger{},
+		Storage:    st,
+		BatchStore: newTestBatchStore(t),
+		Logger:     &core.NopLogger{},
 	}
 
 	server, err := NewServer(cfg)
@@ -148,8 +159,9 @@ func TestServerClose_PersistsBufferedMetrics(t *testing.T) {
 			TLSHandshakeTimeout: time.Second,
 			RequestTimeout:      time.Second,
 		},
-		Storage: st,
-		Logger:  &core.NopLogger{},
+		Storage:    st,
+		BatchStore: newTestBatchStore(t),
+		Logger:     &core.NopLogger{},
 	}
 
 	server, err := NewServer(cfg)
@@ -229,3 +241,75 @@ func TestServerRoutes_OpenAIAndAnthropicModelErrors(t *testing.T) {
 		t.Fatalf("/v1/messages 模型不存在应返回 404，实际 %d", w.Code)
 	}
 }
+
+func TestServerRoutes_InvalidReasoningEffort(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		param string
+	}{
+		{"chat completions", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"reasoning_effort":"extreme"}`, `"param":"reasoning_effort"`},
+		{"responses", "/v1/responses", `{"model":"gpt-4o","input":"hi","reasoning":{"effort":"extreme"}}`, `"param":"reasoning.effort"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, http.MethodPost, tt.path, tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.param) {
+				t.Errorf("未知的推理强度应返回 400 并指明 %s，实际 %d: %s", tt.param, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerRoutes_InvalidOpenAIImage(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"chat completions", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":[` +
+			`{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/bmp;base64,AAAA"}}]}]}`},
+		{"responses", "/v1/responses", `{"model":"gpt-4o","input":[{"role":"user","content":[` +
+			`{"type":"input_image","image_url":"data:image/bmp;base64,AAAA"}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, http.MethodPost, tt.path, tt.body)
+			body := w.Body.String()
+			if w.Code != http.StatusBadRequest || !strings.Contains(body, "invalid_request_error") || !strings.Contains(body, "messages[0].content[") {
+				t.Errorf("无效图片应返回 400 并指明出错的内容块，实际 %d: %s", w.Code, body)
+			}
+		})
+	}
+}
+
+func TestServerRoutes_ContextWindowExceeded(t *testing.T) {
+	server := newTestServer(t)
+	setTestModelsConfig(server, core.ModelsConfig{
+		Models: map[string]core.ModelSpec{"gpt-4o": {Profile: "openai-gpt-4o", ContextWindow: 100}},
+	})
+	prompt := strings.Repeat("hello world ", 100)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"chat completions", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"` + prompt + `"}]}`},
+		{"messages", "/v1/messages", `{"model":"gpt-4o","max_tokens":10,"messages":[{"role":"user","content":"` + prompt + `"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(server, http.MethodPost, tt.path, tt.body)
+			body := w.Body.String()
+			if w.Code != http.StatusBadRequest || !strings.Contains(body, "invalid_request_error") || !strings.Contains(body, "context window of 100 tokens") {
+				t.Errorf("超出上下文窗口应返回 400，实际 %d: %s", w.Code, body)
+			}
+		})
+	}
+}
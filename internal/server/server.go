@@ -7,15 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"jetbrainsai2api/internal/account"
+	"jetbrainsai2api/internal/batch"
 	"jetbrainsai2api/internal/cache"
 	"jetbrainsai2api/internal/config"
 	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/fetch"
 	"jetbrainsai2api/internal/metrics"
 	"jetbrainsai2api/internal/process"
+	"jetbrainsai2api/internal/usage"
 	"jetbrainsai2api/internal/util"
 
 	"github.com/gin-gonic/gin"
@@ -33,16 +38,20 @@ type Server struct {
 	cache          *cache.CacheService
 	metricsService *metrics.MetricsService
 
-	validClientKeys map[string]bool
-	modelsData      core.ModelList
-	modelsConfig    core.ModelsConfig
+	// state holds what a configuration reload replaces; see reload.go
+	state    atomic.Pointer[serverState]
+	reloadMu sync.Mutex
 
 	requestProcessor *process.RequestProcessor
+	imageFetcher     *fetch.ImageFetcher
 
 	config config.ServerConfig
 
 	rateLimiter *rateLimiter
 
+	batches        *batch.Manager
+	messageBatches *batch.MessageManager // nil when the storage cannot persist message batches
+
 	shutdownCtx    context.Context
 	shutdownCancel context.CancelFunc
 	signalQuit     chan os.Signal
@@ -56,11 +65,17 @@ func NewServer(cfg config.ServerConfig) (*Server, error) {
 	if cfg.Storage == nil {
 		return nil, fmt.Errorf("storage is required in ServerConfig")
 	}
+	if cfg.BatchStore == nil {
+		return nil, fmt.Errorf("batch store is required in ServerConfig")
+	}
 
 	cfg.Logger.Info("Initializing server with %d accounts", len(cfg.JetbrainsAccounts))
 
 	httpClient := createOptimizedHTTPClient(cfg.HTTPClientSettings)
 
+	// Load tokenizer ranks in the background so the first request does not pay for it
+	go usage.WarmUp(cfg.Logger)
+
 	cacheService := cache.NewCacheService()
 
 	metricsService := metrics.NewMetricsService(metrics.MetricsConfig{
@@ -90,15 +105,16 @@ func NewServer(cfg config.ServerConfig) (*Server, error) {
 		return nil, fmt.Errorf("failed to load models config: %w", err)
 	}
 
-	validClientKeys := make(map[string]bool)
-	for _, key := range cfg.ClientAPIKeys {
-		validClientKeys[key] = true
+	clientKeys, err := config.LoadClientAPIKeys(cfg.ClientAPIKeys, cfg.ClientAPIKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client API keys: %w", err)
 	}
+	state := newServerState(modelsData, modelsConfig, clientKeys)
 
-	if len(validClientKeys) == 0 {
+	if len(state.clientKeys) == 0 {
 		cfg.Logger.Warn("No client API keys configured")
 	} else {
-		cfg.Logger.Info("Loaded %d client API keys", len(validClientKeys))
+		cfg.Logger.Info("Loaded %d client API keys", len(state.clientKeys))
 	}
 
 	rateLimit := 120
@@ -118,14 +134,51 @@ func NewServer(cfg config.ServerConfig) (*Server, error) {
 		httpClient:       httpClient,
 		cache:            cacheService,
 		metricsService:   metricsService,
-		validClientKeys:  validClientKeys,
-		modelsData:       modelsData,
-		modelsConfig:     modelsConfig,
-		requestProcessor: process.NewRequestProcessor(modelsConfig, httpClient, cacheService, metricsService, cfg.Logger),
-		config:           cfg,
-		rateLimiter:      newRateLimiter(rateLimit),
-		shutdownCtx:      shutdownCtx,
-		shutdownCancel:   shutdownCancel,
+		requestProcessor: process.NewRequestProcessor(httpClient, cacheService, metricsService, cfg.Logger),
+		imageFetcher: fetch.NewImageFetcher(fetch.ImageFetcherConfig{
+			AllowedHosts: cfg.ImageFetch.AllowedHosts,
+			Timeout:      cfg.ImageFetch.Timeout,
+			MaxBytes:     cfg.ImageFetch.MaxBytes,
+			Cache:        cacheService,
+			Logger:       cfg.Logger,
+		}),
+		config:         cfg,
+		rateLimiter:    newRateLimiter(rateLimit),
+		shutdownCtx:    shutdownCtx,
+		shutdownCancel: shutdownCancel,
+	}
+	server.state.Store(state)
+
+	// Batch requests share the accounts with live traffic; neither manager runs more at once than there are accounts
+	batchConcurrency := cfg.Batch.Concurrency
+	if batchConcurrency <= 0 {
+		batchConcurrency = core.DefaultBatchConcurrency
+	}
+	batchConcurrency = max(1, min(batchConcurrency, accountManager.GetAccountCount()))
+	server.batches = batch.NewManager(batch.Config{
+		Store:       cfg.BatchStore,
+		Executor:    server.executeBatchRequest,
+		Concurrency: batchConcurrency,
+		Logger:      cfg.Logger,
+	})
+	if err := server.batches.Start(); err != nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("failed to start batch manager: %w", err)
+	}
+
+	if messageBatchStorage, ok := cfg.Storage.(core.MessageBatchStorage); ok {
+		server.messageBatches = batch.NewMessageManager(batch.MessageConfig{
+			Storage:     messageBatchStorage,
+			Executor:    server.executeMessageBatchRequest,
+			Concurrency: batchConcurrency,
+			Logger:      cfg.Logger,
+		})
+		if err := server.messageBatches.Start(); err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("failed to start message batch manager: %w", err)
+		}
+	} else {
+		cfg.Logger.Warn("Storage does not support message batches; /v1/messages/batches is disabled")
 	}
 
 	server.setupRoutes()
@@ -156,6 +209,7 @@ func createOptimizedHTTPClient(settings config.HTTPClientSettings) *http.Client
 // Run runs the server
 func (s *Server) Run() error {
 	s.setupGracefulShutdown()
+	s.setupConfigReload()
 
 	srv := &http.Server{
 		Addr:              ":" + s.port,
@@ -282,6 +336,18 @@ func (s *Server) Close() error {
 
 	var closeErr error
 
+	// Stop batch workers first so in-flight batch requests release their accounts
+	if s.batches != nil {
+		if err := s.batches.Close(); err != nil {
+			closeErr = errors.Join(closeErr, fmt.Errorf("close batch manager: %w", err))
+		}
+	}
+	if s.messageBatches != nil {
+		if err := s.messageBatches.Close(); err != nil {
+			closeErr = errors.Join(closeErr, fmt.Errorf("close message batch manager: %w", err))
+		}
+	}
+
 	if s.accountManager != nil {
 		if err := s.accountManager.Close(); err != nil {
 			closeErr = errors.Join(closeErr, fmt.Errorf("close account manager: %w", err))
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// streamFailure describes a stream that broke after the response headers were sent.
+type streamFailure struct {
+	transient bool
+	message   string
+}
+
+// classifyStreamError separates transient transport failures (connection reset, truncated
+// body, timeout) from failures that a retry would not fix, such as an oversized event.
+func classifyStreamError(err error) streamFailure {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return streamFailure{message: "upstream event exceeds the maximum supported size"}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return streamFailure{transient: true, message: "upstream connection was interrupted"}
+	}
+	return streamFailure{message: "upstream stream failed"}
+}
+
+// anthropicErrorType maps the failure to overloaded_error (worth retrying) or api_error.
+func (f streamFailure) anthropicErrorType() string {
+	if f.transient {
+		return core.AnthropicErrorOverloaded
+	}
+	return core.AnthropicErrorAPI
+}
+
+// writeOpenAIStreamError terminates an OpenAI SSE stream with an error chunk instead of a finish chunk.
+func writeOpenAIStreamError(w io.Writer, err error) error {
+	failure := classifyStreamError(err)
+	data, marshalErr := util.MarshalJSON(newAPIError(http.StatusBadGateway, core.ErrorCodeStreamInterrupted, failure.message).openAIBody())
+	if marshalErr != nil {
+		return marshalErr
+	}
+	_, writeErr := writeSSEData(w, data)
+	return writeErr
+}
+
+// anthropicStreamErrorPayload builds the payload of the Anthropic error event for a failed stream.
+func anthropicStreamErrorPayload(err error) ([]byte, error) {
+	failure := classifyStreamError(err)
+	return util.MarshalJSON(core.AnthropicErrorEvent{
+		Type: core.StreamEventTypeError,
+		Error: core.AnthropicErrorDetail{
+			Type:    failure.anthropicErrorType(),
+			Message: failure.message,
+		},
+	})
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClassifyStreamError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedType  string
+		transientFlag bool
+	}{
+		{"连接被重置", fmt.Errorf("stream read error: %w", syscall.ECONNRESET), core.AnthropicErrorOverloaded, true},
+		{"响应体被截断", fmt.Errorf("stream read error: %w", io.ErrUnexpectedEOF), core.AnthropicErrorOverloaded, true},
+		{"单行超出缓冲区", fmt.Errorf("stream read error: %w", bufio.ErrTooLong), core.AnthropicErrorAPI, false},
+		{"其他错误", errors.New("boom"), core.AnthropicErrorAPI, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := classifyStreamError(tt.err)
+			if failure.transient != tt.transientFlag {
+				t.Errorf("期望 transient=%v，实际 %v", tt.transientFlag, failure.transient)
+			}
+			if failure.anthropicErrorType() != tt.expectedType {
+				t.Errorf("期望错误类型 %s，实际 %s", tt.expectedType, failure.anthropicErrorType())
+			}
+		})
+	}
+}
+
+// brokenStream yields a content event and then fails like a reset upstream connection.
+func brokenStream() io.ReadCloser {
+	return io.NopCloser(io.MultiReader(
+		strings.NewReader("data: {\"type\":\"Content\",\"content\":\"partial\"}\n"),
+		iotest.ErrReader(syscall.ECONNRESET),
+	))
+}
+
+func newStreamTestContext() (*gin.Context, *httptest.ResponseRecorder, *metrics.MetricsService) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+	return c, w, m
+}
+
+func waitForFailedRequest(t *testing.T, m *metrics.MetricsService) {
+	t.Helper()
+	// Wait for buffer flush
+	time.Sleep(200 * time.Millisecond)
+	if stats := m.GetRequestStats(); stats.FailedRequests != 1 || stats.SuccessfulRequests != 0 {
+		t.Errorf("中断的流应记录为失败，实际 成功=%d 失败=%d", stats.SuccessfulRequests, stats.FailedRequests)
+	}
+}
+
+func TestHandleStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, core.ChatCompletionRequest{Model: "gpt-4o"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"partial"`) {
+		t.Fatalf("应先输出已收到的内容，实际: %s", body)
+	}
+	if !strings.Contains(body, `data: {"error":{"message":"upstream connection was interrupted","type":"server_error","param":null,"code":"stream_interrupted"}}`) {
+		t.Fatalf("应输出错误块，实际: %s", body)
+	}
+	if strings.Contains(body, `"finish_reason":"stop"`) || strings.Contains(body, "[DONE]") {
+		t.Fatalf("中断的流不应输出正常结束块，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleNonStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleNonStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, core.ChatCompletionRequest{Model: "gpt-4o"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if w.Code != http.StatusBadGateway || !strings.Contains(body, `"code":"stream_interrupted"`) {
+		t.Fatalf("中断的响应应返回 502 错误，实际 %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, `"finish_reason"`) {
+		t.Fatalf("中断的响应不应返回补全结果，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleAnthropicStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleAnthropicStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.AnthropicMessagesRequest{Model: "gpt-4o", MaxTokens: 100}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if !strings.Contains(body, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\"") {
+		t.Fatalf("应输出 overloaded_error 错误事件，实际: %s", body)
+	}
+	if strings.Contains(body, "event: message_stop") {
+		t.Fatalf("中断的流不应输出 message_stop，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleResponsesStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleResponsesStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.ResponsesRequest{Model: "gpt-4o", Input: "hi"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if !strings.Contains(body, "event: response.failed") || !strings.Contains(body, `"status":"failed"`) {
+		t.Fatalf("应输出 response.failed 事件，实际: %s", body)
+	}
+	if strings.Contains(body, "event: response.completed") {
+		t.Fatalf("中断的流不应输出 response.completed，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleResponsesNonStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleResponsesNonStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.ResponsesRequest{Model: "gpt-4o", Input: "hi"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"status":"failed"`) || !strings.Contains(body, `"message":"upstream connection was interrupted"`) {
+		t.Fatalf("中断的响应应标记为 failed 并包含错误，实际: %s", body)
+	}
+	if strings.Contains(body, `"status":"completed"`) {
+		t.Fatalf("中断的响应不应标记为 completed，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleGeminiNonStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleGeminiNonStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.ChatCompletionRequest{Model: "gemini-2.5-pro"}, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if w.Code != http.StatusBadGateway || !strings.Contains(body, "upstream connection was interrupted") {
+		t.Fatalf("中断的响应应返回 502 错误，实际 %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, core.GeminiFinishReasonStop) {
+		t.Fatalf("中断的响应不应以 STOP 结束，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleCompletionNonStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleCompletionNonStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.ChatCompletionRequest{Model: "gpt-4o"}, "", time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if w.Code != http.StatusBadGateway || !strings.Contains(body, `"code":"stream_interrupted"`) {
+		t.Fatalf("中断的响应应返回 502 错误，实际 %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, `"finish_reason"`) {
+		t.Fatalf("中断的响应不应返回补全结果，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
+
+func TestHandleOllamaNonStreamingResponseWithMetrics_MidStreamError(t *testing.T) {
+	c, w, m := newStreamTestContext()
+	defer func() { _ = m.Close() }()
+
+	handleOllamaNonStreamingResponseWithMetrics(c, &http.Response{Body: brokenStream()}, &core.ChatCompletionRequest{Model: "gpt-4o"}, false, time.Now(), "acc", m, &core.NopLogger{})
+
+	body := w.Body.String()
+	if w.Code != http.StatusBadGateway || !strings.Contains(body, "upstream connection was interrupted") {
+		t.Fatalf("中断的响应应返回 502 错误，实际 %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, `"done":true`) {
+		t.Fatalf("中断的响应不应标记为完成，实际: %s", body)
+	}
+	waitForFailedRequest(t, m)
+}
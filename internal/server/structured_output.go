@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/validate"
+
+	"github.com/bytedance/sonic"
+)
+
+// sendChatCompletion sends a chat completion request. JetBrains has no structured output mode, so
+// for a non-streaming request with a JSON response_format each upstream stream is buffered and its
+// text checked; output that does not conform is discarded and the request sent again, up to
+// core.StructuredOutputAttempts times. The account is released before each retry, so the account
+// manager may hand out the same account again. The last attempt is returned as is and checked by
+// collectChatCompletion.
+func (s *Server) sendChatCompletion(ctx context.Context, endpoint string, payloadBytes []byte, choice core.ToolChoice, request *core.ChatCompletionRequest, logger core.Logger) (*http.Response, *core.JetbrainsAccount, error) {
+	if request.Stream || !request.ResponseFormat.IsJSON() {
+		return s.sendWithToolChoice(ctx, endpoint, payloadBytes, choice, logger)
+	}
+
+	for attempt := 1; attempt < core.StructuredOutputAttempts; attempt++ {
+		resp, acct, err := s.sendWithToolChoice(ctx, endpoint, payloadBytes, choice, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, acct, nil
+		}
+
+		data, err := readBufferedStream(resp.Body)
+		if err != nil {
+			_ = resp.Body.Close()
+			s.accountManager.ReleaseAccount(acct)
+			return nil, nil, fmt.Errorf("failed to read upstream stream: %w", err)
+		}
+		resp.Body = replayBody{Reader: bytes.NewReader(data), Closer: resp.Body}
+
+		content, calledTool := streamText(data)
+		if calledTool {
+			return resp, acct, nil
+		}
+		_, validateErr := validate.StructuredOutput(content, request.ResponseFormat)
+		if validateErr == nil {
+			return resp, acct, nil
+		}
+
+		_ = resp.Body.Close()
+		s.accountManager.ReleaseAccount(acct)
+		logger.Warn("Output does not conform to response_format (attempt %d/%d): %v", attempt, core.StructuredOutputAttempts, validateErr)
+	}
+
+	return s.sendWithToolChoice(ctx, endpoint, payloadBytes, choice, logger)
+}
+
+// readBufferedStream reads a whole upstream stream, failing rather than truncating one larger
+// than core.MaxResponseBodySize
+func readBufferedStream(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, core.MaxResponseBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > core.MaxResponseBodySize {
+		return nil, fmt.Errorf("stream exceeds %d bytes", core.MaxResponseBodySize)
+	}
+	return data, nil
+}
+
+// streamText returns the text content of a buffered JetBrains stream and whether it called a tool.
+func streamText(data []byte) (string, bool) {
+	var text strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), core.MaxScannerBufferSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, core.StreamChunkPrefix) {
+			continue
+		}
+		var event struct {
+			Type    string `json:"type"`
+			Content string `json:"content"`
+		}
+		if err := sonic.UnmarshalString(strings.TrimSpace(strings.TrimPrefix(line, core.StreamChunkPrefix)), &event); err != nil {
+			continue
+		}
+		switch event.Type {
+		case core.JetBrainsEventTypeContent:
+			text.WriteString(event.Content)
+		case core.JetBrainsEventTypeToolCall, core.JetBrainsEventTypeFunctionCall:
+			return text.String(), true
+		}
+	}
+	return text.String(), false
+}
+
+// structuredOutputRefusal checks the final text against a JSON response_format. It returns the
+// normalized JSON, or a refusal message when the output does not conform.
+func structuredOutputRefusal(content string, format *core.ResponseFormat, logger core.Logger) (string, *string) {
+	normalized, err := validate.StructuredOutput(content, format)
+	if err == nil {
+		return normalized, nil
+	}
+	logger.Warn("Output does not conform to response_format: %v", err)
+	refusal := "The model output does not conform to the requested response_format: " + err.Error()
+	return content, &refusal
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/metrics"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+func contentStream(chunks ...string) string {
+	var b strings.Builder
+	for _, chunk := range chunks {
+		event, _ := util.MarshalJSON(map[string]any{"type": core.JetBrainsEventTypeContent, "content": chunk})
+		b.WriteString("data: " + string(event) + "\n")
+	}
+	b.WriteString("data: {\"type\":\"FinishMetadata\",\"reason\":\"stop\"}\n")
+	return b.String()
+}
+
+func TestCollectChatCompletion_ResponseFormat(t *testing.T) {
+	strict := true
+	schemaFormat := &core.ResponseFormat{Type: core.ResponseFormatJSONSchema, JSONSchema: &core.JSONSchemaFormat{
+		Name:   "person",
+		Strict: &strict,
+		Schema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"name": map[string]any{"type": "string"}},
+			"required":   []any{"name"},
+		},
+	}}
+
+	tests := []struct {
+		name            string
+		format          *core.ResponseFormat
+		chunks          []string
+		expectedContent any
+		expectRefusal   bool
+	}{
+		{"json_object 去除代码块", &core.ResponseFormat{Type: core.ResponseFormatJSONObject}, []string{"```json\n{\"a\":", "1}\n```"}, `{"a":1}`, false},
+		{"json_object 非 JSON", &core.ResponseFormat{Type: core.ResponseFormatJSONObject}, []string{"Sure! Here it is"}, nil, true},
+		{"json_schema 符合", schemaFormat, []string{`{"name":"Ann"}`}, `{"name":"Ann"}`, false},
+		{"json_schema 缺少字段", schemaFormat, []string{`{"age":3}`}, nil, true},
+		{"text 不校验", &core.ResponseFormat{Type: core.ResponseFormatText}, []string{"plain"}, "plain", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := core.ChatCompletionRequest{Model: "gpt-4o", ResponseFormat: tt.format}
+			body := io.NopCloser(strings.NewReader(contentStream(tt.chunks...)))
+			response, err := collectChatCompletion(context.Background(), body, request, &core.NopLogger{})
+			if err != nil {
+				t.Fatalf("不应返回错误: %v", err)
+			}
+			message := response.Choices[0].Message
+			if message.Content != tt.expectedContent || (message.Refusal != nil) != tt.expectRefusal {
+				t.Errorf("期望 content=%v refusal=%v，实际 %+v", tt.expectedContent, tt.expectRefusal, message)
+			}
+		})
+	}
+}
+
+func TestHandleStreamingResponseWithMetrics_FlagsNonConformingOutput(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		expectRefusal bool
+	}{
+		{"合法 JSON", []string{`{"a":`, `1}`}, false},
+		{"非法 JSON", []string{`{"a":`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(`{}`))
+
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(contentStream(tt.chunks...)))}
+			m := metrics.NewMetricsService(metrics.MetricsConfig{SaveInterval: time.Second, HistorySize: 10, Storage: nil, Logger: &core.NopLogger{}})
+			defer func() { _ = m.Close() }()
+
+			request := core.ChatCompletionRequest{Model: "gpt-4o", Stream: true, ResponseFormat: &core.ResponseFormat{Type: core.ResponseFormatJSONObject}}
+			handleStreamingResponseWithMetrics(c, resp, request, time.Now(), "acc", m, &core.NopLogger{})
+
+			body := w.Body.String()
+			if strings.Contains(body, `"refusal"`) != tt.expectRefusal || !strings.Contains(body, "data: [DONE]") {
+				t.Errorf("期望 refusal=%v 且以 [DONE] 结束，实际: %s", tt.expectRefusal, body)
+			}
+		})
+	}
+}
+
+func TestStreamText(t *testing.T) {
+	tests := []struct {
+		name         string
+		stream       string
+		expectedText string
+		expectedTool bool
+	}{
+		{"纯文本", contentStream("a", "b"), "ab", false},
+		{"工具调用", "data: {\"type\":\"Content\",\"content\":\"x\"}\ndata: {\"type\":\"ToolCall\",\"id\":\"t1\",\"name\":\"f\"}\n", "x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, calledTool := streamText([]byte(tt.stream))
+			if text != tt.expectedText || calledTool != tt.expectedTool {
+				t.Errorf("期望 %q/%v，实际 %q/%v", tt.expectedText, tt.expectedTool, text, calledTool)
+			}
+		})
+	}
+}
+
+func TestReadBufferedStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"未超过上限", core.MaxResponseBodySize, false},
+		{"超过上限时报错而不是截断", core.MaxResponseBodySize + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := readBufferedStream(strings.NewReader(strings.Repeat("x", tt.size)))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("错误不符合预期: %v", err)
+			}
+			if !tt.wantErr && len(data) != tt.size {
+				t.Errorf("期望读取 %d 字节，实际 %d", tt.size, len(data))
+			}
+		})
+	}
+}
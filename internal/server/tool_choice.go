@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+)
+
+// errToolCallRequired is returned when tool_choice demands a tool call and the upstream never produced one.
+var errToolCallRequired = errors.New("model did not call a tool although tool_choice requires one")
+
+// sendWithToolChoice sends the request, emulating a required tool_choice since JetBrains has no
+// equivalent parameter: each upstream stream is peeked up to its first tool call, and streams that
+// finish without one are discarded and retried up to core.ToolCallRequiredAttempts times.
+func (s *Server) sendWithToolChoice(ctx context.Context, endpoint string, payloadBytes []byte, choice core.ToolChoice, logger core.Logger) (*http.Response, *core.JetbrainsAccount, error) {
+	if choice.Mode != core.ToolChoiceRequired {
+		return s.sendWithRetry(ctx, endpoint, payloadBytes, logger)
+	}
+
+	for attempt := range core.ToolCallRequiredAttempts {
+		resp, acct, err := s.sendWithRetry(ctx, endpoint, payloadBytes, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, acct, nil
+		}
+
+		replay, found, err := peekToolCall(resp.Body)
+		if err != nil {
+			_ = resp.Body.Close()
+			s.accountManager.ReleaseAccount(acct)
+			return nil, nil, fmt.Errorf("failed to read upstream stream: %w", err)
+		}
+		if found {
+			resp.Body = replayBody{Reader: replay, Closer: resp.Body}
+			return resp, acct, nil
+		}
+
+		_ = resp.Body.Close()
+		s.accountManager.ReleaseAccount(acct)
+		logger.Warn("tool_choice requires a tool call but none was produced (attempt %d/%d)", attempt+1, core.ToolCallRequiredAttempts)
+	}
+
+	return nil, nil, errToolCallRequired
+}
+
+// replayBody serves the peeked prefix followed by the rest of the upstream body.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// peekToolCall reads the upstream stream until its first tool call or its end, and returns
+// a reader that replays everything from the start.
+func peekToolCall(body io.Reader) (io.Reader, bool, error) {
+	reader := bufio.NewReader(body)
+	var consumed bytes.Buffer
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		consumed.Write(line)
+		if consumed.Len() > core.MaxResponseBodySize {
+			return nil, false, fmt.Errorf("stream exceeds %d bytes before the first tool call", core.MaxResponseBodySize)
+		}
+
+		switch streamEventType(line) {
+		case core.JetBrainsEventTypeToolCall, core.JetBrainsEventTypeFunctionCall:
+			return io.MultiReader(&consumed, reader), true, nil
+		case core.JetBrainsEventTypeFinishMetadata:
+			return io.MultiReader(&consumed, reader), false, nil
+		}
+
+		if readErr == io.EOF {
+			return io.MultiReader(&consumed, reader), false, nil
+		}
+		if readErr != nil {
+			return nil, false, readErr
+		}
+	}
+}
+
+// streamEventType returns the JetBrains event type of a single SSE line, or "" for other lines.
+func streamEventType(line []byte) string {
+	trimmed := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(trimmed, core.StreamChunkPrefix) {
+		return ""
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := sonic.UnmarshalString(strings.TrimSpace(strings.TrimPrefix(trimmed, core.StreamChunkPrefix)), &event); err != nil {
+		return ""
+	}
+	return event.Type
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPeekToolCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		expected bool
+	}{
+		{"文本后出现工具调用", "data: {\"type\":\"Content\",\"content\":\"hi\"}\ndata: {\"type\":\"ToolCall\",\"id\":\"t1\",\"name\":\"f\"}\ndata: {\"type\":\"FinishMetadata\"}\n", true},
+		{"旧版函数调用", "data: {\"type\":\"FunctionCall\",\"name\":\"f\"}\n", true},
+		{"没有工具调用即结束", "data: {\"type\":\"Content\",\"content\":\"hi\"}\ndata: {\"type\":\"FinishMetadata\"}\n", false},
+		{"流提前结束", "data: {\"type\":\"Content\",\"content\":\"hi\"}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, found, err := peekToolCall(strings.NewReader(tt.stream))
+			if err != nil {
+				t.Fatalf("不应返回错误: %v", err)
+			}
+			if found != tt.expected {
+				t.Errorf("期望 found=%v，实际 %v", tt.expected, found)
+			}
+
+			// The replay reader must reproduce the whole stream, peeked part included
+			all, err := io.ReadAll(replay)
+			if err != nil {
+				t.Fatalf("读取重放内容失败: %v", err)
+			}
+			if string(all) != tt.stream {
+				t.Errorf("重放内容应与原始流一致，实际 %q", string(all))
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	batchFilesDir     = "files"
+	batchJobsDir      = "batches"
+	batchMetaExt      = ".json"
+	batchContentExt   = ".jsonl"
+	batchOutputSuffix = ".output.jsonl"
+	batchErrorSuffix  = ".errors.jsonl"
+)
+
+// FileBatchStore implements core.BatchStore on the local filesystem. Files keep their metadata
+// and content side by side under files/; batches keep their record and in-progress results
+// under batches/.
+type FileBatchStore struct {
+	filesDir string
+	jobsDir  string
+	mu       sync.Mutex // serializes result appends
+}
+
+// NewFileBatchStore creates a batch store rooted at dir, creating the directories as needed.
+func NewFileBatchStore(dir string) (*FileBatchStore, error) {
+	if dir == "" {
+		dir = core.DefaultBatchDataDir
+	}
+	store := &FileBatchStore{
+		filesDir: filepath.Join(dir, batchFilesDir),
+		jobsDir:  filepath.Join(dir, batchJobsDir),
+	}
+	for _, d := range []string{store.filesDir, store.jobsDir} {
+		if err := os.MkdirAll(d, core.DirPermission); err != nil {
+			return nil, fmt.Errorf("failed to create batch directory: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// InitBatchStore initializes the batch store under BATCH_DATA_DIR (default "batches").
+func InitBatchStore(logger core.Logger) (core.BatchStore, error) {
+	dir := os.Getenv("BATCH_DATA_DIR")
+	if dir == "" {
+		dir = core.DefaultBatchDataDir
+	}
+	store, err := NewFileBatchStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	logStorageInfo(logger, "Using batch store at %s", dir)
+	return store, nil
+}
+
+// validBatchID rejects IDs that could escape the store directory; IDs come from request paths.
+func validBatchID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && !strings.Contains(id, "..")
+}
+
+// SaveFile stores the content first so that a file record never points to missing content.
+func (s *FileBatchStore) SaveFile(file *core.OpenAIFile, content []byte) error {
+	if !validBatchID(file.ID) {
+		return fmt.Errorf("invalid file id %q", file.ID)
+	}
+	if err := writeFileAtomic(filepath.Join(s.filesDir, file.ID+batchContentExt), content); err != nil {
+		return err
+	}
+	return writeJSONAtomic(filepath.Join(s.filesDir, file.ID+batchMetaExt), file)
+}
+
+// GetFile returns the file record, or core.ErrNotFound.
+func (s *FileBatchStore) GetFile(id string) (*core.OpenAIFile, error) {
+	var file core.OpenAIFile
+	if err := s.readJSON(s.filesDir, id, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetFileContent returns the file content, or core.ErrNotFound.
+func (s *FileBatchStore) GetFileContent(id string) ([]byte, error) {
+	if !validBatchID(id) {
+		return nil, core.ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.filesDir, id+batchContentExt)) //nolint:gosec // G304: id validated above
+	if os.IsNotExist(err) {
+		return nil, core.ErrNotFound
+	}
+	return data, err
+}
+
+// ListFiles returns all files, newest first.
+func (s *FileBatchStore) ListFiles() ([]core.OpenAIFile, error) {
+	files := []core.OpenAIFile{}
+	err := s.readAll(s.filesDir, func(data []byte) error {
+		var file core.OpenAIFile
+		if err := sonic.Unmarshal(data, &file); err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID > files[j].ID
+	})
+	return files, err
+}
+
+// DeleteFile removes the file record and content, or returns core.ErrNotFound.
+func (s *FileBatchStore) DeleteFile(id string) error {
+	if !validBatchID(id) {
+		return core.ErrNotFound
+	}
+	if err := os.Remove(filepath.Join(s.filesDir, id+batchMetaExt)); err != nil {
+		if os.IsNotExist(err) {
+			return core.ErrNotFound
+		}
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.filesDir, id+batchContentExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SaveBatch writes the batch record atomically.
+func (s *FileBatchStore) SaveBatch(batch *core.Batch) error {
+	if !validBatchID(batch.ID) {
+		return fmt.Errorf("invalid batch id %q", batch.ID)
+	}
+	return writeJSONAtomic(filepath.Join(s.jobsDir, batch.ID+batchMetaExt), batch)
+}
+
+// GetBatch returns the batch record, or core.ErrNotFound.
+func (s *FileBatchStore) GetBatch(id string) (*core.Batch, error) {
+	var batch core.Batch
+	if err := s.readJSON(s.jobsDir, id, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListBatches returns all batches, newest first.
+func (s *FileBatchStore) ListBatches() ([]core.Batch, error) {
+	batches := []core.Batch{}
+	err := s.readAll(s.jobsDir, func(data []byte) error {
+		var batch core.Batch
+		if err := sonic.Unmarshal(data, &batch); err != nil {
+			return err
+		}
+		batches = append(batches, batch)
+		return nil
+	})
+	sort.SliceStable(batches, func(i, j int) bool {
+		if batches[i].CreatedAt != batches[j].CreatedAt {
+			return batches[i].CreatedAt > batches[j].CreatedAt
+		}
+		return batches[i].ID > batches[j].ID
+	})
+	return batches, err
+}
+
+func (s *FileBatchStore) resultsPath(batchID string, failed bool) string {
+	if failed {
+		return filepath.Join(s.jobsDir, batchID+batchErrorSuffix)
+	}
+	return filepath.Join(s.jobsDir, batchID+batchOutputSuffix)
+}
+
+// AppendBatchResult appends one JSONL line to the output or error results of a batch.
+func (s *FileBatchStore) AppendBatchResult(batchID string, failed bool, line []byte) error {
+	if !validBatchID(batchID) {
+		return fmt.Errorf("invalid batch id %q", batchID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.resultsPath(batchID, failed), os.O_CREATE|os.O_WRONLY|os.O_APPEND, core.FilePermissionReadWrite)
+	if err != nil {
+		return fmt.Errorf("failed to open batch results: %w", err)
+	}
+	_, err = f.Write(append(append([]byte(nil), line...), '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GetBatchResults returns the results appended so far; empty if there are none.
+func (s *FileBatchStore) GetBatchResults(batchID string, failed bool) ([]byte, error) {
+	if !validBatchID(batchID) {
+		return nil, core.ErrNotFound
+	}
+	data, err := os.ReadFile(s.resultsPath(batchID, failed))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// DeleteBatchResults removes the results of a finalized batch.
+func (s *FileBatchStore) DeleteBatchResults(batchID string) error {
+	if !validBatchID(batchID) {
+		return nil
+	}
+	for _, failed := range []bool{false, true} {
+		if err := os.Remove(s.resultsPath(batchID, failed)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileBatchStore) readJSON(dir, id string, v any) error {
+	if !validBatchID(id) {
+		return core.ErrNotFound
+	}
+	return readJSONFile(filepath.Join(dir, id+batchMetaExt), v)
+}
+
+// readAll calls fn with every record in dir; records removed concurrently are skipped.
+func (s *FileBatchStore) readAll(dir string, fn func(data []byte) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != batchMetaExt {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name())) //nolint:gosec // G304: name read from the store directory
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err := fn(data); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func writeJSONAtomic(path string, v any) error {
+	data, err := sonic.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temp file and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, core.FilePermissionReadWrite); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
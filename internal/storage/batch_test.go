@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestFileBatchStore_Files(t *testing.T) {
+	store, err := NewFileBatchStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+
+	older := &core.OpenAIFile{ID: "file-old", CreatedAt: 1}
+	newer := &core.OpenAIFile{ID: "file-new", CreatedAt: 2}
+	for _, f := range []*core.OpenAIFile{older, newer} {
+		if err := store.SaveFile(f, []byte(f.ID)); err != nil {
+			t.Fatalf("保存文件失败: %v", err)
+		}
+	}
+
+	files, err := store.ListFiles()
+	if err != nil || len(files) != 2 || files[0].ID != "file-new" {
+		t.Fatalf("应按创建时间倒序列出文件，实际 %+v, %v", files, err)
+	}
+	content, err := store.GetFileContent("file-old")
+	if err != nil || string(content) != "file-old" {
+		t.Errorf("文件内容错误: %q, %v", content, err)
+	}
+
+	if err := store.DeleteFile("file-old"); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"已删除", "file-old"},
+		{"路径穿越", "../file-new"},
+		{"空 ID", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.GetFile(tt.id); !errors.Is(err, core.ErrNotFound) {
+				t.Errorf("期望 ErrNotFound，实际 %v", err)
+			}
+			if _, err := store.GetFileContent(tt.id); !errors.Is(err, core.ErrNotFound) {
+				t.Errorf("期望 ErrNotFound，实际 %v", err)
+			}
+		})
+	}
+}
+
+func TestFileBatchStore_Results(t *testing.T) {
+	store, err := NewFileBatchStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+
+	if data, err := store.GetBatchResults("batch_1", false); err != nil || len(data) != 0 {
+		t.Fatalf("没有结果时应返回空，实际 %q, %v", data, err)
+	}
+	for _, line := range []string{`{"custom_id":"a"}`, `{"custom_id":"b"}`} {
+		if err := store.AppendBatchResult("batch_1", false, []byte(line)); err != nil {
+			t.Fatalf("追加结果失败: %v", err)
+		}
+	}
+	if err := store.AppendBatchResult("batch_1", true, []byte(`{"custom_id":"c"}`)); err != nil {
+		t.Fatalf("追加错误结果失败: %v", err)
+	}
+
+	output, _ := store.GetBatchResults("batch_1", false)
+	if string(output) != "{\"custom_id\":\"a\"}\n{\"custom_id\":\"b\"}\n" {
+		t.Errorf("输出结果错误: %q", output)
+	}
+	errs, _ := store.GetBatchResults("batch_1", true)
+	if string(errs) != "{\"custom_id\":\"c\"}\n" {
+		t.Errorf("错误结果应单独保存: %q", errs)
+	}
+
+	if err := store.DeleteBatchResults("batch_1"); err != nil {
+		t.Fatalf("删除结果失败: %v", err)
+	}
+	if output, _ := store.GetBatchResults("batch_1", false); len(output) != 0 {
+		t.Errorf("删除后结果应为空: %q", output)
+	}
+}
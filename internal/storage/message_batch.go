@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	messageBatchFile        = "batch.json"
+	messageBatchRequestFile = "requests.json"
+	messageBatchResultFile  = "results.jsonl"
+
+	messageBatchRedisPrefix = "jetbrainsai2api:msgbatch:"
+	messageBatchRedisIndex  = "jetbrainsai2api:msgbatches"
+)
+
+// messageBatchDir returns the directory of a message batch; each batch has its own directory
+// under message_batches/ next to the stats file.
+func (fs *FileStorage) messageBatchDir(id string) string {
+	return filepath.Join(filepath.Dir(fs.filePath), core.MessageBatchDirName, id)
+}
+
+// SaveMessageBatch writes the batch record atomically.
+func (fs *FileStorage) SaveMessageBatch(batch *core.MessageBatch) error {
+	if !validBatchID(batch.ID) {
+		return fmt.Errorf("invalid message batch id %q", batch.ID)
+	}
+	dir := fs.messageBatchDir(batch.ID)
+	if err := os.MkdirAll(dir, core.DirPermission); err != nil {
+		return fmt.Errorf("failed to create message batch directory: %w", err)
+	}
+	return writeJSONAtomic(filepath.Join(dir, messageBatchFile), batch)
+}
+
+// GetMessageBatch returns the batch record, or core.ErrNotFound.
+func (fs *FileStorage) GetMessageBatch(id string) (*core.MessageBatch, error) {
+	if !validBatchID(id) {
+		return nil, core.ErrNotFound
+	}
+	var batch core.MessageBatch
+	if err := readJSONFile(filepath.Join(fs.messageBatchDir(id), messageBatchFile), &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListMessageBatches returns all message batches, newest first.
+func (fs *FileStorage) ListMessageBatches() ([]core.MessageBatch, error) {
+	batches := []core.MessageBatch{}
+	entries, err := os.ReadDir(filepath.Join(filepath.Dir(fs.filePath), core.MessageBatchDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return batches, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		batch, err := fs.GetMessageBatch(entry.Name())
+		if errors.Is(err, core.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load message batch %s: %w", entry.Name(), err)
+		}
+		batches = append(batches, *batch)
+	}
+	sortMessageBatches(batches)
+	return batches, nil
+}
+
+// SaveMessageBatchRequests stores the requests of a batch.
+func (fs *FileStorage) SaveMessageBatchRequests(id string, requests []core.MessageBatchRequest) error {
+	if !validBatchID(id) {
+		return fmt.Errorf("invalid message batch id %q", id)
+	}
+	dir := fs.messageBatchDir(id)
+	if err := os.MkdirAll(dir, core.DirPermission); err != nil {
+		return fmt.Errorf("failed to create message batch directory: %w", err)
+	}
+	data, err := util.MarshalJSON(requests)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message batch requests: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, messageBatchRequestFile), data)
+}
+
+// GetMessageBatchRequests returns the requests of a batch, or core.ErrNotFound.
+func (fs *FileStorage) GetMessageBatchRequests(id string) ([]core.MessageBatchRequest, error) {
+	if !validBatchID(id) {
+		return nil, core.ErrNotFound
+	}
+	var requests []core.MessageBatchRequest
+	if err := readJSONFile(filepath.Join(fs.messageBatchDir(id), messageBatchRequestFile), &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// AppendMessageBatchResult appends one JSONL result line.
+func (fs *FileStorage) AppendMessageBatchResult(id string, line []byte) error {
+	if !validBatchID(id) {
+		return fmt.Errorf("invalid message batch id %q", id)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(fs.messageBatchDir(id), messageBatchResultFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, core.FilePermissionReadWrite)
+	if err != nil {
+		return fmt.Errorf("failed to open message batch results: %w", err)
+	}
+	_, err = f.Write(append(append([]byte(nil), line...), '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GetMessageBatchResults returns the result lines recorded so far; empty if there are none.
+func (fs *FileStorage) GetMessageBatchResults(id string) ([]byte, error) {
+	if !validBatchID(id) {
+		return nil, core.ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(fs.messageBatchDir(id), messageBatchResultFile)) //nolint:gosec // G304: id validated above
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func messageBatchRedisKey(id, suffix string) string {
+	return messageBatchRedisPrefix + id + suffix
+}
+
+// SaveMessageBatch stores the batch record and indexes it by creation time.
+func (rs *RedisStorage) SaveMessageBatch(batch *core.MessageBatch) error {
+	data, err := util.MarshalJSON(batch)
+	if err != nil {
+		return err
+	}
+	createdAt, err := time.Parse(time.RFC3339, batch.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("invalid created_at of message batch %s: %w", batch.ID, err)
+	}
+
+	_, err = rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(rs.ctx, messageBatchRedisKey(batch.ID, ""), data, 0)
+		pipe.ZAdd(rs.ctx, messageBatchRedisIndex, redis.Z{Score: float64(createdAt.Unix()), Member: batch.ID})
+		return nil
+	})
+	return err
+}
+
+// GetMessageBatch returns the batch record, or core.ErrNotFound.
+func (rs *RedisStorage) GetMessageBatch(id string) (*core.MessageBatch, error) {
+	val, err := rs.client.Get(rs.ctx, messageBatchRedisKey(id, "")).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, core.ErrNotFound
+		}
+		return nil, err
+	}
+	var batch core.MessageBatch
+	if err := sonic.UnmarshalString(val, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListMessageBatches returns all message batches, newest first.
+func (rs *RedisStorage) ListMessageBatches() ([]core.MessageBatch, error) {
+	batches := []core.MessageBatch{}
+	ids, err := rs.client.ZRange(rs.ctx, messageBatchRedisIndex, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return batches, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = messageBatchRedisKey(id, "")
+	}
+	values, err := rs.client.MGet(rs.ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var batch core.MessageBatch
+		if err := sonic.UnmarshalString(data, &batch); err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	sortMessageBatches(batches)
+	return batches, nil
+}
+
+// SaveMessageBatchRequests stores the requests of a batch.
+func (rs *RedisStorage) SaveMessageBatchRequests(id string, requests []core.MessageBatchRequest) error {
+	data, err := util.MarshalJSON(requests)
+	if err != nil {
+		return err
+	}
+	return rs.client.Set(rs.ctx, messageBatchRedisKey(id, ":requests"), data, 0).Err()
+}
+
+// GetMessageBatchRequests returns the requests of a batch, or core.ErrNotFound.
+func (rs *RedisStorage) GetMessageBatchRequests(id string) ([]core.MessageBatchRequest, error) {
+	val, err := rs.client.Get(rs.ctx, messageBatchRedisKey(id, ":requests")).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, core.ErrNotFound
+		}
+		return nil, err
+	}
+	var requests []core.MessageBatchRequest
+	if err := sonic.UnmarshalString(val, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// AppendMessageBatchResult appends one result line to the batch's result list.
+func (rs *RedisStorage) AppendMessageBatchResult(id string, line []byte) error {
+	return rs.client.RPush(rs.ctx, messageBatchRedisKey(id, ":results"), line).Err()
+}
+
+// GetMessageBatchResults returns the result lines recorded so far as JSONL.
+func (rs *RedisStorage) GetMessageBatchResults(id string) ([]byte, error) {
+	lines, err := rs.client.LRange(rs.ctx, messageBatchRedisKey(id, ":results"), 0, -1).Result()
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+func sortMessageBatches(batches []core.MessageBatch) {
+	// RFC 3339 timestamps in UTC sort chronologically as strings
+	sort.SliceStable(batches, func(i, j int) bool {
+		if batches[i].CreatedAt != batches[j].CreatedAt {
+			return batches[i].CreatedAt > batches[j].CreatedAt
+		}
+		return batches[i].ID > batches[j].ID
+	})
+}
+
+// readJSONFile decodes a JSON file, returning core.ErrNotFound if it does not exist.
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path built from a validated id
+	if err != nil {
+		if os.IsNotExist(err) {
+			return core.ErrNotFound
+		}
+		return err
+	}
+	return sonic.Unmarshal(data, v)
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestFileStorage_MessageBatches(t *testing.T) {
+	fs := NewFileStorage(filepath.Join(t.TempDir(), "stats.json"))
+
+	batches, err := fs.ListMessageBatches()
+	if err != nil || len(batches) != 0 {
+		t.Fatalf("目录不存在时应返回空列表，实际 %+v, %v", batches, err)
+	}
+
+	older := &core.MessageBatch{ID: "msgbatch_old", CreatedAt: "2025-01-01T00:00:00Z"}
+	newer := &core.MessageBatch{ID: "msgbatch_new", CreatedAt: "2025-01-02T00:00:00Z"}
+	for _, b := range []*core.MessageBatch{older, newer} {
+		if err := fs.SaveMessageBatchRequests(b.ID, []core.MessageBatchRequest{{CustomID: "a", Params: map[string]any{}}}); err != nil {
+			t.Fatalf("保存请求失败: %v", err)
+		}
+		if err := fs.SaveMessageBatch(b); err != nil {
+			t.Fatalf("保存批处理失败: %v", err)
+		}
+	}
+
+	batches, err = fs.ListMessageBatches()
+	if err != nil || len(batches) != 2 || batches[0].ID != "msgbatch_new" {
+		t.Fatalf("应按创建时间倒序列出，实际 %+v, %v", batches, err)
+	}
+	requests, err := fs.GetMessageBatchRequests("msgbatch_old")
+	if err != nil || len(requests) != 1 || requests[0].CustomID != "a" {
+		t.Errorf("请求读取错误: %+v, %v", requests, err)
+	}
+
+	for _, line := range []string{`{"custom_id":"a"}`, `{"custom_id":"b"}`} {
+		if err := fs.AppendMessageBatchResult("msgbatch_old", []byte(line)); err != nil {
+			t.Fatalf("追加结果失败: %v", err)
+		}
+	}
+	results, err := fs.GetMessageBatchResults("msgbatch_old")
+	if err != nil || string(results) != "{\"custom_id\":\"a\"}\n{\"custom_id\":\"b\"}\n" {
+		t.Errorf("结果错误: %q, %v", results, err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"不存在", "msgbatch_missing"},
+		{"路径穿越", "../msgbatch_new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fs.GetMessageBatch(tt.id); !errors.Is(err, core.ErrNotFound) {
+				t.Errorf("期望 ErrNotFound，实际 %v", err)
+			}
+			if _, err := fs.GetMessageBatchRequests(tt.id); !errors.Is(err, core.ErrNotFound) {
+				t.Errorf("期望 ErrNotFound，实际 %v", err)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"jetbrainsai2api/internal/core"
 	"jetbrainsai2api/internal/util"
@@ -19,6 +20,7 @@ const (
 // FileStorage implements persistence using JSON files
 type FileStorage struct {
 	filePath string
+	mu       sync.Mutex // serializes message batch result appends
 }
 
 // NewFileStorage creates a new file-based storage instance.
@@ -35,15 +37,7 @@ func (fs *FileStorage) SaveStats(stats *core.RequestStats) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal stats: %w", err)
 	}
-	tmpFile := fs.filePath + ".tmp"
-	if err := os.WriteFile(tmpFile, data, core.FilePermissionReadWrite); err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
-	}
-	if err := os.Rename(tmpFile, fs.filePath); err != nil {
-		_ = os.Remove(tmpFile)
-		return fmt.Errorf("failed to rename temp file: %w", err)
-	}
-	return nil
+	return writeFileAtomic(fs.filePath, data)
 }
 
 // LoadStats reads request statistics from the JSON file.
@@ -0,0 +1,178 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/gif"  // register GIF decoder for image.DecodeConfig
+	_ "image/jpeg" // register JPEG decoder for image.DecodeConfig
+	_ "image/png"  // register PNG decoder for image.DecodeConfig
+	"io"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+)
+
+// CountJSON counts tokens in the JSON encoding of v (tool schemas, tool inputs).
+func CountJSON(v any) int {
+	if v == nil {
+		return 0
+	}
+	data, err := util.MarshalJSON(v)
+	if err != nil {
+		return 0
+	}
+	return CountText(string(data))
+}
+
+// CountOpenAIRequest computes the prompt token count of an OpenAI chat completion request locally.
+func CountOpenAIRequest(req *core.ChatCompletionRequest) int {
+	total := core.RequestOverheadTokens
+
+	for _, tool := range req.Tools {
+		total += core.ToolOverheadTokens + CountText(tool.Function.Name) +
+			CountText(tool.Function.Description) + CountJSON(tool.Function.Parameters)
+	}
+
+	for _, msg := range req.Messages {
+		total += core.MessageOverheadTokens + countOpenAIContent(msg.Content) + CountText(msg.ReasoningContent)
+		for _, toolCall := range msg.ToolCalls {
+			total += CountText(toolCall.Function.Name) + CountText(toolCall.Function.Arguments)
+		}
+	}
+
+	return total
+}
+
+func countOpenAIContent(content any) int {
+	switch v := content.(type) {
+	case nil:
+		return 0
+	case string:
+		return CountText(v)
+	case []any:
+		total := 0
+		for _, part := range v {
+			partMap, ok := part.(map[string]any)
+			if !ok {
+				continue
+			}
+			partType, _ := partMap["type"].(string)
+			switch partType {
+			case core.ContentBlockTypeText:
+				text, _ := partMap["text"].(string)
+				total += CountText(text)
+			case core.ContentPartTypeImageURL:
+				imageURL, _ := partMap["image_url"].(map[string]any)
+				url, _ := imageURL["url"].(string)
+				total += EstimateImageTokens(dataURLPayload(url))
+			default:
+				total += CountJSON(partMap)
+			}
+		}
+		return total
+	default:
+		return CountJSON(v)
+	}
+}
+
+// dataURLPayload returns the base64 payload of a data: URL, or "" for remote URLs.
+func dataURLPayload(url string) string {
+	if !strings.HasPrefix(url, "data:") {
+		return ""
+	}
+	if _, payload, found := strings.Cut(url, ","); found {
+		return payload
+	}
+	return ""
+}
+
+// CountAnthropicRequest computes the input token count of an Anthropic Messages request locally,
+// covering system prompt, tool definitions, and every message content block including images.
+func CountAnthropicRequest(req *core.AnthropicMessagesRequest) int {
+	total := core.RequestOverheadTokens
+
+	if req.System != "" {
+		total += CountText(string(req.System)) + core.MessageOverheadTokens
+	}
+
+	for _, tool := range req.Tools {
+		total += core.ToolOverheadTokens + CountText(tool.Name) + CountText(tool.Description) + CountJSON(tool.InputSchema)
+	}
+
+	for _, msg := range req.Messages {
+		total += core.MessageOverheadTokens + countAnthropicContent(msg.Content)
+	}
+
+	return total
+}
+
+func countAnthropicContent(content any) int {
+	switch v := content.(type) {
+	case nil:
+		return 0
+	case string:
+		return CountText(v)
+	case []any:
+		total := 0
+		for _, block := range v {
+			if blockMap, ok := block.(map[string]any); ok {
+				total += countAnthropicBlock(blockMap)
+			}
+		}
+		return total
+	default:
+		return CountJSON(v)
+	}
+}
+
+func countAnthropicBlock(block map[string]any) int {
+	blockType, _ := block["type"].(string)
+
+	switch blockType {
+	case core.ContentBlockTypeText:
+		text, _ := block["text"].(string)
+		return CountText(text)
+	case core.ContentBlockTypeToolUse:
+		name, _ := block["name"].(string)
+		return CountText(name) + CountJSON(block["input"])
+	case core.ContentBlockTypeThinking:
+		thinking, _ := block["thinking"].(string)
+		return CountText(thinking)
+	case core.ContentBlockTypeToolResult:
+		return countAnthropicContent(block["content"])
+	case core.ContentBlockTypeImage:
+		source, _ := block["source"].(map[string]any)
+		data, _ := source["data"].(string)
+		return EstimateImageTokens(data)
+	default:
+		return CountJSON(block)
+	}
+}
+
+// EstimateImageTokens estimates the token cost of a base64 image using the
+// (width*height)/750 rule after scaling the long edge down to the model limit.
+// Undecodable images (or remote URLs, passed as empty data) cost the maximum.
+func EstimateImageTokens(base64Data string) int {
+	if base64Data == "" {
+		return core.ImageFallbackTokenCount
+	}
+
+	// Only the header is needed for DecodeConfig; avoid decoding the full payload.
+	decoder := base64.NewDecoder(base64.StdEncoding, bytes.NewReader([]byte(base64Data)))
+	cfg, _, err := image.DecodeConfig(io.LimitReader(decoder, 64*1024))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return core.ImageFallbackTokenCount
+	}
+
+	width, height := float64(cfg.Width), float64(cfg.Height)
+	if longEdge := max(width, height); longEdge > core.ImageMaxLongEdgePixels {
+		scale := core.ImageMaxLongEdgePixels / longEdge
+		width *= scale
+		height *= scale
+	}
+
+	tokens := int(width * height / core.ImageTokenPixelDivisor)
+	return min(max(tokens, 1), core.ImageMaxTokens)
+}
@@ -0,0 +1,126 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func encodeTestPNG(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("生成测试 PNG 失败: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestEstimateImageTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected int
+	}{
+		{"空数据使用兜底值", "", core.ImageFallbackTokenCount},
+		{"无法解码使用兜底值", "!!!", core.ImageFallbackTokenCount},
+		{"小图按像素计算", encodeTestPNG(t, 75, 100), 10},
+		{"超大图被上限截断", encodeTestPNG(t, 4000, 4000), core.ImageMaxTokens},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateImageTokens(tt.data); got != tt.expected {
+				t.Errorf("期望 %d，实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCountAnthropicRequest(t *testing.T) {
+	base := &core.AnthropicMessagesRequest{
+		Model:    "claude",
+		Messages: []core.AnthropicMessage{{Role: core.RoleUser, Content: "hello world"}},
+	}
+	baseCount := CountAnthropicRequest(base)
+	if baseCount <= core.RequestOverheadTokens {
+		t.Fatalf("基础请求计数应大于固定开销，实际 %d", baseCount)
+	}
+
+	withSystem := *base
+	withSystem.System = "You are a helpful assistant."
+	if CountAnthropicRequest(&withSystem) <= baseCount {
+		t.Error("system 提示应增加计数")
+	}
+
+	withTools := *base
+	withTools.Tools = []core.AnthropicTool{{
+		Name:        "get_weather",
+		Description: "Get weather for a city",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+	}}
+	if CountAnthropicRequest(&withTools) <= baseCount {
+		t.Error("工具定义应增加计数")
+	}
+
+	withImage := *base
+	withImage.Messages = []core.AnthropicMessage{{
+		Role: core.RoleUser,
+		Content: []any{
+			map[string]any{"type": "text", "text": "hello world"},
+			map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": encodeTestPNG(t, 750, 100)}},
+		},
+	}}
+	if got := CountAnthropicRequest(&withImage); got != baseCount+100 {
+		t.Errorf("图片应按像素计入 100 tokens，期望 %d，实际 %d", baseCount+100, got)
+	}
+
+	withToolResult := *base
+	withToolResult.Messages = append(withToolResult.Messages,
+		core.AnthropicMessage{Role: core.RoleAssistant, Content: []any{
+			map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{"city": "Beijing"}},
+		}},
+		core.AnthropicMessage{Role: core.RoleUser, Content: []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{map[string]any{"type": "text", "text": "sunny"}}},
+		}},
+	)
+	if CountAnthropicRequest(&withToolResult) <= baseCount {
+		t.Error("tool_use/tool_result 应增加计数")
+	}
+}
+
+func TestCountOpenAIRequest(t *testing.T) {
+	base := &core.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []core.ChatMessage{{Role: core.RoleUser, Content: "hello world"}},
+	}
+	baseCount := CountOpenAIRequest(base)
+	if expected := core.RequestOverheadTokens + core.MessageOverheadTokens + CountText("hello world"); baseCount != expected {
+		t.Fatalf("期望 %d，实际 %d", expected, baseCount)
+	}
+
+	withImage := *base
+	withImage.Messages = []core.ChatMessage{{
+		Role: core.RoleUser,
+		Content: []any{
+			map[string]any{"type": "text", "text": "hello world"},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64," + encodeTestPNG(t, 750, 100)}},
+		},
+	}}
+	if got := CountOpenAIRequest(&withImage); got != baseCount+100 {
+		t.Errorf("图片应按像素计入 100 tokens，期望 %d，实际 %d", baseCount+100, got)
+	}
+
+	withTools := *base
+	withTools.Tools = []core.Tool{{Type: core.ToolTypeFunction, Function: core.ToolFunction{Name: "get_weather", Parameters: map[string]any{"type": "object"}}}}
+	withTools.Messages = append(withTools.Messages, core.ChatMessage{
+		Role:      core.RoleAssistant,
+		ToolCalls: []core.ToolCall{{ID: "call_1", Type: core.ToolTypeFunction, Function: core.Function{Name: "get_weather", Arguments: `{"city":"Beijing"}`}}},
+	})
+	if CountOpenAIRequest(&withTools) <= baseCount {
+		t.Error("工具定义和 tool_calls 应增加计数")
+	}
+}
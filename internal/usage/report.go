@@ -0,0 +1,152 @@
+package usage
+
+import (
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+)
+
+// Report holds the token usage of a single request.
+type Report struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// TotalTokens returns input plus output tokens.
+func (r Report) TotalTokens() int {
+	return r.InputTokens + r.OutputTokens
+}
+
+// OpenAI converts the report to OpenAI chat completion usage.
+func (r Report) OpenAI() core.OpenAIUsage {
+	return core.OpenAIUsage{
+		PromptTokens:     r.InputTokens,
+		CompletionTokens: r.OutputTokens,
+		TotalTokens:      r.TotalTokens(),
+	}
+}
+
+// Anthropic converts the report to Anthropic message usage.
+func (r Report) Anthropic() core.AnthropicUsage {
+	return core.AnthropicUsage{
+		InputTokens:  r.InputTokens,
+		OutputTokens: r.OutputTokens,
+	}
+}
+
+// Responses converts the report to Responses API usage.
+func (r Report) Responses() *core.ResponsesUsage {
+	return &core.ResponsesUsage{
+		InputTokens:  r.InputTokens,
+		OutputTokens: r.OutputTokens,
+		TotalTokens:  r.TotalTokens(),
+	}
+}
+
+// Gemini converts the report to Gemini usage metadata.
+func (r Report) Gemini() *core.GeminiUsageMetadata {
+	return &core.GeminiUsageMetadata{
+		PromptTokenCount:     r.InputTokens,
+		CandidatesTokenCount: r.OutputTokens,
+		TotalTokenCount:      r.TotalTokens(),
+	}
+}
+
+// Accumulator collects the output of a JetBrains stream for token accounting.
+// Counts reported by the upstream in FinishMetadata take precedence over local counts.
+type Accumulator struct {
+	inputTokens int
+	output      strings.Builder
+
+	upstreamInput  int
+	upstreamOutput int
+	hasUpstreamIn  bool
+	hasUpstreamOut bool
+}
+
+// NewAccumulator creates an accumulator with a locally computed input token count.
+func NewAccumulator(inputTokens int) *Accumulator {
+	return &Accumulator{inputTokens: inputTokens}
+}
+
+// ObserveEvent accounts a single JetBrains stream event: generated text, reasoning and tool calls
+// count towards output, and FinishMetadata may carry the authoritative upstream usage.
+func (a *Accumulator) ObserveEvent(event map[string]any) {
+	eventType, _ := event["type"].(string)
+
+	switch eventType {
+	case core.JetBrainsEventTypeContent, core.JetBrainsEventTypeReasoning, core.JetBrainsEventTypeToolCall, core.JetBrainsEventTypeFunctionCall:
+		if name, ok := event["name"].(string); ok {
+			a.output.WriteString(name)
+		}
+		if content, ok := event["content"].(string); ok {
+			a.output.WriteString(content)
+		}
+	case core.JetBrainsEventTypeFinishMetadata:
+		input, output, hasInput, hasOutput := ParseUpstreamUsage(event)
+		if hasInput {
+			a.upstreamInput, a.hasUpstreamIn = input, true
+		}
+		if hasOutput {
+			a.upstreamOutput, a.hasUpstreamOut = output, true
+		}
+	}
+}
+
+// Report returns the final usage, preferring upstream counts when present.
+func (a *Accumulator) Report() Report {
+	report := Report{InputTokens: a.inputTokens}
+	if a.hasUpstreamIn {
+		report.InputTokens = a.upstreamInput
+	}
+	if a.hasUpstreamOut {
+		report.OutputTokens = a.upstreamOutput
+	} else {
+		report.OutputTokens = CountText(a.output.String())
+	}
+	return report
+}
+
+var (
+	upstreamInputKeys  = []string{"inputTokens", "promptTokens", "input_tokens", "prompt_tokens"}
+	upstreamOutputKeys = []string{"outputTokens", "completionTokens", "output_tokens", "completion_tokens"}
+)
+
+// ParseUpstreamUsage extracts token counts from a FinishMetadata event. The counts may sit at the
+// top level or inside a "usage" object, in camelCase or snake_case.
+func ParseUpstreamUsage(event map[string]any) (input, output int, hasInput, hasOutput bool) {
+	sources := []map[string]any{event}
+	if nested, ok := event["usage"].(map[string]any); ok {
+		sources = append(sources, nested)
+	}
+
+	for _, src := range sources {
+		if v, ok := lookupCount(src, upstreamInputKeys); ok {
+			input, hasInput = v, true
+		}
+		if v, ok := lookupCount(src, upstreamOutputKeys); ok {
+			output, hasOutput = v, true
+		}
+	}
+	return input, output, hasInput, hasOutput
+}
+
+func lookupCount(src map[string]any, keys []string) (int, bool) {
+	for _, key := range keys {
+		switch v := src[key].(type) {
+		case float64:
+			if v >= 0 {
+				return int(v), true
+			}
+		case int64:
+			if v >= 0 {
+				return int(v), true
+			}
+		case int:
+			if v >= 0 {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package usage
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"jetbrainsai2api/internal/core"
+)
+
+func TestAccumulator_Report(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         []map[string]any
+		expectedInput  int
+		expectedOutput int
+	}{
+		{
+			name: "无上游用量时本地计数",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeContent, "content": "hello world"},
+				{"type": core.JetBrainsEventTypeFinishMetadata, "reason": "stop"},
+			},
+			expectedInput:  10,
+			expectedOutput: CountText("hello world"),
+		},
+		{
+			name: "工具调用计入输出",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeToolCall, "id": "call_1", "name": "get_weather"},
+				{"type": core.JetBrainsEventTypeToolCall, "content": `{"city":"Beijing"}`},
+			},
+			expectedInput:  10,
+			expectedOutput: CountText(`get_weather{"city":"Beijing"}`),
+		},
+		{
+			name: "推理内容计入输出",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeReasoning, "content": "let me think"},
+				{"type": core.JetBrainsEventTypeContent, "content": "answer"},
+			},
+			expectedInput:  10,
+			expectedOutput: CountText("let me thinkanswer"),
+		},
+		{
+			name: "顶层驼峰用量优先",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeContent, "content": "hello world"},
+				{"type": core.JetBrainsEventTypeFinishMetadata, "promptTokens": float64(42), "completionTokens": float64(7)},
+			},
+			expectedInput:  42,
+			expectedOutput: 7,
+		},
+		{
+			name: "嵌套蛇形用量",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeFinishMetadata, "usage": map[string]any{"input_tokens": float64(5), "output_tokens": float64(3)}},
+			},
+			expectedInput:  5,
+			expectedOutput: 3,
+		},
+		{
+			name: "仅上游输出用量",
+			events: []map[string]any{
+				{"type": core.JetBrainsEventTypeFinishMetadata, "usage": map[string]any{"completion_tokens": float64(9)}},
+			},
+			expectedInput:  10,
+			expectedOutput: 9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := NewAccumulator(10)
+			for _, event := range tt.events {
+				acc.ObserveEvent(event)
+			}
+			report := acc.Report()
+			if report.InputTokens != tt.expectedInput || report.OutputTokens != tt.expectedOutput {
+				t.Errorf("期望 input=%d output=%d，实际 input=%d output=%d",
+					tt.expectedInput, tt.expectedOutput, report.InputTokens, report.OutputTokens)
+			}
+		})
+	}
+}
+
+func TestReport_Conversions(t *testing.T) {
+	report := Report{InputTokens: 12, OutputTokens: 8}
+
+	if got := report.OpenAI(); got.PromptTokens != 12 || got.CompletionTokens != 8 || got.TotalTokens != 20 {
+		t.Errorf("OpenAI 用量转换错误: %+v", got)
+	}
+	if got := report.Anthropic(); got.InputTokens != 12 || got.OutputTokens != 8 {
+		t.Errorf("Anthropic 用量转换错误: %+v", got)
+	}
+	if got := report.Responses(); got.InputTokens != 12 || got.OutputTokens != 8 || got.TotalTokens != 20 {
+		t.Errorf("Responses 用量转换错误: %+v", got)
+	}
+	if got := report.Gemini(); got.PromptTokenCount != 12 || got.CandidatesTokenCount != 8 || got.TotalTokenCount != 20 {
+		t.Errorf("Gemini 用量转换错误: %+v", got)
+	}
+}
+
+func TestCountText(t *testing.T) {
+	if got := CountText(""); got != 0 {
+		t.Errorf("空文本应为 0，实际 %d", got)
+	}
+	if got := CountText("hello world"); got != 2 {
+		t.Errorf("\"hello world\" 在 o200k_base 下应为 2 tokens，实际 %d", got)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		limit    int
+		expected string
+	}{
+		{"预算内保持原样", "hello world", 5, "hello world"},
+		{"截断到预算", "hello world again", 2, "hello world"},
+		{"零预算", "hello", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TruncateText(tt.text, tt.limit); got != tt.expected {
+				t.Errorf("期望 %q，实际 %q", tt.expected, got)
+			}
+		})
+	}
+
+	// Cutting inside a multi-byte rune must still yield valid UTF-8
+	if got := TruncateText("你好世界再见朋友", 1); !utf8.ValidString(got) {
+		t.Errorf("截断结果应为有效 UTF-8，实际 %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package usage
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"jetbrainsai2api/internal/core"
+	"jetbrainsai2api/internal/util"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// The BPE ranks are embedded in the binary via the offline loader, so counting never
+// touches the network. Loading the ranks is expensive, hence the lazy sync.Once.
+var (
+	tokenizerOnce sync.Once
+	tokenizer     *tiktoken.Tiktoken
+	tokenizerErr  error
+)
+
+func getTokenizer() (*tiktoken.Tiktoken, error) {
+	tokenizerOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+		tokenizer, tokenizerErr = tiktoken.GetEncoding(core.TokenizerEncoding)
+	})
+	return tokenizer, tokenizerErr
+}
+
+// CountText counts tokens in plain text with the bundled BPE tokenizer,
+// falling back to the rune-based estimate if the tokenizer cannot be loaded.
+func CountText(text string) int {
+	if text == "" {
+		return 0
+	}
+	enc, err := getTokenizer()
+	if err != nil {
+		return util.EstimateTokenCount(text)
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// TruncateText returns the longest prefix of text that fits in limit tokens.
+func TruncateText(text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	enc, err := getTokenizer()
+	if err != nil {
+		// Inverse of the rune-based estimate used by CountText's fallback
+		runes := []rune(text)
+		if keep := limit * 5 / 3; keep < len(runes) {
+			return string(runes[:keep])
+		}
+		return text
+	}
+
+	tokens := enc.EncodeOrdinary(text)
+	if len(tokens) <= limit {
+		return text
+	}
+	// A token boundary may split a multi-byte rune; drop the incomplete tail
+	prefix := enc.Decode(tokens[:limit])
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
+
+// WarmUp loads the tokenizer ranks ahead of the first request.
+func WarmUp(logger core.Logger) {
+	if _, err := getTokenizer(); err != nil {
+		logger.Warn("Failed to load BPE tokenizer, falling back to estimation: %v", err)
+	}
+}
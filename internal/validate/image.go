@@ -24,7 +24,7 @@ func (v *ImageValidator) ValidateImageData(mediaType, data string) error {
 	}
 
 	// Pre-check base64 string length to avoid OOM from decoding huge data
-	estimatedSize := int64(len(data)) * 3 / 4
+	estimatedSize := DecodedImageSize(data)
 	if estimatedSize > core.MaxImageSizeBytes {
 		return fmt.Errorf("image data too large: estimated %d bytes exceeds %d limit", estimatedSize, core.MaxImageSizeBytes)
 	}
@@ -51,35 +51,21 @@ func (v *ImageValidator) isFormatSupported(mediaType string) bool {
 	return false
 }
 
-// ExtractImageDataFromContent extracts the first image data from OpenAI content format.
-// NOTE: Only the first image is extracted; subsequent images in the content array are ignored.
-func ExtractImageDataFromContent(content any) (mediaType, data string, hasImage bool) {
-	if content == nil {
+// ParseImageDataURL splits a base64 data URL ("data:image/png;base64,...") into media type and payload.
+// Remote URLs and malformed data URLs return ok=false.
+func ParseImageDataURL(url string) (mediaType, data string, ok bool) {
+	if !strings.HasPrefix(url, "data:") {
 		return "", "", false
 	}
-
-	if contentArray, ok := content.([]any); ok {
-		for _, item := range contentArray {
-			if itemMap, ok := item.(map[string]any); ok {
-				if itemType, ok := itemMap["type"].(string); ok && itemType == "image_url" {
-					if imageUrl, ok := itemMap["image_url"].(map[string]any); ok {
-						if url, ok := imageUrl["url"].(string); ok {
-							if strings.HasPrefix(url, "data:") {
-								parts := strings.SplitN(url, ",", 2)
-								if len(parts) == 2 {
-									headerParts := strings.Split(parts[0], ";")
-									if len(headerParts) > 0 {
-										mediaType := strings.TrimPrefix(headerParts[0], "data:")
-										return mediaType, parts[1], true
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+	header, payload, found := strings.Cut(url, ",")
+	if !found {
+		return "", "", false
 	}
+	mediaType, _, _ = strings.Cut(strings.TrimPrefix(header, "data:"), ";")
+	return mediaType, payload, true
+}
 
-	return "", "", false
+// DecodedImageSize estimates the decoded byte size of base64 image data without decoding it
+func DecodedImageSize(data string) int64 {
+	return int64(base64.StdEncoding.DecodedLen(len(data)))
 }
@@ -36,69 +36,29 @@ func TestImageValidator_ValidateImageData(t *testing.T) {
 	}
 }
 
-func TestExtractImageDataFromContent(t *testing.T) {
+func TestParseImageDataURL(t *testing.T) {
 	tests := []struct {
 		name      string
-		content   any
+		url       string
 		wantMedia string
 		wantData  string
-		wantImage bool
+		wantOK    bool
 	}{
-		{
-			name:      "字符串内容无图片",
-			content:   "Hello world",
-			wantImage: false,
-		},
-		{
-			name:      "nil内容",
-			content:   nil,
-			wantImage: false,
-		},
-		{
-			name: "包含data URI图片",
-			content: []any{
-				map[string]any{
-					"type": "image_url",
-					"image_url": map[string]any{
-						"url": "data:image/png;base64,iVBORw0KGgoAAAANSU",
-					},
-				},
-			},
-			wantMedia: "image/png",
-			wantData:  "iVBORw0KGgoAAAANSU",
-			wantImage: true,
-		},
-		{
-			name: "只有文本块",
-			content: []any{
-				map[string]any{"type": "text", "text": "Just text"},
-			},
-			wantImage: false,
-		},
-		{
-			// ExtractImageDataFromContent only handles "image_url" type with data: URI
-			// It does NOT handle Anthropic-style "image" with "source" - that's a different path
-			name: "非image_url类型不提取",
-			content: []any{
-				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/jpeg", "data": "dGVzdA=="}},
-			},
-			wantImage: false,
-		},
+		{name: "base64 data URL", url: "data:image/png;base64,iVBORw0KGgoAAAANSU", wantMedia: "image/png", wantData: "iVBORw0KGgoAAAANSU", wantOK: true},
+		{name: "无参数的 data URL", url: "data:image/jpeg,dGVzdA==", wantMedia: "image/jpeg", wantData: "dGVzdA==", wantOK: true},
+		{name: "远程 URL", url: "https://example.com/a.png", wantOK: false},
+		{name: "缺少逗号", url: "data:image/png;base64", wantOK: false},
+		{name: "空字符串", url: "", wantOK: false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mediaType, data, hasImage := ExtractImageDataFromContent(tt.content)
-			if hasImage != tt.wantImage {
-				t.Errorf("hasImage = %v, want %v", hasImage, tt.wantImage)
+			mediaType, data, ok := ParseImageDataURL(tt.url)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
 			}
-			if hasImage {
-				if mediaType != tt.wantMedia {
-					t.Errorf("mediaType = %s, want %s", mediaType, tt.wantMedia)
-				}
-				if data != tt.wantData {
-					t.Errorf("data = %s, want %s", data, tt.wantData)
-				}
+			if ok && (mediaType != tt.wantMedia || data != tt.wantData) {
+				t.Errorf("got (%s, %s), want (%s, %s)", mediaType, data, tt.wantMedia, tt.wantData)
 			}
 		})
 	}
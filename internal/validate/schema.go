@@ -0,0 +1,304 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+)
+
+// StructuredOutput checks model output against a JSON response_format and returns the JSON text
+// with surrounding whitespace and Markdown code fences removed.
+func StructuredOutput(content string, format *core.ResponseFormat) (string, error) {
+	text := stripCodeFence(content)
+	var value any
+	if err := sonic.UnmarshalString(text, &value); err != nil {
+		return "", fmt.Errorf("output is not valid JSON")
+	}
+
+	switch format.Type {
+	case core.ResponseFormatJSONObject:
+		if _, ok := value.(map[string]any); !ok {
+			return "", fmt.Errorf("output is not a JSON object")
+		}
+	case core.ResponseFormatJSONSchema:
+		if format.JSONSchema != nil && format.JSONSchema.Schema != nil {
+			strict := format.JSONSchema.Strict != nil && *format.JSONSchema.Strict
+			if err := ValidateJSONSchema(value, format.JSONSchema.Schema, strict); err != nil {
+				return "", err
+			}
+		}
+	}
+	return text, nil
+}
+
+// stripCodeFence removes a Markdown code fence wrapped around the whole text.
+func stripCodeFence(content string) string {
+	text := strings.TrimSpace(content)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text[3:], "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 && !strings.ContainsAny(text[:newline], "{[\"") {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// ValidateJSONSchema checks a decoded JSON value against a JSON Schema. It covers the subset used by
+// structured outputs: type, properties, required, additionalProperties, items, enum, const,
+// anyOf/oneOf/allOf, string, number and array bounds, pattern, and local $ref. In strict mode
+// objects without additionalProperties accept no undeclared properties.
+func ValidateJSONSchema(value any, schema map[string]any, strict bool) error {
+	v := schemaValidator{root: schema, strict: strict}
+	return v.validate(value, schema, "$", 0)
+}
+
+type schemaValidator struct {
+	root   map[string]any
+	strict bool
+}
+
+func (v *schemaValidator) validate(value any, schema map[string]any, path string, depth int) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= core.MaxSchemaRefDepth {
+			return fmt.Errorf("%s: $ref nesting exceeds %d levels", path, core.MaxSchemaRefDepth)
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return v.validate(value, target, path, depth+1)
+	}
+
+	if err := v.validateType(value, schema["type"], path); err != nil {
+		return err
+	}
+	if enum, ok := schema["enum"].([]any); ok && !containsValue(enum, value) {
+		return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+	}
+	if constant, ok := schema["const"]; ok && !equalValues(constant, value) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+	if err := v.validateCombinators(value, schema, path, depth); err != nil {
+		return err
+	}
+
+	switch typed := value.(type) {
+	case map[string]any:
+		return v.validateObject(typed, schema, path, depth)
+	case []any:
+		return v.validateArray(typed, schema, path, depth)
+	case string:
+		return validateString(typed, schema, path)
+	case float64:
+		return validateNumber(typed, schema, path)
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateType(value any, schemaType any, path string) error {
+	var allowed []string
+	switch t := schemaType.(type) {
+	case string:
+		allowed = []string{t}
+	case []any:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				allowed = append(allowed, s)
+			}
+		}
+	default:
+		return nil
+	}
+
+	actual := jsonType(value)
+	for _, want := range allowed {
+		if want == actual || (want == core.SchemaTypeNumber && actual == core.SchemaTypeInteger) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(allowed, " or "), actual)
+}
+
+func (v *schemaValidator) validateCombinators(value any, schema map[string]any, path string, depth int) error {
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]any); ok {
+				if err := v.validate(value, subSchema, path, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		options, ok := schema[keyword].([]any)
+		if !ok {
+			continue
+		}
+		matched := 0
+		for _, sub := range options {
+			if subSchema, ok := sub.(map[string]any); ok && v.validate(value, subSchema, path, depth) == nil {
+				matched++
+			}
+		}
+		if matched == 0 || (keyword == "oneOf" && matched > 1) {
+			return fmt.Errorf("%s: value does not match %s", path, keyword)
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateObject(obj map[string]any, schema map[string]any, path string, depth int) error {
+	properties, _ := schema["properties"].(map[string]any)
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := obj[key]; !present {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+
+	for key, propValue := range obj {
+		propPath := path + "." + key
+		if propSchema, ok := properties[key].(map[string]any); ok {
+			if err := v.validate(propValue, propSchema, propPath, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, key)
+			}
+		case map[string]any:
+			if err := v.validate(propValue, additional, propPath, depth); err != nil {
+				return err
+			}
+		default:
+			if v.strict && properties != nil {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(arr []any, schema map[string]any, path string, depth int) error {
+	if minItems, ok := schema["minItems"].(float64); ok && float64(len(arr)) < minItems {
+		return fmt.Errorf("%s: expected at least %v items", path, minItems)
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(arr)) > maxItems {
+		return fmt.Errorf("%s: expected at most %v items", path, maxItems)
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		for i, item := range arr {
+			if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i), depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(s string, schema map[string]any, path string) error {
+	length := float64(len([]rune(s)))
+	if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+		return fmt.Errorf("%s: expected at least %v characters", path, minLength)
+	}
+	if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+		return fmt.Errorf("%s: expected at most %v characters", path, maxLength)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(s) {
+			return fmt.Errorf("%s: value does not match pattern %s", path, pattern)
+		}
+	}
+	return nil
+}
+
+func validateNumber(n float64, schema map[string]any, path string) error {
+	if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+		return fmt.Errorf("%s: expected a value >= %v", path, minimum)
+	}
+	if maximum, ok := schema["maximum"].(float64); ok && n > maximum {
+		return fmt.Errorf("%s: expected a value <= %v", path, maximum)
+	}
+	if minimum, ok := schema["exclusiveMinimum"].(float64); ok && n <= minimum {
+		return fmt.Errorf("%s: expected a value > %v", path, minimum)
+	}
+	if maximum, ok := schema["exclusiveMaximum"].(float64); ok && n >= maximum {
+		return fmt.Errorf("%s: expected a value < %v", path, maximum)
+	}
+	return nil
+}
+
+// resolve follows a local JSON pointer reference such as #/$defs/item.
+func (v *schemaValidator) resolve(ref string) (map[string]any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q; only local references are supported", ref)
+	}
+
+	var node any = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		node = m[part]
+	}
+	target, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+func jsonType(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return core.SchemaTypeNull
+	case bool:
+		return core.SchemaTypeBoolean
+	case string:
+		return core.SchemaTypeString
+	case float64:
+		if typed == math.Trunc(typed) && !math.IsInf(typed, 0) {
+			return core.SchemaTypeInteger
+		}
+		return core.SchemaTypeNumber
+	case []any:
+		return core.SchemaTypeArray
+	case map[string]any:
+		return core.SchemaTypeObject
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func containsValue(values []any, value any) bool {
+	for _, candidate := range values {
+		if equalValues(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares decoded JSON values; they are plain maps, slices and scalars.
+func equalValues(a, b any) bool {
+	left, errA := sonic.ConfigStd.Marshal(a)
+	right, errB := sonic.ConfigStd.Marshal(b)
+	return errA == nil && errB == nil && string(left) == string(right)
+}
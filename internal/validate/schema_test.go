@@ -0,0 +1,99 @@
+package validate
+
+import (
+	"testing"
+
+	"jetbrainsai2api/internal/core"
+
+	"github.com/bytedance/sonic"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string", "minLength": float64(1)},
+			"age":   map[string]any{"type": "integer", "minimum": float64(0)},
+			"role":  map[string]any{"enum": []any{"admin", "user"}},
+			"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": float64(2)},
+			"email": map[string]any{"type": []any{"string", "null"}, "pattern": "^[^@]+@[^@]+$"},
+			"pet":   map[string]any{"$ref": "#/$defs/pet"},
+		},
+		"required": []any{"name"},
+		"$defs": map[string]any{
+			"pet": map[string]any{"anyOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{"type": "object", "properties": map[string]any{"kind": map[string]any{"const": "cat"}}, "required": []any{"kind"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		strict  bool
+		wantErr bool
+	}{
+		{"最小合法对象", `{"name":"Ann"}`, true, false},
+		{"全部字段", `{"name":"Ann","age":3,"role":"admin","tags":["a"],"email":null,"pet":{"kind":"cat"}}`, true, false},
+		{"缺少必填字段", `{"age":3}`, false, true},
+		{"类型错误", `{"name":1}`, false, true},
+		{"整数字段为小数", `{"name":"Ann","age":1.5}`, false, true},
+		{"低于最小值", `{"name":"Ann","age":-1}`, false, true},
+		{"枚举不匹配", `{"name":"Ann","role":"root"}`, false, true},
+		{"数组过长", `{"name":"Ann","tags":["a","b","c"]}`, false, true},
+		{"数组元素类型错误", `{"name":"Ann","tags":[1]}`, false, true},
+		{"正则不匹配", `{"name":"Ann","email":"nope"}`, false, true},
+		{"$ref 与 anyOf 不匹配", `{"name":"Ann","pet":{"kind":"dog"}}`, false, true},
+		{"严格模式拒绝额外字段", `{"name":"Ann","extra":true}`, true, true},
+		{"非严格模式允许额外字段", `{"name":"Ann","extra":true}`, false, false},
+		{"根类型错误", `[1]`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := sonic.UnmarshalString(tt.value, &value); err != nil {
+				t.Fatalf("测试数据无效: %v", err)
+			}
+			err := ValidateJSONSchema(value, schema, tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	objectFormat := &core.ResponseFormat{Type: core.ResponseFormatJSONObject}
+	schemaFormat := &core.ResponseFormat{Type: core.ResponseFormatJSONSchema, JSONSchema: &core.JSONSchemaFormat{
+		Name:   "list",
+		Schema: map[string]any{"type": "array"},
+	}}
+
+	tests := []struct {
+		name     string
+		content  string
+		format   *core.ResponseFormat
+		expected string
+		wantErr  bool
+	}{
+		{"JSON 对象", ` {"a":1} `, objectFormat, `{"a":1}`, false},
+		{"代码块包裹", "```json\n{\"a\":1}\n```", objectFormat, `{"a":1}`, false},
+		{"无语言标记的代码块", "```\n{\"a\":1}\n```", objectFormat, `{"a":1}`, false},
+		{"json_object 不接受数组", `[1]`, objectFormat, "", true},
+		{"夹带说明文字", `Here: {"a":1}`, objectFormat, "", true},
+		{"json_schema 数组", `[1,2]`, schemaFormat, `[1,2]`, false},
+		{"json_schema 类型不符", `{"a":1}`, schemaFormat, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := StructuredOutput(tt.content, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+			if text != tt.expected {
+				t.Errorf("期望 %q，实际 %q", tt.expected, text)
+			}
+		})
+	}
+}